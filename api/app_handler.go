@@ -147,7 +147,7 @@ func (a *Application) PutAppHandler(c echo.Context) error {
 	}
 	app.ID = id
 	err = WithSegment("db-update", c, func() error {
-		_, err = a.DB.Model(&app).Column("name").Column("bundle_id").Column("updated_at").Returning("*").Update()
+		_, err = a.DB.Model(&app).Column("name").Column("bundle_id").Column("max_pushes_per_second").Column("default_locale").Column("locale_fallbacks").Column("updated_at").Returning("*").Update()
 		return err
 	})
 	if err != nil {
@@ -323,9 +323,46 @@ var _ = Describe("App Handler", func() {
 				Expect(dbApp.BundleID).To(Equal(payload["bundleId"]))
 				Expect(dbApp.CreatedBy).To(Equal(existingApp.CreatedBy))
 			})
+
+			It("should return 200 and update the app default locale and locale fallbacks", func() {
+				existingApp := CreateTestApp(app.DB)
+				payload := GetAppPayload()
+				payload["defaultLocale"] = "pt-BR"
+				payload["localeFallbacks"] = map[string][]string{"es": []string{"pt"}}
+				pl, _ := json.Marshal(payload)
+				status, body := Put(app, fmt.Sprintf("/apps/%s", existingApp.ID), string(pl), "update@test.com")
+				Expect(status).To(Equal(http.StatusOK))
+
+				var response map[string]interface{}
+				err := json.Unmarshal([]byte(body), &response)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(response["defaultLocale"]).To(Equal("pt-BR"))
+				Expect(response["localeFallbacks"]).To(Equal(map[string]interface{}{"es": []interface{}{"pt"}}))
+
+				dbApp := &model.App{ID: existingApp.ID}
+				err = app.DB.Select(dbApp)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(dbApp.DefaultLocale).To(Equal("pt-BR"))
+				Expect(dbApp.LocaleFallbacks).To(Equal(map[string][]string{"es": []string{"pt"}}))
+				Expect(dbApp.LocaleFallbackChain("es_MX")).To(Equal([]string{"es-MX", "es", "pt", "pt-BR"}))
+			})
 		})
 
 		Describe("Unsucesfully", func() {
+			It("should return 422 if invalid defaultLocale", func() {
+				existingApp := CreateTestApp(app.DB)
+				payload := GetAppPayload()
+				payload["defaultLocale"] = strings.Repeat("a", 11)
+				pl, _ := json.Marshal(payload)
+				status, body := Put(app, fmt.Sprintf("/apps/%s", existingApp.ID), string(pl), "update@test.com")
+				Expect(status).To(Equal(http.StatusUnprocessableEntity))
+
+				var response map[string]interface{}
+				err := json.Unmarshal([]byte(body), &response)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(response["reason"]).To(Equal("invalid defaultLocale"))
+			})
+
 			It("should return 401 if no authenticated user", func() {
 				existingApp := CreateTestApp(app.DB)
 				status, _ := Put(app, fmt.Sprintf("/apps/%s", existingApp.ID), "", "")
@@ -0,0 +1,353 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package api
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"gopkg.in/pg.v5/types"
+
+	"github.com/labstack/echo"
+	"github.com/satori/go.uuid"
+	"github.com/topfreegames/marathon/log"
+	"github.com/topfreegames/marathon/model"
+	"github.com/uber-go/zap"
+)
+
+// ListAudiencesHandler is the method called when a get to /apps/:aid/audiences is called
+func (a *Application) ListAudiencesHandler(c echo.Context) error {
+	l := a.Logger.With(
+		zap.String("source", "audienceHandler"),
+		zap.String("operation", "listAudiences"),
+		zap.String("appId", c.Param("aid")),
+	)
+	aid, err := uuid.FromString(c.Param("aid"))
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error()})
+	}
+	audiences := []model.Audience{}
+	err = WithSegment("db-select", c, func() error {
+		return a.DB.Model(&audiences).Column("audience.*", "App").Where("audience.app_id = ?", aid).Order("audience.name").Select()
+	})
+	if err != nil {
+		log.E(l, "Failed to list audiences.", func(cm log.CM) {
+			cm.Write(zap.Error(err))
+		})
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error()})
+	}
+	log.D(l, "Listed audiences successfully.", func(cm log.CM) {
+		cm.Write(zap.Object("audiences", audiences))
+	})
+	return c.JSON(http.StatusOK, audiences)
+}
+
+// prepareAudience checks the app of the audience and normalizes its filters
+// It returns the status code to be sent if it fails
+func (a *Application) prepareAudience(c echo.Context, audience *model.Audience) (int, error) {
+	app := &model.App{ID: audience.AppID}
+	err := WithSegment("db-select", c, func() error {
+		return a.DB.Select(&app)
+	})
+	if err != nil {
+		if err.Error() == RecordNotFoundString {
+			return http.StatusUnprocessableEntity, err
+		}
+		return http.StatusInternalServerError, err
+	}
+
+	err = WithSegment("normalize-filters", c, func() error {
+		return a.normalizeJobFilters(app, audience.Service, audience.Filters)
+	})
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
+// enqueueAudienceSize sends the audience to audience_size_worker. The size is only a cache, so the audience is
+// kept if it fails and its size can be counted again later
+func (a *Application) enqueueAudienceSize(c echo.Context, l zap.Logger, audience *model.Audience) error {
+	var wJobID string
+	err := WithSegment("create-job", c, func() error {
+		var err error
+		wJobID, err = a.Worker.CreateAudienceSizeJob(audience.ID.String())
+		return err
+	})
+	if err != nil {
+		log.E(l, "Failed to send audience to audience_size_worker.", func(cm log.CM) {
+			cm.Write(zap.Error(err))
+		})
+		return err
+	}
+	log.D(l, "Audience successfully sent to audience_size_worker", func(cm log.CM) {
+		cm.Write(zap.String("workerJobId", wJobID))
+	})
+	return nil
+}
+
+// PostAudienceHandler is the method called when a post to /apps/:aid/audiences is called
+func (a *Application) PostAudienceHandler(c echo.Context) error {
+	l := a.Logger.With(
+		zap.String("source", "audienceHandler"),
+		zap.String("operation", "postAudience"),
+		zap.String("appId", c.Param("aid")),
+	)
+	aid, err := uuid.FromString(c.Param("aid"))
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error()})
+	}
+	email := c.Get("user-email").(string)
+	audience := &model.Audience{
+		ID:        uuid.NewV4(),
+		AppID:     aid,
+		CreatedBy: email,
+		CreatedAt: time.Now().UnixNano(),
+		UpdatedAt: time.Now().UnixNano(),
+	}
+	err = WithSegment("decodeAndValidate", c, func() error {
+		return decodeAndValidate(c, audience)
+	})
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error(), Value: audience})
+	}
+	audience.ID = uuid.NewV4()
+	audience.AppID = aid
+	audience.Size = 0
+	audience.SizeUpdatedAt = 0
+
+	status, err := a.prepareAudience(c, audience)
+	if err != nil {
+		if status == http.StatusInternalServerError {
+			log.E(l, "Failed to create audience.", func(cm log.CM) {
+				cm.Write(zap.Error(err))
+			})
+		}
+		return c.JSON(status, &Error{Reason: err.Error(), Value: audience})
+	}
+
+	err = WithSegment("db-insert", c, func() error {
+		return a.DB.Insert(&audience)
+	})
+	if err != nil {
+		if strings.Contains(err.Error(), "duplicate key") {
+			return c.JSON(http.StatusConflict, &Error{Reason: err.Error(), Value: audience})
+		}
+		if strings.Contains(err.Error(), "violates foreign key constraint") {
+			return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error(), Value: audience})
+		}
+		log.E(l, "Failed to create audience.", func(cm log.CM) {
+			cm.Write(zap.Error(err))
+		})
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error(), Value: audience})
+	}
+	a.enqueueAudienceSize(c, l, audience)
+	log.D(l, "Created audience successfully.", func(cm log.CM) {
+		cm.Write(zap.Object("audience", audience))
+	})
+	return c.JSON(http.StatusCreated, audience)
+}
+
+// getAudience returns the audience of the app with the given id
+func (a *Application) getAudience(c echo.Context, aid, audid uuid.UUID) (*model.Audience, error) {
+	audience := &model.Audience{}
+	err := WithSegment("db-select", c, func() error {
+		return a.DB.Model(audience).Column("audience.*", "App").Where("audience.id = ?", audid).Where("audience.app_id = ?", aid).Select()
+	})
+	return audience, err
+}
+
+// GetAudienceHandler is the method called when a get to /apps/:aid/audiences/:audid is called
+func (a *Application) GetAudienceHandler(c echo.Context) error {
+	l := a.Logger.With(
+		zap.String("source", "audienceHandler"),
+		zap.String("operation", "getAudience"),
+		zap.String("appId", c.Param("aid")),
+		zap.String("audienceId", c.Param("audid")),
+	)
+	aid, err := uuid.FromString(c.Param("aid"))
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error()})
+	}
+	audid, err := uuid.FromString(c.Param("audid"))
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error()})
+	}
+	audience, err := a.getAudience(c, aid, audid)
+	if err != nil {
+		if err.Error() == RecordNotFoundString {
+			return c.JSON(http.StatusNotFound, map[string]string{})
+		}
+		log.E(l, "Failed to retrieve audience.", func(cm log.CM) {
+			cm.Write(zap.Error(err))
+		})
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error()})
+	}
+	return c.JSON(http.StatusOK, audience)
+}
+
+// PutAudienceHandler is the method called when a put to /apps/:aid/audiences/:audid is called
+// The size of the audience is reset and counted again
+func (a *Application) PutAudienceHandler(c echo.Context) error {
+	l := a.Logger.With(
+		zap.String("source", "audienceHandler"),
+		zap.String("operation", "putAudience"),
+		zap.String("appId", c.Param("aid")),
+		zap.String("audienceId", c.Param("audid")),
+	)
+	aid, err := uuid.FromString(c.Param("aid"))
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error()})
+	}
+	audid, err := uuid.FromString(c.Param("audid"))
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error()})
+	}
+	email := c.Get("user-email").(string)
+	audience := &model.Audience{
+		ID:        audid,
+		AppID:     aid,
+		CreatedBy: email,
+		UpdatedAt: time.Now().UnixNano(),
+	}
+	err = WithSegment("decodeAndValidate", c, func() error {
+		return decodeAndValidate(c, audience)
+	})
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error(), Value: audience})
+	}
+	audience.ID = audid
+	audience.AppID = aid
+	audience.Size = 0
+	audience.SizeUpdatedAt = 0
+
+	status, err := a.prepareAudience(c, audience)
+	if err != nil {
+		if status == http.StatusInternalServerError {
+			log.E(l, "Failed to update audience.", func(cm log.CM) {
+				cm.Write(zap.Error(err))
+			})
+		}
+		return c.JSON(status, &Error{Reason: err.Error(), Value: audience})
+	}
+
+	var values *types.Result
+	err = WithSegment("db-update", c, func() error {
+		values, err = a.DB.Model(&audience).
+			Column("name", "service", "filters", "csv_path", "size", "size_updated_at", "updated_at").
+			Where("id = ? AND app_id = ?", audid, aid).
+			Returning("*").
+			Update()
+		return err
+	})
+	if err != nil {
+		if strings.Contains(err.Error(), "duplicate key") {
+			return c.JSON(http.StatusConflict, &Error{Reason: err.Error(), Value: audience})
+		}
+		log.E(l, "Failed to update audience.", func(cm log.CM) {
+			cm.Write(zap.Error(err))
+		})
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error(), Value: audience})
+	}
+	if values.RowsAffected() == 0 {
+		return c.JSON(http.StatusNotFound, map[string]string{})
+	}
+	a.enqueueAudienceSize(c, l, audience)
+	log.D(l, "Updated audience successfully.", func(cm log.CM) {
+		cm.Write(zap.Object("audience", audience))
+	})
+	return c.JSON(http.StatusOK, audience)
+}
+
+// RefreshAudienceSizeHandler is the method called when a post to /apps/:aid/audiences/:audid/size is called
+// The push db changes over time, so the cached size of an audience can be counted again on demand
+func (a *Application) RefreshAudienceSizeHandler(c echo.Context) error {
+	l := a.Logger.With(
+		zap.String("source", "audienceHandler"),
+		zap.String("operation", "refreshAudienceSize"),
+		zap.String("appId", c.Param("aid")),
+		zap.String("audienceId", c.Param("audid")),
+	)
+	aid, err := uuid.FromString(c.Param("aid"))
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error()})
+	}
+	audid, err := uuid.FromString(c.Param("audid"))
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error()})
+	}
+	audience, err := a.getAudience(c, aid, audid)
+	if err != nil {
+		if err.Error() == RecordNotFoundString {
+			return c.JSON(http.StatusNotFound, map[string]string{})
+		}
+		log.E(l, "Failed to retrieve audience.", func(cm log.CM) {
+			cm.Write(zap.Error(err))
+		})
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error()})
+	}
+	err = a.enqueueAudienceSize(c, l, audience)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error(), Value: audience})
+	}
+	return c.JSON(http.StatusAccepted, audience)
+}
+
+// DeleteAudienceHandler is the method called when a delete to /apps/:aid/audiences/:audid is called
+// The jobs created with the audience are kept
+func (a *Application) DeleteAudienceHandler(c echo.Context) error {
+	l := a.Logger.With(
+		zap.String("source", "audienceHandler"),
+		zap.String("operation", "deleteAudience"),
+		zap.String("appId", c.Param("aid")),
+		zap.String("audienceId", c.Param("audid")),
+	)
+	aid, err := uuid.FromString(c.Param("aid"))
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error()})
+	}
+	audid, err := uuid.FromString(c.Param("audid"))
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error()})
+	}
+	audience := &model.Audience{}
+	var res *types.Result
+	err = WithSegment("db-delete", c, func() error {
+		res, err = a.DB.Model(&audience).Where("id = ? AND app_id = ?", audid, aid).Delete()
+		return err
+	})
+	if err != nil {
+		log.E(l, "Failed to delete audience.", func(cm log.CM) {
+			cm.Write(zap.Error(err))
+		})
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error(), Value: audience})
+	}
+	if res.RowsAffected() == 0 {
+		return c.JSON(http.StatusNotFound, map[string]string{})
+	}
+	log.D(l, "Deleted audience successfully.", func(cm log.CM) {
+		cm.Write(zap.Object("audience", audience))
+	})
+	return c.JSON(http.StatusNoContent, "")
+}
@@ -0,0 +1,295 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package api_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	uuid "github.com/satori/go.uuid"
+	"github.com/topfreegames/marathon/model"
+	. "github.com/topfreegames/marathon/testing"
+	"github.com/topfreegames/marathon/worker"
+	"github.com/uber-go/zap"
+)
+
+var _ = Describe("Audience Handler", func() {
+	logger := zap.New(
+		zap.NewJSONEncoder(zap.NoTime()), // drop timestamps in tests
+		zap.FatalLevel,
+	)
+	app := GetDefaultTestApp(logger)
+	faultyDb := GetFaultyTestDB(app)
+	var existingApp *model.App
+	var baseRoute string
+
+	config := GetConf()
+	w := worker.NewWorker(false, logger, GetConfPath())
+	createBatchesWorker := worker.NewCreateBatchesWorker(config, logger, w)
+
+	expectAudienceSizeMessage := func(audienceID string) {
+		res, err := createBatchesWorker.RedisClient.LLen("queue:audience_size_worker").Result()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(res).To(BeEquivalentTo(1))
+		msg, err := createBatchesWorker.RedisClient.LPop("queue:audience_size_worker").Result()
+		Expect(err).NotTo(HaveOccurred())
+		m := map[string]interface{}{}
+		err = json.Unmarshal([]byte(msg), &m)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(m["queue"].(string)).To(Equal("audience_size_worker"))
+		Expect(m["args"].([]interface{})[0]).To(Equal(audienceID))
+	}
+
+	BeforeEach(func() {
+		app.DB.Exec("DELETE FROM apps;")
+		app.DB.Exec("DELETE FROM audiences;")
+		createBatchesWorker.RedisClient.FlushAll()
+		existingApp = CreateTestApp(app.DB)
+		baseRoute = fmt.Sprintf("/apps/%s/audiences", existingApp.ID)
+	})
+
+	Describe("Get /apps/:id/audiences", func() {
+		Describe("Sucesfully", func() {
+			It("should return 200 and the list of audiences of the app", func() {
+				CreateTestAudience(app.DB, existingApp.ID)
+				CreateTestAudience(app.DB, existingApp.ID, map[string]interface{}{"csvPath": "s3.aws.com/my-link"})
+				anotherApp := CreateTestApp(app.DB)
+				CreateTestAudience(app.DB, anotherApp.ID)
+
+				status, body := Get(app, baseRoute, "test@test.com")
+				Expect(status).To(Equal(http.StatusOK))
+
+				var response []map[string]interface{}
+				err := json.Unmarshal([]byte(body), &response)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(response).To(HaveLen(2))
+				for _, audience := range response {
+					Expect(audience["appId"]).To(Equal(existingApp.ID.String()))
+				}
+			})
+		})
+
+		Describe("Unsucesfully", func() {
+			It("should return 500 if some error occured", func() {
+				goodDB := app.DB
+				app.DB = faultyDb
+				status, _ := Get(app, baseRoute, "test@test.com")
+				Expect(status).To(Equal(http.StatusInternalServerError))
+				app.DB = goodDB
+			})
+		})
+	})
+
+	Describe("Post /apps/:id/audiences", func() {
+		Describe("Sucesfully", func() {
+			It("should return 201 and the created audience and send it to audience_size_worker", func() {
+				payload := GetAudiencePayload(map[string]interface{}{"name": "brazilian players"})
+				pl, _ := json.Marshal(payload)
+				status, body := Post(app, baseRoute, string(pl), "success@test.com")
+				Expect(status).To(Equal(http.StatusCreated))
+
+				var audience map[string]interface{}
+				err := json.Unmarshal([]byte(body), &audience)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(audience["id"]).ToNot(BeNil())
+				Expect(audience["appId"]).To(Equal(existingApp.ID.String()))
+				Expect(audience["name"]).To(Equal("brazilian players"))
+				Expect(audience["createdBy"]).To(Equal("success@test.com"))
+				Expect(audience["size"]).To(BeEquivalentTo(0))
+				Expect(audience["sizeUpdatedAt"]).To(BeEquivalentTo(0))
+
+				id, err := uuid.FromString(audience["id"].(string))
+				Expect(err).NotTo(HaveOccurred())
+				dbAudience := &model.Audience{ID: id}
+				err = app.DB.Select(&dbAudience)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(dbAudience.Name).To(Equal("brazilian players"))
+
+				expectAudienceSizeMessage(audience["id"].(string))
+			})
+
+			It("should return 201 and the created audience with a csvPath", func() {
+				payload := GetAudiencePayload(map[string]interface{}{"csvPath": "s3.aws.com/my-link"})
+				pl, _ := json.Marshal(payload)
+				status, body := Post(app, baseRoute, string(pl), "success@test.com")
+				Expect(status).To(Equal(http.StatusCreated))
+
+				var audience map[string]interface{}
+				err := json.Unmarshal([]byte(body), &audience)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(audience["csvPath"]).To(Equal("s3.aws.com/my-link"))
+			})
+		})
+
+		Describe("Unsucesfully", func() {
+			It("should return 422 if both filters and csvPath are specified", func() {
+				payload := GetAudiencePayload()
+				payload["csvPath"] = "s3.aws.com/my-link"
+				pl, _ := json.Marshal(payload)
+				status, body := Post(app, baseRoute, string(pl), "success@test.com")
+				Expect(status).To(Equal(http.StatusUnprocessableEntity))
+
+				var response map[string]interface{}
+				err := json.Unmarshal([]byte(body), &response)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(response["reason"]).To(Equal("invalid filters or csvPath must exist, not both"))
+			})
+
+			It("should return 422 if neither filters nor csvPath are specified", func() {
+				payload := GetAudiencePayload()
+				delete(payload, "filters")
+				pl, _ := json.Marshal(payload)
+				status, _ := Post(app, baseRoute, string(pl), "success@test.com")
+				Expect(status).To(Equal(http.StatusUnprocessableEntity))
+			})
+
+			It("should return 422 if the service is invalid", func() {
+				payload := GetAudiencePayload(map[string]interface{}{"service": "blabla"})
+				pl, _ := json.Marshal(payload)
+				status, body := Post(app, baseRoute, string(pl), "success@test.com")
+				Expect(status).To(Equal(http.StatusUnprocessableEntity))
+
+				var response map[string]interface{}
+				err := json.Unmarshal([]byte(body), &response)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(response["reason"]).To(Equal("invalid service"))
+			})
+
+			It("should return 409 if the app already has an audience with the same name", func() {
+				existingAudience := CreateTestAudience(app.DB, existingApp.ID)
+				payload := GetAudiencePayload(map[string]interface{}{"name": existingAudience.Name})
+				pl, _ := json.Marshal(payload)
+				status, _ := Post(app, baseRoute, string(pl), "success@test.com")
+				Expect(status).To(Equal(http.StatusConflict))
+			})
+
+			It("should return 401 if no authenticated user", func() {
+				pl, _ := json.Marshal(GetAudiencePayload())
+				status, _ := Post(app, baseRoute, string(pl), "")
+				Expect(status).To(Equal(http.StatusUnauthorized))
+			})
+		})
+	})
+
+	Describe("Get /apps/:id/audiences/:audid", func() {
+		It("should return 200 and the audience", func() {
+			existingAudience := CreateTestAudience(app.DB, existingApp.ID, map[string]interface{}{"size": 10})
+			status, body := Get(app, fmt.Sprintf("%s/%s", baseRoute, existingAudience.ID), "test@test.com")
+			Expect(status).To(Equal(http.StatusOK))
+
+			var audience map[string]interface{}
+			err := json.Unmarshal([]byte(body), &audience)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(audience["id"]).To(Equal(existingAudience.ID.String()))
+			Expect(audience["name"]).To(Equal(existingAudience.Name))
+			Expect(audience["size"]).To(BeEquivalentTo(10))
+		})
+
+		It("should return 404 if the audience does not exist", func() {
+			status, _ := Get(app, fmt.Sprintf("%s/%s", baseRoute, uuid.NewV4()), "test@test.com")
+			Expect(status).To(Equal(http.StatusNotFound))
+		})
+
+		It("should return 404 if the audience is of another app", func() {
+			anotherApp := CreateTestApp(app.DB)
+			existingAudience := CreateTestAudience(app.DB, anotherApp.ID)
+			status, _ := Get(app, fmt.Sprintf("%s/%s", baseRoute, existingAudience.ID), "test@test.com")
+			Expect(status).To(Equal(http.StatusNotFound))
+		})
+	})
+
+	Describe("Put /apps/:id/audiences/:audid", func() {
+		It("should return 200 and the updated audience with its size reset", func() {
+			existingAudience := CreateTestAudience(app.DB, existingApp.ID, map[string]interface{}{"size": 10})
+			payload := GetAudiencePayload(map[string]interface{}{
+				"name":    "new name",
+				"filters": map[string]interface{}{"region": "br"},
+			})
+			pl, _ := json.Marshal(payload)
+			status, body := Put(app, fmt.Sprintf("%s/%s", baseRoute, existingAudience.ID), string(pl), "success@test.com")
+			Expect(status).To(Equal(http.StatusOK))
+
+			var audience map[string]interface{}
+			err := json.Unmarshal([]byte(body), &audience)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(audience["name"]).To(Equal("new name"))
+			Expect(audience["size"]).To(BeEquivalentTo(0))
+
+			dbAudience := &model.Audience{ID: existingAudience.ID}
+			err = app.DB.Select(&dbAudience)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(dbAudience.Name).To(Equal("new name"))
+			Expect(dbAudience.Size).To(Equal(0))
+			Expect(dbAudience.Filters).To(HaveKey("region"))
+
+			expectAudienceSizeMessage(existingAudience.ID.String())
+		})
+
+		It("should return 404 if the audience does not exist", func() {
+			pl, _ := json.Marshal(GetAudiencePayload())
+			status, _ := Put(app, fmt.Sprintf("%s/%s", baseRoute, uuid.NewV4()), string(pl), "success@test.com")
+			Expect(status).To(Equal(http.StatusNotFound))
+		})
+	})
+
+	Describe("Post /apps/:id/audiences/:audid/size", func() {
+		It("should return 202 and send the audience to audience_size_worker", func() {
+			existingAudience := CreateTestAudience(app.DB, existingApp.ID)
+			status, _ := Post(app, fmt.Sprintf("%s/%s/size", baseRoute, existingAudience.ID), "", "test@test.com")
+			Expect(status).To(Equal(http.StatusAccepted))
+
+			expectAudienceSizeMessage(existingAudience.ID.String())
+		})
+
+		It("should return 404 if the audience does not exist", func() {
+			status, _ := Post(app, fmt.Sprintf("%s/%s/size", baseRoute, uuid.NewV4()), "", "test@test.com")
+			Expect(status).To(Equal(http.StatusNotFound))
+		})
+	})
+
+	Describe("Delete /apps/:id/audiences/:audid", func() {
+		It("should return 204 and keep the jobs created with the audience", func() {
+			existingAudience := CreateTestAudience(app.DB, existingApp.ID)
+			existingTemplate := CreateTestTemplate(app.DB, existingApp.ID)
+			job := CreateTestJob(app.DB, existingApp.ID, existingTemplate.Name)
+			_, err := app.DB.Model(&model.Job{}).Set("audience_id = ?", existingAudience.ID).Where("id = ?", job.ID).Update()
+			Expect(err).NotTo(HaveOccurred())
+
+			status, _ := Delete(app, fmt.Sprintf("%s/%s", baseRoute, existingAudience.ID), "test@test.com")
+			Expect(status).To(Equal(http.StatusNoContent))
+
+			dbJob := &model.Job{ID: job.ID}
+			err = app.DB.Select(&dbJob)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(dbJob.AudienceID).To(BeNil())
+		})
+
+		It("should return 404 if the audience does not exist", func() {
+			status, _ := Delete(app, fmt.Sprintf("%s/%s", baseRoute, uuid.NewV4()), "test@test.com")
+			Expect(status).To(Equal(http.StatusNotFound))
+		})
+	})
+})
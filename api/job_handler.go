@@ -23,22 +23,30 @@
 package api
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"strings"
 	"time"
 
 	"gopkg.in/pg.v5/types"
+	redis "gopkg.in/redis.v5"
 
 	"github.com/labstack/echo"
 	"github.com/satori/go.uuid"
+	"github.com/topfreegames/marathon/extensions"
 	"github.com/topfreegames/marathon/log"
 	"github.com/topfreegames/marathon/model"
 	"github.com/topfreegames/marathon/worker"
 	"github.com/uber-go/zap"
 )
 
-// ListJobsHandler is the method called when a get to /apps/:aid/templates/:templateName/jobs is called
+// ListJobsHandler is the method called when a get to /apps/:aid/jobs is called
 func (a *Application) ListJobsHandler(c echo.Context) error {
 	l := a.Logger.With(
 		zap.String("source", "jobHandler"),
@@ -50,134 +58,945 @@ func (a *Application) ListJobsHandler(c echo.Context) error {
 	if err != nil {
 		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error()})
 	}
-	templateName := c.QueryParam("template")
+	page, err := getJobsPage(c)
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error()})
+	}
 	jobs := []model.Job{}
-	query := a.DB.Model(&jobs).Column("job.*", "App").Where("job.app_id = ?", aid)
-	if templateName != "" {
-		query.Where("job.template_name = ?", templateName)
+	query, err := applyJobsFilters(c, a.DB.Model(&jobs).Column("job.*", "App").Where("job.app_id = ?", aid))
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error()})
+	}
+	countQuery, _ := applyJobsFilters(c, a.DB.Model(&model.Job{}).Where("job.app_id = ?", aid))
+	var count int
+	err = WithSegment("db-select", c, func() error {
+		count, err = countQuery.Count()
+		if err != nil {
+			return err
+		}
+		return page.apply(query).Select()
+	})
+	if err != nil {
+		log.E(l, "Failed to list jobs.", func(cm log.CM) {
+			cm.Write(zap.Error(err))
+		})
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error()})
+	}
+	jobs = page.setHeaders(c, jobs, count)
+	log.D(l, "Listed jobs successfully.", func(cm log.CM) {
+		cm.Write(zap.Object("jobs", jobs))
+	})
+	return c.JSON(http.StatusOK, jobs)
+}
+
+// filterValueCase returns the function that converts the filter values of a column to the case of value, a value
+// of the column in the push db
+func filterValueCase(value, column string) (func(string) string, error) {
+	isUpperCase := strings.ToUpper(value) == value
+	isLowerCase := strings.ToLower(value) == value
+	if isUpperCase && !isLowerCase {
+		return strings.ToUpper, nil
+	}
+	if isLowerCase && !isUpperCase {
+		return strings.ToLower, nil
+	}
+	return nil, fmt.Errorf("%s case check failed in Push DB", column)
+}
+
+// normalizeJobFilters converts the values of the locale and region conditions of the filters, flat or filter tree,
+// to the case used in the push db
+func (a *Application) normalizeJobFilters(app *model.App, service string, filters map[string]interface{}) error {
+	filter, err := model.ParseFilters(filters)
+	if err != nil {
+		return err
+	}
+	usesLocale := false
+	usesRegion := false
+	for _, condition := range filter.Conditions() {
+		usesLocale = usesLocale || condition.Column == "locale"
+		usesRegion = usesRegion || condition.Column == "region"
+	}
+	if !usesLocale && !usesRegion {
+		return nil
+	}
+	var users []worker.User
+	query := fmt.Sprintf("SELECT locale, region FROM %s WHERE locale is not NULL AND region is not NULL LIMIT 1;", worker.GetPushDBTableName(app.Name, service))
+	a.PushDB.Query(&users, query)
+	if len(users) != 1 {
+		return fmt.Errorf("Failed to check filters in Push DB")
+	}
+
+	mappers := map[string]func(string) string{}
+	if usesLocale {
+		mappers["locale"], err = filterValueCase(users[0].Locale, "Locale")
+		if err != nil {
+			return err
+		}
+	}
+	if usesRegion {
+		mappers["region"], err = filterValueCase(users[0].Region, "Region")
+		if err != nil {
+			return err
+		}
+	}
+	normalized, err := model.MapFilterValues(filters, mappers)
+	if err != nil {
+		return err
+	}
+	for key := range filters {
+		delete(filters, key)
+	}
+	for key, value := range normalized {
+		filters[key] = value
+	}
+	return nil
+}
+
+// applyJobAudience copies the filters and csvPath of the audience of the job, if it has one, to the job
+// It returns the status code to be sent if it fails
+func (a *Application) applyJobAudience(c echo.Context, aid uuid.UUID, job *model.Job) (int, error) {
+	if job.AudienceID == nil {
+		return http.StatusOK, nil
+	}
+	audience, err := a.getAudience(c, aid, *job.AudienceID)
+	if err != nil {
+		if err.Error() == RecordNotFoundString {
+			return http.StatusUnprocessableEntity, fmt.Errorf("audience not found with given id")
+		}
+		return http.StatusInternalServerError, err
+	}
+	if audience.Service != job.Service {
+		return http.StatusUnprocessableEntity, fmt.Errorf("invalid service: the audience is of service %s", audience.Service)
+	}
+	job.Filters = audience.Filters
+	job.CSVPath = audience.CSVPath
+	return http.StatusOK, nil
+}
+
+// IdempotencyKeyHeader is the request header with the key that makes job creation idempotent
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// setJobSampleSalt sets the job id as the salt of sampled jobs without one, so that the jobs that expand
+// its sample put each user in the same position
+func setJobSampleSalt(job *model.Job) {
+	if job.SampleRate > 0 && job.SampleSalt == "" {
+		job.SampleSalt = job.ID.String()
+	}
+}
+
+// hashJobRequest returns a hash of the job creation request, used to tell if a request
+// repeated with the same idempotency key is the same request
+func hashJobRequest(c echo.Context, templateName string) (string, error) {
+	body, err := ioutil.ReadAll(c.Request().Body)
+	if err != nil {
+		return "", err
+	}
+	c.Request().Body.Close()
+	c.Request().Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	// the body is re-encoded so that key order and whitespace do not change the hash
+	var payload interface{}
+	canonical := body
+	if err := json.Unmarshal(body, &payload); err == nil {
+		canonical, err = json.Marshal(payload)
+		if err != nil {
+			return "", err
+		}
+	}
+	hash := sha256.New()
+	hash.Write([]byte(templateName))
+	hash.Write([]byte{0})
+	hash.Write(canonical)
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func (a *Application) getIdempotentJob(c echo.Context, aid uuid.UUID, idempotencyKey string) (*model.Job, error) {
+	job := &model.Job{}
+	err := WithSegment("db-select", c, func() error {
+		return a.DB.Model(job).Column("job.*").Where("job.app_id = ?", aid).Where("job.idempotency_key = ?", idempotencyKey).First()
+	})
+	if err != nil {
+		if err.Error() == RecordNotFoundString {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return job, nil
+}
+
+func respondIdempotentJob(c echo.Context, job *model.Job, requestHash string) error {
+	if job.RequestHash != requestHash {
+		return c.JSON(http.StatusConflict, &Error{Reason: "idempotency key already used by a different request", Value: job})
+	}
+	return c.JSON(http.StatusOK, job)
+}
+
+// PostJobHandler is the method called when a post to /apps/:aid/templates/:templateName/jobs is called
+func (a *Application) PostJobHandler(c echo.Context) error {
+	l := a.Logger.With(
+		zap.String("source", "jobHandler"),
+		zap.String("operation", "postJob"),
+		zap.String("appId", c.Param("aid")),
+		zap.String("template", c.QueryParam("template")),
+	)
+	aid, err := uuid.FromString(c.Param("aid"))
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error()})
+	}
+
+	app := &model.App{ID: aid}
+	err = WithSegment("db-select", c, func() error {
+		return a.DB.Select(&app)
+	})
+	if err != nil {
+		if err.Error() == RecordNotFoundString {
+			return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: "App not found with given id."})
+		}
+		log.E(l, "Failed to retrieve app.", func(cm log.CM) {
+			cm.Write(zap.Error(err))
+		})
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error(), Value: app})
+	}
+
+	templateName := c.QueryParam("template")
+	if templateName == "" {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: "template name must be specified"})
+	}
+	idempotencyKey := c.Request().Header.Get(IdempotencyKeyHeader)
+	var requestHash string
+	if idempotencyKey != "" {
+		requestHash, err = hashJobRequest(c, templateName)
+		if err != nil {
+			return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error()})
+		}
+		existingJob, err := a.getIdempotentJob(c, aid, idempotencyKey)
+		if err != nil {
+			log.E(l, "Failed to retrieve job by idempotency key.", func(cm log.CM) {
+				cm.Write(zap.Error(err))
+			})
+			return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error()})
+		}
+		if existingJob != nil {
+			return respondIdempotentJob(c, existingJob, requestHash)
+		}
+	}
+
+	email := c.Get("user-email").(string)
+	job := &model.Job{
+		ID:             uuid.NewV4(),
+		AppID:          aid,
+		TemplateName:   templateName,
+		IdempotencyKey: idempotencyKey,
+		RequestHash:    requestHash,
+		CreatedBy:      email,
+		CreatedAt:      time.Now().UnixNano(),
+		UpdatedAt:      time.Now().UnixNano(),
+	}
+	err = WithSegment("decodeAndValidate", c, func() error {
+		return decodeAndValidate(c, job)
+	})
+
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error(), Value: job})
+	}
+	setJobSampleSalt(job)
+
+	status, err := a.applyJobAudience(c, aid, job)
+	if err != nil {
+		if status == http.StatusInternalServerError {
+			log.E(l, "Failed to retrieve job audience.", func(cm log.CM) {
+				cm.Write(zap.Error(err))
+			})
+		}
+		return c.JSON(status, &Error{Reason: err.Error(), Value: job})
+	}
+
+	err = WithSegment("normalize-filters", c, func() error {
+		return a.normalizeJobFilters(app, job.Service, job.Filters)
+	})
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error()})
+	}
+
+	template := &model.Template{}
+	err = WithSegment("db-select", c, func() error {
+		return a.DB.Model(&template).Column("template.*").Where("template.app_id = ?", aid).Where("template.name = ?", templateName).First()
+	})
+	if err != nil {
+		if err.Error() == RecordNotFoundString {
+			return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error(), Value: job})
+		}
+		log.E(l, "Failed to create job.", func(cm log.CM) {
+			cm.Write(zap.Error(err))
+		})
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error(), Value: job})
+	}
+
+	err = WithSegment("db-select", c, func() error {
+		job.TemplateVersions, err = worker.GetTemplateVersions(a.DB, aid, templateName)
+		return err
+	})
+	if err != nil {
+		log.E(l, "Failed to retrieve template versions.", func(cm log.CM) {
+			cm.Write(zap.Error(err))
+		})
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error(), Value: job})
+	}
+
+	err = WithSegment("db-insert", c, func() error {
+		return a.DB.Insert(&job)
+	})
+
+	if err != nil {
+		if strings.Contains(err.Error(), "duplicate key") {
+			if idempotencyKey != "" {
+				// a concurrent request with the same idempotency key created the job first
+				existingJob, err := a.getIdempotentJob(c, aid, idempotencyKey)
+				if err == nil && existingJob != nil {
+					return respondIdempotentJob(c, existingJob, requestHash)
+				}
+			}
+			return c.JSON(http.StatusConflict, job)
+		}
+		if strings.Contains(err.Error(), "violates foreign key constraint") {
+			return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error(), Value: job})
+		}
+		log.E(l, "Failed to create job.", func(cm log.CM) {
+			cm.Write(zap.Error(err))
+		})
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error(), Value: job})
+	}
+	log.D(l, "job successfully created! creating job in create_batches_worker")
+	var wJobID string
+	err = WithSegment("create-job", c, func() error {
+		wJobID, err = a.Worker.EnqueueJob(job)
+		return err
+	})
+
+	if err != nil {
+		a.DB.Delete(&job)
+		log.E(l, "Failed to send job to create_batches_worker.", func(cm log.CM) {
+			cm.Write(zap.Error(err))
+		})
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error(), Value: job})
+	}
+	log.I(l, "Job successfully sent to create_batches_worker", func(cm log.CM) {
+		cm.Write(zap.String("workerJobId", wJobID))
+	})
+
+	if a.SendgridClient != nil {
+		log.D(l, "sending email with job info")
+		app := &model.App{ID: aid}
+		a.DB.Select(&app)
+
+		err := SendCreatedJobEmail(a.SendgridClient, job, app)
+		if err != nil {
+			log.E(l, "Failed to send email with job info.", func(cm log.CM) {
+				cm.Write(zap.Error(err))
+			})
+		}
+		log.I(l, "Successfully sent email with job info.")
+
+	}
+	return c.JSON(http.StatusCreated, job)
+}
+
+// JobPreview is the result of a job dry-run
+type JobPreview struct {
+	TotalUsers int                        `json:"totalUsers"`
+	Buckets    []worker.UsersBucket       `json:"buckets"`
+	Messages   map[string]json.RawMessage `json:"messages"`
+}
+
+func (a *Application) getJobPreviewBuckets(app *model.App, job *model.Job) (int, []worker.UsersBucket, error) {
+	if len(job.CSVPath) == 0 {
+		totalUsers, err := worker.CountUsersFromFilters(a.PushDB, app.Name, job.Service, job.Filters)
+		if err != nil {
+			return 0, nil, err
+		}
+		buckets, err := worker.GetUsersBucketsFromFilters(a.PushDB, app.Name, job.Service, job.Filters)
+		return totalUsers, buckets, err
+	}
+
+	csvFile, err := extensions.S3GetObject(a.S3Client, job.CSVPath)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer (*csvFile).Close()
+	csvBytes, err := ioutil.ReadAll(*csvFile)
+	if err != nil {
+		return 0, nil, err
+	}
+	userIds, err := worker.ReadUserIDsFromCSV(csvBytes)
+	if err != nil {
+		return 0, nil, err
+	}
+	dbPageSize := a.Config.GetInt("workers.createBatches.dbPageSize")
+	if dbPageSize <= 0 {
+		dbPageSize = len(userIds)
+	}
+	buckets := []worker.UsersBucket{}
+	for start := 0; start < len(userIds); start += dbPageSize {
+		end := start + dbPageSize
+		if end > len(userIds) {
+			end = len(userIds)
+		}
+		pageBuckets, err := worker.GetUsersBucketsFromUserIDs(a.PushDB, app.Name, job.Service, userIds[start:end])
+		if err != nil {
+			return 0, nil, err
+		}
+		buckets = append(buckets, pageBuckets...)
+	}
+	buckets = worker.MergeUsersBuckets(buckets)
+	return len(userIds), buckets, nil
+}
+
+// PreviewJobHandler is the method called when a post to /apps/:aid/jobs/preview is called
+func (a *Application) PreviewJobHandler(c echo.Context) error {
+	l := a.Logger.With(
+		zap.String("source", "jobHandler"),
+		zap.String("operation", "previewJob"),
+		zap.String("appId", c.Param("aid")),
+		zap.String("template", c.QueryParam("template")),
+	)
+	aid, err := uuid.FromString(c.Param("aid"))
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error()})
+	}
+
+	app := &model.App{ID: aid}
+	err = WithSegment("db-select", c, func() error {
+		return a.DB.Select(&app)
+	})
+	if err != nil {
+		if err.Error() == RecordNotFoundString {
+			return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: "App not found with given id."})
+		}
+		log.E(l, "Failed to retrieve app.", func(cm log.CM) {
+			cm.Write(zap.Error(err))
+		})
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error(), Value: app})
+	}
+
+	templateName := c.QueryParam("template")
+	if templateName == "" {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: "template name must be specified"})
+	}
+	email := c.Get("user-email").(string)
+	job := &model.Job{
+		ID:           uuid.NewV4(),
+		AppID:        aid,
+		TemplateName: templateName,
+		CreatedBy:    email,
+		CreatedAt:    time.Now().UnixNano(),
+		UpdatedAt:    time.Now().UnixNano(),
+	}
+	err = WithSegment("decodeAndValidate", c, func() error {
+		return decodeAndValidate(c, job)
+	})
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error(), Value: job})
+	}
+
+	status, err := a.applyJobAudience(c, aid, job)
+	if err != nil {
+		if status == http.StatusInternalServerError {
+			log.E(l, "Failed to retrieve job audience.", func(cm log.CM) {
+				cm.Write(zap.Error(err))
+			})
+		}
+		return c.JSON(status, &Error{Reason: err.Error(), Value: job})
+	}
+
+	err = WithSegment("normalize-filters", c, func() error {
+		return a.normalizeJobFilters(app, job.Service, job.Filters)
+	})
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error()})
+	}
+
+	templates := []model.Template{}
+	err = WithSegment("db-select", c, func() error {
+		return a.DB.Model(&templates).Where("app_id = ? AND name = ?", aid, templateName).Select()
+	})
+	if err != nil {
+		log.E(l, "Failed to retrieve templates.", func(cm log.CM) {
+			cm.Write(zap.Error(err))
+		})
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error(), Value: job})
+	}
+	if len(templates) == 0 {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: RecordNotFoundString, Value: job})
+	}
+
+	preview := &JobPreview{Messages: map[string]json.RawMessage{}}
+	for _, template := range templates {
+		msgStr, err := worker.BuildMessageFromTemplate(template.ServiceTemplate(job.Service), job.Context, nil, "")
+		if err != nil {
+			return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: fmt.Sprintf("template %s: %s", template.Locale, err.Error()), Value: job})
+		}
+		var msg map[string]interface{}
+		err = json.Unmarshal([]byte(msgStr), &msg)
+		if err != nil {
+			return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: fmt.Sprintf("template %s: %s", template.Locale, err.Error()), Value: job})
+		}
+		pushMessage, err := worker.BuildPushMessage(job.Service, "", msg, job.Metadata, worker.BuildPushMetadata(job, worker.User{}), job.ExpiresAt)
+		if err != nil {
+			return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error(), Value: job})
+		}
+		preview.Messages[template.Locale] = json.RawMessage(pushMessage)
+	}
+
+	err = WithSegment("push-db-select", c, func() error {
+		preview.TotalUsers, preview.Buckets, err = a.getJobPreviewBuckets(app, job)
+		return err
+	})
+	if err != nil {
+		log.E(l, "Failed to count job users.", func(cm log.CM) {
+			cm.Write(zap.Error(err))
+		})
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error(), Value: job})
+	}
+	log.D(l, "Previewed job successfully.", func(cm log.CM) {
+		cm.Write(zap.Int("totalUsers", preview.TotalUsers))
+	})
+	return c.JSON(http.StatusOK, preview)
+}
+
+// GetJobHandler is the method called when a get to /apps/:aid/templates/:templateName/jobs/:jid is called
+func (a *Application) GetJobHandler(c echo.Context) error {
+	l := a.Logger.With(
+		zap.String("source", "jobHandler"),
+		zap.String("operation", "getJob"),
+		zap.String("appId", c.Param("aid")),
+		zap.String("jobId", c.Param("jid")),
+	)
+	aid, err := uuid.FromString(c.Param("aid"))
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error()})
+	}
+	jid, err := uuid.FromString(c.Param("jid"))
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error()})
+	}
+	job := &model.Job{
+		ID:    jid,
+		AppID: aid,
+	}
+	err = WithSegment("db-select", c, func() error {
+		return a.DB.Model(&job).Column("job.*", "App").Where("job.id = ?", job.ID).Select()
+	})
+	if err != nil {
+		if err.Error() == RecordNotFoundString {
+			return c.JSON(http.StatusNotFound, job)
+		}
+		log.E(l, "Failed to retrieve job.", func(cm log.CM) {
+			cm.Write(zap.Error(err))
+		})
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error(), Value: job})
+	}
+	log.D(l, "Retrieved job successfully.", func(cm log.CM) {
+		cm.Write(zap.Object("job", job))
+	})
+	return c.JSON(http.StatusOK, job)
+}
+
+// PutJobHandler is the method called when a put to /apps/:aid/jobs/:jid is called
+// A job can only be edited while its create batches message is still waiting in the workers
+func (a *Application) PutJobHandler(c echo.Context) error {
+	l := a.Logger.With(
+		zap.String("source", "jobHandler"),
+		zap.String("operation", "putJob"),
+		zap.String("appId", c.Param("aid")),
+		zap.String("jobId", c.Param("jid")),
+		zap.String("template", c.QueryParam("template")),
+	)
+	aid, err := uuid.FromString(c.Param("aid"))
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error()})
+	}
+	jid, err := uuid.FromString(c.Param("jid"))
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error()})
+	}
+	prevJob := &model.Job{}
+	err = WithSegment("db-select", c, func() error {
+		return a.DB.Model(&prevJob).Column("job.*", "App").Where("job.id = ?", jid).Where("job.app_id = ?", aid).Select()
+	})
+	if err != nil {
+		if err.Error() == RecordNotFoundString {
+			return c.JSON(http.StatusNotFound, &Error{Reason: err.Error()})
+		}
+		log.E(l, "Failed to retrieve job.", func(cm log.CM) {
+			cm.Write(zap.Error(err))
+		})
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error(), Value: prevJob})
+	}
+	if prevJob.TotalBatches != 0 {
+		return c.JSON(http.StatusForbidden, &Error{Reason: "cannot edit job that already created batches"})
+	}
+	if prevJob.Status != "" {
+		return c.JSON(http.StatusForbidden, &Error{Reason: fmt.Sprintf("cannot edit %s job", prevJob.Status)})
+	}
+
+	templateName := c.QueryParam("template")
+	if templateName == "" {
+		templateName = prevJob.TemplateName
+	}
+	job := &model.Job{
+		ID:           jid,
+		AppID:        aid,
+		TemplateName: templateName,
+		CreatedBy:    prevJob.CreatedBy,
+		CreatedAt:    prevJob.CreatedAt,
+		UpdatedAt:    time.Now().UnixNano(),
+	}
+	err = WithSegment("decodeAndValidate", c, func() error {
+		return decodeAndValidate(c, job)
+	})
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error(), Value: job})
+	}
+	job.ID = jid
+	job.AppID = aid
+	job.App = prevJob.App
+	job.CreatedBy = prevJob.CreatedBy
+	setJobSampleSalt(job)
+
+	status, err := a.applyJobAudience(c, aid, job)
+	if err != nil {
+		if status == http.StatusInternalServerError {
+			log.E(l, "Failed to retrieve job audience.", func(cm log.CM) {
+				cm.Write(zap.Error(err))
+			})
+		}
+		return c.JSON(status, &Error{Reason: err.Error(), Value: job})
+	}
+
+	err = WithSegment("normalize-filters", c, func() error {
+		return a.normalizeJobFilters(&prevJob.App, job.Service, job.Filters)
+	})
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error()})
 	}
+
+	template := &model.Template{}
 	err = WithSegment("db-select", c, func() error {
-		return query.Select()
+		return a.DB.Model(&template).Column("template.*").Where("template.app_id = ?", aid).Where("template.name = ?", templateName).First()
 	})
 	if err != nil {
-		log.E(l, "Failed to list jobs.", func(cm log.CM) {
+		if err.Error() == RecordNotFoundString {
+			return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error(), Value: job})
+		}
+		log.E(l, "Failed to retrieve template.", func(cm log.CM) {
 			cm.Write(zap.Error(err))
 		})
-		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error()})
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error(), Value: job})
 	}
-	log.D(l, "Listed jobs successfully.", func(cm log.CM) {
-		cm.Write(zap.Object("jobs", jobs))
+
+	err = WithSegment("db-select", c, func() error {
+		job.TemplateVersions, err = worker.GetTemplateVersions(a.DB, aid, templateName)
+		return err
 	})
-	return c.JSON(http.StatusOK, jobs)
+	if err != nil {
+		log.E(l, "Failed to retrieve template versions.", func(cm log.CM) {
+			cm.Write(zap.Error(err))
+		})
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error(), Value: job})
+	}
+
+	// the job is claimed by removing its create batches message, so that no worker starts it while it is edited
+	var removed int
+	err = WithSegment("remove-job", c, func() error {
+		removed, err = a.Worker.RemoveCreateBatchesMessages(jid.String())
+		return err
+	})
+	if err != nil {
+		log.E(l, "Failed to remove job from create batches workers.", func(cm log.CM) {
+			cm.Write(zap.Error(err))
+		})
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error(), Value: job})
+	}
+	if removed == 0 {
+		return c.JSON(http.StatusForbidden, &Error{Reason: "cannot edit job that already started creating batches"})
+	}
+
+	err = WithSegment("db-update", c, func() error {
+		_, err = a.DB.Model(&job).
+			Column("template_name", "template_versions", "localized", "expires_at", "starts_at", "context", "service", "filters").
+			Column("metadata", "csv_path", "audience_id", "past_time_strategy", "max_pushes_per_second", "priority").
+			Column("sample_rate", "sample_from", "sample_salt", "updated_at").
+			Returning("*").
+			Update()
+		return err
+	})
+	if err != nil {
+		log.E(l, "Failed to update job.", func(cm log.CM) {
+			cm.Write(zap.Error(err))
+		})
+		// the job was not changed so it is sent to the workers as it was
+		job = prevJob
+	}
+
+	var wJobID string
+	enqueueErr := WithSegment("create-job", c, func() error {
+		var err error
+		wJobID, err = a.Worker.EnqueueJob(job)
+		return err
+	})
+	if enqueueErr != nil {
+		log.E(l, "Failed to send job to create_batches_worker.", func(cm log.CM) {
+			cm.Write(zap.Error(enqueueErr))
+		})
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: enqueueErr.Error(), Value: job})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error(), Value: job})
+	}
+	log.I(l, "Job successfully updated and sent to create_batches_worker", func(cm log.CM) {
+		cm.Write(zap.String("workerJobId", wJobID))
+	})
+	return c.JSON(http.StatusOK, job)
 }
 
-// PostJobHandler is the method called when a post to /apps/:aid/templates/:templateName/jobs is called
-func (a *Application) PostJobHandler(c echo.Context) error {
-	l := a.Logger.With(
-		zap.String("source", "jobHandler"),
-		zap.String("operation", "postJob"),
-		zap.String("appId", c.Param("aid")),
-		zap.String("template", c.QueryParam("template")),
-	)
+// jobOverrideFields are the fields of the job that the body of the routes that create a job from an existing
+// one can replace. The other fields of the body, like the status and the counters, are ignored
+var jobOverrideFields = map[string]bool{
+	"startsAt":           true,
+	"expiresAt":          true,
+	"context":            true,
+	"metadata":           true,
+	"filters":            true,
+	"csvPath":            true,
+	"audienceId":         true,
+	"localized":          true,
+	"pastTimeStrategy":   true,
+	"maxPushesPerSecond": true,
+	"priority":           true,
+	"sampleRate":         true,
+}
+
+// cloneJobPayload returns the payload of a new job with the configuration of the given job, replaced by the
+// given overrides. The audience of the job is used again instead of the filters or csvPath it had when the job
+// was created. AudienceId, filters and csvPath exclude each other, so overriding one of them drops the others.
+// The sample of the job is not kept, a sampled clone gets a new salt so it samples other users
+func cloneJobPayload(job *model.Job, overrides map[string]interface{}) map[string]interface{} {
+	payload := map[string]interface{}{
+		"localized":          job.Localized,
+		"context":            job.Context,
+		"service":            job.Service,
+		"filters":            job.Filters,
+		"metadata":           job.Metadata,
+		"csvPath":            job.CSVPath,
+		"pastTimeStrategy":   job.PastTimeStrategy,
+		"maxPushesPerSecond": job.MaxPushesPerSecond,
+		"priority":           job.Priority,
+		"sampleRate":         job.SampleRate,
+	}
+	// the create batches from filters worker writes the csv of the users of a filters job in its csvPath
+	if len(job.Filters) > 0 {
+		delete(payload, "csvPath")
+	}
+	if job.AudienceID != nil {
+		payload["audienceId"] = job.AudienceID
+		delete(payload, "filters")
+		delete(payload, "csvPath")
+	}
+	if _, ok := overrides["csvPath"]; ok {
+		delete(payload, "filters")
+		delete(payload, "audienceId")
+	}
+	if _, ok := overrides["filters"]; ok {
+		delete(payload, "csvPath")
+		delete(payload, "audienceId")
+	}
+	if _, ok := overrides["audienceId"]; ok {
+		delete(payload, "filters")
+		delete(payload, "csvPath")
+	}
+	for key, value := range overrides {
+		payload[key] = value
+	}
+	return payload
+}
+
+// getSourceJob returns the job of the route and the overrides sent in the body of the routes that create a job
+// from an existing one. It returns the status code to be sent if it fails
+func (a *Application) getSourceJob(c echo.Context, l zap.Logger) (*model.Job, map[string]interface{}, int, error) {
 	aid, err := uuid.FromString(c.Param("aid"))
 	if err != nil {
-		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error()})
+		return nil, nil, http.StatusUnprocessableEntity, err
 	}
-
-	app := &model.App{ID: aid}
+	jid, err := uuid.FromString(c.Param("jid"))
+	if err != nil {
+		return nil, nil, http.StatusUnprocessableEntity, err
+	}
+	sourceJob := &model.Job{}
 	err = WithSegment("db-select", c, func() error {
-		return a.DB.Select(&app)
+		return a.DB.Model(&sourceJob).Column("job.*", "App").Where("job.id = ?", jid).Where("job.app_id = ?", aid).Select()
 	})
 	if err != nil {
 		if err.Error() == RecordNotFoundString {
-			return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: "App not found with given id."})
+			return nil, nil, http.StatusNotFound, err
 		}
-		log.E(l, "Failed to retrieve app.", func(cm log.CM) {
+		log.E(l, "Failed to retrieve job.", func(cm log.CM) {
 			cm.Write(zap.Error(err))
 		})
-		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error(), Value: app})
+		return nil, nil, http.StatusInternalServerError, err
 	}
 
-	templateName := c.QueryParam("template")
-	if templateName == "" {
-		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: "template name must be specified"})
+	overrides := map[string]interface{}{}
+	body, err := ioutil.ReadAll(c.Request().Body)
+	if err != nil {
+		return nil, nil, http.StatusUnprocessableEntity, err
 	}
-	email := c.Get("user-email").(string)
-	job := &model.Job{
-		ID:           uuid.NewV4(),
-		AppID:        aid,
-		TemplateName: templateName,
-		CreatedBy:    email,
-		CreatedAt:    time.Now().UnixNano(),
-		UpdatedAt:    time.Now().UnixNano(),
+	defer c.Request().Body.Close()
+	if len(bytes.TrimSpace(body)) > 0 {
+		if err = json.Unmarshal(body, &overrides); err != nil {
+			return nil, nil, http.StatusUnprocessableEntity, err
+		}
 	}
-	err = WithSegment("decodeAndValidate", c, func() error {
-		return decodeAndValidate(c, job)
-	})
+	for key := range overrides {
+		if !jobOverrideFields[key] {
+			delete(overrides, key)
+		}
+	}
+	return sourceJob, overrides, http.StatusOK, nil
+}
 
+// CloneJobHandler is the method called when a post to /apps/:aid/jobs/:jid/clone is called
+// It creates a new job with the configuration of an existing one, overriding the fields sent in the body
+func (a *Application) CloneJobHandler(c echo.Context) error {
+	l := a.Logger.With(
+		zap.String("source", "jobHandler"),
+		zap.String("operation", "cloneJob"),
+		zap.String("appId", c.Param("aid")),
+		zap.String("jobId", c.Param("jid")),
+		zap.String("template", c.QueryParam("template")),
+	)
+	sourceJob, overrides, status, err := a.getSourceJob(c, l)
 	if err != nil {
-		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error(), Value: job})
+		return c.JSON(status, &Error{Reason: err.Error()})
 	}
+	return a.createJobFromSource(c, l, sourceJob, overrides, false)
+}
 
-	if job.Filters["region"] != nil || job.Filters["NOTregion"] != nil || job.Filters["locale"] != nil || job.Filters["NOTlocale"] != nil {
-		var users []worker.User
-		query := fmt.Sprintf("SELECT locale, region FROM %s WHERE locale is not NULL AND region is not NULL LIMIT 1;", worker.GetPushDBTableName(app.Name, job.Service))
-		a.PushDB.Query(&users, query)
-		if len(users) != 1 {
-			return c.JSON(http.StatusInternalServerError, &Error{Reason: "Failed to check filters in Push DB"})
-		}
-		locale := users[0].Locale
-		region := users[0].Region
+// ExpandJobHandler is the method called when a post to /apps/:aid/jobs/:jid/expand is called
+// It creates a new job with the configuration of a sampled job, overriding the fields sent in the body, that is
+// sent only to the users between the sample rate of the job and the new sample rate, which defaults to 1
+func (a *Application) ExpandJobHandler(c echo.Context) error {
+	l := a.Logger.With(
+		zap.String("source", "jobHandler"),
+		zap.String("operation", "expandJob"),
+		zap.String("appId", c.Param("aid")),
+		zap.String("jobId", c.Param("jid")),
+		zap.String("template", c.QueryParam("template")),
+	)
+	sourceJob, overrides, status, err := a.getSourceJob(c, l)
+	if err != nil {
+		return c.JSON(status, &Error{Reason: err.Error()})
+	}
+	if sourceJob.SampleRate == 0 || sourceJob.SampleRate == 1 {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: "job was already sent to its whole audience"})
+	}
 
-		localeSettings := map[string]bool{
-			"isUpperCase": strings.ToUpper(locale) == locale,
-			"isLowerCase": strings.ToLower(locale) == locale,
+	sampleRate := 1.0
+	if value, ok := overrides["sampleRate"]; ok {
+		sampleRate, ok = value.(float64)
+		if !ok || sampleRate <= sourceJob.SampleRate || sampleRate > 1 {
+			return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: fmt.Sprintf("sampleRate must be greater than %v and at most 1", sourceJob.SampleRate)})
 		}
+	}
 
-		regionSettings := map[string]bool{
-			"isUpperCase": strings.ToUpper(region) == region,
-			"isLowerCase": strings.ToLower(region) == region,
-		}
+	overrides["sampleRate"] = sampleRate
+	overrides["sampleFrom"] = sourceJob.SampleRate
+	overrides["sampleSalt"] = sourceJob.SampleSalt
+	return a.createJobFromSource(c, l, sourceJob, overrides, true)
+}
 
-		if job.Filters["locale"] != nil {
-			if localeSettings["isUpperCase"] && !localeSettings["isLowerCase"] {
-				job.Filters["locale"] = strings.ToUpper(job.Filters["locale"].(string))
-			} else if localeSettings["isLowerCase"] && !localeSettings["isUpperCase"] {
-				job.Filters["locale"] = strings.ToLower(job.Filters["locale"].(string))
-			} else {
-				return c.JSON(http.StatusInternalServerError, &Error{Reason: "Locale case check failed in Push DB"})
-			}
-		}
+// insertJobExpansion inserts the job that expands the sample of sourceJob unless another job was already sent to
+// the users of its sample, which it returns. The source job is locked until the job is inserted, so concurrent
+// expansions of the same job can't both pass the check
+func (a *Application) insertJobExpansion(sourceJob, job *model.Job) (*model.Job, error) {
+	tx, err := a.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.Exec("SELECT id FROM jobs WHERE id = ? FOR UPDATE", sourceJob.ID)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	overlappingJob := &model.Job{}
+	err = tx.Model(overlappingJob).Column("job.id").
+		Where("job.app_id = ?", sourceJob.AppID).
+		Where("job.sample_salt = ?", sourceJob.SampleSalt).
+		Where("job.sample_rate > ?", sourceJob.SampleRate).
+		Where("coalesce(job.sample_from, 0) < ?", job.SampleRate).
+		First()
+	if err == nil {
+		tx.Rollback()
+		return overlappingJob, nil
+	}
+	if err.Error() != RecordNotFoundString {
+		tx.Rollback()
+		return nil, err
+	}
+	err = tx.Insert(job)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	return nil, tx.Commit()
+}
 
-		if job.Filters["NOTlocale"] != nil {
-			if localeSettings["isUpperCase"] && !localeSettings["isLowerCase"] {
-				job.Filters["NOTlocale"] = strings.ToUpper(job.Filters["NOTlocale"].(string))
-			} else if localeSettings["isLowerCase"] && !localeSettings["isUpperCase"] {
-				job.Filters["NOTlocale"] = strings.ToLower(job.Filters["NOTlocale"].(string))
-			} else {
-				return c.JSON(http.StatusInternalServerError, &Error{Reason: "Locale case check failed in Push DB"})
-			}
-		}
+// createJobFromSource creates a new job with the configuration of sourceJob replaced by the overrides and sends it
+// to the create batches workers. If isExpansion the job is only created if the users of its sample were not sent yet
+func (a *Application) createJobFromSource(c echo.Context, l zap.Logger, sourceJob *model.Job, overrides map[string]interface{}, isExpansion bool) error {
+	aid := sourceJob.AppID
+	payload, err := json.Marshal(cloneJobPayload(sourceJob, overrides))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error()})
+	}
 
-		if job.Filters["region"] != nil {
-			if regionSettings["isUpperCase"] && !regionSettings["isLowerCase"] {
-				job.Filters["region"] = strings.ToUpper(job.Filters["region"].(string))
-			} else if regionSettings["isLowerCase"] && !regionSettings["isUpperCase"] {
-				job.Filters["region"] = strings.ToLower(job.Filters["region"].(string))
-			} else {
-				return c.JSON(http.StatusInternalServerError, &Error{Reason: "Region case check failed in Push DB"})
-			}
-		}
+	templateName := c.QueryParam("template")
+	if templateName == "" {
+		templateName = sourceJob.TemplateName
+	}
+	job := &model.Job{}
+	err = json.Unmarshal(payload, job)
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error()})
+	}
+	job.ID = uuid.NewV4()
+	job.AppID = aid
+	job.TemplateName = templateName
+	job.CreatedBy = c.Get("user-email").(string)
+	job.CreatedAt = time.Now().UnixNano()
+	job.UpdatedAt = job.CreatedAt
+	err = WithSegment("validate", c, func() error {
+		return job.Validate(c)
+	})
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error(), Value: job})
+	}
+	setJobSampleSalt(job)
 
-		if job.Filters["NOTregion"] != nil {
-			if regionSettings["isUpperCase"] && !regionSettings["isLowerCase"] {
-				job.Filters["NOTregion"] = strings.ToUpper(job.Filters["NOTregion"].(string))
-			} else if regionSettings["isLowerCase"] && !regionSettings["isUpperCase"] {
-				job.Filters["NOTregion"] = strings.ToLower(job.Filters["NOTregion"].(string))
-			} else {
-				return c.JSON(http.StatusInternalServerError, &Error{Reason: "Region case check failed in Push DB"})
-			}
+	status, err := a.applyJobAudience(c, aid, job)
+	if err != nil {
+		if status == http.StatusInternalServerError {
+			log.E(l, "Failed to retrieve job audience.", func(cm log.CM) {
+				cm.Write(zap.Error(err))
+			})
 		}
+		return c.JSON(status, &Error{Reason: err.Error(), Value: job})
+	}
+
+	err = WithSegment("normalize-filters", c, func() error {
+		return a.normalizeJobFilters(&sourceJob.App, job.Service, job.Filters)
+	})
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error()})
 	}
 
 	template := &model.Template{}
@@ -188,47 +1007,46 @@ func (a *Application) PostJobHandler(c echo.Context) error {
 		if err.Error() == RecordNotFoundString {
 			return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error(), Value: job})
 		}
-		log.E(l, "Failed to create job.", func(cm log.CM) {
+		log.E(l, "Failed to retrieve template.", func(cm log.CM) {
 			cm.Write(zap.Error(err))
 		})
 		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error(), Value: job})
 	}
 
+	err = WithSegment("db-select", c, func() error {
+		job.TemplateVersions, err = worker.GetTemplateVersions(a.DB, aid, templateName)
+		return err
+	})
+	if err != nil {
+		log.E(l, "Failed to retrieve template versions.", func(cm log.CM) {
+			cm.Write(zap.Error(err))
+		})
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error(), Value: job})
+	}
+
+	// the users between the two sample rates must not be sent twice
+	var overlappingJob *model.Job
 	err = WithSegment("db-insert", c, func() error {
+		if isExpansion {
+			overlappingJob, err = a.insertJobExpansion(sourceJob, job)
+			return err
+		}
 		return a.DB.Insert(&job)
 	})
-
 	if err != nil {
-		if strings.Contains(err.Error(), "duplicate key") {
-			return c.JSON(http.StatusConflict, job)
-		}
-		if strings.Contains(err.Error(), "violates foreign key constraint") {
-			return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error(), Value: job})
-		}
 		log.E(l, "Failed to create job.", func(cm log.CM) {
 			cm.Write(zap.Error(err))
 		})
 		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error(), Value: job})
 	}
-	log.D(l, "job successfully created! creating job in create_batches_worker")
+	if overlappingJob != nil {
+		return c.JSON(http.StatusConflict, &Error{Reason: fmt.Sprintf("job sample was already expanded by job %s", overlappingJob.ID)})
+	}
 	var wJobID string
 	err = WithSegment("create-job", c, func() error {
-		if job.StartsAt != 0 && !job.Localized {
-			if len(job.CSVPath) > 0 {
-				wJobID, err = a.Worker.ScheduleCreateBatchesJob(&[]string{job.ID.String()}, job.StartsAt)
-			} else {
-				wJobID, err = a.Worker.ScheduleCreateBatchesFromFiltersJob(&[]string{job.ID.String()}, job.StartsAt)
-			}
-		} else {
-			if len(job.CSVPath) > 0 {
-				wJobID, err = a.Worker.CreateBatchesJob(&[]string{job.ID.String()})
-			} else {
-				wJobID, err = a.Worker.CreateBatchesFromFiltersJob(&[]string{job.ID.String()})
-			}
-		}
+		wJobID, err = a.Worker.EnqueueJob(job)
 		return err
 	})
-
 	if err != nil {
 		a.DB.Delete(&job)
 		log.E(l, "Failed to send job to create_batches_worker.", func(cm log.CM) {
@@ -236,32 +1054,47 @@ func (a *Application) PostJobHandler(c echo.Context) error {
 		})
 		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error(), Value: job})
 	}
-	log.I(l, "Job successfully sent to create_batches_worker", func(cm log.CM) {
-		cm.Write(zap.String("workerJobId", wJobID))
+	log.I(l, "Cloned job successfully sent to create_batches_worker", func(cm log.CM) {
+		cm.Write(
+			zap.String("clonedJobId", job.ID.String()),
+			zap.String("workerJobId", wJobID),
+		)
 	})
 
 	if a.SendgridClient != nil {
 		log.D(l, "sending email with job info")
-		app := &model.App{ID: aid}
-		a.DB.Select(&app)
-
-		err := SendCreatedJobEmail(a.SendgridClient, job, app)
+		err := SendCreatedJobEmail(a.SendgridClient, job, &sourceJob.App)
 		if err != nil {
 			log.E(l, "Failed to send email with job info.", func(cm log.CM) {
 				cm.Write(zap.Error(err))
 			})
 		}
-		log.I(l, "Successfully sent email with job info.")
-
 	}
 	return c.JSON(http.StatusCreated, job)
 }
 
-// GetJobHandler is the method called when a get to /apps/:aid/templates/:templateName/jobs/:jid is called
-func (a *Application) GetJobHandler(c echo.Context) error {
+// jobEventsHeartbeatInterval is the interval of the comments sent to keep idle job events streams open
+const jobEventsHeartbeatInterval = 15 * time.Second
+
+func writeServerSentEvent(res *echo.Response, event, data string) error {
+	_, err := fmt.Fprintf(res, "event: %s\ndata: %s\n\n", event, data)
+	if err != nil {
+		return err
+	}
+	res.Flush()
+	return nil
+}
+
+func isFinalJobEvent(event *model.JobEvent) bool {
+	return event.Type == model.JobStatusEvent && (event.Status == "stopped" || event.Status == model.CompletedJobStatus)
+}
+
+// JobEventsHandler is the method called when a get to /apps/:aid/jobs/:jid/events is called
+// It streams the job events as server-sent events until the job is stopped or completed or the client disconnects
+func (a *Application) JobEventsHandler(c echo.Context) error {
 	l := a.Logger.With(
 		zap.String("source", "jobHandler"),
-		zap.String("operation", "getJob"),
+		zap.String("operation", "jobEvents"),
 		zap.String("appId", c.Param("aid")),
 		zap.String("jobId", c.Param("jid")),
 	)
@@ -273,26 +1106,89 @@ func (a *Application) GetJobHandler(c echo.Context) error {
 	if err != nil {
 		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error()})
 	}
-	job := &model.Job{
-		ID:    jid,
-		AppID: aid,
-	}
+	job := &model.Job{}
 	err = WithSegment("db-select", c, func() error {
-		return a.DB.Model(&job).Column("job.*", "App").Where("job.id = ?", job.ID).Select()
+		return a.DB.Model(&job).Column("job.*").Where("job.id = ?", jid).Where("job.app_id = ?", aid).Select()
 	})
 	if err != nil {
 		if err.Error() == RecordNotFoundString {
-			return c.JSON(http.StatusNotFound, job)
+			return c.JSON(http.StatusNotFound, &Error{Reason: err.Error()})
 		}
 		log.E(l, "Failed to retrieve job.", func(cm log.CM) {
 			cm.Write(zap.Error(err))
 		})
-		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error(), Value: job})
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error()})
 	}
-	log.D(l, "Retrieved job successfully.", func(cm log.CM) {
-		cm.Write(zap.Object("job", job))
-	})
-	return c.JSON(http.StatusOK, job)
+
+	// the subscription is made before sending the current state of the job so that no event is lost in between
+	pubsub, err := a.Worker.RedisClient.Subscribe(model.JobEventsChannel(jid.String()))
+	if err != nil {
+		log.E(l, "Failed to subscribe to job events.", func(cm log.CM) {
+			cm.Write(zap.Error(err))
+		})
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error()})
+	}
+	defer pubsub.Close()
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set("Cache-Control", "no-cache")
+	res.Header().Set("Connection", "keep-alive")
+	res.WriteHeader(http.StatusOK)
+
+	current := model.NewJobEvent(model.JobProgressEvent, job)
+	if job.CompletedAt != 0 {
+		current.Type = model.JobStatusEvent
+		current.Status = model.CompletedJobStatus
+	} else if job.Status != "" {
+		current.Type = model.JobStatusEvent
+	}
+	data, _ := json.Marshal(current)
+	if err = writeServerSentEvent(res, current.Type, string(data)); err != nil || isFinalJobEvent(current) {
+		return nil
+	}
+	log.D(l, "Streaming job events.")
+
+	done := c.Request().Context().Done()
+	for {
+		select {
+		case <-done:
+			log.D(l, "Client closed job events stream.")
+			return nil
+		default:
+		}
+		msg, err := pubsub.ReceiveTimeout(jobEventsHeartbeatInterval)
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				if _, err = fmt.Fprint(res, ": heartbeat\n\n"); err != nil {
+					return nil
+				}
+				res.Flush()
+				continue
+			}
+			log.E(l, "Failed to receive job event.", func(cm log.CM) {
+				cm.Write(zap.Error(err))
+			})
+			return nil
+		}
+		message, ok := msg.(*redis.Message)
+		if !ok {
+			continue
+		}
+		event := &model.JobEvent{}
+		if err = json.Unmarshal([]byte(message.Payload), event); err != nil {
+			continue
+		}
+		if err = writeServerSentEvent(res, event.Type, message.Payload); err != nil || isFinalJobEvent(event) {
+			return nil
+		}
+	}
+}
+
+// purgedJob is the job returned by the routes that remove its messages from the workers
+type purgedJob struct {
+	*model.Job
+	PurgedMessages int `json:"purgedMessages"`
 }
 
 // PauseJobHandler is the method called when a put to apps/:id/jobs/:jid/pause is called
@@ -348,6 +1244,25 @@ func (a *Application) PauseJobHandler(c echo.Context) error {
 	log.D(l, "Updated job successfully.", func(cm log.CM) {
 		cm.Write(zap.Object("job", job))
 	})
+	worker.PublishJobEvent(a.Worker.RedisClient, l, model.NewJobEvent(model.JobStatusEvent, job))
+
+	purge := c.QueryParam("purge") == "true"
+	var purged int
+	if purge {
+		err = WithSegment("purge-job", c, func() error {
+			purged, err = a.Worker.MoveJobMessagesToPausedQueue(job.ID.String())
+			return err
+		})
+		if err != nil {
+			log.E(l, "Failed to move job messages to paused queue.", func(cm log.CM) {
+				cm.Write(zap.Error(err))
+			})
+			return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error(), Value: job})
+		}
+		log.I(l, "Moved job messages to paused queue.", func(cm log.CM) {
+			cm.Write(zap.Int("purgedMessages", purged))
+		})
+	}
 
 	if a.SendgridClient != nil {
 		log.D(l, "sending email with paused job info")
@@ -363,6 +1278,9 @@ func (a *Application) PauseJobHandler(c echo.Context) error {
 		}
 		log.I(l, "Successfully sent email with paused job info.")
 	}
+	if purge {
+		return c.JSON(http.StatusOK, &purgedJob{Job: job, PurgedMessages: purged})
+	}
 	return c.JSON(http.StatusOK, job)
 }
 
@@ -407,6 +1325,22 @@ func (a *Application) StopJobHandler(c echo.Context) error {
 	log.D(l, "Updated job successfully.", func(cm log.CM) {
 		cm.Write(zap.Object("job", job))
 	})
+	worker.PublishJobEvent(a.Worker.RedisClient, l, model.NewJobEvent(model.JobStatusEvent, job))
+
+	var purged int
+	err = WithSegment("purge-job", c, func() error {
+		purged, err = a.Worker.RemoveJobMessages(job.ID.String())
+		return err
+	})
+	if err != nil {
+		log.E(l, "Failed to remove job messages from workers.", func(cm log.CM) {
+			cm.Write(zap.Error(err))
+		})
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error(), Value: job})
+	}
+	log.I(l, "Removed job messages from workers.", func(cm log.CM) {
+		cm.Write(zap.Int("purgedMessages", purged))
+	})
 
 	if a.SendgridClient != nil {
 		log.D(l, "sending email with stopped job info")
@@ -421,7 +1355,7 @@ func (a *Application) StopJobHandler(c echo.Context) error {
 		}
 		log.I(l, "Successfully sent email with stopped job info.")
 	}
-	return c.JSON(http.StatusOK, job)
+	return c.JSON(http.StatusOK, &purgedJob{Job: job, PurgedMessages: purged})
 }
 
 // ResumeJobHandler is the method called when a put to apps/:id/jobs/:jid/resume is called
@@ -495,5 +1429,6 @@ func (a *Application) ResumeJobHandler(c echo.Context) error {
 	log.D(l, "Resumed job successfully.", func(cm log.CM) {
 		cm.Write(zap.Object("job", job))
 	})
+	worker.PublishJobEvent(a.Worker.RedisClient, l, model.NewJobEvent(model.JobStatusEvent, job))
 	return c.JSON(http.StatusOK, job)
 }
@@ -21,9 +21,12 @@
 package api_test
 
 import (
+	"bufio"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"time"
 
 	. "github.com/onsi/ginkgo"
@@ -75,6 +78,24 @@ var _ = Describe("Job Handler", func() {
 				Expect(response).To(HaveLen(0))
 			})
 
+			It("should return 200 and the jobs created for a recurring job", func() {
+				recurringJob := CreateTestRecurringJob(app.DB, existingApp.ID, existingTemplate.Name)
+				job := recurringJob.NewJob(recurringJob.NextRunAt)
+				err := app.DB.Insert(job)
+				Expect(err).NotTo(HaveOccurred())
+				CreateTestJobs(app.DB, existingApp.ID, existingTemplate.Name, 2)
+
+				status, body := Get(app, fmt.Sprintf("%s?recurringJob=%s", baseRouteWithoutTemplate, recurringJob.ID), "test@test.com")
+				Expect(status).To(Equal(http.StatusOK))
+
+				var response []map[string]interface{}
+				err = json.Unmarshal([]byte(body), &response)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(response).To(HaveLen(1))
+				Expect(response[0]["id"]).To(Equal(job.ID.String()))
+				Expect(response[0]["recurringJobId"]).To(Equal(recurringJob.ID.String()))
+			})
+
 			It("should return 200 and a list of jobs with template", func() {
 				testJobs := CreateTestJobs(app.DB, existingApp.ID, existingTemplate.Name, 10)
 				anotherTemplate := CreateTestTemplate(app.DB, existingApp.ID)
@@ -172,8 +193,170 @@ var _ = Describe("Job Handler", func() {
 		})
 	})
 
+	Describe("Get /apps/:id/jobs with pagination and filters", func() {
+		getJobs := func(query string) ([]map[string]interface{}, http.Header) {
+			status, body, headers := GetWithHeaders(app, fmt.Sprintf("%s?%s", baseRouteWithoutTemplate, query), "test@test.com")
+			Expect(status).To(Equal(http.StatusOK))
+			var response []map[string]interface{}
+			err := json.Unmarshal([]byte(body), &response)
+			Expect(err).NotTo(HaveOccurred())
+			return response, headers
+		}
+
+		It("should return the jobs in pages following the next cursor", func() {
+			testJobs := CreateTestJobs(app.DB, existingApp.ID, existingTemplate.Name, 5)
+
+			page, headers := getJobs("limit=2")
+			Expect(page).To(HaveLen(2))
+			Expect(page[0]["id"]).To(Equal(testJobs[0].ID.String()))
+			Expect(page[1]["id"]).To(Equal(testJobs[1].ID.String()))
+			Expect(headers.Get("X-Total-Count")).To(Equal("5"))
+			cursor := headers.Get("X-Next-Cursor")
+			Expect(cursor).NotTo(BeEmpty())
+
+			page, headers = getJobs(fmt.Sprintf("limit=2&cursor=%s", cursor))
+			Expect(page).To(HaveLen(2))
+			Expect(page[0]["id"]).To(Equal(testJobs[2].ID.String()))
+			Expect(page[1]["id"]).To(Equal(testJobs[3].ID.String()))
+			cursor = headers.Get("X-Next-Cursor")
+			Expect(cursor).NotTo(BeEmpty())
+
+			page, headers = getJobs(fmt.Sprintf("limit=2&cursor=%s", cursor))
+			Expect(page).To(HaveLen(1))
+			Expect(page[0]["id"]).To(Equal(testJobs[4].ID.String()))
+			Expect(headers.Get("X-Next-Cursor")).To(BeEmpty())
+			Expect(headers.Get("X-Total-Count")).To(Equal("5"))
+		})
+
+		It("should return the first 50 jobs if neither limit nor cursor is sent", func() {
+			CreateTestJobs(app.DB, existingApp.ID, existingTemplate.Name, 60)
+
+			page, headers := getJobs("")
+			Expect(page).To(HaveLen(50))
+			Expect(headers.Get("X-Total-Count")).To(Equal("60"))
+			Expect(headers.Get("X-Next-Cursor")).NotTo(BeEmpty())
+
+			page, headers = getJobs(fmt.Sprintf("cursor=%s", headers.Get("X-Next-Cursor")))
+			Expect(page).To(HaveLen(10))
+			Expect(headers.Get("X-Next-Cursor")).To(BeEmpty())
+		})
+
+		It("should sort the jobs in descending order", func() {
+			testJobs := CreateTestJobs(app.DB, existingApp.ID, existingTemplate.Name, 3)
+
+			page, headers := getJobs("sort=-createdAt&limit=2")
+			Expect(page).To(HaveLen(2))
+			Expect(page[0]["id"]).To(Equal(testJobs[2].ID.String()))
+			Expect(page[1]["id"]).To(Equal(testJobs[1].ID.String()))
+
+			page, _ = getJobs(fmt.Sprintf("sort=-createdAt&limit=2&cursor=%s", headers.Get("X-Next-Cursor")))
+			Expect(page).To(HaveLen(1))
+			Expect(page[0]["id"]).To(Equal(testJobs[0].ID.String()))
+		})
+
+		It("should sort the jobs by startsAt", func() {
+			later := CreateTestJob(app.DB, existingApp.ID, existingTemplate.Name, map[string]interface{}{
+				"startsAt": time.Now().Add(2 * time.Hour).UnixNano(),
+			})
+			sooner := CreateTestJob(app.DB, existingApp.ID, existingTemplate.Name, map[string]interface{}{
+				"startsAt": time.Now().Add(time.Hour).UnixNano(),
+			})
+
+			page, _ := getJobs("sort=startsAt")
+			Expect(page).To(HaveLen(2))
+			Expect(page[0]["id"]).To(Equal(sooner.ID.String()))
+			Expect(page[1]["id"]).To(Equal(later.ID.String()))
+		})
+
+		It("should filter the jobs by status, service and creator", func() {
+			stopped := CreateTestJob(app.DB, existingApp.ID, existingTemplate.Name, map[string]interface{}{"status": "stopped"})
+			paused := CreateTestJob(app.DB, existingApp.ID, existingTemplate.Name, map[string]interface{}{"status": "paused"})
+			gcm := CreateTestJob(app.DB, existingApp.ID, existingTemplate.Name, map[string]interface{}{
+				"service":   "gcm",
+				"createdBy": "someone@test.com",
+			})
+
+			page, headers := getJobs("status=stopped,paused")
+			Expect(page).To(HaveLen(2))
+			Expect(page[0]["id"]).To(Equal(stopped.ID.String()))
+			Expect(page[1]["id"]).To(Equal(paused.ID.String()))
+			Expect(headers.Get("X-Total-Count")).To(Equal("2"))
+
+			page, _ = getJobs("service=gcm")
+			Expect(page).To(HaveLen(1))
+			Expect(page[0]["id"]).To(Equal(gcm.ID.String()))
+
+			page, _ = getJobs("createdBy=someone@test.com")
+			Expect(page).To(HaveLen(1))
+			Expect(page[0]["id"]).To(Equal(gcm.ID.String()))
+		})
+
+		It("should filter the jobs by created and starts date ranges", func() {
+			now := time.Now()
+			old := CreateTestJob(app.DB, existingApp.ID, existingTemplate.Name, map[string]interface{}{
+				"createdAt": now.Add(-48 * time.Hour).UnixNano(),
+				"startsAt":  now.Add(-47 * time.Hour).UnixNano(),
+			})
+			recent := CreateTestJob(app.DB, existingApp.ID, existingTemplate.Name, map[string]interface{}{
+				"createdAt": now.Add(-time.Hour).UnixNano(),
+				"startsAt":  now.Add(time.Hour).UnixNano(),
+			})
+
+			page, _ := getJobs(fmt.Sprintf("createdAfter=%d", now.Add(-24*time.Hour).UnixNano()))
+			Expect(page).To(HaveLen(1))
+			Expect(page[0]["id"]).To(Equal(recent.ID.String()))
+
+			page, _ = getJobs(fmt.Sprintf("createdBefore=%d", now.Add(-24*time.Hour).UnixNano()))
+			Expect(page).To(HaveLen(1))
+			Expect(page[0]["id"]).To(Equal(old.ID.String()))
+
+			page, _ = getJobs(fmt.Sprintf("startsAfter=%d&startsBefore=%d", now.UnixNano(), now.Add(2*time.Hour).UnixNano()))
+			Expect(page).To(HaveLen(1))
+			Expect(page[0]["id"]).To(Equal(recent.ID.String()))
+		})
+
+		It("should search the jobs by template name", func() {
+			weeklyTemplate := CreateTestTemplate(app.DB, existingApp.ID, map[string]interface{}{"name": "weekly_100%_bonus"})
+			weekly := CreateTestJob(app.DB, existingApp.ID, weeklyTemplate.Name)
+			CreateTestJob(app.DB, existingApp.ID, existingTemplate.Name)
+
+			page, _ := getJobs("q=WEEKLY")
+			Expect(page).To(HaveLen(1))
+			Expect(page[0]["id"]).To(Equal(weekly.ID.String()))
+
+			page, _ = getJobs("q=100%25_")
+			Expect(page).To(HaveLen(1))
+			Expect(page[0]["id"]).To(Equal(weekly.ID.String()))
+
+			page, _ = getJobs("q=monthly")
+			Expect(page).To(HaveLen(0))
+		})
+
+		It("should return 422 if the pagination parameters are invalid", func() {
+			for _, query := range []string{"limit=0", "limit=abc", "limit=1001", "sort=name", "cursor=abc", "createdAfter=yesterday"} {
+				status, _ := Get(app, fmt.Sprintf("%s?%s", baseRouteWithoutTemplate, query), "test@test.com")
+				Expect(status).To(Equal(http.StatusUnprocessableEntity))
+			}
+		})
+	})
+
 	Describe("Post /apps/:id/jobs?template=:templateName", func() {
 		Describe("Sucesfully", func() {
+			It("should return 201 and use the job id as the salt of a sampled job", func() {
+				payload := GetJobPayload()
+				payload["sampleRate"] = 0.1
+				pl, _ := json.Marshal(payload)
+				status, body := Post(app, baseRoute, string(pl), "success@test.com")
+				Expect(status).To(Equal(http.StatusCreated))
+
+				var job map[string]interface{}
+				err := json.Unmarshal([]byte(body), &job)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(job["sampleRate"]).To(Equal(0.1))
+				Expect(job["sampleFrom"]).To(BeEquivalentTo(0))
+				Expect(job["sampleSalt"]).To(Equal(job["id"]))
+			})
+
 			It("should return 201 and the created job with filters", func() {
 				payload := GetJobPayload()
 				delete(payload, "csvPath")
@@ -195,6 +378,7 @@ var _ = Describe("Job Handler", func() {
 				Expect(job["csvPath"]).To(Equal(""))
 				Expect(job["service"]).To(Equal(payload["service"]))
 				Expect(job["createdBy"]).To(Equal("success@test.com"))
+				Expect(job["templateVersions"]).To(HaveKey(existingTemplate.Locale))
 				Expect(job["createdAt"]).ToNot(BeNil())
 				Expect(job["createdAt"]).ToNot(Equal(0))
 				Expect(job["updatedAt"]).ToNot(BeNil())
@@ -291,6 +475,87 @@ var _ = Describe("Job Handler", func() {
 				}
 			})
 
+			It("should return 201 and the created job with a filter tree", func() {
+				payload := GetJobPayload()
+				payload["service"] = "gcm"
+				payload["filters"] = map[string]interface{}{
+					"and": []interface{}{
+						map[string]interface{}{"column": "locale", "op": "in", "value": []interface{}{"en", "fr"}},
+						map[string]interface{}{"not": map[string]interface{}{"column": "region", "op": "is null"}},
+					},
+				}
+				delete(payload, "csvPath")
+				pl, _ := json.Marshal(payload)
+				status, body := Post(app, baseRoute, string(pl), "success@test.com")
+				Expect(status).To(Equal(http.StatusCreated))
+
+				var job map[string]interface{}
+				err := json.Unmarshal([]byte(body), &job)
+				Expect(err).NotTo(HaveOccurred())
+				expectedFilters := map[string]interface{}{
+					"and": []interface{}{
+						map[string]interface{}{"column": "locale", "op": "in", "value": []interface{}{"EN", "FR"}},
+						map[string]interface{}{"not": map[string]interface{}{"column": "region", "op": "is null"}},
+					},
+				}
+				Expect(job["filters"]).To(Equal(expectedFilters))
+
+				id, err := uuid.FromString(job["id"].(string))
+				Expect(err).NotTo(HaveOccurred())
+				dbJob := &model.Job{
+					ID: id,
+				}
+				err = app.DB.Select(&dbJob)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(dbJob.Filters).To(Equal(expectedFilters))
+			})
+
+			It("should return 201 and the created job with the locale and region conditions of a filter tree converted to the correct case", func() {
+				payload := GetJobPayload()
+				payload["service"] = "gcm"
+				payload["filters"] = map[string]interface{}{
+					"or": []interface{}{
+						map[string]interface{}{"column": "locale", "op": "=", "value": "en"},
+						map[string]interface{}{"column": "region", "op": "!=", "value": "US"},
+						map[string]interface{}{"column": "level", "op": ">", "value": 10},
+					},
+				}
+				delete(payload, "csvPath")
+				pl, _ := json.Marshal(payload)
+				status, body := Post(app, baseRoute, string(pl), "success@test.com")
+				Expect(status).To(Equal(http.StatusCreated))
+
+				var job map[string]interface{}
+				err := json.Unmarshal([]byte(body), &job)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(job["filters"]).To(Equal(map[string]interface{}{
+					"or": []interface{}{
+						map[string]interface{}{"column": "locale", "op": "=", "value": "EN"},
+						map[string]interface{}{"column": "region", "op": "!=", "value": "us"},
+						map[string]interface{}{"column": "level", "op": ">", "value": float64(10)},
+					},
+				}))
+			})
+
+			It("should return 201 and the created job with a filter tree with non string top level locale", func() {
+				payload := GetJobPayload()
+				payload["service"] = "gcm"
+				payload["filters"] = map[string]interface{}{
+					"column": "level",
+					"op":     "is null",
+					"locale": []interface{}{"en"},
+				}
+				delete(payload, "csvPath")
+				pl, _ := json.Marshal(payload)
+				status, body := Post(app, baseRoute, string(pl), "success@test.com")
+				Expect(status).To(Equal(http.StatusCreated))
+
+				var job map[string]interface{}
+				err := json.Unmarshal([]byte(body), &job)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(job["filters"]).To(Equal(payload["filters"]))
+			})
+
 			It("should return 201 and the created job with localized set to false by default", func() {
 				payload := GetJobPayload()
 				delete(payload, "csvPath")
@@ -625,6 +890,91 @@ var _ = Describe("Job Handler", func() {
 				Expect(err).NotTo(HaveOccurred())
 				Expect(res1).To(BeEquivalentTo(0))
 			})
+
+			It("should return 200 and the original job if the idempotency key is repeated with the same body", func() {
+				payload := GetJobPayload()
+				delete(payload, "csvPath")
+				pl, _ := json.Marshal(payload)
+				headers := map[string]string{"Idempotency-Key": "some-key"}
+				status, body, _ := DoRequest(app, "POST", baseRoute, string(pl), "success@test.com", headers)
+				Expect(status).To(Equal(http.StatusCreated))
+				var job map[string]interface{}
+				err := json.Unmarshal([]byte(body), &job)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(job["idempotencyKey"]).To(Equal("some-key"))
+
+				status, body, _ = DoRequest(app, "POST", baseRoute, string(pl), "success@test.com", headers)
+				Expect(status).To(Equal(http.StatusOK))
+				var repeated map[string]interface{}
+				err = json.Unmarshal([]byte(body), &repeated)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(repeated["id"]).To(Equal(job["id"]))
+
+				count, err := app.DB.Model(&model.Job{}).Where("app_id = ?", existingApp.ID).Count()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(count).To(Equal(1))
+				res, err := app.Worker.RedisClient.ZCard("schedule").Result()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(res).To(BeEquivalentTo(1))
+			})
+
+			It("should create different jobs for different idempotency keys", func() {
+				payload := GetJobPayload()
+				delete(payload, "csvPath")
+				pl, _ := json.Marshal(payload)
+				status, _, _ := DoRequest(app, "POST", baseRoute, string(pl), "success@test.com", map[string]string{"Idempotency-Key": "some-key"})
+				Expect(status).To(Equal(http.StatusCreated))
+				status, _, _ = DoRequest(app, "POST", baseRoute, string(pl), "success@test.com", map[string]string{"Idempotency-Key": "other-key"})
+				Expect(status).To(Equal(http.StatusCreated))
+
+				count, err := app.DB.Model(&model.Job{}).Where("app_id = ?", existingApp.ID).Count()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(count).To(Equal(2))
+			})
+
+			It("should return 201 and the created job with the filters of the audience", func() {
+				audience := CreateTestAudience(app.DB, existingApp.ID, map[string]interface{}{
+					"filters": map[string]interface{}{"locale": "pt"},
+				})
+				payload := GetJobPayload()
+				delete(payload, "csvPath")
+				delete(payload, "filters")
+				payload["audienceId"] = audience.ID.String()
+				pl, _ := json.Marshal(payload)
+				status, body := Post(app, baseRoute, string(pl), "success@test.com")
+				Expect(status).To(Equal(http.StatusCreated))
+
+				var job map[string]interface{}
+				err := json.Unmarshal([]byte(body), &job)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(job["audienceId"]).To(Equal(audience.ID.String()))
+				Expect(job["filters"]).To(Equal(map[string]interface{}{"locale": "pt"}))
+
+				id, err := uuid.FromString(job["id"].(string))
+				Expect(err).NotTo(HaveOccurred())
+				dbJob := &model.Job{ID: id}
+				err = app.DB.Select(&dbJob)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(*dbJob.AudienceID).To(Equal(audience.ID))
+				Expect(dbJob.Filters).To(Equal(map[string]interface{}{"locale": "pt"}))
+			})
+
+			It("should return 201 and the created job with the csvPath of the audience", func() {
+				audience := CreateTestAudience(app.DB, existingApp.ID, map[string]interface{}{"csvPath": "s3.aws.com/my-link"})
+				payload := GetJobPayload()
+				delete(payload, "csvPath")
+				delete(payload, "filters")
+				payload["audienceId"] = audience.ID.String()
+				pl, _ := json.Marshal(payload)
+				status, body := Post(app, baseRoute, string(pl), "success@test.com")
+				Expect(status).To(Equal(http.StatusCreated))
+
+				var job map[string]interface{}
+				err := json.Unmarshal([]byte(body), &job)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(job["csvPath"]).To(Equal("s3.aws.com/my-link"))
+				Expect(job["filters"]).To(BeEmpty())
+			})
 		})
 
 		Describe("Unsucesfully", func() {
@@ -634,6 +984,25 @@ var _ = Describe("Job Handler", func() {
 				Expect(status).To(Equal(http.StatusUnauthorized))
 			})
 
+			It("should return 409 if the idempotency key is repeated with a different body", func() {
+				payload := GetJobPayload()
+				delete(payload, "csvPath")
+				pl, _ := json.Marshal(payload)
+				headers := map[string]string{"Idempotency-Key": "some-key"}
+				status, _, _ := DoRequest(app, "POST", baseRoute, string(pl), "success@test.com", headers)
+				Expect(status).To(Equal(http.StatusCreated))
+
+				payload["context"] = map[string]interface{}{"param1": "other"}
+				pl, _ = json.Marshal(payload)
+				status, body, _ := DoRequest(app, "POST", baseRoute, string(pl), "success@test.com", headers)
+				Expect(status).To(Equal(http.StatusConflict))
+				Expect(body).To(ContainSubstring("idempotency key already used by a different request"))
+
+				count, err := app.DB.Model(&model.Job{}).Where("app_id = ?", existingApp.ID).Count()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(count).To(Equal(1))
+			})
+
 			It("should return 500 if some error occured", func() {
 				goodDB := app.DB
 				app.DB = faultyDb
@@ -706,6 +1075,52 @@ var _ = Describe("Job Handler", func() {
 				Expect(response["reason"]).To(Equal("invalid filters or csvPath must exist, not both"))
 			})
 
+			It("should return 422 if both audienceId and filters are provided", func() {
+				audience := CreateTestAudience(app.DB, existingApp.ID)
+				payload := GetJobPayload()
+				delete(payload, "csvPath")
+				payload["audienceId"] = audience.ID.String()
+				pl, _ := json.Marshal(payload)
+				status, body := Post(app, baseRoute, string(pl), "test@test.com")
+				Expect(status).To(Equal(http.StatusUnprocessableEntity))
+
+				var response map[string]interface{}
+				err := json.Unmarshal([]byte(body), &response)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(response["reason"]).To(Equal("invalid audienceId or filters and csvPath must exist, not both"))
+			})
+
+			It("should return 422 if the audience does not exist", func() {
+				payload := GetJobPayload()
+				delete(payload, "csvPath")
+				delete(payload, "filters")
+				payload["audienceId"] = uuid.NewV4().String()
+				pl, _ := json.Marshal(payload)
+				status, body := Post(app, baseRoute, string(pl), "test@test.com")
+				Expect(status).To(Equal(http.StatusUnprocessableEntity))
+
+				var response map[string]interface{}
+				err := json.Unmarshal([]byte(body), &response)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(response["reason"]).To(Equal("audience not found with given id"))
+			})
+
+			It("should return 422 if the audience is of another service", func() {
+				audience := CreateTestAudience(app.DB, existingApp.ID, map[string]interface{}{"service": "gcm"})
+				payload := GetJobPayload()
+				delete(payload, "csvPath")
+				delete(payload, "filters")
+				payload["audienceId"] = audience.ID.String()
+				pl, _ := json.Marshal(payload)
+				status, body := Post(app, baseRoute, string(pl), "test@test.com")
+				Expect(status).To(Equal(http.StatusUnprocessableEntity))
+
+				var response map[string]interface{}
+				err := json.Unmarshal([]byte(body), &response)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(response["reason"]).To(Equal("invalid service: the audience is of service gcm"))
+			})
+
 			It("should return 422 if missing service", func() {
 				payload := GetJobPayload()
 				delete(payload, "service")
@@ -770,6 +1185,25 @@ var _ = Describe("Job Handler", func() {
 				Expect(response["reason"]).To(ContainSubstring("cannot unmarshal string into Go value"))
 			})
 
+			It("should return 422 if the filter tree is invalid", func() {
+				payload := GetJobPayload()
+				payload["filters"] = map[string]interface{}{
+					"or": []interface{}{
+						map[string]interface{}{"column": "locale", "op": "=", "value": "en"},
+						map[string]interface{}{"column": "region", "op": "matches", "value": "US"},
+					},
+				}
+				delete(payload, "csvPath")
+				pl, _ := json.Marshal(payload)
+				status, body := Post(app, baseRoute, string(pl), "test@test.com")
+				Expect(status).To(Equal(http.StatusUnprocessableEntity))
+
+				var response map[string]interface{}
+				err := json.Unmarshal([]byte(body), &response)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(response["reason"]).To(Equal("invalid filters: invalid filter operator 'matches' of column region"))
+			})
+
 			It("should return 422 if invalid expiresAt", func() {
 				payload := GetJobPayload()
 				payload["expiresAt"] = "not-json"
@@ -808,35 +1242,169 @@ var _ = Describe("Job Handler", func() {
 				Expect(err).NotTo(HaveOccurred())
 				Expect(response["reason"]).To(Equal("invalid startsAt"))
 			})
+
+			It("should return 422 if invalid sampleRate", func() {
+				payload := GetJobPayload()
+				payload["sampleRate"] = 1.5
+				pl, _ := json.Marshal(payload)
+				status, body := Post(app, baseRoute, string(pl), "test@test.com")
+				Expect(status).To(Equal(http.StatusUnprocessableEntity))
+
+				var response map[string]interface{}
+				err := json.Unmarshal([]byte(body), &response)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(response["reason"]).To(Equal("invalid sampleRate"))
+			})
 		})
 	})
 
-	Describe("Get /apps/:id/jobs/:jid", func() {
+	Describe("Post /apps/:id/jobs/preview?template=:templateName", func() {
+		var previewRoute string
+		BeforeEach(func() {
+			previewRoute = fmt.Sprintf("/apps/%s/jobs/preview?template=%s", existingApp.ID, existingTemplate.Name)
+		})
+
 		Describe("Sucesfully", func() {
-			It("should return 200 and the requested job", func() {
-				existingJob := CreateTestJob(app.DB, existingApp.ID, existingTemplate.Name)
-				status, body := Get(app, fmt.Sprintf("%s/%s", baseRouteWithoutTemplate, existingJob.ID), "success@test.com")
+			It("should return 200 and the users and messages of the job without creating it", func() {
+				payload := GetJobPayload()
+				payload["filters"] = map[string]interface{}{
+					"locale": "pt",
+				}
+				delete(payload, "csvPath")
+				pl, _ := json.Marshal(payload)
+				status, body := Post(app, previewRoute, string(pl), "success@test.com")
 				Expect(status).To(Equal(http.StatusOK))
 
-				var job map[string]interface{}
-				err := json.Unmarshal([]byte(body), &job)
+				var preview map[string]interface{}
+				err := json.Unmarshal([]byte(body), &preview)
 				Expect(err).NotTo(HaveOccurred())
-				Expect(job["id"]).ToNot(BeNil())
-				Expect(job["appId"]).To(Equal(existingApp.ID.String()))
-				Expect(job["templateName"]).To(Equal(existingTemplate.Name))
-				Expect(job["totalBatches"]).To(Equal(float64(existingJob.TotalBatches)))
-				Expect(job["completedBatches"]).To(Equal(float64(existingJob.CompletedBatches)))
-				Expect(job["expiresAt"]).To(Equal(float64(existingJob.ExpiresAt)))
-				Expect(job["startsAt"]).To(Equal(float64(existingJob.StartsAt)))
-				Expect(job["csvPath"]).To(Equal(existingJob.CSVPath))
-				Expect(job["service"]).To(Equal(existingJob.Service))
-				Expect(job["createdBy"]).To(Equal(existingJob.CreatedBy))
-				Expect(job["createdAt"]).To(Equal(float64(existingJob.CreatedAt)))
-				Expect(job["updatedAt"]).To(Equal(float64(existingJob.UpdatedAt)))
 
-				tempFilters := job["filters"].(map[string]interface{})
-				plFilters := existingJob.Filters
-				for key := range plFilters {
+				buckets := preview["buckets"].([]interface{})
+				Expect(buckets).NotTo(BeEmpty())
+				totalUsers := 0
+				for _, b := range buckets {
+					bucket := b.(map[string]interface{})
+					Expect(bucket["locale"]).To(Equal("pt"))
+					Expect(bucket["tz"]).NotTo(BeEmpty())
+					totalUsers += int(bucket["users"].(float64))
+				}
+				Expect(preview["totalUsers"]).To(BeEquivalentTo(totalUsers))
+
+				messages := preview["messages"].(map[string]interface{})
+				Expect(messages).To(HaveLen(1))
+				msg := messages[existingTemplate.Locale].(map[string]interface{})
+				aps := msg["Payload"].(map[string]interface{})["aps"].(map[string]interface{})
+				Expect(aps["value"]).To(Equal(existingTemplate.Body["value"]))
+				Expect(msg["metadata"].(map[string]interface{})["templateName"]).To(Equal(existingTemplate.Name))
+
+				var count int
+				_, err = app.DB.Query(&count, "SELECT count(1) FROM jobs WHERE app_id = ?", existingApp.ID)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(count).To(Equal(0))
+				res, err := createBatchesWorker.RedisClient.LLen("queue:create_batches_from_filters_worker").Result()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(res).To(BeEquivalentTo(0))
+			})
+
+			It("should return 200 and render the template using the job context", func() {
+				template := CreateTestTemplate(app.DB, existingApp.ID, map[string]interface{}{
+					"body": map[string]interface{}{
+						"alert": "{{user_name}} just liked your {{object_name}}!",
+					},
+					"defaults": map[string]interface{}{
+						"user_name":   "Someone",
+						"object_name": "village",
+					},
+				})
+				payload := GetJobPayload()
+				payload["service"] = "gcm"
+				payload["context"] = map[string]interface{}{
+					"user_name": "Camila",
+				}
+				payload["filters"] = map[string]interface{}{}
+				delete(payload, "csvPath")
+				pl, _ := json.Marshal(payload)
+				route := fmt.Sprintf("/apps/%s/jobs/preview?template=%s", existingApp.ID, template.Name)
+				status, body := Post(app, route, string(pl), "success@test.com")
+				Expect(status).To(Equal(http.StatusOK))
+
+				var preview map[string]interface{}
+				err := json.Unmarshal([]byte(body), &preview)
+				Expect(err).NotTo(HaveOccurred())
+				msg := preview["messages"].(map[string]interface{})[template.Locale].(map[string]interface{})
+				data := msg["data"].(map[string]interface{})
+				Expect(data["alert"]).To(Equal("Camila just liked your village!"))
+			})
+		})
+
+		Describe("Unsucesfully", func() {
+			It("should return 401 if no authenticated user", func() {
+				status, _ := Post(app, previewRoute, "", "")
+
+				Expect(status).To(Equal(http.StatusUnauthorized))
+			})
+
+			It("should return 422 if template name is not specified", func() {
+				payload := GetJobPayload()
+				pl, _ := json.Marshal(payload)
+				status, body := Post(app, fmt.Sprintf("/apps/%s/jobs/preview", existingApp.ID), string(pl), "test@test.com")
+				Expect(status).To(Equal(http.StatusUnprocessableEntity))
+
+				var response map[string]interface{}
+				err := json.Unmarshal([]byte(body), &response)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(response["reason"]).To(Equal("template name must be specified"))
+			})
+
+			It("should return 422 if the job is invalid", func() {
+				payload := GetJobPayload()
+				payload["service"] = "blabla"
+				pl, _ := json.Marshal(payload)
+				status, body := Post(app, previewRoute, string(pl), "test@test.com")
+				Expect(status).To(Equal(http.StatusUnprocessableEntity))
+
+				var response map[string]interface{}
+				err := json.Unmarshal([]byte(body), &response)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(response["reason"]).To(Equal("invalid service"))
+			})
+
+			It("should return 422 if there are no templates with the given name", func() {
+				payload := GetJobPayload()
+				delete(payload, "csvPath")
+				pl, _ := json.Marshal(payload)
+				status, _ := Post(app, fmt.Sprintf("/apps/%s/jobs/preview?template=not-a-template", existingApp.ID), string(pl), "test@test.com")
+				Expect(status).To(Equal(http.StatusUnprocessableEntity))
+			})
+		})
+	})
+
+	Describe("Get /apps/:id/jobs/:jid", func() {
+		Describe("Sucesfully", func() {
+			It("should return 200 and the requested job", func() {
+				existingJob := CreateTestJob(app.DB, existingApp.ID, existingTemplate.Name)
+				status, body := Get(app, fmt.Sprintf("%s/%s", baseRouteWithoutTemplate, existingJob.ID), "success@test.com")
+				Expect(status).To(Equal(http.StatusOK))
+
+				var job map[string]interface{}
+				err := json.Unmarshal([]byte(body), &job)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(job["id"]).ToNot(BeNil())
+				Expect(job["appId"]).To(Equal(existingApp.ID.String()))
+				Expect(job["templateName"]).To(Equal(existingTemplate.Name))
+				Expect(job["totalBatches"]).To(Equal(float64(existingJob.TotalBatches)))
+				Expect(job["completedBatches"]).To(Equal(float64(existingJob.CompletedBatches)))
+				Expect(job["expiresAt"]).To(Equal(float64(existingJob.ExpiresAt)))
+				Expect(job["startsAt"]).To(Equal(float64(existingJob.StartsAt)))
+				Expect(job["csvPath"]).To(Equal(existingJob.CSVPath))
+				Expect(job["service"]).To(Equal(existingJob.Service))
+				Expect(job["createdBy"]).To(Equal(existingJob.CreatedBy))
+				Expect(job["createdAt"]).To(Equal(float64(existingJob.CreatedAt)))
+				Expect(job["updatedAt"]).To(Equal(float64(existingJob.UpdatedAt)))
+
+				tempFilters := job["filters"].(map[string]interface{})
+				plFilters := existingJob.Filters
+				for key := range plFilters {
 					Expect(tempFilters[key]).To(Equal(plFilters[key]))
 				}
 
@@ -900,6 +1468,465 @@ var _ = Describe("Job Handler", func() {
 		})
 	})
 
+	Describe("Put /apps/:id/jobs/:jid", func() {
+		var existingJob map[string]interface{}
+		BeforeEach(func() {
+			payload := GetJobPayload()
+			delete(payload, "csvPath")
+			pl, _ := json.Marshal(payload)
+			status, body := Post(app, baseRoute, string(pl), "success@test.com")
+			Expect(status).To(Equal(http.StatusCreated))
+			err := json.Unmarshal([]byte(body), &existingJob)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		Describe("Sucesfully", func() {
+			It("should return 200, update the job and reschedule it", func() {
+				startsAt := time.Now().Add(3 * time.Hour).UnixNano()
+				payload := GetJobPayload(map[string]interface{}{
+					"startsAt": startsAt,
+					"context":  map[string]interface{}{"value": "fixed"},
+				})
+				delete(payload, "csvPath")
+				pl, _ := json.Marshal(payload)
+				status, body := Put(app, fmt.Sprintf("%s/%s", baseRouteWithoutTemplate, existingJob["id"]), string(pl), "test@test.com")
+				Expect(status).To(Equal(http.StatusOK))
+
+				var job map[string]interface{}
+				err := json.Unmarshal([]byte(body), &job)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(job["id"]).To(Equal(existingJob["id"]))
+				Expect(job["templateName"]).To(Equal(existingTemplate.Name))
+				Expect(job["createdBy"]).To(Equal("success@test.com"))
+				Expect(job["startsAt"]).To(Equal(float64(startsAt)))
+				Expect(job["context"]).To(Equal(map[string]interface{}{"value": "fixed"}))
+
+				id, err := uuid.FromString(job["id"].(string))
+				Expect(err).NotTo(HaveOccurred())
+				dbJob := &model.Job{ID: id}
+				err = app.DB.Select(&dbJob)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(dbJob.StartsAt).To(Equal(startsAt))
+				Expect(dbJob.Context).To(Equal(map[string]interface{}{"value": "fixed"}))
+
+				scheduled, err := app.Worker.RedisClient.ZRangeWithScores("schedule", 0, -1).Result()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(scheduled).To(HaveLen(1))
+				Expect(scheduled[0].Member).To(ContainSubstring(existingJob["id"].(string)))
+				Expect(scheduled[0].Score).To(BeNumerically("~", float64(startsAt)/1e9, 1))
+			})
+		})
+
+		Describe("Unsucesfully", func() {
+			It("should return 403 if the job already created batches", func() {
+				_, err := app.DB.Model(&model.Job{}).Set("total_batches = 10").Where("id = ?", existingJob["id"]).Update()
+				Expect(err).NotTo(HaveOccurred())
+				payload := GetJobPayload()
+				delete(payload, "csvPath")
+				pl, _ := json.Marshal(payload)
+				status, body := Put(app, fmt.Sprintf("%s/%s", baseRouteWithoutTemplate, existingJob["id"]), string(pl), "test@test.com")
+				Expect(status).To(Equal(http.StatusForbidden))
+
+				var response map[string]interface{}
+				err = json.Unmarshal([]byte(body), &response)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(response["reason"]).To(Equal("cannot edit job that already created batches"))
+			})
+
+			It("should return 403 if the job already started creating batches", func() {
+				app.Worker.RedisClient.Del("schedule")
+				payload := GetJobPayload()
+				delete(payload, "csvPath")
+				pl, _ := json.Marshal(payload)
+				status, body := Put(app, fmt.Sprintf("%s/%s", baseRouteWithoutTemplate, existingJob["id"]), string(pl), "test@test.com")
+				Expect(status).To(Equal(http.StatusForbidden))
+
+				var response map[string]interface{}
+				err := json.Unmarshal([]byte(body), &response)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(response["reason"]).To(Equal("cannot edit job that already started creating batches"))
+			})
+
+			It("should return 422 and keep the job scheduled if the payload is invalid", func() {
+				payload := GetJobPayload(map[string]interface{}{"startsAt": time.Now().Add(-time.Hour).UnixNano()})
+				delete(payload, "csvPath")
+				pl, _ := json.Marshal(payload)
+				status, _ := Put(app, fmt.Sprintf("%s/%s", baseRouteWithoutTemplate, existingJob["id"]), string(pl), "test@test.com")
+				Expect(status).To(Equal(http.StatusUnprocessableEntity))
+				Expect(app.Worker.RedisClient.ZCard("schedule").Val()).To(BeEquivalentTo(1))
+			})
+
+			It("should return 404 if the job does not exist", func() {
+				payload := GetJobPayload()
+				delete(payload, "csvPath")
+				pl, _ := json.Marshal(payload)
+				status, _ := Put(app, fmt.Sprintf("%s/%s", baseRouteWithoutTemplate, uuid.NewV4()), string(pl), "test@test.com")
+				Expect(status).To(Equal(http.StatusNotFound))
+			})
+		})
+	})
+
+	Describe("Post /apps/:id/jobs/:jid/clone", func() {
+		Describe("Sucesfully", func() {
+			It("should return 201 and create a new job with the configuration of the cloned one", func() {
+				existingJob := CreateTestJob(app.DB, existingApp.ID, existingTemplate.Name, map[string]interface{}{
+					"status":   "stopped",
+					"priority": "high",
+				})
+				_, err := app.DB.Model(&model.Job{}).Set("completed_batches = 10, completed_users = 100, feedbacks = ?", `{"ack": 90}`).Where("id = ?", existingJob.ID).Update()
+				Expect(err).NotTo(HaveOccurred())
+
+				status, body := Post(app, fmt.Sprintf("%s/%s/clone", baseRouteWithoutTemplate, existingJob.ID), "", "clone@test.com")
+				Expect(status).To(Equal(http.StatusCreated))
+
+				var job map[string]interface{}
+				err = json.Unmarshal([]byte(body), &job)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(job["id"]).NotTo(Equal(existingJob.ID.String()))
+				Expect(job["appId"]).To(Equal(existingApp.ID.String()))
+				Expect(job["templateName"]).To(Equal(existingJob.TemplateName))
+				Expect(job["service"]).To(Equal(existingJob.Service))
+				Expect(job["filters"]).To(Equal(existingJob.Filters))
+				Expect(job["context"]).To(Equal(existingJob.Context))
+				Expect(job["metadata"]).To(Equal(existingJob.Metadata))
+				Expect(job["priority"]).To(Equal("high"))
+				Expect(job["createdBy"]).To(Equal("clone@test.com"))
+				Expect(job["status"]).To(Equal(""))
+				Expect(job["completedBatches"]).To(BeEquivalentTo(0))
+				Expect(job["completedUsers"]).To(BeEquivalentTo(0))
+				Expect(job["startsAt"]).To(BeEquivalentTo(0))
+				Expect(job["expiresAt"]).To(BeEquivalentTo(0))
+
+				id, err := uuid.FromString(job["id"].(string))
+				Expect(err).NotTo(HaveOccurred())
+				dbJob := &model.Job{ID: id}
+				err = app.DB.Select(&dbJob)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(dbJob.Feedbacks).To(BeEmpty())
+
+				res, err := app.Worker.RedisClient.LRange("queue:create_batches_from_filters_worker", 0, -1).Result()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(res).To(HaveLen(1))
+				Expect(res[0]).To(ContainSubstring(job["id"].(string)))
+			})
+
+			It("should override the cloned job configuration with the body", func() {
+				existingJob := CreateTestJob(app.DB, existingApp.ID, existingTemplate.Name)
+				startsAt := time.Now().Add(2 * time.Hour).UnixNano()
+				pl, _ := json.Marshal(map[string]interface{}{
+					"startsAt": startsAt,
+					"context":  map[string]interface{}{"value": "other"},
+					"csvPath":  "tfg-push-notifications/test/jobs/obj1.csv",
+				})
+				status, body := Post(app, fmt.Sprintf("%s/%s/clone", baseRouteWithoutTemplate, existingJob.ID), string(pl), "clone@test.com")
+				Expect(status).To(Equal(http.StatusCreated))
+
+				var job map[string]interface{}
+				err := json.Unmarshal([]byte(body), &job)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(job["startsAt"]).To(Equal(float64(startsAt)))
+				Expect(job["context"]).To(Equal(map[string]interface{}{"value": "other"}))
+				Expect(job["csvPath"]).To(Equal("tfg-push-notifications/test/jobs/obj1.csv"))
+				Expect(job["filters"]).To(BeNil())
+				Expect(job["metadata"]).To(Equal(existingJob.Metadata))
+
+				scheduled, err := app.Worker.RedisClient.ZRange("schedule", 0, -1).Result()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(scheduled).To(HaveLen(1))
+				Expect(scheduled[0]).To(ContainSubstring(job["id"].(string)))
+				Expect(scheduled[0]).To(ContainSubstring("create_batches_worker"))
+			})
+
+			It("should ignore the overrides of fields that can't be configured", func() {
+				existingJob := CreateTestJob(app.DB, existingApp.ID, existingTemplate.Name)
+				pl, _ := json.Marshal(map[string]interface{}{
+					"status":           "stopped",
+					"completedBatches": 10,
+					"totalUsers":       100,
+					"feedbacks":        map[string]interface{}{"ack": 90},
+					"priority":         "low",
+				})
+				status, body := Post(app, fmt.Sprintf("%s/%s/clone", baseRouteWithoutTemplate, existingJob.ID), string(pl), "clone@test.com")
+				Expect(status).To(Equal(http.StatusCreated))
+
+				var job map[string]interface{}
+				err := json.Unmarshal([]byte(body), &job)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(job["priority"]).To(Equal("low"))
+
+				id, err := uuid.FromString(job["id"].(string))
+				Expect(err).NotTo(HaveOccurred())
+				dbJob := &model.Job{ID: id}
+				err = app.DB.Select(&dbJob)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(dbJob.Status).To(Equal(""))
+				Expect(dbJob.CompletedBatches).To(Equal(0))
+				Expect(dbJob.TotalUsers).To(Equal(0))
+				Expect(dbJob.Feedbacks).To(BeEmpty())
+			})
+
+			It("should use the filters of a filters job whose csv was already written by the workers", func() {
+				existingJob := CreateTestJob(app.DB, existingApp.ID, existingTemplate.Name)
+				_, err := app.DB.Model(&model.Job{}).Set("csv_path = ?", "tfg-push-notifications/test/jobs/job.csv").Where("id = ?", existingJob.ID).Update()
+				Expect(err).NotTo(HaveOccurred())
+
+				status, body := Post(app, fmt.Sprintf("%s/%s/clone", baseRouteWithoutTemplate, existingJob.ID), "", "clone@test.com")
+				Expect(status).To(Equal(http.StatusCreated))
+
+				var job map[string]interface{}
+				err = json.Unmarshal([]byte(body), &job)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(job["filters"]).To(Equal(existingJob.Filters))
+				Expect(job["csvPath"]).To(Equal(""))
+			})
+
+			It("should sample the users with a new salt", func() {
+				existingJob := CreateTestJob(app.DB, existingApp.ID, existingTemplate.Name, map[string]interface{}{
+					"sampleFrom": 0.1,
+					"sampleRate": 0.5,
+					"sampleSalt": "campaign",
+				})
+				pl, _ := json.Marshal(map[string]interface{}{"sampleRate": 1})
+				status, body := Post(app, fmt.Sprintf("%s/%s/clone", baseRouteWithoutTemplate, existingJob.ID), string(pl), "clone@test.com")
+				Expect(status).To(Equal(http.StatusCreated))
+
+				var job map[string]interface{}
+				err := json.Unmarshal([]byte(body), &job)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(job["sampleFrom"]).To(Equal(0.0))
+				Expect(job["sampleRate"]).To(Equal(1.0))
+				Expect(job["sampleSalt"]).To(Equal(job["id"]))
+			})
+		})
+
+		Describe("Unsucesfully", func() {
+			It("should return 401 if no authenticated user", func() {
+				existingJob := CreateTestJob(app.DB, existingApp.ID, existingTemplate.Name)
+				status, _ := Post(app, fmt.Sprintf("%s/%s/clone", baseRouteWithoutTemplate, existingJob.ID), "", "")
+				Expect(status).To(Equal(http.StatusUnauthorized))
+			})
+
+			It("should return 404 if the job does not exist", func() {
+				status, _ := Post(app, fmt.Sprintf("%s/%s/clone", baseRouteWithoutTemplate, uuid.NewV4()), "", "clone@test.com")
+				Expect(status).To(Equal(http.StatusNotFound))
+			})
+
+			It("should return 422 if an override is invalid", func() {
+				existingJob := CreateTestJob(app.DB, existingApp.ID, existingTemplate.Name)
+				pl, _ := json.Marshal(map[string]interface{}{
+					"startsAt": time.Now().Add(-time.Hour).UnixNano(),
+				})
+				status, body := Post(app, fmt.Sprintf("%s/%s/clone", baseRouteWithoutTemplate, existingJob.ID), string(pl), "clone@test.com")
+				Expect(status).To(Equal(http.StatusUnprocessableEntity))
+				Expect(body).To(ContainSubstring("startsAt"))
+			})
+
+			It("should return 422 if the template does not exist", func() {
+				existingJob := CreateTestJob(app.DB, existingApp.ID, existingTemplate.Name)
+				status, _ := Post(app, fmt.Sprintf("%s/%s/clone?template=unknown", baseRouteWithoutTemplate, existingJob.ID), "", "clone@test.com")
+				Expect(status).To(Equal(http.StatusUnprocessableEntity))
+			})
+		})
+	})
+
+	Describe("Post /apps/:id/jobs/:jid/expand", func() {
+		Describe("Sucesfully", func() {
+			It("should return 201 and create a job covering the next range of the sample", func() {
+				existingJob := CreateTestJob(app.DB, existingApp.ID, existingTemplate.Name, map[string]interface{}{
+					"sampleRate": 0.1,
+					"sampleSalt": "campaign",
+				})
+				pl, _ := json.Marshal(map[string]interface{}{"sampleRate": 0.5})
+				status, body := Post(app, fmt.Sprintf("%s/%s/expand", baseRouteWithoutTemplate, existingJob.ID), string(pl), "expand@test.com")
+				Expect(status).To(Equal(http.StatusCreated))
+
+				var job map[string]interface{}
+				err := json.Unmarshal([]byte(body), &job)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(job["id"]).NotTo(Equal(existingJob.ID.String()))
+				Expect(job["templateName"]).To(Equal(existingJob.TemplateName))
+				Expect(job["filters"]).To(Equal(existingJob.Filters))
+				Expect(job["sampleFrom"]).To(Equal(0.1))
+				Expect(job["sampleRate"]).To(Equal(0.5))
+				Expect(job["sampleSalt"]).To(Equal("campaign"))
+				Expect(job["createdBy"]).To(Equal("expand@test.com"))
+
+				res, err := app.Worker.RedisClient.LRange("queue:create_batches_from_filters_worker", 0, -1).Result()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(res).To(HaveLen(1))
+				Expect(res[0]).To(ContainSubstring(job["id"].(string)))
+			})
+
+			It("should expand the sample of a filters job that was already sent", func() {
+				existingJob := CreateTestJob(app.DB, existingApp.ID, existingTemplate.Name, map[string]interface{}{
+					"sampleRate": 0.1,
+					"sampleSalt": "campaign",
+				})
+				_, err := app.DB.Model(&model.Job{}).Set("csv_path = ?", "tfg-push-notifications/test/jobs/job.csv").Where("id = ?", existingJob.ID).Update()
+				Expect(err).NotTo(HaveOccurred())
+
+				status, body := Post(app, fmt.Sprintf("%s/%s/expand", baseRouteWithoutTemplate, existingJob.ID), "", "expand@test.com")
+				Expect(status).To(Equal(http.StatusCreated))
+
+				var job map[string]interface{}
+				err = json.Unmarshal([]byte(body), &job)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(job["filters"]).To(Equal(existingJob.Filters))
+				Expect(job["csvPath"]).To(Equal(""))
+				Expect(job["sampleFrom"]).To(Equal(0.1))
+				Expect(job["sampleSalt"]).To(Equal("campaign"))
+			})
+
+			It("should expand the sample to the whole audience by default", func() {
+				existingJob := CreateTestJob(app.DB, existingApp.ID, existingTemplate.Name, map[string]interface{}{
+					"sampleRate": 0.1,
+					"sampleSalt": "campaign",
+				})
+				status, body := Post(app, fmt.Sprintf("%s/%s/expand", baseRouteWithoutTemplate, existingJob.ID), "", "expand@test.com")
+				Expect(status).To(Equal(http.StatusCreated))
+
+				var job map[string]interface{}
+				err := json.Unmarshal([]byte(body), &job)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(job["sampleFrom"]).To(Equal(0.1))
+				Expect(job["sampleRate"]).To(Equal(1.0))
+			})
+		})
+
+		Describe("Unsucesfully", func() {
+			It("should return 422 if the job is not sampled", func() {
+				existingJob := CreateTestJob(app.DB, existingApp.ID, existingTemplate.Name)
+				status, body := Post(app, fmt.Sprintf("%s/%s/expand", baseRouteWithoutTemplate, existingJob.ID), "", "expand@test.com")
+				Expect(status).To(Equal(http.StatusUnprocessableEntity))
+				Expect(body).To(ContainSubstring("job was already sent to its whole audience"))
+			})
+
+			It("should return 422 if the sample rate is not greater than the job sample rate", func() {
+				existingJob := CreateTestJob(app.DB, existingApp.ID, existingTemplate.Name, map[string]interface{}{
+					"sampleRate": 0.5,
+					"sampleSalt": "campaign",
+				})
+				pl, _ := json.Marshal(map[string]interface{}{"sampleRate": 0.3})
+				status, body := Post(app, fmt.Sprintf("%s/%s/expand", baseRouteWithoutTemplate, existingJob.ID), string(pl), "expand@test.com")
+				Expect(status).To(Equal(http.StatusUnprocessableEntity))
+				Expect(body).To(ContainSubstring("sampleRate must be greater than 0.5 and at most 1"))
+			})
+
+			It("should return 409 if the sample was already expanded", func() {
+				existingJob := CreateTestJob(app.DB, existingApp.ID, existingTemplate.Name, map[string]interface{}{
+					"sampleRate": 0.1,
+					"sampleSalt": "campaign",
+				})
+				expandedJob := CreateTestJob(app.DB, existingApp.ID, existingTemplate.Name, map[string]interface{}{
+					"sampleFrom": 0.1,
+					"sampleRate": 0.5,
+					"sampleSalt": "campaign",
+				})
+				status, body := Post(app, fmt.Sprintf("%s/%s/expand", baseRouteWithoutTemplate, existingJob.ID), "", "expand@test.com")
+				Expect(status).To(Equal(http.StatusConflict))
+				Expect(body).To(ContainSubstring(expandedJob.ID.String()))
+
+				status, _ = Post(app, fmt.Sprintf("%s/%s/expand", baseRouteWithoutTemplate, expandedJob.ID), "", "expand@test.com")
+				Expect(status).To(Equal(http.StatusCreated))
+			})
+
+			It("should create only one job if the sample is expanded concurrently", func() {
+				existingJob := CreateTestJob(app.DB, existingApp.ID, existingTemplate.Name, map[string]interface{}{
+					"sampleRate": 0.1,
+					"sampleSalt": "campaign",
+				})
+				statuses := make(chan int, 2)
+				for i := 0; i < 2; i++ {
+					go func() {
+						defer GinkgoRecover()
+						status, _ := Post(app, fmt.Sprintf("%s/%s/expand", baseRouteWithoutTemplate, existingJob.ID), "", "expand@test.com")
+						statuses <- status
+					}()
+				}
+				Expect([]int{<-statuses, <-statuses}).To(ConsistOf(http.StatusCreated, http.StatusConflict))
+
+				count, err := app.DB.Model(&model.Job{}).Where("app_id = ?", existingApp.ID).Where("sample_salt = ?", "campaign").Count()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(count).To(Equal(2))
+			})
+
+			It("should return 404 if the job does not exist", func() {
+				status, _ := Post(app, fmt.Sprintf("%s/%s/expand", baseRouteWithoutTemplate, uuid.NewV4()), "", "expand@test.com")
+				Expect(status).To(Equal(http.StatusNotFound))
+			})
+		})
+	})
+
+	Describe("Get /apps/:id/jobs/:jid/events", func() {
+		readEvents := func(url string, events chan<- string) {
+			defer GinkgoRecover()
+			defer close(events)
+			ts := httptest.NewServer(app.API)
+			defer ts.Close()
+			req, err := http.NewRequest("GET", fmt.Sprintf("%s%s", ts.URL, url), nil)
+			Expect(err).NotTo(HaveOccurred())
+			req.Header.Add("x-forwarded-email", "test@test.com")
+			res, err := http.DefaultClient.Do(req)
+			Expect(err).NotTo(HaveOccurred())
+			defer res.Body.Close()
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(res.Header.Get("Content-Type")).To(Equal("text/event-stream"))
+			scanner := bufio.NewScanner(res.Body)
+			for scanner.Scan() {
+				if line := scanner.Text(); line != "" {
+					events <- line
+				}
+			}
+		}
+
+		It("should stream the job current state and its events until it is stopped", func() {
+			existingJob := CreateTestJob(app.DB, existingApp.ID, existingTemplate.Name)
+			events := make(chan string, 10)
+			go readEvents(fmt.Sprintf("%s/%s/events", baseRouteWithoutTemplate, existingJob.ID), events)
+
+			Eventually(events).Should(Receive(Equal("event: progress")))
+			var line string
+			Eventually(events).Should(Receive(&line))
+			current := map[string]interface{}{}
+			err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &current)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(current["jobId"]).To(Equal(existingJob.ID.String()))
+			Expect(current["completedBatches"]).To(BeEquivalentTo(0))
+
+			existingJob.CompletedBatches = 3
+			worker.PublishJobEvent(app.Worker.RedisClient, logger, model.NewJobEvent(model.JobProgressEvent, existingJob))
+			Eventually(events).Should(Receive(Equal("event: progress")))
+			Eventually(events).Should(Receive(&line))
+			Expect(line).To(ContainSubstring(`"completedBatches":3`))
+
+			status, _ := Put(app, fmt.Sprintf("%s/%s/stop", baseRouteWithoutTemplate, existingJob.ID), "", "test@test.com")
+			Expect(status).To(Equal(http.StatusOK))
+			Eventually(events).Should(Receive(Equal("event: status")))
+			Eventually(events).Should(Receive(&line))
+			Expect(line).To(ContainSubstring(`"status":"stopped"`))
+			Eventually(events).Should(BeClosed())
+		})
+
+		It("should only send the current state of a completed job", func() {
+			existingJob := CreateTestJob(app.DB, existingApp.ID, existingTemplate.Name)
+			_, err := app.DB.Model(&model.Job{}).Set("completed_at = ?", time.Now().UnixNano()).Where("id = ?", existingJob.ID).Update()
+			Expect(err).NotTo(HaveOccurred())
+			events := make(chan string, 10)
+			go readEvents(fmt.Sprintf("%s/%s/events", baseRouteWithoutTemplate, existingJob.ID), events)
+
+			Eventually(events).Should(Receive(Equal("event: status")))
+			var line string
+			Eventually(events).Should(Receive(&line))
+			Expect(line).To(ContainSubstring(`"status":"completed"`))
+			Eventually(events).Should(BeClosed())
+		})
+
+		It("should return 404 if the job does not exist", func() {
+			status, _ := Get(app, fmt.Sprintf("%s/%s/events", baseRouteWithoutTemplate, uuid.NewV4()), "test@test.com")
+			Expect(status).To(Equal(http.StatusNotFound))
+		})
+	})
+
 	Describe("Put /apps/:id/jobs/:jid/pause", func() {
 		Describe("Sucesfully", func() {
 			It("should return 200 and the paused job", func() {
@@ -934,6 +1961,49 @@ var _ = Describe("Job Handler", func() {
 				Expect(dbJob.ID).To(Equal(existingJob.ID))
 				Expect(dbJob.Status).To(Equal("paused"))
 			})
+
+			It("should move the job messages to the paused queue if purge is true", func() {
+				existingJob := CreateTestJob(app.DB, existingApp.ID, existingTemplate.Name)
+				users := []worker.User{{UserID: uuid.NewV4().String(), Token: "token", Locale: "en"}}
+				at := time.Now().Add(-time.Minute).UnixNano()
+				_, err := app.Worker.ScheduleProcessBatchJob(existingJob.ID.String(), existingApp.Name, &users, existingJob.Priority, at)
+				Expect(err).NotTo(HaveOccurred())
+
+				status, body := Put(app, fmt.Sprintf("%s/%s/pause?purge=true", baseRouteWithoutTemplate, existingJob.ID), "", "success@test.com")
+				Expect(status).To(Equal(http.StatusOK))
+
+				var job map[string]interface{}
+				err = json.Unmarshal([]byte(body), &job)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(job["status"]).To(Equal("paused"))
+				Expect(job["purgedMessages"]).To(BeEquivalentTo(1))
+
+				Expect(app.Worker.RedisClient.ZCard("schedule").Val()).To(BeEquivalentTo(0))
+				pausedJobs, err := app.Worker.RedisClient.LRange(fmt.Sprintf("%s-pausedjobs", existingJob.ID), 0, -1).Result()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(pausedJobs).To(HaveLen(1))
+				Expect(pausedJobs[0]).To(ContainSubstring(existingJob.ID.String()))
+			})
+
+			It("should keep the job messages scheduled to the future if purge is true", func() {
+				existingJob := CreateTestJob(app.DB, existingApp.ID, existingTemplate.Name)
+				users := []worker.User{{UserID: uuid.NewV4().String(), Token: "token", Locale: "en"}}
+				at := time.Now().Add(time.Hour).UnixNano()
+				_, err := app.Worker.ScheduleProcessBatchJob(existingJob.ID.String(), existingApp.Name, &users, existingJob.Priority, at)
+				Expect(err).NotTo(HaveOccurred())
+
+				status, body := Put(app, fmt.Sprintf("%s/%s/pause?purge=true", baseRouteWithoutTemplate, existingJob.ID), "", "success@test.com")
+				Expect(status).To(Equal(http.StatusOK))
+
+				var job map[string]interface{}
+				err = json.Unmarshal([]byte(body), &job)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(job["status"]).To(Equal("paused"))
+				Expect(job["purgedMessages"]).To(BeEquivalentTo(0))
+
+				Expect(app.Worker.RedisClient.ZCard("schedule").Val()).To(BeEquivalentTo(1))
+				Expect(app.Worker.RedisClient.LLen(fmt.Sprintf("%s-pausedjobs", existingJob.ID)).Val()).To(BeEquivalentTo(0))
+			})
 		})
 
 		Describe("Unsucesfully", func() {
@@ -1008,6 +2078,52 @@ var _ = Describe("Job Handler", func() {
 				Expect(dbJob.ID).To(Equal(existingJob.ID))
 				Expect(dbJob.Status).To(Equal("stopped"))
 			})
+
+			It("should remove the job messages from the workers", func() {
+				existingJob := CreateTestJob(app.DB, existingApp.ID, existingTemplate.Name)
+				otherJob := CreateTestJob(app.DB, existingApp.ID, existingTemplate.Name)
+				users := []worker.User{{UserID: uuid.NewV4().String(), Token: "token", Locale: "en"}}
+				at := time.Now().Add(time.Hour).UnixNano()
+				_, err := app.Worker.ScheduleProcessBatchJob(existingJob.ID.String(), existingApp.Name, &users, existingJob.Priority, at)
+				Expect(err).NotTo(HaveOccurred())
+				_, err = app.Worker.CreateProcessBatchJob(existingJob.ID.String(), existingApp.Name, &users, existingJob.Priority)
+				Expect(err).NotTo(HaveOccurred())
+				_, err = app.Worker.ScheduleProcessBatchJob(otherJob.ID.String(), existingApp.Name, &users, otherJob.Priority, at)
+				Expect(err).NotTo(HaveOccurred())
+
+				status, body := Put(app, fmt.Sprintf("%s/%s/stop", baseRouteWithoutTemplate, existingJob.ID), "", "success@test.com")
+				Expect(status).To(Equal(http.StatusOK))
+
+				var job map[string]interface{}
+				err = json.Unmarshal([]byte(body), &job)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(job["purgedMessages"]).To(BeEquivalentTo(2))
+
+				scheduled, err := app.Worker.RedisClient.ZRange("schedule", 0, -1).Result()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(scheduled).To(HaveLen(1))
+				Expect(scheduled[0]).To(ContainSubstring(otherJob.ID.String()))
+				Expect(app.Worker.RedisClient.LLen("queue:process_batch_worker").Val()).To(BeEquivalentTo(0))
+			})
+
+			It("should remove the job messages from the queue of the job priority", func() {
+				existingJob := CreateTestJob(app.DB, existingApp.ID, existingTemplate.Name, map[string]interface{}{
+					"priority": "low",
+				})
+				users := []worker.User{{UserID: uuid.NewV4().String(), Token: "token", Locale: "en"}}
+				_, err := app.Worker.CreateProcessBatchJob(existingJob.ID.String(), existingApp.Name, &users, existingJob.Priority)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(app.Worker.RedisClient.LLen("queue:process_batch_worker_low").Val()).To(BeEquivalentTo(1))
+
+				status, body := Put(app, fmt.Sprintf("%s/%s/stop", baseRouteWithoutTemplate, existingJob.ID), "", "success@test.com")
+				Expect(status).To(Equal(http.StatusOK))
+
+				var job map[string]interface{}
+				err = json.Unmarshal([]byte(body), &job)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(job["purgedMessages"]).To(BeEquivalentTo(1))
+				Expect(app.Worker.RedisClient.LLen("queue:process_batch_worker_low").Val()).To(BeEquivalentTo(0))
+			})
 		})
 
 		Describe("Unsucesfully", func() {
@@ -0,0 +1,201 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package api
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gopkg.in/pg.v5"
+	"gopkg.in/pg.v5/orm"
+
+	"github.com/labstack/echo"
+	"github.com/satori/go.uuid"
+	"github.com/topfreegames/marathon/model"
+)
+
+const (
+	defaultJobsPageLimit = 50
+	maxJobsPageLimit     = 1000
+	// NextCursorHeader is the response header with the cursor of the next page of a listing
+	NextCursorHeader = "X-Next-Cursor"
+	// TotalCountHeader is the response header with the number of records that match the listing filters
+	TotalCountHeader = "X-Total-Count"
+)
+
+// jobSortColumns maps the sort query string values to the job columns, nulls are sorted as 0
+var jobSortColumns = map[string]string{
+	"createdAt": "coalesce(job.created_at, 0)",
+	"updatedAt": "coalesce(job.updated_at, 0)",
+	"startsAt":  "coalesce(job.starts_at, 0)",
+}
+
+func jobSortValue(sortBy string, job *model.Job) int64 {
+	switch sortBy {
+	case "updatedAt":
+		return job.UpdatedAt
+	case "startsAt":
+		return job.StartsAt
+	default:
+		return job.CreatedAt
+	}
+}
+
+// jobsPage is a page of the jobs listing, sorted by a column and then by id
+type jobsPage struct {
+	Limit  int
+	SortBy string
+	Desc   bool
+	// the cursor is the sort column value and id of the last job of the previous page
+	AfterValue int64
+	AfterID    *uuid.UUID
+}
+
+func encodeJobsCursor(value int64, id uuid.UUID) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d:%s", value, id)))
+}
+
+func decodeJobsCursor(cursor string) (int64, *uuid.UUID, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, nil, fmt.Errorf("invalid cursor")
+	}
+	parts := strings.SplitN(string(b), ":", 2)
+	if len(parts) != 2 {
+		return 0, nil, fmt.Errorf("invalid cursor")
+	}
+	value, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return 0, nil, fmt.Errorf("invalid cursor")
+	}
+	id, err := uuid.FromString(parts[1])
+	if err != nil {
+		return 0, nil, fmt.Errorf("invalid cursor")
+	}
+	return value, &id, nil
+}
+
+// getJobsPage reads the limit, sort and cursor query string parameters
+func getJobsPage(c echo.Context) (*jobsPage, error) {
+	page := &jobsPage{
+		Limit:  defaultJobsPageLimit,
+		SortBy: "createdAt",
+	}
+	if limit := c.QueryParam("limit"); limit != "" {
+		l, err := strconv.Atoi(limit)
+		if err != nil || l <= 0 || l > maxJobsPageLimit {
+			return nil, fmt.Errorf("limit must be between 1 and %d", maxJobsPageLimit)
+		}
+		page.Limit = l
+	}
+	if sort := c.QueryParam("sort"); sort != "" {
+		page.Desc = strings.HasPrefix(sort, "-")
+		page.SortBy = strings.TrimPrefix(sort, "-")
+		if _, ok := jobSortColumns[page.SortBy]; !ok {
+			return nil, fmt.Errorf("cannot sort jobs by %s", sort)
+		}
+	}
+	if cursor := c.QueryParam("cursor"); cursor != "" {
+		value, id, err := decodeJobsCursor(cursor)
+		if err != nil {
+			return nil, err
+		}
+		page.AfterValue = value
+		page.AfterID = id
+	}
+	return page, nil
+}
+
+// apply adds the cursor, order and limit of the page to the query
+// One more job than the limit is selected to know if there is a next page
+func (p *jobsPage) apply(query *orm.Query) *orm.Query {
+	column := jobSortColumns[p.SortBy]
+	direction, comparison := "ASC", ">"
+	if p.Desc {
+		direction, comparison = "DESC", "<"
+	}
+	if p.AfterID != nil {
+		query = query.Where(fmt.Sprintf("(%s, job.id) %s (?, ?)", column, comparison), p.AfterValue, *p.AfterID)
+	}
+	return query.
+		OrderExpr(fmt.Sprintf("%s %s", column, direction)).
+		OrderExpr(fmt.Sprintf("job.id %s", direction)).
+		Limit(p.Limit + 1)
+}
+
+// applyJobsFilters adds the filters in the query string parameters to a jobs query
+func applyJobsFilters(c echo.Context, query *orm.Query) (*orm.Query, error) {
+	if templateName := c.QueryParam("template"); templateName != "" {
+		query = query.Where("job.template_name = ?", templateName)
+	}
+	if q := c.QueryParam("q"); q != "" {
+		escaped := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(q)
+		query = query.Where("job.template_name ILIKE ?", "%"+escaped+"%")
+	}
+	if recurringJob := c.QueryParam("recurringJob"); recurringJob != "" {
+		rjid, err := uuid.FromString(recurringJob)
+		if err != nil {
+			return nil, err
+		}
+		query = query.Where("job.recurring_job_id = ?", rjid)
+	}
+	for param, column := range map[string]string{
+		"status":    "job.status",
+		"service":   "job.service",
+		"createdBy": "job.created_by",
+	} {
+		if value := c.QueryParam(param); value != "" {
+			query = query.Where(fmt.Sprintf("%s IN (?)", column), pg.In(strings.Split(value, ",")))
+		}
+	}
+	for param, condition := range map[string]string{
+		"createdAfter":  "job.created_at >= ?",
+		"createdBefore": "job.created_at < ?",
+		"startsAfter":   "job.starts_at >= ?",
+		"startsBefore":  "job.starts_at < ?",
+	} {
+		if value := c.QueryParam(param); value != "" {
+			t, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%s must be a timestamp in nanoseconds", param)
+			}
+			query = query.Where(condition, t)
+		}
+	}
+	return query, nil
+}
+
+// setHeaders sets the total count header and, if the page has more jobs than its limit, the next cursor header
+// It returns the jobs of the page without the extra job
+func (p *jobsPage) setHeaders(c echo.Context, jobs []model.Job, count int) []model.Job {
+	c.Response().Header().Set(TotalCountHeader, strconv.Itoa(count))
+	if len(jobs) <= p.Limit {
+		return jobs
+	}
+	jobs = jobs[:p.Limit]
+	last := &jobs[len(jobs)-1]
+	c.Response().Header().Set(NextCursorHeader, encodeJobsCursor(jobSortValue(p.SortBy, last), last.ID))
+	return jobs
+}
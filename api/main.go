@@ -190,18 +190,51 @@ func (a *Application) configureApplication() {
 
 	// Templates Routes
 	e.POST("/apps/:aid/templates", a.PostTemplateHandler)
+	e.POST("/apps/:aid/templates/lint", a.LintTemplateHandler)
+	e.GET("/apps/:aid/templates/export", a.ExportTemplatesHandler)
+	e.POST("/apps/:aid/templates/import", a.ImportTemplatesHandler)
 	e.GET("/apps/:aid/templates", a.ListTemplatesHandler)
 	e.GET("/apps/:aid/templates/:tid", a.GetTemplateHandler)
 	e.PUT("/apps/:aid/templates/:tid", a.PutTemplateHandler)
+	e.POST("/apps/:aid/templates/:tid/render", a.RenderTemplateHandler)
+	e.GET("/apps/:aid/templates/:tid/versions", a.ListTemplateVersionsHandler)
+	e.POST("/apps/:aid/templates/:tid/versions/:version/rollback", a.RollbackTemplateHandler)
 	e.DELETE("/apps/:aid/templates/:tid", a.DeleteTemplateHandler)
 
 	// Jobs Routes
 	e.POST("/apps/:aid/jobs", a.PostJobHandler)
+	e.POST("/apps/:aid/jobs/preview", a.PreviewJobHandler)
 	e.GET("/apps/:aid/jobs", a.ListJobsHandler)
 	e.GET("/apps/:aid/jobs/:jid", a.GetJobHandler)
+	e.PUT("/apps/:aid/jobs/:jid", a.PutJobHandler)
+	e.POST("/apps/:aid/jobs/:jid/clone", a.CloneJobHandler)
+	e.POST("/apps/:aid/jobs/:jid/expand", a.ExpandJobHandler)
+	e.GET("/apps/:aid/jobs/:jid/events", a.JobEventsHandler)
 	e.PUT("/apps/:aid/jobs/:jid/pause", a.PauseJobHandler)
 	e.PUT("/apps/:aid/jobs/:jid/stop", a.StopJobHandler)
 	e.PUT("/apps/:aid/jobs/:jid/resume", a.ResumeJobHandler)
+
+	// Recurring Jobs Routes
+	e.POST("/apps/:aid/recurring-jobs", a.PostRecurringJobHandler)
+	e.GET("/apps/:aid/recurring-jobs", a.ListRecurringJobsHandler)
+	e.GET("/apps/:aid/recurring-jobs/:rjid", a.GetRecurringJobHandler)
+	e.PUT("/apps/:aid/recurring-jobs/:rjid", a.PutRecurringJobHandler)
+	e.DELETE("/apps/:aid/recurring-jobs/:rjid", a.DeleteRecurringJobHandler)
+
+	// Audiences Routes
+	e.POST("/apps/:aid/audiences", a.PostAudienceHandler)
+	e.GET("/apps/:aid/audiences", a.ListAudiencesHandler)
+	e.GET("/apps/:aid/audiences/:audid", a.GetAudienceHandler)
+	e.PUT("/apps/:aid/audiences/:audid", a.PutAudienceHandler)
+	e.POST("/apps/:aid/audiences/:audid/size", a.RefreshAudienceSizeHandler)
+	e.DELETE("/apps/:aid/audiences/:audid", a.DeleteAudienceHandler)
+
+	// Suppressions Routes
+	e.POST("/apps/:aid/suppressions", a.PostSuppressionsHandler)
+	e.POST("/apps/:aid/suppressions/remove", a.RemoveSuppressionsHandler)
+	e.GET("/apps/:aid/suppressions", a.ListSuppressionsHandler)
+	e.GET("/apps/:aid/suppressions/:uid", a.GetSuppressionHandler)
+	e.DELETE("/apps/:aid/suppressions/:uid", a.DeleteSuppressionHandler)
 	a.API = e
 }
 
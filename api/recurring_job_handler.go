@@ -0,0 +1,316 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package api
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"gopkg.in/pg.v5/types"
+
+	"github.com/labstack/echo"
+	"github.com/satori/go.uuid"
+	"github.com/topfreegames/marathon/log"
+	"github.com/topfreegames/marathon/model"
+	"github.com/uber-go/zap"
+)
+
+// ListRecurringJobsHandler is the method called when a get to /apps/:aid/recurring-jobs is called
+func (a *Application) ListRecurringJobsHandler(c echo.Context) error {
+	l := a.Logger.With(
+		zap.String("source", "recurringJobHandler"),
+		zap.String("operation", "listRecurringJobs"),
+		zap.String("appId", c.Param("aid")),
+	)
+	aid, err := uuid.FromString(c.Param("aid"))
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error()})
+	}
+	recurringJobs := []model.RecurringJob{}
+	err = WithSegment("db-select", c, func() error {
+		return a.DB.Model(&recurringJobs).Column("recurring_job.*", "App").Where("recurring_job.app_id = ?", aid).Select()
+	})
+	if err != nil {
+		log.E(l, "Failed to list recurring jobs.", func(cm log.CM) {
+			cm.Write(zap.Error(err))
+		})
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error()})
+	}
+	log.D(l, "Listed recurring jobs successfully.", func(cm log.CM) {
+		cm.Write(zap.Object("recurringJobs", recurringJobs))
+	})
+	return c.JSON(http.StatusOK, recurringJobs)
+}
+
+// prepareRecurringJob checks the template and filters of the recurring job and schedules its next run after the
+// given time. It returns the status code to be sent if it fails
+func (a *Application) prepareRecurringJob(c echo.Context, recurringJob *model.RecurringJob, after int64) (int, error) {
+	app := &model.App{ID: recurringJob.AppID}
+	err := WithSegment("db-select", c, func() error {
+		return a.DB.Select(&app)
+	})
+	if err != nil {
+		if err.Error() == RecordNotFoundString {
+			return http.StatusUnprocessableEntity, err
+		}
+		return http.StatusInternalServerError, err
+	}
+
+	template := &model.Template{}
+	err = WithSegment("db-select", c, func() error {
+		return a.DB.Model(&template).Column("template.*").Where("template.app_id = ?", app.ID).Where("template.name = ?", recurringJob.TemplateName).First()
+	})
+	if err != nil {
+		if err.Error() == RecordNotFoundString {
+			return http.StatusUnprocessableEntity, err
+		}
+		return http.StatusInternalServerError, err
+	}
+
+	err = WithSegment("normalize-filters", c, func() error {
+		return a.normalizeJobFilters(app, recurringJob.Service, recurringJob.Filters)
+	})
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	err = recurringJob.ScheduleNextRun(after)
+	if err != nil {
+		return http.StatusUnprocessableEntity, err
+	}
+	return http.StatusOK, nil
+}
+
+// PostRecurringJobHandler is the method called when a post to /apps/:aid/recurring-jobs is called
+func (a *Application) PostRecurringJobHandler(c echo.Context) error {
+	l := a.Logger.With(
+		zap.String("source", "recurringJobHandler"),
+		zap.String("operation", "postRecurringJob"),
+		zap.String("appId", c.Param("aid")),
+	)
+	aid, err := uuid.FromString(c.Param("aid"))
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error()})
+	}
+	email := c.Get("user-email").(string)
+	recurringJob := &model.RecurringJob{
+		ID:        uuid.NewV4(),
+		AppID:     aid,
+		CreatedBy: email,
+		CreatedAt: time.Now().UnixNano(),
+		UpdatedAt: time.Now().UnixNano(),
+	}
+	err = WithSegment("decodeAndValidate", c, func() error {
+		return decodeAndValidate(c, recurringJob)
+	})
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error(), Value: recurringJob})
+	}
+	recurringJob.ID = uuid.NewV4()
+	recurringJob.AppID = aid
+
+	status, err := a.prepareRecurringJob(c, recurringJob, time.Now().UnixNano())
+	if err != nil {
+		if status == http.StatusInternalServerError {
+			log.E(l, "Failed to create recurring job.", func(cm log.CM) {
+				cm.Write(zap.Error(err))
+			})
+		}
+		return c.JSON(status, &Error{Reason: err.Error(), Value: recurringJob})
+	}
+
+	err = WithSegment("db-insert", c, func() error {
+		return a.DB.Insert(&recurringJob)
+	})
+	if err != nil {
+		if strings.Contains(err.Error(), "violates foreign key constraint") {
+			return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error(), Value: recurringJob})
+		}
+		log.E(l, "Failed to create recurring job.", func(cm log.CM) {
+			cm.Write(zap.Error(err))
+		})
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error(), Value: recurringJob})
+	}
+	log.D(l, "Created recurring job successfully.", func(cm log.CM) {
+		cm.Write(zap.Object("recurringJob", recurringJob))
+	})
+	return c.JSON(http.StatusCreated, recurringJob)
+}
+
+// GetRecurringJobHandler is the method called when a get to /apps/:aid/recurring-jobs/:rjid is called
+func (a *Application) GetRecurringJobHandler(c echo.Context) error {
+	l := a.Logger.With(
+		zap.String("source", "recurringJobHandler"),
+		zap.String("operation", "getRecurringJob"),
+		zap.String("appId", c.Param("aid")),
+		zap.String("recurringJobId", c.Param("rjid")),
+	)
+	aid, err := uuid.FromString(c.Param("aid"))
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error()})
+	}
+	rjid, err := uuid.FromString(c.Param("rjid"))
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error()})
+	}
+	recurringJob := &model.RecurringJob{ID: rjid, AppID: aid}
+	err = WithSegment("db-select", c, func() error {
+		return a.DB.Model(&recurringJob).Column("recurring_job.*", "App").Where("recurring_job.id = ?", rjid).Where("recurring_job.app_id = ?", aid).Select()
+	})
+	if err != nil {
+		if err.Error() == RecordNotFoundString {
+			return c.JSON(http.StatusNotFound, recurringJob)
+		}
+		log.E(l, "Failed to retrieve recurring job.", func(cm log.CM) {
+			cm.Write(zap.Error(err))
+		})
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error(), Value: recurringJob})
+	}
+	return c.JSON(http.StatusOK, recurringJob)
+}
+
+// PutRecurringJobHandler is the method called when a put to /apps/:aid/recurring-jobs/:rjid is called
+func (a *Application) PutRecurringJobHandler(c echo.Context) error {
+	l := a.Logger.With(
+		zap.String("source", "recurringJobHandler"),
+		zap.String("operation", "putRecurringJob"),
+		zap.String("appId", c.Param("aid")),
+		zap.String("recurringJobId", c.Param("rjid")),
+	)
+	aid, err := uuid.FromString(c.Param("aid"))
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error()})
+	}
+	rjid, err := uuid.FromString(c.Param("rjid"))
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error()})
+	}
+	email := c.Get("user-email").(string)
+	recurringJob := &model.RecurringJob{
+		ID:        rjid,
+		AppID:     aid,
+		CreatedBy: email,
+		UpdatedAt: time.Now().UnixNano(),
+	}
+	err = WithSegment("decodeAndValidate", c, func() error {
+		return decodeAndValidate(c, recurringJob)
+	})
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error(), Value: recurringJob})
+	}
+	recurringJob.ID = rjid
+	recurringJob.AppID = aid
+
+	current := &model.RecurringJob{}
+	err = WithSegment("db-select", c, func() error {
+		return a.DB.Model(&current).Where("id = ? AND app_id = ?", rjid, aid).Select()
+	})
+	if err != nil {
+		if err.Error() == RecordNotFoundString {
+			return c.JSON(http.StatusNotFound, map[string]string{})
+		}
+		log.E(l, "Failed to retrieve recurring job.", func(cm log.CM) {
+			cm.Write(zap.Error(err))
+		})
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error(), Value: recurringJob})
+	}
+	// the scheduler creates the occurrences ahead of time, so the next run must be after the last one created
+	after := time.Now().UnixNano()
+	if current.LastRunAt > after {
+		after = current.LastRunAt
+	}
+
+	status, err := a.prepareRecurringJob(c, recurringJob, after)
+	if err != nil {
+		if status == http.StatusInternalServerError {
+			log.E(l, "Failed to update recurring job.", func(cm log.CM) {
+				cm.Write(zap.Error(err))
+			})
+		}
+		return c.JSON(status, &Error{Reason: err.Error(), Value: recurringJob})
+	}
+
+	var values *types.Result
+	err = WithSegment("db-update", c, func() error {
+		values, err = a.DB.Model(&recurringJob).
+			Column("cron_spec", "localized", "ends_at", "next_run_at", "context", "service", "filters").
+			Column("metadata", "csv_path", "template_name", "past_time_strategy", "updated_at").
+			Where("id = ? AND app_id = ?", rjid, aid).
+			Where("coalesce(last_run_at, 0) = ?", current.LastRunAt).
+			Returning("*").
+			Update()
+		return err
+	})
+	if err != nil {
+		log.E(l, "Failed to update recurring job.", func(cm log.CM) {
+			cm.Write(zap.Error(err))
+		})
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error(), Value: recurringJob})
+	}
+	if values.RowsAffected() == 0 {
+		return c.JSON(http.StatusConflict, &Error{Reason: "recurring job was scheduled while it was updated, try again", Value: recurringJob})
+	}
+	log.D(l, "Updated recurring job successfully.", func(cm log.CM) {
+		cm.Write(zap.Object("recurringJob", recurringJob))
+	})
+	return c.JSON(http.StatusOK, recurringJob)
+}
+
+// DeleteRecurringJobHandler is the method called when a delete to /apps/:aid/recurring-jobs/:rjid is called
+// The jobs already created for the recurring job are kept
+func (a *Application) DeleteRecurringJobHandler(c echo.Context) error {
+	l := a.Logger.With(
+		zap.String("source", "recurringJobHandler"),
+		zap.String("operation", "deleteRecurringJob"),
+		zap.String("appId", c.Param("aid")),
+		zap.String("recurringJobId", c.Param("rjid")),
+	)
+	aid, err := uuid.FromString(c.Param("aid"))
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error()})
+	}
+	rjid, err := uuid.FromString(c.Param("rjid"))
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error()})
+	}
+	recurringJob := &model.RecurringJob{}
+	var res *types.Result
+	err = WithSegment("db-delete", c, func() error {
+		res, err = a.DB.Model(&recurringJob).Where("id = ? AND app_id = ?", rjid, aid).Delete()
+		return err
+	})
+	if err != nil {
+		log.E(l, "Failed to delete recurring job.", func(cm log.CM) {
+			cm.Write(zap.Error(err))
+		})
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error(), Value: recurringJob})
+	}
+	if res.RowsAffected() == 0 {
+		return c.JSON(http.StatusNotFound, map[string]string{})
+	}
+	log.D(l, "Deleted recurring job successfully.", func(cm log.CM) {
+		cm.Write(zap.Object("recurringJob", recurringJob))
+	})
+	return c.JSON(http.StatusNoContent, "")
+}
@@ -0,0 +1,290 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package api_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	uuid "github.com/satori/go.uuid"
+	"github.com/topfreegames/marathon/model"
+	. "github.com/topfreegames/marathon/testing"
+	"github.com/uber-go/zap"
+)
+
+var _ = Describe("Recurring Job Handler", func() {
+	logger := zap.New(
+		zap.NewJSONEncoder(zap.NoTime()), // drop timestamps in tests
+		zap.FatalLevel,
+	)
+	app := GetDefaultTestApp(logger)
+	faultyDb := GetFaultyTestDB(app)
+	var existingApp *model.App
+	var existingTemplate *model.Template
+	var baseRoute string
+
+	BeforeEach(func() {
+		app.DB.Exec("DELETE FROM apps;")
+		app.DB.Exec("DELETE FROM templates;")
+		app.DB.Exec("DELETE FROM recurring_jobs;")
+		existingApp = CreateTestApp(app.DB)
+		existingTemplate = CreateTestTemplate(app.DB, existingApp.ID)
+		baseRoute = fmt.Sprintf("/apps/%s/recurring-jobs", existingApp.ID)
+	})
+
+	Describe("Get /apps/:id/recurring-jobs", func() {
+		Describe("Sucesfully", func() {
+			It("should return 200 and an empty list if there are no recurring jobs", func() {
+				status, body := Get(app, baseRoute, "test@test.com")
+				Expect(status).To(Equal(http.StatusOK))
+
+				var response []map[string]interface{}
+				err := json.Unmarshal([]byte(body), &response)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(response).To(HaveLen(0))
+			})
+
+			It("should return 200 and the list of recurring jobs of the app", func() {
+				CreateTestRecurringJob(app.DB, existingApp.ID, existingTemplate.Name)
+				CreateTestRecurringJob(app.DB, existingApp.ID, existingTemplate.Name)
+				anotherApp := CreateTestApp(app.DB)
+				anotherTemplate := CreateTestTemplate(app.DB, anotherApp.ID)
+				CreateTestRecurringJob(app.DB, anotherApp.ID, anotherTemplate.Name)
+
+				status, body := Get(app, baseRoute, "test@test.com")
+				Expect(status).To(Equal(http.StatusOK))
+
+				var response []map[string]interface{}
+				err := json.Unmarshal([]byte(body), &response)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(response).To(HaveLen(2))
+				for _, recurringJob := range response {
+					Expect(recurringJob["appId"]).To(Equal(existingApp.ID.String()))
+				}
+			})
+		})
+
+		Describe("Unsucesfully", func() {
+			It("should return 500 if some error occured", func() {
+				goodDB := app.DB
+				app.DB = faultyDb
+				status, _ := Get(app, baseRoute, "test@test.com")
+				Expect(status).To(Equal(http.StatusInternalServerError))
+				app.DB = goodDB
+			})
+		})
+	})
+
+	Describe("Post /apps/:id/recurring-jobs", func() {
+		Describe("Sucesfully", func() {
+			It("should return 201 and the created recurring job with its next run", func() {
+				payload := GetRecurringJobPayload(map[string]interface{}{"templateName": existingTemplate.Name})
+				delete(payload, "csvPath")
+				pl, _ := json.Marshal(payload)
+				status, body := Post(app, baseRoute, string(pl), "success@test.com")
+				Expect(status).To(Equal(http.StatusCreated))
+
+				var recurringJob map[string]interface{}
+				err := json.Unmarshal([]byte(body), &recurringJob)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(recurringJob["id"]).ToNot(BeNil())
+				Expect(recurringJob["appId"]).To(Equal(existingApp.ID.String()))
+				Expect(recurringJob["templateName"]).To(Equal(existingTemplate.Name))
+				Expect(recurringJob["cronSpec"]).To(Equal("0 10 * * 1"))
+				Expect(recurringJob["createdBy"]).To(Equal("success@test.com"))
+
+				nextRunAt := time.Unix(0, int64(recurringJob["nextRunAt"].(float64))).UTC()
+				Expect(nextRunAt.After(time.Now())).To(BeTrue())
+				Expect(nextRunAt.Weekday()).To(Equal(time.Monday))
+				Expect(nextRunAt.Hour()).To(Equal(10))
+				Expect(nextRunAt.Minute()).To(Equal(0))
+
+				id, err := uuid.FromString(recurringJob["id"].(string))
+				Expect(err).NotTo(HaveOccurred())
+				dbRecurringJob := &model.RecurringJob{ID: id}
+				err = app.DB.Select(&dbRecurringJob)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(dbRecurringJob.NextRunAt).To(Equal(nextRunAt.UnixNano()))
+			})
+
+			It("should not schedule a next run after the end date", func() {
+				payload := GetRecurringJobPayload(map[string]interface{}{
+					"templateName": existingTemplate.Name,
+					"cronSpec":     "0 10 1 1 *",
+					"endsAt":       time.Now().Add(time.Minute).UnixNano(),
+				})
+				delete(payload, "csvPath")
+				pl, _ := json.Marshal(payload)
+				status, body := Post(app, baseRoute, string(pl), "success@test.com")
+				Expect(status).To(Equal(http.StatusCreated))
+
+				var recurringJob map[string]interface{}
+				err := json.Unmarshal([]byte(body), &recurringJob)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(recurringJob["nextRunAt"]).To(BeEquivalentTo(0))
+			})
+		})
+
+		Describe("Unsucesfully", func() {
+			It("should return 422 if the cron spec is invalid", func() {
+				payload := GetRecurringJobPayload(map[string]interface{}{
+					"templateName": existingTemplate.Name,
+					"cronSpec":     "0 25 * * *",
+				})
+				delete(payload, "csvPath")
+				pl, _ := json.Marshal(payload)
+				status, body := Post(app, baseRoute, string(pl), "success@test.com")
+				Expect(status).To(Equal(http.StatusUnprocessableEntity))
+
+				var response map[string]interface{}
+				err := json.Unmarshal([]byte(body), &response)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(response["reason"]).To(Equal("invalid cronSpec"))
+			})
+
+			It("should return 422 if the template does not exist", func() {
+				payload := GetRecurringJobPayload(map[string]interface{}{"templateName": "not-a-template"})
+				delete(payload, "csvPath")
+				pl, _ := json.Marshal(payload)
+				status, _ := Post(app, baseRoute, string(pl), "success@test.com")
+				Expect(status).To(Equal(http.StatusUnprocessableEntity))
+			})
+
+			It("should return 422 if both filters and csvPath are specified", func() {
+				payload := GetRecurringJobPayload(map[string]interface{}{
+					"templateName": existingTemplate.Name,
+					"csvPath":      "s3.aws.com/my-link",
+				})
+				pl, _ := json.Marshal(payload)
+				status, _ := Post(app, baseRoute, string(pl), "success@test.com")
+				Expect(status).To(Equal(http.StatusUnprocessableEntity))
+			})
+
+			It("should return 401 if no authenticated user", func() {
+				payload := GetRecurringJobPayload(map[string]interface{}{"templateName": existingTemplate.Name})
+				pl, _ := json.Marshal(payload)
+				status, _ := Post(app, baseRoute, string(pl), "")
+				Expect(status).To(Equal(http.StatusUnauthorized))
+			})
+		})
+	})
+
+	Describe("Get /apps/:id/recurring-jobs/:rjid", func() {
+		It("should return 200 and the recurring job", func() {
+			existingRecurringJob := CreateTestRecurringJob(app.DB, existingApp.ID, existingTemplate.Name)
+			status, body := Get(app, fmt.Sprintf("%s/%s", baseRoute, existingRecurringJob.ID), "test@test.com")
+			Expect(status).To(Equal(http.StatusOK))
+
+			var recurringJob map[string]interface{}
+			err := json.Unmarshal([]byte(body), &recurringJob)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(recurringJob["id"]).To(Equal(existingRecurringJob.ID.String()))
+			Expect(recurringJob["cronSpec"]).To(Equal(existingRecurringJob.CronSpec))
+			Expect(recurringJob["nextRunAt"]).To(Equal(float64(existingRecurringJob.NextRunAt)))
+		})
+
+		It("should return 404 if the recurring job does not exist", func() {
+			status, _ := Get(app, fmt.Sprintf("%s/%s", baseRoute, uuid.NewV4()), "test@test.com")
+			Expect(status).To(Equal(http.StatusNotFound))
+		})
+	})
+
+	Describe("Put /apps/:id/recurring-jobs/:rjid", func() {
+		It("should return 200 and the updated recurring job", func() {
+			existingRecurringJob := CreateTestRecurringJob(app.DB, existingApp.ID, existingTemplate.Name)
+			payload := GetRecurringJobPayload(map[string]interface{}{
+				"templateName": existingTemplate.Name,
+				"cronSpec":     "30 8 * * *",
+			})
+			delete(payload, "csvPath")
+			pl, _ := json.Marshal(payload)
+			status, body := Put(app, fmt.Sprintf("%s/%s", baseRoute, existingRecurringJob.ID), string(pl), "success@test.com")
+			Expect(status).To(Equal(http.StatusOK))
+
+			var recurringJob map[string]interface{}
+			err := json.Unmarshal([]byte(body), &recurringJob)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(recurringJob["cronSpec"]).To(Equal("30 8 * * *"))
+			nextRunAt := time.Unix(0, int64(recurringJob["nextRunAt"].(float64))).UTC()
+			Expect(nextRunAt.Hour()).To(Equal(8))
+			Expect(nextRunAt.Minute()).To(Equal(30))
+			Expect(nextRunAt.Sub(time.Now())).To(BeNumerically("<=", 24*time.Hour))
+		})
+
+		It("should schedule the next run after the occurrence already created by the scheduler", func() {
+			lastRunAt := time.Now().Add(30 * time.Minute).Truncate(time.Minute)
+			existingRecurringJob := CreateTestRecurringJob(app.DB, existingApp.ID, existingTemplate.Name, map[string]interface{}{
+				"cronSpec":  fmt.Sprintf("%d * * * *", lastRunAt.UTC().Minute()),
+				"lastRunAt": lastRunAt.UnixNano(),
+				"nextRunAt": lastRunAt.Add(time.Hour).UnixNano(),
+			})
+			payload := GetRecurringJobPayload(map[string]interface{}{
+				"templateName": existingTemplate.Name,
+				"cronSpec":     existingRecurringJob.CronSpec,
+			})
+			delete(payload, "csvPath")
+			pl, _ := json.Marshal(payload)
+			status, body := Put(app, fmt.Sprintf("%s/%s", baseRoute, existingRecurringJob.ID), string(pl), "success@test.com")
+			Expect(status).To(Equal(http.StatusOK))
+
+			var recurringJob map[string]interface{}
+			err := json.Unmarshal([]byte(body), &recurringJob)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(int64(recurringJob["nextRunAt"].(float64))).To(Equal(lastRunAt.Add(time.Hour).UnixNano()))
+		})
+
+		It("should return 404 if the recurring job does not exist", func() {
+			payload := GetRecurringJobPayload(map[string]interface{}{"templateName": existingTemplate.Name})
+			delete(payload, "csvPath")
+			pl, _ := json.Marshal(payload)
+			status, _ := Put(app, fmt.Sprintf("%s/%s", baseRoute, uuid.NewV4()), string(pl), "success@test.com")
+			Expect(status).To(Equal(http.StatusNotFound))
+		})
+	})
+
+	Describe("Delete /apps/:id/recurring-jobs/:rjid", func() {
+		It("should return 204 and keep the jobs already created", func() {
+			existingRecurringJob := CreateTestRecurringJob(app.DB, existingApp.ID, existingTemplate.Name)
+			job := existingRecurringJob.NewJob(existingRecurringJob.NextRunAt)
+			err := app.DB.Insert(job)
+			Expect(err).NotTo(HaveOccurred())
+
+			status, _ := Delete(app, fmt.Sprintf("%s/%s", baseRoute, existingRecurringJob.ID), "test@test.com")
+			Expect(status).To(Equal(http.StatusNoContent))
+
+			dbJob := &model.Job{ID: job.ID}
+			err = app.DB.Select(&dbJob)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(dbJob.RecurringJobID).To(BeNil())
+		})
+
+		It("should return 404 if the recurring job does not exist", func() {
+			status, _ := Delete(app, fmt.Sprintf("%s/%s", baseRoute, uuid.NewV4()), "test@test.com")
+			Expect(status).To(Equal(http.StatusNotFound))
+		})
+	})
+})
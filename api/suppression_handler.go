@@ -0,0 +1,358 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package api
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/pg.v5"
+	"gopkg.in/pg.v5/types"
+
+	"github.com/labstack/echo"
+	"github.com/satori/go.uuid"
+	"github.com/topfreegames/marathon/extensions"
+	"github.com/topfreegames/marathon/log"
+	"github.com/topfreegames/marathon/model"
+	"github.com/topfreegames/marathon/worker"
+	"github.com/uber-go/zap"
+)
+
+const (
+	defaultSuppressionsPageLimit = 100
+	maxSuppressionsPageLimit     = 1000
+	// suppressionsWritePageSize is the number of user ids written to the suppression list by each query
+	suppressionsWritePageSize = 1000
+)
+
+// SuppressionsRequest is the payload of the routes that add or remove users of the suppression list of an app,
+// with either the user ids or the full path of the S3 file with a csv whose first column has the user ids
+type SuppressionsRequest struct {
+	UserIDs []string `json:"userIds"`
+	CSVPath string   `json:"csvPath"`
+}
+
+// Validate implementation of the InputValidation interface
+func (r *SuppressionsRequest) Validate(c echo.Context) error {
+	if (len(r.UserIDs) != 0) == (r.CSVPath != "") {
+		return model.InvalidField("userIds or csvPath must exist, not both")
+	}
+	for _, userID := range r.UserIDs {
+		if strings.TrimSpace(userID) == "" || len(userID) > 255 {
+			return model.InvalidField("userIds")
+		}
+	}
+	return nil
+}
+
+// SuppressionsResult is the number of users added to or removed from the suppression list of an app
+type SuppressionsResult struct {
+	Added   int `json:"added"`
+	Removed int `json:"removed"`
+}
+
+// getSuppressionsUserIDs returns the user ids of the request, reading them from its csv if it has one
+func (a *Application) getSuppressionsUserIDs(request *SuppressionsRequest) ([]string, error) {
+	if request.CSVPath == "" {
+		return request.UserIDs, nil
+	}
+	csvFile, err := extensions.S3GetObject(a.S3Client, request.CSVPath)
+	if err != nil {
+		return nil, err
+	}
+	defer (*csvFile).Close()
+	csvBytes, err := ioutil.ReadAll(*csvFile)
+	if err != nil {
+		return nil, err
+	}
+	return worker.ReadUserIDsFromCSV(csvBytes)
+}
+
+// writeSuppressions runs query for each page of the user ids in a single transaction and returns the number of
+// affected rows. The version of the suppression list of the app is incremented so that the workers read it again
+func (a *Application) writeSuppressions(aid uuid.UUID, userIds []string, query func(tx *pg.Tx, page []string) (*types.Result, error)) (int, error) {
+	tx, err := a.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	err = worker.IncrementSuppressionsVersion(tx, aid)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	affected := 0
+	for start := 0; start < len(userIds); start += suppressionsWritePageSize {
+		end := start + suppressionsWritePageSize
+		if end > len(userIds) {
+			end = len(userIds)
+		}
+		res, err := query(tx, userIds[start:end])
+		if err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+		affected += res.RowsAffected()
+	}
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+	return affected, nil
+}
+
+// decodeSuppressionsRequest reads and validates the request and returns its user ids
+// It returns the status code to be sent if it fails
+func (a *Application) decodeSuppressionsRequest(c echo.Context, aid uuid.UUID) ([]string, int, error) {
+	app := &model.App{ID: aid}
+	err := WithSegment("db-select", c, func() error {
+		return a.DB.Select(&app)
+	})
+	if err != nil {
+		if err.Error() == RecordNotFoundString {
+			return nil, http.StatusUnprocessableEntity, fmt.Errorf("App not found with given id.")
+		}
+		return nil, http.StatusInternalServerError, err
+	}
+
+	request := &SuppressionsRequest{}
+	err = WithSegment("decodeAndValidate", c, func() error {
+		return decodeAndValidate(c, request)
+	})
+	if err != nil {
+		return nil, http.StatusUnprocessableEntity, err
+	}
+
+	var userIds []string
+	err = WithSegment("read-csv", c, func() error {
+		userIds, err = a.getSuppressionsUserIDs(request)
+		return err
+	})
+	if err != nil {
+		return nil, http.StatusUnprocessableEntity, err
+	}
+	return userIds, http.StatusOK, nil
+}
+
+// ListSuppressionsHandler is the method called when a get to /apps/:aid/suppressions is called
+// The suppressed users are sorted by user id and paginated by the limit and cursor query string parameters
+func (a *Application) ListSuppressionsHandler(c echo.Context) error {
+	l := a.Logger.With(
+		zap.String("source", "suppressionHandler"),
+		zap.String("operation", "listSuppressions"),
+		zap.String("appId", c.Param("aid")),
+	)
+	aid, err := uuid.FromString(c.Param("aid"))
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error()})
+	}
+	limit := defaultSuppressionsPageLimit
+	if l := c.QueryParam("limit"); l != "" {
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit <= 0 || limit > maxSuppressionsPageLimit {
+			return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: fmt.Sprintf("limit must be between 1 and %d", maxSuppressionsPageLimit)})
+		}
+	}
+	after := ""
+	if cursor := c.QueryParam("cursor"); cursor != "" {
+		b, err := base64.RawURLEncoding.DecodeString(cursor)
+		if err != nil {
+			return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: "invalid cursor"})
+		}
+		after = string(b)
+	}
+
+	suppressedUsers := []model.SuppressedUser{}
+	var count int
+	err = WithSegment("db-select", c, func() error {
+		count, err = a.DB.Model(&model.SuppressedUser{}).Where("app_id = ?", aid).Count()
+		if err != nil {
+			return err
+		}
+		// one more user than the limit is selected to know if there is a next page
+		return a.DB.Model(&suppressedUsers).Where("app_id = ?", aid).Where("user_id > ?", after).Order("user_id").Limit(limit + 1).Select()
+	})
+	if err != nil {
+		log.E(l, "Failed to list suppressed users.", func(cm log.CM) {
+			cm.Write(zap.Error(err))
+		})
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error()})
+	}
+	c.Response().Header().Set(TotalCountHeader, strconv.Itoa(count))
+	if len(suppressedUsers) > limit {
+		suppressedUsers = suppressedUsers[:limit]
+		last := suppressedUsers[limit-1]
+		c.Response().Header().Set(NextCursorHeader, base64.RawURLEncoding.EncodeToString([]byte(last.UserID)))
+	}
+	return c.JSON(http.StatusOK, suppressedUsers)
+}
+
+// GetSuppressionHandler is the method called when a get to /apps/:aid/suppressions/:uid is called
+func (a *Application) GetSuppressionHandler(c echo.Context) error {
+	l := a.Logger.With(
+		zap.String("source", "suppressionHandler"),
+		zap.String("operation", "getSuppression"),
+		zap.String("appId", c.Param("aid")),
+		zap.String("userId", c.Param("uid")),
+	)
+	aid, err := uuid.FromString(c.Param("aid"))
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error()})
+	}
+	suppressedUser := &model.SuppressedUser{}
+	err = WithSegment("db-select", c, func() error {
+		return a.DB.Model(suppressedUser).Where("app_id = ?", aid).Where("user_id = ?", c.Param("uid")).Select()
+	})
+	if err != nil {
+		if err.Error() == RecordNotFoundString {
+			return c.JSON(http.StatusNotFound, map[string]string{})
+		}
+		log.E(l, "Failed to retrieve suppressed user.", func(cm log.CM) {
+			cm.Write(zap.Error(err))
+		})
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error()})
+	}
+	return c.JSON(http.StatusOK, suppressedUser)
+}
+
+// PostSuppressionsHandler is the method called when a post to /apps/:aid/suppressions is called
+// The users already in the suppression list are skipped
+func (a *Application) PostSuppressionsHandler(c echo.Context) error {
+	l := a.Logger.With(
+		zap.String("source", "suppressionHandler"),
+		zap.String("operation", "postSuppressions"),
+		zap.String("appId", c.Param("aid")),
+	)
+	aid, err := uuid.FromString(c.Param("aid"))
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error()})
+	}
+	userIds, status, err := a.decodeSuppressionsRequest(c, aid)
+	if err != nil {
+		if status == http.StatusInternalServerError {
+			log.E(l, "Failed to add suppressed users.", func(cm log.CM) {
+				cm.Write(zap.Error(err))
+			})
+		}
+		return c.JSON(status, &Error{Reason: err.Error()})
+	}
+
+	email := c.Get("user-email").(string)
+	createdAt := time.Now().UnixNano()
+	result := &SuppressionsResult{}
+	err = WithSegment("db-insert", c, func() error {
+		result.Added, err = a.writeSuppressions(aid, userIds, func(tx *pg.Tx, page []string) (*types.Result, error) {
+			return tx.Exec(
+				`INSERT INTO suppressed_users (app_id, user_id, created_by, created_at)
+				SELECT ?, unnest(?::text[]), ?, ? ON CONFLICT DO NOTHING`,
+				aid, pg.Array(page), email, createdAt,
+			)
+		})
+		return err
+	})
+	if err != nil {
+		log.E(l, "Failed to add suppressed users.", func(cm log.CM) {
+			cm.Write(zap.Error(err))
+		})
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error()})
+	}
+	log.D(l, "Added suppressed users successfully.", func(cm log.CM) {
+		cm.Write(zap.Int("added", result.Added))
+	})
+	return c.JSON(http.StatusOK, result)
+}
+
+// RemoveSuppressionsHandler is the method called when a post to /apps/:aid/suppressions/remove is called
+func (a *Application) RemoveSuppressionsHandler(c echo.Context) error {
+	l := a.Logger.With(
+		zap.String("source", "suppressionHandler"),
+		zap.String("operation", "removeSuppressions"),
+		zap.String("appId", c.Param("aid")),
+	)
+	aid, err := uuid.FromString(c.Param("aid"))
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error()})
+	}
+	userIds, status, err := a.decodeSuppressionsRequest(c, aid)
+	if err != nil {
+		if status == http.StatusInternalServerError {
+			log.E(l, "Failed to remove suppressed users.", func(cm log.CM) {
+				cm.Write(zap.Error(err))
+			})
+		}
+		return c.JSON(status, &Error{Reason: err.Error()})
+	}
+
+	result := &SuppressionsResult{}
+	err = WithSegment("db-delete", c, func() error {
+		result.Removed, err = a.writeSuppressions(aid, userIds, func(tx *pg.Tx, page []string) (*types.Result, error) {
+			return tx.Model(&model.SuppressedUser{}).Where("app_id = ?", aid).Where("user_id IN (?)", pg.In(page)).Delete()
+		})
+		return err
+	})
+	if err != nil {
+		log.E(l, "Failed to remove suppressed users.", func(cm log.CM) {
+			cm.Write(zap.Error(err))
+		})
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error()})
+	}
+	log.D(l, "Removed suppressed users successfully.", func(cm log.CM) {
+		cm.Write(zap.Int("removed", result.Removed))
+	})
+	return c.JSON(http.StatusOK, result)
+}
+
+// DeleteSuppressionHandler is the method called when a delete to /apps/:aid/suppressions/:uid is called
+func (a *Application) DeleteSuppressionHandler(c echo.Context) error {
+	l := a.Logger.With(
+		zap.String("source", "suppressionHandler"),
+		zap.String("operation", "deleteSuppression"),
+		zap.String("appId", c.Param("aid")),
+		zap.String("userId", c.Param("uid")),
+	)
+	aid, err := uuid.FromString(c.Param("aid"))
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error()})
+	}
+	var removed int
+	err = WithSegment("db-delete", c, func() error {
+		removed, err = a.writeSuppressions(aid, []string{c.Param("uid")}, func(tx *pg.Tx, page []string) (*types.Result, error) {
+			return tx.Model(&model.SuppressedUser{}).Where("app_id = ?", aid).Where("user_id IN (?)", pg.In(page)).Delete()
+		})
+		return err
+	})
+	if err != nil {
+		log.E(l, "Failed to delete suppressed user.", func(cm log.CM) {
+			cm.Write(zap.Error(err))
+		})
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error()})
+	}
+	if removed == 0 {
+		return c.JSON(http.StatusNotFound, map[string]string{})
+	}
+	return c.JSON(http.StatusNoContent, "")
+}
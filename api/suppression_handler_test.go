@@ -0,0 +1,257 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package api_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"gopkg.in/pg.v5"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/topfreegames/marathon/extensions"
+	"github.com/topfreegames/marathon/model"
+	. "github.com/topfreegames/marathon/testing"
+	"github.com/uber-go/zap"
+)
+
+var _ = Describe("Suppression Handler", func() {
+	logger := zap.New(
+		zap.NewJSONEncoder(zap.NoTime()), // drop timestamps in tests
+		zap.FatalLevel,
+	)
+	app := GetDefaultTestApp(logger)
+	faultyDb := GetFaultyTestDB(app)
+	var existingApp *model.App
+	var baseRoute string
+
+	countSuppressedUsers := func() int {
+		count, err := app.DB.Model(&model.SuppressedUser{}).Where("app_id = ?", existingApp.ID).Count()
+		Expect(err).NotTo(HaveOccurred())
+		return count
+	}
+
+	suppressionsVersion := func() int {
+		var version int
+		_, err := app.DB.Query(pg.Scan(&version), "SELECT suppressions_version FROM apps WHERE id = ?", existingApp.ID)
+		Expect(err).NotTo(HaveOccurred())
+		return version
+	}
+
+	BeforeEach(func() {
+		app.DB.Exec("DELETE FROM apps;")
+		app.DB.Exec("DELETE FROM suppressed_users;")
+		app.Worker.RedisClient.FlushAll()
+		app.S3Client = NewFakeS3()
+		existingApp = CreateTestApp(app.DB)
+		baseRoute = fmt.Sprintf("/apps/%s/suppressions", existingApp.ID)
+	})
+
+	Describe("Get /apps/:id/suppressions", func() {
+		Describe("Sucesfully", func() {
+			It("should return 200 and the suppressed users of the app sorted by user id", func() {
+				CreateTestSuppressedUser(app.DB, existingApp.ID, "user-b")
+				CreateTestSuppressedUser(app.DB, existingApp.ID, "user-a")
+				anotherApp := CreateTestApp(app.DB)
+				CreateTestSuppressedUser(app.DB, anotherApp.ID, "user-c")
+
+				status, body := Get(app, baseRoute, "test@test.com")
+				Expect(status).To(Equal(http.StatusOK))
+
+				var response []map[string]interface{}
+				err := json.Unmarshal([]byte(body), &response)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(response).To(HaveLen(2))
+				Expect(response[0]["userId"]).To(Equal("user-a"))
+				Expect(response[1]["userId"]).To(Equal("user-b"))
+				Expect(response[0]["appId"]).To(Equal(existingApp.ID.String()))
+			})
+
+			It("should paginate the suppressed users", func() {
+				CreateTestSuppressedUser(app.DB, existingApp.ID, "user-a")
+				CreateTestSuppressedUser(app.DB, existingApp.ID, "user-b")
+				CreateTestSuppressedUser(app.DB, existingApp.ID, "user-c")
+
+				status, body, headers := DoRequest(app, "GET", fmt.Sprintf("%s?limit=2", baseRoute), "", "test@test.com", nil)
+				Expect(status).To(Equal(http.StatusOK))
+				Expect(headers.Get("X-Total-Count")).To(Equal("3"))
+				cursor := headers.Get("X-Next-Cursor")
+				Expect(cursor).NotTo(BeEmpty())
+
+				var response []map[string]interface{}
+				err := json.Unmarshal([]byte(body), &response)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(response).To(HaveLen(2))
+
+				status, body, headers = DoRequest(app, "GET", fmt.Sprintf("%s?limit=2&cursor=%s", baseRoute, cursor), "", "test@test.com", nil)
+				Expect(status).To(Equal(http.StatusOK))
+				Expect(headers.Get("X-Next-Cursor")).To(BeEmpty())
+				err = json.Unmarshal([]byte(body), &response)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(response).To(HaveLen(1))
+				Expect(response[0]["userId"]).To(Equal("user-c"))
+			})
+		})
+
+		Describe("Unsucesfully", func() {
+			It("should return 422 if the limit is invalid", func() {
+				status, body := Get(app, fmt.Sprintf("%s?limit=0", baseRoute), "test@test.com")
+				Expect(status).To(Equal(http.StatusUnprocessableEntity))
+				Expect(body).To(ContainSubstring("limit must be between 1 and 1000"))
+			})
+
+			It("should return 500 if some error occured", func() {
+				goodDB := app.DB
+				app.DB = faultyDb
+				status, _ := Get(app, baseRoute, "test@test.com")
+				Expect(status).To(Equal(http.StatusInternalServerError))
+				app.DB = goodDB
+			})
+		})
+	})
+
+	Describe("Get /apps/:id/suppressions/:uid", func() {
+		It("should return 200 and the suppressed user", func() {
+			CreateTestSuppressedUser(app.DB, existingApp.ID, "user-a")
+			status, body := Get(app, fmt.Sprintf("%s/user-a", baseRoute), "test@test.com")
+			Expect(status).To(Equal(http.StatusOK))
+
+			var response map[string]interface{}
+			err := json.Unmarshal([]byte(body), &response)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(response["userId"]).To(Equal("user-a"))
+			Expect(response["createdBy"]).To(Equal("test@test.com"))
+		})
+
+		It("should return 404 if the user is not suppressed", func() {
+			status, _ := Get(app, fmt.Sprintf("%s/user-a", baseRoute), "test@test.com")
+			Expect(status).To(Equal(http.StatusNotFound))
+		})
+	})
+
+	Describe("Post /apps/:id/suppressions", func() {
+		Describe("Sucesfully", func() {
+			It("should return 200 and add the user ids skipping the ones already suppressed", func() {
+				CreateTestSuppressedUser(app.DB, existingApp.ID, "user-a")
+
+				pl, _ := json.Marshal(map[string]interface{}{"userIds": []string{"user-a", "user-b", "user-c"}})
+				status, body := Post(app, baseRoute, string(pl), "success@test.com")
+				Expect(status).To(Equal(http.StatusOK))
+
+				var response map[string]interface{}
+				err := json.Unmarshal([]byte(body), &response)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(response["added"]).To(BeEquivalentTo(2))
+				Expect(countSuppressedUsers()).To(Equal(3))
+
+				suppressedUser := &model.SuppressedUser{}
+				err = app.DB.Model(suppressedUser).Where("app_id = ?", existingApp.ID).Where("user_id = ?", "user-b").Select()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(suppressedUser.CreatedBy).To(Equal("success@test.com"))
+
+				Expect(suppressionsVersion()).To(Equal(1))
+			})
+
+			It("should return 200 and add the user ids of the csv", func() {
+				csv := []byte("userIds\nuser-a\nuser-b\n")
+				err := extensions.S3PutObject(app.Config, app.S3Client, "test/suppressions/obj1.csv", &csv)
+				Expect(err).NotTo(HaveOccurred())
+
+				pl, _ := json.Marshal(map[string]interface{}{"csvPath": "tfg-push-notifications/test/suppressions/obj1.csv"})
+				status, body := Post(app, baseRoute, string(pl), "success@test.com")
+				Expect(status).To(Equal(http.StatusOK))
+				Expect(body).To(ContainSubstring(`"added":2`))
+				Expect(countSuppressedUsers()).To(Equal(2))
+			})
+		})
+
+		Describe("Unsucesfully", func() {
+			It("should return 422 if both userIds and csvPath are sent", func() {
+				pl, _ := json.Marshal(map[string]interface{}{"userIds": []string{"user-a"}, "csvPath": "tfg-push-notifications/test/suppressions/obj1.csv"})
+				status, body := Post(app, baseRoute, string(pl), "success@test.com")
+				Expect(status).To(Equal(http.StatusUnprocessableEntity))
+				Expect(body).To(ContainSubstring("invalid userIds or csvPath must exist, not both"))
+			})
+
+			It("should return 422 if a user id is empty", func() {
+				pl, _ := json.Marshal(map[string]interface{}{"userIds": []string{"user-a", " "}})
+				status, body := Post(app, baseRoute, string(pl), "success@test.com")
+				Expect(status).To(Equal(http.StatusUnprocessableEntity))
+				Expect(body).To(ContainSubstring("invalid userIds"))
+			})
+
+			It("should return 422 if the app does not exist", func() {
+				pl, _ := json.Marshal(map[string]interface{}{"userIds": []string{"user-a"}})
+				status, body := Post(app, "/apps/8b7e8f4e-9fa9-4a8c-8b1b-a5b1c52b8f4e/suppressions", string(pl), "success@test.com")
+				Expect(status).To(Equal(http.StatusUnprocessableEntity))
+				Expect(body).To(ContainSubstring("App not found with given id."))
+			})
+
+			It("should return 422 if the csv does not exist", func() {
+				pl, _ := json.Marshal(map[string]interface{}{"csvPath": "tfg-push-notifications/test/suppressions/notfound.csv"})
+				status, _ := Post(app, baseRoute, string(pl), "success@test.com")
+				Expect(status).To(Equal(http.StatusUnprocessableEntity))
+			})
+
+			It("should return 500 if some error occured", func() {
+				pl, _ := json.Marshal(map[string]interface{}{"userIds": []string{"user-a"}})
+				goodDB := app.DB
+				app.DB = faultyDb
+				status, _ := Post(app, baseRoute, string(pl), "success@test.com")
+				Expect(status).To(Equal(http.StatusInternalServerError))
+				app.DB = goodDB
+			})
+		})
+	})
+
+	Describe("Post /apps/:id/suppressions/remove", func() {
+		It("should return 200 and remove the user ids", func() {
+			CreateTestSuppressedUser(app.DB, existingApp.ID, "user-a")
+			CreateTestSuppressedUser(app.DB, existingApp.ID, "user-b")
+
+			pl, _ := json.Marshal(map[string]interface{}{"userIds": []string{"user-a", "user-c"}})
+			status, body := Post(app, fmt.Sprintf("%s/remove", baseRoute), string(pl), "success@test.com")
+			Expect(status).To(Equal(http.StatusOK))
+			Expect(body).To(ContainSubstring(`"removed":1`))
+			Expect(countSuppressedUsers()).To(Equal(1))
+
+			Expect(suppressionsVersion()).To(Equal(1))
+		})
+	})
+
+	Describe("Delete /apps/:id/suppressions/:uid", func() {
+		It("should return 204 and remove the user", func() {
+			CreateTestSuppressedUser(app.DB, existingApp.ID, "user-a")
+			status, _ := Delete(app, fmt.Sprintf("%s/user-a", baseRoute), "success@test.com")
+			Expect(status).To(Equal(http.StatusNoContent))
+			Expect(countSuppressedUsers()).To(Equal(0))
+		})
+
+		It("should return 404 if the user is not suppressed", func() {
+			status, _ := Delete(app, fmt.Sprintf("%s/user-a", baseRoute), "success@test.com")
+			Expect(status).To(Equal(http.StatusNotFound))
+		})
+	})
+})
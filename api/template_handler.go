@@ -23,19 +23,87 @@
 package api
 
 import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
+	"reflect"
+	"strconv"
 	"strings"
 	"time"
 
 	"gopkg.in/pg.v5/types"
+	yaml "gopkg.in/yaml.v2"
 
 	"github.com/labstack/echo"
 	"github.com/satori/go.uuid"
 	"github.com/topfreegames/marathon/log"
 	"github.com/topfreegames/marathon/model"
+	"github.com/topfreegames/marathon/worker"
 	"github.com/uber-go/zap"
 )
 
+// TemplateLint is the result of a template lint
+type TemplateLint struct {
+	Valid    bool                         `json:"valid"`
+	Findings []worker.TemplateLintFinding `json:"findings"`
+}
+
+// getLintServices returns the services of the app used to lint its templates, none if the app does not exist
+func (a *Application) getLintServices(c echo.Context, aid uuid.UUID) ([]string, error) {
+	app := &model.App{ID: aid}
+	err := WithSegment("db-select", c, func() error {
+		return a.DB.Select(&app)
+	})
+	if err != nil {
+		if err.Error() == RecordNotFoundString {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var services []string
+	err = WithSegment("push-db-select", c, func() error {
+		services, err = worker.GetAppServices(a.PushDB, app.Name)
+		return err
+	})
+	return services, err
+}
+
+// lintTemplate lints the template for the services of its app, or for all services if the app does not exist
+func (a *Application) lintTemplate(c echo.Context, template *model.Template) ([]worker.TemplateLintFinding, error) {
+	services, err := a.getLintServices(c, template.AppID)
+	if err != nil {
+		return nil, err
+	}
+	return worker.LintTemplate(*template, services), nil
+}
+
+// lintFindingsReason joins the findings of a template lint in an error reason
+func lintFindingsReason(findings []worker.TemplateLintFinding) string {
+	reasons := make([]string, len(findings))
+	for i, finding := range findings {
+		reasons[i] = fmt.Sprintf("%s: %s", finding.Field, finding.Message)
+	}
+	return strings.Join(reasons, "; ")
+}
+
+// checkTemplateLint lints the template and writes the error response if it has findings, returning whether
+// the template can be saved
+func (a *Application) checkTemplateLint(c echo.Context, l zap.Logger, template *model.Template) (bool, error) {
+	findings, err := a.lintTemplate(c, template)
+	if err != nil {
+		log.E(l, "Failed to lint template.", func(cm log.CM) {
+			cm.Write(zap.Error(err))
+		})
+		return false, c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error(), Value: template})
+	}
+	if len(findings) > 0 {
+		return false, c.JSON(http.StatusUnprocessableEntity, &Error{Reason: lintFindingsReason(findings), Value: template})
+	}
+	return true, nil
+}
+
 // ListTemplatesHandler is the method called when a get to /apps/:aid/templates is called
 func (a *Application) ListTemplatesHandler(c echo.Context) error {
 	l := a.Logger.With(
@@ -78,6 +146,7 @@ func (a *Application) PostTemplateHandler(c echo.Context) error {
 	template := &model.Template{
 		ID:        uuid.NewV4(),
 		AppID:     aid,
+		Version:   1,
 		CreatedBy: email,
 		CreatedAt: time.Now().UnixNano(),
 		UpdatedAt: time.Now().UnixNano(),
@@ -88,6 +157,9 @@ func (a *Application) PostTemplateHandler(c echo.Context) error {
 	if err != nil {
 		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error(), Value: template})
 	}
+	if ok, err := a.checkTemplateLint(c, l, template); !ok {
+		return err
+	}
 	err = WithSegment("db-insert", c, func() error {
 		return a.DB.Insert(&template)
 	})
@@ -109,6 +181,49 @@ func (a *Application) PostTemplateHandler(c echo.Context) error {
 	return c.JSON(http.StatusCreated, template)
 }
 
+// LintTemplateHandler is the method called when a post to /apps/:aid/templates/lint is called. It returns the
+// findings of the template validation and lint without saving it
+func (a *Application) LintTemplateHandler(c echo.Context) error {
+	l := a.Logger.With(
+		zap.String("source", "templateHandler"),
+		zap.String("operation", "lintTemplate"),
+		zap.String("appId", c.Param("aid")),
+	)
+	aid, err := uuid.FromString(c.Param("aid"))
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error()})
+	}
+	email := c.Get("user-email").(string)
+	template := &model.Template{
+		AppID:     aid,
+		CreatedBy: email,
+	}
+	err = WithSegment("decode", c, func() error {
+		defer c.Request().Body.Close()
+		return json.NewDecoder(c.Request().Body).Decode(template)
+	})
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error(), Value: template})
+	}
+	lint := &TemplateLint{Findings: []worker.TemplateLintFinding{}}
+	if err := template.Validate(c); err != nil {
+		lint.Findings = append(lint.Findings, worker.TemplateLintFinding{Field: "template", Message: err.Error()})
+	}
+	// bodies that do not parse are already reported by the validation
+	if _, err := model.TemplatePlaceholders(template); err == nil && len(template.Body) > 0 {
+		findings, err := a.lintTemplate(c, template)
+		if err != nil {
+			log.E(l, "Failed to lint template.", func(cm log.CM) {
+				cm.Write(zap.Error(err))
+			})
+			return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error(), Value: template})
+		}
+		lint.Findings = append(lint.Findings, findings...)
+	}
+	lint.Valid = len(lint.Findings) == 0
+	return c.JSON(http.StatusOK, lint)
+}
+
 // GetTemplateHandler is the method called when a get to /apps/:aid/templates/:tid is called
 func (a *Application) GetTemplateHandler(c echo.Context) error {
 	l := a.Logger.With(
@@ -162,6 +277,7 @@ func (a *Application) PutTemplateHandler(c echo.Context) error {
 		ID:        tid,
 		AppID:     aid,
 		CreatedBy: email,
+		UpdatedBy: email,
 		UpdatedAt: time.Now().UnixNano(),
 	}
 	err = WithSegment("decodeAndValidate", c, func() error {
@@ -172,9 +288,13 @@ func (a *Application) PutTemplateHandler(c echo.Context) error {
 	}
 	template.ID = tid
 	template.AppID = aid
+	template.UpdatedBy = email
+	if ok, err := a.checkTemplateLint(c, l, template); !ok {
+		return err
+	}
 	var values *types.Result
 	err = WithSegment("db-update", c, func() error {
-		values, err = a.DB.Model(&template).Column("name").Column("locale").Column("defaults").Column("body").Column("updated_at").Returning("*").Update()
+		values, err = a.DB.Model(&template).Column("name").Column("locale").Column("defaults").Column("body").Column("service_bodies").Column("engine").Column("updated_by").Column("updated_at").Returning("*").Update()
 		return err
 	})
 	if err != nil {
@@ -195,6 +315,103 @@ func (a *Application) PutTemplateHandler(c echo.Context) error {
 	return c.JSON(http.StatusOK, template)
 }
 
+// ListTemplateVersionsHandler is the method called when a get to /apps/:aid/templates/:tid/versions is called
+func (a *Application) ListTemplateVersionsHandler(c echo.Context) error {
+	l := a.Logger.With(
+		zap.String("source", "templateHandler"),
+		zap.String("operation", "listTemplateVersions"),
+		zap.String("appId", c.Param("aid")),
+		zap.String("templateId", c.Param("tid")),
+	)
+	aid, err := uuid.FromString(c.Param("aid"))
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error()})
+	}
+	tid, err := uuid.FromString(c.Param("tid"))
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error()})
+	}
+	versions := []model.TemplateVersion{}
+	err = WithSegment("db-select", c, func() error {
+		return a.DB.Model(&versions).Where("template_id = ? AND app_id = ?", tid, aid).Order("version DESC").Select()
+	})
+	if err != nil {
+		log.E(l, "Failed to list template versions.", func(cm log.CM) {
+			cm.Write(zap.Error(err))
+		})
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error()})
+	}
+	if len(versions) == 0 {
+		return c.JSON(http.StatusNotFound, map[string]string{})
+	}
+	return c.JSON(http.StatusOK, versions)
+}
+
+// RollbackTemplateHandler is the method called when a post to /apps/:aid/templates/:tid/versions/:version/rollback
+// is called. It restores the defaults, body and engine of the template version, creating a new version of the template
+func (a *Application) RollbackTemplateHandler(c echo.Context) error {
+	l := a.Logger.With(
+		zap.String("source", "templateHandler"),
+		zap.String("operation", "rollbackTemplate"),
+		zap.String("appId", c.Param("aid")),
+		zap.String("templateId", c.Param("tid")),
+		zap.String("version", c.Param("version")),
+	)
+	aid, err := uuid.FromString(c.Param("aid"))
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error()})
+	}
+	tid, err := uuid.FromString(c.Param("tid"))
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error()})
+	}
+	version, err := strconv.Atoi(c.Param("version"))
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error()})
+	}
+	templateVersion := &model.TemplateVersion{}
+	err = WithSegment("db-select", c, func() error {
+		return a.DB.Model(&templateVersion).Where("template_id = ? AND app_id = ? AND version = ?", tid, aid, version).First()
+	})
+	if err != nil {
+		if err.Error() == RecordNotFoundString {
+			return c.JSON(http.StatusNotFound, &Error{Reason: err.Error()})
+		}
+		log.E(l, "Failed to retrieve template version.", func(cm log.CM) {
+			cm.Write(zap.Error(err))
+		})
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error()})
+	}
+	template := &model.Template{
+		ID:            tid,
+		AppID:         aid,
+		Defaults:      templateVersion.Defaults,
+		Body:          templateVersion.Body,
+		ServiceBodies: templateVersion.ServiceBodies,
+		Engine:        templateVersion.Engine,
+		UpdatedBy:     c.Get("user-email").(string),
+		UpdatedAt:     time.Now().UnixNano(),
+	}
+	var values *types.Result
+	err = WithSegment("db-update", c, func() error {
+		values, err = a.DB.Model(&template).Column("defaults").Column("body").Column("service_bodies").Column("engine").Column("updated_by").Column("updated_at").Returning("*").Update()
+		return err
+	})
+	if err != nil {
+		log.E(l, "Failed to rollback template.", func(cm log.CM) {
+			cm.Write(zap.Error(err))
+		})
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error(), Value: template})
+	}
+	if values.RowsAffected() == 0 {
+		return c.JSON(http.StatusNotFound, map[string]string{})
+	}
+	log.D(l, "Rolled back template successfully.", func(cm log.CM) {
+		cm.Write(zap.Object("template", template))
+	})
+	return c.JSON(http.StatusOK, template)
+}
+
 // DeleteTemplateHandler is the method called when a delete to /apps/:aid/templates/:tid is called
 func (a *Application) DeleteTemplateHandler(c echo.Context) error {
 	l := a.Logger.With(
@@ -231,3 +448,502 @@ func (a *Application) DeleteTemplateHandler(c echo.Context) error {
 	})
 	return c.JSON(http.StatusNoContent, "")
 }
+
+// maxTemplatesDocumentSize is the largest templates document in bytes accepted by the templates import
+const maxTemplatesDocumentSize = 10 << 20
+
+// errTemplatesDocumentTooLarge is returned when the templates document is larger than maxTemplatesDocumentSize
+var errTemplatesDocumentTooLarge = fmt.Errorf("templates document is larger than %d bytes", maxTemplatesDocumentSize)
+
+// TemplatesDocument is the document with the templates of an app used by the templates export and import
+type TemplatesDocument struct {
+	Templates []DocumentTemplate `json:"templates"`
+}
+
+// DocumentTemplate is a template of a templates document
+type DocumentTemplate struct {
+	Name          string                            `json:"name"`
+	Locale        string                            `json:"locale"`
+	Engine        string                            `json:"engine,omitempty"`
+	Defaults      map[string]interface{}            `json:"defaults"`
+	Body          map[string]interface{}            `json:"body"`
+	ServiceBodies map[string]map[string]interface{} `json:"serviceBodies,omitempty"`
+}
+
+// TemplateKey identifies a template of an app
+type TemplateKey struct {
+	Name   string `json:"name"`
+	Locale string `json:"locale"`
+}
+
+// TemplatesImport is the result of a templates import
+type TemplatesImport struct {
+	DryRun    bool          `json:"dryRun"`
+	Created   []TemplateKey `json:"created"`
+	Updated   []TemplateKey `json:"updated"`
+	Deleted   []TemplateKey `json:"deleted"`
+	Unchanged int           `json:"unchanged"`
+}
+
+func isYAMLRequest(c echo.Context) bool {
+	if format := c.QueryParam("format"); format != "" {
+		return format == "yaml"
+	}
+	return strings.Contains(c.Request().Header.Get(echo.HeaderContentType), "yaml")
+}
+
+// jsonValue converts the maps decoded from yaml, which have interface{} keys, to maps with string keys
+func jsonValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for key, child := range v {
+			result[fmt.Sprint(key)] = jsonValue(child)
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, child := range v {
+			result[i] = jsonValue(child)
+		}
+		return result
+	}
+	return value
+}
+
+func decodeTemplatesDocument(c echo.Context, document *TemplatesDocument) error {
+	defer c.Request().Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(c.Request().Body, maxTemplatesDocumentSize+1))
+	if err != nil {
+		return err
+	}
+	if len(body) > maxTemplatesDocumentSize {
+		return errTemplatesDocumentTooLarge
+	}
+	if isYAMLRequest(c) {
+		var value interface{}
+		if err := yaml.Unmarshal(body, &value); err != nil {
+			return err
+		}
+		body, err = json.Marshal(jsonValue(value))
+		if err != nil {
+			return err
+		}
+	}
+	return json.Unmarshal(body, document)
+}
+
+func templateEngine(engine string) string {
+	if engine == "" {
+		return model.SimpleTemplateEngine
+	}
+	return engine
+}
+
+// ExportTemplatesHandler is the method called when a get to /apps/:aid/templates/export is called. It returns the
+// templates of the app, or the ones with the name in the query string, as a json or yaml document
+func (a *Application) ExportTemplatesHandler(c echo.Context) error {
+	l := a.Logger.With(
+		zap.String("source", "templateHandler"),
+		zap.String("operation", "exportTemplates"),
+		zap.String("appId", c.Param("aid")),
+	)
+	aid, err := uuid.FromString(c.Param("aid"))
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error()})
+	}
+	templates := []model.Template{}
+	err = WithSegment("db-select", c, func() error {
+		query := a.DB.Model(&templates).Where("app_id = ?", aid)
+		if name := c.QueryParam("name"); name != "" {
+			query = query.Where("name = ?", name)
+		}
+		return query.Order("name").Order("locale").Select()
+	})
+	if err != nil {
+		log.E(l, "Failed to list templates.", func(cm log.CM) {
+			cm.Write(zap.Error(err))
+		})
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error()})
+	}
+	document := &TemplatesDocument{Templates: []DocumentTemplate{}}
+	for _, template := range templates {
+		document.Templates = append(document.Templates, DocumentTemplate{
+			Name:          template.Name,
+			Locale:        template.Locale,
+			Engine:        template.Engine,
+			Defaults:      template.Defaults,
+			Body:          template.Body,
+			ServiceBodies: template.ServiceBodies,
+		})
+	}
+	if c.QueryParam("format") != "yaml" {
+		return c.JSON(http.StatusOK, document)
+	}
+	// marshal to json first so that the yaml keys are the json ones
+	var value interface{}
+	body, err := json.Marshal(document)
+	if err == nil {
+		err = json.Unmarshal(body, &value)
+	}
+	if err == nil {
+		body, err = yaml.Marshal(value)
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error()})
+	}
+	return c.Blob(http.StatusOK, "application/x-yaml", body)
+}
+
+// ImportTemplatesHandler is the method called when a post to /apps/:aid/templates/import is called. It creates the
+// templates of the document that do not exist, updates the ones that changed and deletes the locales of the document
+// template names that are not in the document, or every template of the app not in the document if prune is true.
+// All changes are made in one transaction and none is made if dryRun is true
+func (a *Application) ImportTemplatesHandler(c echo.Context) error {
+	l := a.Logger.With(
+		zap.String("source", "templateHandler"),
+		zap.String("operation", "importTemplates"),
+		zap.String("appId", c.Param("aid")),
+	)
+	aid, err := uuid.FromString(c.Param("aid"))
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error()})
+	}
+	email := c.Get("user-email").(string)
+	document := &TemplatesDocument{}
+	err = WithSegment("decode", c, func() error {
+		return decodeTemplatesDocument(c, document)
+	})
+	if err == errTemplatesDocumentTooLarge {
+		return c.JSON(http.StatusRequestEntityTooLarge, &Error{Reason: err.Error()})
+	}
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error()})
+	}
+
+	app := &model.App{ID: aid}
+	err = WithSegment("db-select", c, func() error {
+		return a.DB.Select(&app)
+	})
+	if err != nil {
+		if err.Error() == RecordNotFoundString {
+			return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: "App not found with given id."})
+		}
+		log.E(l, "Failed to retrieve app.", func(cm log.CM) {
+			cm.Write(zap.Error(err))
+		})
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error()})
+	}
+	services, err := a.getLintServices(c, aid)
+	if err != nil {
+		log.E(l, "Failed to retrieve app services.", func(cm log.CM) {
+			cm.Write(zap.Error(err))
+		})
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error()})
+	}
+
+	existing := []model.Template{}
+	err = WithSegment("db-select", c, func() error {
+		return a.DB.Model(&existing).Where("app_id = ?", aid).Select()
+	})
+	if err != nil {
+		log.E(l, "Failed to list templates.", func(cm log.CM) {
+			cm.Write(zap.Error(err))
+		})
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error()})
+	}
+	existingByKey := map[TemplateKey]model.Template{}
+	for _, template := range existing {
+		existingByKey[TemplateKey{Name: template.Name, Locale: template.Locale}] = template
+	}
+
+	result := &TemplatesImport{
+		DryRun:  c.QueryParam("dryRun") == "true",
+		Created: []TemplateKey{},
+		Updated: []TemplateKey{},
+		Deleted: []TemplateKey{},
+	}
+	creates := []*model.Template{}
+	updates := []*model.Template{}
+	deletes := []model.Template{}
+	names := map[string]bool{}
+	imported := map[TemplateKey]bool{}
+	reasons := []string{}
+	now := time.Now().UnixNano()
+	for _, documentTemplate := range document.Templates {
+		key := TemplateKey{Name: documentTemplate.Name, Locale: documentTemplate.Locale}
+		if imported[key] {
+			reasons = append(reasons, fmt.Sprintf("%s/%s: duplicated template", key.Name, key.Locale))
+			continue
+		}
+		imported[key] = true
+		names[key.Name] = true
+		template := &model.Template{
+			ID:            uuid.NewV4(),
+			AppID:         aid,
+			Name:          documentTemplate.Name,
+			Locale:        documentTemplate.Locale,
+			Engine:        documentTemplate.Engine,
+			Defaults:      documentTemplate.Defaults,
+			Body:          documentTemplate.Body,
+			ServiceBodies: documentTemplate.ServiceBodies,
+			Version:       1,
+			CreatedBy:     email,
+			UpdatedBy:     email,
+			CreatedAt:     now,
+			UpdatedAt:     now,
+		}
+		if err := template.Validate(c); err != nil {
+			reasons = append(reasons, fmt.Sprintf("%s/%s: %s", key.Name, key.Locale, err.Error()))
+			continue
+		}
+		if findings := worker.LintTemplate(*template, services); len(findings) > 0 {
+			reasons = append(reasons, fmt.Sprintf("%s/%s: %s", key.Name, key.Locale, lintFindingsReason(findings)))
+			continue
+		}
+		current, ok := existingByKey[key]
+		if !ok {
+			creates = append(creates, template)
+			result.Created = append(result.Created, key)
+			continue
+		}
+		if templateEngine(current.Engine) == templateEngine(template.Engine) &&
+			reflect.DeepEqual(current.Defaults, template.Defaults) && reflect.DeepEqual(current.Body, template.Body) &&
+			(len(current.ServiceBodies) == 0 && len(template.ServiceBodies) == 0 || reflect.DeepEqual(current.ServiceBodies, template.ServiceBodies)) {
+			result.Unchanged++
+			continue
+		}
+		template.ID = current.ID
+		updates = append(updates, template)
+		result.Updated = append(result.Updated, key)
+	}
+	if len(reasons) > 0 {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: strings.Join(reasons, "; ")})
+	}
+	prune := c.QueryParam("prune") == "true"
+	for _, template := range existing {
+		key := TemplateKey{Name: template.Name, Locale: template.Locale}
+		if (prune || names[key.Name]) && !imported[key] {
+			deletes = append(deletes, template)
+			result.Deleted = append(result.Deleted, key)
+		}
+	}
+	if result.DryRun {
+		return c.JSON(http.StatusOK, result)
+	}
+
+	err = WithSegment("db-transaction", c, func() error {
+		tx, err := a.DB.Begin()
+		if err != nil {
+			return err
+		}
+		for _, template := range deletes {
+			if _, err := tx.Model(&model.Template{}).Where("id = ?", template.ID).Delete(); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+		for _, template := range updates {
+			if _, err := tx.Model(template).Column("defaults").Column("body").Column("service_bodies").Column("engine").Column("updated_by").Column("updated_at").Update(); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+		for _, template := range creates {
+			if err := tx.Insert(template); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+		return tx.Commit()
+	})
+	if err != nil {
+		if strings.Contains(err.Error(), "duplicate key") {
+			return c.JSON(http.StatusConflict, &Error{Reason: err.Error()})
+		}
+		log.E(l, "Failed to import templates.", func(cm log.CM) {
+			cm.Write(zap.Error(err))
+		})
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error()})
+	}
+	log.D(l, "Imported templates successfully.", func(cm log.CM) {
+		cm.Write(zap.Object("result", result))
+	})
+	return c.JSON(http.StatusOK, result)
+}
+
+// TemplateRenderRequest is the payload of the template render preview
+type TemplateRenderRequest struct {
+	Context  map[string]interface{} `json:"context"`
+	Metadata map[string]interface{} `json:"metadata"`
+	Service  string                 `json:"service"`
+	User     worker.User            `json:"user"`
+}
+
+// Validate implementation of the InputValidation interface
+func (r *TemplateRenderRequest) Validate(c echo.Context) error {
+	if r.Service == "" {
+		return nil
+	}
+	for _, service := range worker.PushServices {
+		if r.Service == service {
+			return nil
+		}
+	}
+	return model.InvalidField("service")
+}
+
+// TemplateRenderPayload is the push sent to a service for a rendered template
+type TemplateRenderPayload struct {
+	Body           map[string]interface{} `json:"body"`
+	Message        json.RawMessage        `json:"message"`
+	Size           int                    `json:"size"`
+	PayloadSize    int                    `json:"payloadSize"`
+	MaxPayloadSize int                    `json:"maxPayloadSize"`
+}
+
+// TemplateRender is the result of a template render preview
+type TemplateRender struct {
+	TemplateID uuid.UUID                        `json:"templateId"`
+	Locale     string                           `json:"locale"`
+	Body       map[string]interface{}           `json:"body"`
+	Payloads   map[string]TemplateRenderPayload `json:"payloads"`
+}
+
+// getRenderTemplate returns the template of the same name as template that a user with the given locale receives,
+// or template itself if the locale is empty or no locale of its fallback chain has a template
+func (a *Application) getRenderTemplate(c echo.Context, template *model.Template, locale string) (*model.Template, error) {
+	if locale == "" {
+		return template, nil
+	}
+	templates := []model.Template{}
+	err := WithSegment("db-select", c, func() error {
+		return a.DB.Model(&templates).Where("app_id = ? AND name = ?", template.AppID, template.Name).Select()
+	})
+	if err != nil {
+		return nil, err
+	}
+	templatesByLocale := map[string]*model.Template{}
+	for i := range templates {
+		templatesByLocale[model.NormalizeLocale(templates[i].Locale)] = &templates[i]
+	}
+	for _, l := range template.App.LocaleFallbackChain(locale) {
+		if localeTemplate, ok := templatesByLocale[l]; ok {
+			return localeTemplate, nil
+		}
+	}
+	return template, nil
+}
+
+func renderTemplateBody(template model.Template, request *TemplateRenderRequest) (map[string]interface{}, error) {
+	msgStr, err := worker.BuildMessageFromTemplate(template, request.Context, request.User.Vars, request.User.Locale)
+	if err != nil {
+		return nil, err
+	}
+	var body map[string]interface{}
+	err = json.Unmarshal([]byte(msgStr), &body)
+	return body, err
+}
+
+// RenderTemplateHandler is the method called when a post to /apps/:aid/templates/:tid/render is called. It returns
+// the body rendered with the context and the push of each service as sent to kafka. If a user locale is given the
+// template of the same name that the user receives is rendered instead
+func (a *Application) RenderTemplateHandler(c echo.Context) error {
+	l := a.Logger.With(
+		zap.String("source", "templateHandler"),
+		zap.String("operation", "renderTemplate"),
+		zap.String("appId", c.Param("aid")),
+		zap.String("templateId", c.Param("tid")),
+	)
+	aid, err := uuid.FromString(c.Param("aid"))
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error()})
+	}
+	tid, err := uuid.FromString(c.Param("tid"))
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error()})
+	}
+	request := &TemplateRenderRequest{}
+	err = WithSegment("decodeAndValidate", c, func() error {
+		return decodeAndValidate(c, request)
+	})
+	if err != nil && err != io.EOF {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error(), Value: request})
+	}
+
+	template := &model.Template{ID: tid}
+	err = WithSegment("db-select", c, func() error {
+		return a.DB.Model(&template).Column("template.*", "App").Where("template.id = ? AND template.app_id = ?", tid, aid).Select()
+	})
+	if err != nil {
+		if err.Error() == RecordNotFoundString {
+			return c.JSON(http.StatusNotFound, map[string]string{})
+		}
+		log.E(l, "Failed to retrieve template.", func(cm log.CM) {
+			cm.Write(zap.Error(err))
+		})
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error()})
+	}
+	template, err = a.getRenderTemplate(c, template, request.User.Locale)
+	if err != nil {
+		log.E(l, "Failed to retrieve templates.", func(cm log.CM) {
+			cm.Write(zap.Error(err))
+		})
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error()})
+	}
+
+	render := &TemplateRender{
+		TemplateID: template.ID,
+		Locale:     template.Locale,
+		Payloads:   map[string]TemplateRenderPayload{},
+	}
+	render.Body, err = renderTemplateBody(*template, request)
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error(), Value: request})
+	}
+
+	services := []string{request.Service}
+	if request.Service == "" {
+		services, err = a.getLintServices(c, aid)
+		if err != nil {
+			log.E(l, "Failed to retrieve app services.", func(cm log.CM) {
+				cm.Write(zap.Error(err))
+			})
+			return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error()})
+		}
+		if len(services) == 0 {
+			services = worker.PushServices
+		}
+	}
+	job := &model.Job{TemplateName: template.Name, Metadata: request.Metadata}
+	for _, service := range services {
+		body := render.Body
+		if _, ok := template.ServiceBodies[service]; ok {
+			body, err = renderTemplateBody(template.ServiceTemplate(service), request)
+			if err != nil {
+				return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error(), Value: request})
+			}
+		}
+		// the gcm message adds the metadata to the map it is built with
+		msg := make(map[string]interface{}, len(body))
+		for key, value := range body {
+			msg[key] = value
+		}
+		pushMessage, err := worker.BuildPushMessage(service, request.User.Token, msg, request.Metadata, worker.BuildPushMetadata(job, request.User), 0)
+		if err != nil {
+			return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error(), Value: request})
+		}
+		payloadSize, maxPayloadSize, err := worker.PushPayloadSize(service, body, request.Metadata)
+		if err != nil {
+			return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error(), Value: request})
+		}
+		render.Payloads[service] = TemplateRenderPayload{
+			Body:           body,
+			Message:        json.RawMessage(pushMessage),
+			Size:           len(pushMessage),
+			PayloadSize:    payloadSize,
+			MaxPayloadSize: maxPayloadSize,
+		}
+	}
+	return c.JSON(http.StatusOK, render)
+}
@@ -189,6 +189,58 @@ var _ = Describe("Template Handler", func() {
 				}
 
 			})
+			It("should return 201 and the created template with the go template engine", func() {
+				payload := GetTemplatePayload()
+				payload["engine"] = "gotemplate"
+				payload["body"] = map[string]interface{}{
+					"alert": "{{if .vip}}Dear{{else}}Hi{{end}} {{.user_name | default \"friend\"}}",
+				}
+				payload["defaults"] = map[string]interface{}{"user_name": "", "vip": false}
+				pl, _ := json.Marshal(payload)
+				status, body := Post(app, baseRoute, string(pl), "success@test.com")
+				Expect(status).To(Equal(http.StatusCreated))
+
+				var template map[string]interface{}
+				err := json.Unmarshal([]byte(body), &template)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(template["engine"]).To(Equal("gotemplate"))
+
+				id, err := uuid.FromString(template["id"].(string))
+				Expect(err).NotTo(HaveOccurred())
+				dbTemplate := &model.Template{
+					ID: id,
+				}
+				err = app.DB.Select(&dbTemplate)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(dbTemplate.Engine).To(Equal(model.GoTemplateEngine))
+			})
+			It("should return 201 and the created template with service bodies", func() {
+				payload := GetTemplatePayload()
+				payload["defaults"] = map[string]interface{}{"user_name": "Someone"}
+				payload["serviceBodies"] = map[string]interface{}{
+					"apns": map[string]interface{}{"alert": "{{user_name}} liked your village!", "badge": 1},
+					"gcm":  map[string]interface{}{"title": "Village", "message": "{{user_name}} liked your village!"},
+				}
+				pl, _ := json.Marshal(payload)
+				status, body := Post(app, baseRoute, string(pl), "success@test.com")
+				Expect(status).To(Equal(http.StatusCreated))
+
+				var template map[string]interface{}
+				err := json.Unmarshal([]byte(body), &template)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(template["serviceBodies"]).To(HaveKey("apns"))
+				Expect(template["serviceBodies"]).To(HaveKey("gcm"))
+
+				id, err := uuid.FromString(template["id"].(string))
+				Expect(err).NotTo(HaveOccurred())
+				dbTemplate := &model.Template{
+					ID: id,
+				}
+				err = app.DB.Select(&dbTemplate)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(dbTemplate.ServiceBodies["apns"]).To(Equal(map[string]interface{}{"alert": "{{user_name}} liked your village!", "badge": float64(1)}))
+				Expect(dbTemplate.ServiceBodies["gcm"]["title"]).To(Equal("Village"))
+			})
 		})
 
 		Describe("Unsucesfully", func() {
@@ -364,6 +416,129 @@ var _ = Describe("Template Handler", func() {
 				Expect(err).NotTo(HaveOccurred())
 				Expect(response["reason"]).To(ContainSubstring("cannot unmarshal string into Go value"))
 			})
+
+			It("should return 422 if invalid engine", func() {
+				payload := GetTemplatePayload()
+				payload["engine"] = "handlebars"
+				pl, _ := json.Marshal(payload)
+				status, body := Post(app, baseRoute, string(pl), "test@test.com")
+				Expect(status).To(Equal(http.StatusUnprocessableEntity))
+
+				var response map[string]interface{}
+				err := json.Unmarshal([]byte(body), &response)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(response["reason"]).To(Equal("invalid engine"))
+			})
+
+			It("should return 422 if invalid go template body", func() {
+				payload := GetTemplatePayload()
+				payload["engine"] = "gotemplate"
+				payload["body"] = map[string]interface{}{"alert": "{{if .vip}}Dear"}
+				pl, _ := json.Marshal(payload)
+				status, body := Post(app, baseRoute, string(pl), "test@test.com")
+				Expect(status).To(Equal(http.StatusUnprocessableEntity))
+
+				var response map[string]interface{}
+				err := json.Unmarshal([]byte(body), &response)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(response["reason"]).To(ContainSubstring("invalid body"))
+			})
+
+			It("should return 422 if go template body defines templates", func() {
+				payload := GetTemplatePayload()
+				payload["engine"] = "gotemplate"
+				payload["body"] = map[string]interface{}{"alert": `{{define "a"}}{{template "a"}}{{end}}{{template "a"}}`}
+				pl, _ := json.Marshal(payload)
+				status, body := Post(app, baseRoute, string(pl), "test@test.com")
+				Expect(status).To(Equal(http.StatusUnprocessableEntity))
+
+				var response map[string]interface{}
+				err := json.Unmarshal([]byte(body), &response)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(response["reason"]).To(ContainSubstring("invalid body"))
+			})
+
+			It("should return 422 if a plural argument is invalid", func() {
+				payload := GetTemplatePayload()
+				payload["defaults"] = map[string]interface{}{"count": 1}
+				payload["body"] = map[string]interface{}{"alert": "{count, plural, one {# gift} several {# gifts}}"}
+				pl, _ := json.Marshal(payload)
+				status, body := Post(app, baseRoute, string(pl), "test@test.com")
+				Expect(status).To(Equal(http.StatusUnprocessableEntity))
+
+				var response map[string]interface{}
+				err := json.Unmarshal([]byte(body), &response)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(response["reason"]).To(Equal("invalid body: body.alert: plural argument count has an invalid option 'several'"))
+			})
+
+			It("should return 422 if a plural argument has no default", func() {
+				payload := GetTemplatePayload()
+				payload["defaults"] = map[string]interface{}{"user_name": "someone"}
+				payload["body"] = map[string]interface{}{"alert": "{{user_name}} sent you {count, plural, one {# gift} other {# gifts}}"}
+				pl, _ := json.Marshal(payload)
+				status, body := Post(app, baseRoute, string(pl), "test@test.com")
+				Expect(status).To(Equal(http.StatusUnprocessableEntity))
+
+				var response map[string]interface{}
+				err := json.Unmarshal([]byte(body), &response)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(response["reason"]).To(ContainSubstring("placeholder count has no default"))
+			})
+
+			It("should return 422 if a service body is empty", func() {
+				payload := GetTemplatePayload()
+				payload["serviceBodies"] = map[string]interface{}{"apns": map[string]interface{}{}}
+				pl, _ := json.Marshal(payload)
+				status, body := Post(app, baseRoute, string(pl), "test@test.com")
+				Expect(status).To(Equal(http.StatusUnprocessableEntity))
+
+				var response map[string]interface{}
+				err := json.Unmarshal([]byte(body), &response)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(response["reason"]).To(Equal("invalid serviceBodies.apns"))
+			})
+
+			It("should return 422 if a placeholder of a service body has no default", func() {
+				payload := GetTemplatePayload()
+				payload["serviceBodies"] = map[string]interface{}{"gcm": map[string]interface{}{"message": "{{object_name}}"}}
+				pl, _ := json.Marshal(payload)
+				status, body := Post(app, baseRoute, string(pl), "test@test.com")
+				Expect(status).To(Equal(http.StatusUnprocessableEntity))
+
+				var response map[string]interface{}
+				err := json.Unmarshal([]byte(body), &response)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(response["reason"]).To(ContainSubstring("placeholder object_name has no default"))
+			})
+
+			It("should return 422 if a placeholder has no default", func() {
+				payload := GetTemplatePayload()
+				payload["body"] = map[string]interface{}{"alert": "{{user_name}} just liked your {{object_name}}!"}
+				payload["defaults"] = map[string]interface{}{"user_name": "Someone"}
+				pl, _ := json.Marshal(payload)
+				status, body := Post(app, baseRoute, string(pl), "test@test.com")
+				Expect(status).To(Equal(http.StatusUnprocessableEntity))
+
+				var response map[string]interface{}
+				err := json.Unmarshal([]byte(body), &response)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(response["reason"]).To(Equal("defaults: placeholder object_name has no default"))
+			})
+
+			It("should return 422 if the rendered body exceeds the payload size limit", func() {
+				payload := GetTemplatePayload()
+				payload["body"] = map[string]interface{}{"alert": strings.Repeat("a", 5000)}
+				pl, _ := json.Marshal(payload)
+				status, body := Post(app, baseRoute, string(pl), "test@test.com")
+				Expect(status).To(Equal(http.StatusUnprocessableEntity))
+
+				var response map[string]interface{}
+				err := json.Unmarshal([]byte(body), &response)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(response["reason"]).To(ContainSubstring("apns payload has"))
+				Expect(response["reason"]).To(ContainSubstring("gcm payload has"))
+			})
 		})
 	})
 
@@ -464,6 +639,7 @@ var _ = Describe("Template Handler", func() {
 				Expect(template["name"]).To(Equal(payload["name"]))
 				Expect(template["locale"]).To(Equal(payload["locale"]))
 				Expect(template["createdBy"]).To(Equal(existingTemplate.CreatedBy))
+				Expect(template["updatedBy"]).To(Equal("success@test.com"))
 				Expect(int64(template["createdAt"].(float64))).To(Equal(existingTemplate.CreatedAt))
 				Expect(template["updatedAt"]).ToNot(Equal(existingTemplate.UpdatedAt))
 
@@ -698,6 +874,610 @@ var _ = Describe("Template Handler", func() {
 				Expect(err).NotTo(HaveOccurred())
 				Expect(response["reason"]).To(ContainSubstring("cannot unmarshal string into Go value"))
 			})
+
+			It("should return 422 if a placeholder has no default", func() {
+				existingTemplate := CreateTestTemplate(app.DB, existingApp.ID)
+				payload := GetTemplatePayload()
+				payload["body"] = map[string]interface{}{"alert": "{{user_name}} just liked your village!"}
+				pl, _ := json.Marshal(payload)
+				status, body := Put(app, fmt.Sprintf("%s/%s", baseRoute, existingTemplate.ID), string(pl), "test@test.com")
+				Expect(status).To(Equal(http.StatusUnprocessableEntity))
+
+				var response map[string]interface{}
+				err := json.Unmarshal([]byte(body), &response)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(response["reason"]).To(Equal("defaults: placeholder user_name has no default"))
+
+				dbTemplate := &model.Template{ID: existingTemplate.ID}
+				err = app.DB.Select(&dbTemplate)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(dbTemplate.Body).To(Equal(existingTemplate.Body))
+			})
+		})
+	})
+
+	Describe("Post /apps/:id/templates/lint", func() {
+		Describe("Sucesfully", func() {
+			It("should return 200 and no findings for a valid template", func() {
+				payload := GetTemplatePayload()
+				pl, _ := json.Marshal(payload)
+				status, body := Post(app, fmt.Sprintf("%s/lint", baseRoute), string(pl), "test@test.com")
+				Expect(status).To(Equal(http.StatusOK))
+
+				var lint map[string]interface{}
+				err := json.Unmarshal([]byte(body), &lint)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(lint["valid"]).To(BeTrue())
+				Expect(lint["findings"]).To(BeEmpty())
+			})
+
+			It("should return 200 and all the findings without saving the template", func() {
+				payload := GetTemplatePayload()
+				payload["name"] = strings.Repeat("a", 256)
+				payload["body"] = map[string]interface{}{"alert": "{{user_name}} just liked your {{object_name}}!"}
+				payload["defaults"] = map[string]interface{}{"user_name": "Someone"}
+				pl, _ := json.Marshal(payload)
+				status, body := Post(app, fmt.Sprintf("%s/lint", baseRoute), string(pl), "test@test.com")
+				Expect(status).To(Equal(http.StatusOK))
+
+				var lint map[string]interface{}
+				err := json.Unmarshal([]byte(body), &lint)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(lint["valid"]).To(BeFalse())
+				Expect(lint["findings"]).To(Equal([]interface{}{
+					map[string]interface{}{"field": "template", "message": "invalid name"},
+					map[string]interface{}{"field": "defaults", "message": "placeholder object_name has no default"},
+				}))
+
+				count, err := app.DB.Model(&model.Template{}).Where("app_id = ?", existingApp.ID).Count()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(count).To(Equal(0))
+			})
+		})
+
+		Describe("Unsucesfully", func() {
+			It("should return 401 if no authenticated user", func() {
+				status, _ := Post(app, fmt.Sprintf("%s/lint", baseRoute), "", "")
+				Expect(status).To(Equal(http.StatusUnauthorized))
+			})
+
+			It("should return 422 if app id is not UUID", func() {
+				pl, _ := json.Marshal(GetTemplatePayload())
+				status, _ := Post(app, "/apps/not-uuid/templates/lint", string(pl), "test@test.com")
+				Expect(status).To(Equal(http.StatusUnprocessableEntity))
+			})
+
+			It("should return 422 if invalid body", func() {
+				payload := GetTemplatePayload()
+				payload["body"] = "not-json"
+				pl, _ := json.Marshal(payload)
+				status, body := Post(app, fmt.Sprintf("%s/lint", baseRoute), string(pl), "test@test.com")
+				Expect(status).To(Equal(http.StatusUnprocessableEntity))
+
+				var response map[string]interface{}
+				err := json.Unmarshal([]byte(body), &response)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(response["reason"]).To(ContainSubstring("cannot unmarshal string into Go value"))
+			})
+		})
+	})
+
+	Describe("Get /apps/:id/templates/export", func() {
+		Describe("Sucesfully", func() {
+			It("should return 200 and the templates of the app sorted by name and locale", func() {
+				CreateTestTemplate(app.DB, existingApp.ID, map[string]interface{}{"name": "b-template", "locale": "en"})
+				CreateTestTemplate(app.DB, existingApp.ID, map[string]interface{}{"name": "a-template", "locale": "pt"})
+				CreateTestTemplate(app.DB, existingApp.ID, map[string]interface{}{"name": "a-template", "locale": "en"})
+				status, body := Get(app, fmt.Sprintf("%s/export", baseRoute), "test@test.com")
+				Expect(status).To(Equal(http.StatusOK))
+
+				var document api.TemplatesDocument
+				err := json.Unmarshal([]byte(body), &document)
+				Expect(err).NotTo(HaveOccurred())
+				keys := []string{}
+				for _, template := range document.Templates {
+					keys = append(keys, fmt.Sprintf("%s/%s", template.Name, template.Locale))
+					Expect(template.Body).NotTo(BeEmpty())
+					Expect(template.Defaults).NotTo(BeEmpty())
+				}
+				Expect(keys).To(Equal([]string{"a-template/en", "a-template/pt", "b-template/en"}))
+			})
+
+			It("should return 200 and the templates with the given name", func() {
+				CreateTestTemplate(app.DB, existingApp.ID, map[string]interface{}{"name": "b-template", "locale": "en"})
+				CreateTestTemplate(app.DB, existingApp.ID, map[string]interface{}{"name": "a-template", "locale": "en"})
+				status, body := Get(app, fmt.Sprintf("%s/export?name=b-template", baseRoute), "test@test.com")
+				Expect(status).To(Equal(http.StatusOK))
+
+				var document api.TemplatesDocument
+				err := json.Unmarshal([]byte(body), &document)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(document.Templates).To(HaveLen(1))
+				Expect(document.Templates[0].Name).To(Equal("b-template"))
+			})
+
+			It("should return 200 and a yaml document if format is yaml", func() {
+				CreateTestTemplate(app.DB, existingApp.ID, map[string]interface{}{"name": "a-template", "locale": "en"})
+				status, body, headers := DoRequest(app, "GET", fmt.Sprintf("%s/export?format=yaml", baseRoute), "", "test@test.com", map[string]string{})
+				Expect(status).To(Equal(http.StatusOK))
+				Expect(headers.Get("Content-Type")).To(Equal("application/x-yaml"))
+				Expect(body).To(HavePrefix("templates:\n"))
+				Expect(body).To(ContainSubstring("name: a-template"))
+				Expect(body).To(ContainSubstring("locale: en"))
+			})
+		})
+
+		Describe("Unsucesfully", func() {
+			It("should return 401 if no authenticated user", func() {
+				status, _ := Get(app, fmt.Sprintf("%s/export", baseRoute), "")
+				Expect(status).To(Equal(http.StatusUnauthorized))
+			})
+
+			It("should return 422 if app id is not UUID", func() {
+				status, _ := Get(app, "/apps/not-uuid/templates/export", "test@test.com")
+				Expect(status).To(Equal(http.StatusUnprocessableEntity))
+			})
+		})
+	})
+
+	Describe("Post /apps/:id/templates/import", func() {
+		var document map[string]interface{}
+		var unchanged, changed, removed, other *model.Template
+		BeforeEach(func() {
+			unchanged = CreateTestTemplate(app.DB, existingApp.ID, map[string]interface{}{"name": "village-like", "locale": "en"})
+			changed = CreateTestTemplate(app.DB, existingApp.ID, map[string]interface{}{"name": "village-like", "locale": "pt"})
+			removed = CreateTestTemplate(app.DB, existingApp.ID, map[string]interface{}{"name": "village-like", "locale": "fr"})
+			other = CreateTestTemplate(app.DB, existingApp.ID, map[string]interface{}{"name": "other", "locale": "en"})
+			document = map[string]interface{}{
+				"templates": []interface{}{
+					map[string]interface{}{
+						"name":     "village-like",
+						"locale":   "en",
+						"defaults": unchanged.Defaults,
+						"body":     unchanged.Body,
+					},
+					map[string]interface{}{
+						"name":     "village-like",
+						"locale":   "pt",
+						"defaults": map[string]interface{}{"user_name": "Alguém"},
+						"body":     map[string]interface{}{"alert": "{{user_name}} curtiu sua vila!"},
+					},
+					map[string]interface{}{
+						"name":     "village-like",
+						"locale":   "es",
+						"defaults": map[string]interface{}{"user_name": "Alguien"},
+						"body":     map[string]interface{}{"alert": "¡A {{user_name}} le gustó tu aldea!"},
+					},
+				},
+			}
+		})
+
+		getTemplates := func() map[string]model.Template {
+			templates := []model.Template{}
+			err := app.DB.Model(&templates).Where("app_id = ?", existingApp.ID).Select()
+			Expect(err).NotTo(HaveOccurred())
+			templatesByKey := map[string]model.Template{}
+			for _, template := range templates {
+				templatesByKey[fmt.Sprintf("%s/%s", template.Name, template.Locale)] = template
+			}
+			return templatesByKey
+		}
+
+		Describe("Sucesfully", func() {
+			It("should return 200 and create, update and delete the templates of the document names", func() {
+				pl, _ := json.Marshal(document)
+				status, body := Post(app, fmt.Sprintf("%s/import", baseRoute), string(pl), "import@test.com")
+				Expect(status).To(Equal(http.StatusOK))
+
+				var result map[string]interface{}
+				err := json.Unmarshal([]byte(body), &result)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(result["dryRun"]).To(BeFalse())
+				Expect(result["created"]).To(Equal([]interface{}{map[string]interface{}{"name": "village-like", "locale": "es"}}))
+				Expect(result["updated"]).To(Equal([]interface{}{map[string]interface{}{"name": "village-like", "locale": "pt"}}))
+				Expect(result["deleted"]).To(Equal([]interface{}{map[string]interface{}{"name": "village-like", "locale": "fr"}}))
+				Expect(result["unchanged"]).To(BeEquivalentTo(1))
+
+				templates := getTemplates()
+				Expect(templates).To(HaveLen(4))
+				Expect(templates["village-like/en"].ID).To(Equal(unchanged.ID))
+				Expect(templates["village-like/en"].Version).To(Equal(unchanged.Version))
+				Expect(templates["village-like/pt"].ID).To(Equal(changed.ID))
+				Expect(templates["village-like/pt"].Body["alert"]).To(Equal("{{user_name}} curtiu sua vila!"))
+				Expect(templates["village-like/pt"].CreatedBy).To(Equal(changed.CreatedBy))
+				Expect(templates["village-like/pt"].UpdatedBy).To(Equal("import@test.com"))
+				Expect(templates["village-like/es"].CreatedBy).To(Equal("import@test.com"))
+				Expect(templates).NotTo(HaveKey("village-like/fr"))
+				Expect(templates["other/en"].ID).To(Equal(other.ID))
+				err = app.DB.Select(&model.Template{ID: removed.ID})
+				Expect(err).To(HaveOccurred())
+
+				versions := []model.TemplateVersion{}
+				err = app.DB.Model(&versions).Where("template_id = ?", changed.ID).Order("version DESC").Select()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(versions[0].CreatedBy).To(Equal("import@test.com"))
+			})
+
+			It("should return 200 and delete every template not in the document if prune is true", func() {
+				pl, _ := json.Marshal(document)
+				status, _ := Post(app, fmt.Sprintf("%s/import?prune=true", baseRoute), string(pl), "import@test.com")
+				Expect(status).To(Equal(http.StatusOK))
+
+				templates := getTemplates()
+				Expect(templates).To(HaveLen(3))
+				Expect(templates).NotTo(HaveKey("other/en"))
+			})
+
+			It("should return 200 and the changes without making them if dryRun is true", func() {
+				pl, _ := json.Marshal(document)
+				status, body := Post(app, fmt.Sprintf("%s/import?dryRun=true", baseRoute), string(pl), "import@test.com")
+				Expect(status).To(Equal(http.StatusOK))
+
+				var result map[string]interface{}
+				err := json.Unmarshal([]byte(body), &result)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(result["dryRun"]).To(BeTrue())
+				Expect(result["created"]).To(HaveLen(1))
+				Expect(result["updated"]).To(HaveLen(1))
+				Expect(result["deleted"]).To(HaveLen(1))
+
+				templates := getTemplates()
+				Expect(templates).To(HaveLen(4))
+				Expect(templates["village-like/pt"].Body).To(Equal(changed.Body))
+				Expect(templates).To(HaveKey("village-like/fr"))
+				Expect(templates).NotTo(HaveKey("village-like/es"))
+			})
+
+			It("should return 200 and import a yaml document", func() {
+				yamlDocument := `templates:
+- name: village-like
+  locale: es
+  defaults:
+    user_name: Alguien
+  body:
+    alert: "{{user_name}} le gustó tu aldea!"
+    badge: 1
+`
+				status, body, _ := DoRequest(app, "POST", fmt.Sprintf("%s/import?format=yaml", baseRoute), yamlDocument, "import@test.com", map[string]string{})
+				Expect(status).To(Equal(http.StatusOK))
+
+				var result map[string]interface{}
+				err := json.Unmarshal([]byte(body), &result)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(result["created"]).To(HaveLen(1))
+				Expect(result["deleted"]).To(HaveLen(3))
+
+				templates := getTemplates()
+				Expect(templates["village-like/es"].Body).To(Equal(map[string]interface{}{
+					"alert": "{{user_name}} le gustó tu aldea!",
+					"badge": float64(1),
+				}))
+			})
+		})
+
+		Describe("Unsucesfully", func() {
+			It("should return 401 if no authenticated user", func() {
+				status, _ := Post(app, fmt.Sprintf("%s/import", baseRoute), "", "")
+				Expect(status).To(Equal(http.StatusUnauthorized))
+			})
+
+			It("should return 422 if app id is not UUID", func() {
+				pl, _ := json.Marshal(document)
+				status, _ := Post(app, "/apps/not-uuid/templates/import", string(pl), "import@test.com")
+				Expect(status).To(Equal(http.StatusUnprocessableEntity))
+			})
+
+			It("should return 422 if app does not exist", func() {
+				pl, _ := json.Marshal(document)
+				status, _ := Post(app, fmt.Sprintf("/apps/%s/templates/import", uuid.NewV4()), string(pl), "import@test.com")
+				Expect(status).To(Equal(http.StatusUnprocessableEntity))
+			})
+
+			It("should return 422 and change nothing if a template is invalid", func() {
+				templates := document["templates"].([]interface{})
+				templates = append(templates, map[string]interface{}{
+					"name":     "village-like",
+					"locale":   "de",
+					"defaults": map[string]interface{}{"user_name": "Jemand"},
+					"body":     map[string]interface{}{"alert": "{{user_name}} mag dein {{object_name}}!"},
+				}, map[string]interface{}{
+					"name":     "village-like",
+					"locale":   "en",
+					"defaults": unchanged.Defaults,
+					"body":     unchanged.Body,
+				})
+				document["templates"] = templates
+				pl, _ := json.Marshal(document)
+				status, body := Post(app, fmt.Sprintf("%s/import", baseRoute), string(pl), "import@test.com")
+				Expect(status).To(Equal(http.StatusUnprocessableEntity))
+
+				var response map[string]interface{}
+				err := json.Unmarshal([]byte(body), &response)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(response["reason"]).To(Equal("village-like/de: defaults: placeholder object_name has no default; village-like/en: duplicated template"))
+
+				dbTemplates := getTemplates()
+				Expect(dbTemplates).To(HaveLen(4))
+				Expect(dbTemplates["village-like/pt"].Body).To(Equal(changed.Body))
+			})
+
+			It("should return 422 if the document is not valid json", func() {
+				status, _ := Post(app, fmt.Sprintf("%s/import", baseRoute), "not-json", "import@test.com")
+				Expect(status).To(Equal(http.StatusUnprocessableEntity))
+			})
+
+			It("should return 413 if the document is too large", func() {
+				status, _ := Post(app, fmt.Sprintf("%s/import", baseRoute), strings.Repeat(" ", 10<<20+1), "import@test.com")
+				Expect(status).To(Equal(http.StatusRequestEntityTooLarge))
+			})
+		})
+	})
+
+	Describe("Post /apps/:id/templates/:tid/render", func() {
+		var existingTemplate *model.Template
+		BeforeEach(func() {
+			existingTemplate = CreateTestTemplate(app.DB, existingApp.ID, map[string]interface{}{
+				"name":     "render-template",
+				"locale":   "en",
+				"defaults": map[string]interface{}{"user_name": "someone", "count": 1},
+				"body":     map[string]interface{}{"alert": "{{user_name}} has {{count}} gifts", "count": "{{count}}"},
+			})
+		})
+
+		Describe("Sucesfully", func() {
+			It("should return 200 and the body and the push of each service of the app", func() {
+				payload := map[string]interface{}{
+					"context":  map[string]interface{}{"user_name": "Camila"},
+					"metadata": map[string]interface{}{"some": "metadata"},
+					"user":     map[string]interface{}{"user_id": "user-1", "token": "some-token"},
+				}
+				pay, _ := json.Marshal(payload)
+				status, body := Post(app, fmt.Sprintf("%s/%s/render", baseRoute, existingTemplate.ID), string(pay), "success@test.com")
+				Expect(status).To(Equal(http.StatusOK))
+
+				var response map[string]interface{}
+				err := json.Unmarshal([]byte(body), &response)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(response["templateId"]).To(Equal(existingTemplate.ID.String()))
+				Expect(response["locale"]).To(Equal("en"))
+				Expect(response["body"]).To(Equal(map[string]interface{}{"alert": "Camila has 1 gifts", "count": float64(1)}))
+
+				payloads := response["payloads"].(map[string]interface{})
+				Expect(payloads).To(HaveLen(2))
+				for _, service := range []string{"apns", "gcm"} {
+					servicePayload := payloads[service].(map[string]interface{})
+					Expect(servicePayload["size"]).To(BeNumerically(">", 0))
+					Expect(servicePayload["payloadSize"]).To(BeNumerically(">", 0))
+					Expect(servicePayload["maxPayloadSize"]).To(BeEquivalentTo(4096))
+					Expect(servicePayload["message"]).NotTo(BeNil())
+				}
+				apns := payloads["apns"].(map[string]interface{})["message"].(map[string]interface{})
+				Expect(apns["DeviceToken"]).To(Equal("some-token"))
+				gcm := payloads["gcm"].(map[string]interface{})["message"].(map[string]interface{})
+				Expect(gcm["to"]).To(Equal("some-token"))
+				Expect(gcm["data"].(map[string]interface{})["m"]).To(Equal(map[string]interface{}{"some": "metadata"}))
+			})
+
+			It("should return 200 and only the push of the given service", func() {
+				payload := map[string]interface{}{"service": "gcm"}
+				pay, _ := json.Marshal(payload)
+				status, body := Post(app, fmt.Sprintf("%s/%s/render", baseRoute, existingTemplate.ID), string(pay), "success@test.com")
+				Expect(status).To(Equal(http.StatusOK))
+
+				var response map[string]interface{}
+				err := json.Unmarshal([]byte(body), &response)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(response["body"]).To(Equal(map[string]interface{}{"alert": "someone has 1 gifts", "count": float64(1)}))
+				payloads := response["payloads"].(map[string]interface{})
+				Expect(payloads).To(HaveLen(1))
+				Expect(payloads).To(HaveKey("gcm"))
+			})
+
+			It("should return 200 and render with the template of the user locale and the user vars", func() {
+				ptTemplate := CreateTestTemplate(app.DB, existingApp.ID, map[string]interface{}{
+					"name":     "render-template",
+					"locale":   "pt",
+					"defaults": map[string]interface{}{"user_name": "alguém"},
+					"body":     map[string]interface{}{"alert": "{{user_name}} ganhou presentes"},
+				})
+				payload := map[string]interface{}{
+					"context": map[string]interface{}{"user_name": "Camila"},
+					"user": map[string]interface{}{
+						"locale": "pt_BR",
+						"vars":   map[string]interface{}{"user_name": "Joana"},
+					},
+				}
+				pay, _ := json.Marshal(payload)
+				status, body := Post(app, fmt.Sprintf("%s/%s/render", baseRoute, existingTemplate.ID), string(pay), "success@test.com")
+				Expect(status).To(Equal(http.StatusOK))
+
+				var response map[string]interface{}
+				err := json.Unmarshal([]byte(body), &response)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(response["templateId"]).To(Equal(ptTemplate.ID.String()))
+				Expect(response["locale"]).To(Equal("pt"))
+				Expect(response["body"]).To(Equal(map[string]interface{}{"alert": "Joana ganhou presentes"}))
+			})
+
+			It("should return 200 and the push of each service with its service body", func() {
+				serviceTemplate := CreateTestTemplate(app.DB, existingApp.ID, map[string]interface{}{
+					"defaults": map[string]interface{}{"user_name": "someone"},
+					"body":     map[string]interface{}{"alert": "{{user_name}} liked your village"},
+					"serviceBodies": map[string]map[string]interface{}{
+						"gcm": map[string]interface{}{"message": "{{user_name}} liked your village", "title": "Village"},
+					},
+				})
+				status, body := Post(app, fmt.Sprintf("%s/%s/render", baseRoute, serviceTemplate.ID), "{}", "success@test.com")
+				Expect(status).To(Equal(http.StatusOK))
+
+				var response map[string]interface{}
+				err := json.Unmarshal([]byte(body), &response)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(response["body"]).To(Equal(map[string]interface{}{"alert": "someone liked your village"}))
+				payloads := response["payloads"].(map[string]interface{})
+				apns := payloads["apns"].(map[string]interface{})
+				Expect(apns["body"]).To(Equal(map[string]interface{}{"alert": "someone liked your village"}))
+				gcm := payloads["gcm"].(map[string]interface{})
+				Expect(gcm["body"]).To(Equal(map[string]interface{}{"message": "someone liked your village", "title": "Village"}))
+				gcmMessage := gcm["message"].(map[string]interface{})
+				Expect(gcmMessage["data"]).To(Equal(map[string]interface{}{"message": "someone liked your village", "title": "Village"}))
+			})
+
+			It("should return 200 and render the template with its defaults if there is no body", func() {
+				status, body := Post(app, fmt.Sprintf("%s/%s/render", baseRoute, existingTemplate.ID), "", "success@test.com")
+				Expect(status).To(Equal(http.StatusOK))
+
+				var response map[string]interface{}
+				err := json.Unmarshal([]byte(body), &response)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(response["body"]).To(Equal(map[string]interface{}{"alert": "someone has 1 gifts", "count": float64(1)}))
+			})
+		})
+
+		Describe("Unsucesfully", func() {
+			It("should return 401 if no authenticated user", func() {
+				status, _ := Post(app, fmt.Sprintf("%s/%s/render", baseRoute, existingTemplate.ID), "{}", "")
+				Expect(status).To(Equal(http.StatusUnauthorized))
+			})
+
+			It("should return 404 if the template does not exist", func() {
+				status, _ := Post(app, fmt.Sprintf("%s/%s/render", baseRoute, uuid.NewV4()), "{}", "test@test.com")
+				Expect(status).To(Equal(http.StatusNotFound))
+			})
+
+			It("should return 422 if the service is invalid", func() {
+				status, body := Post(app, fmt.Sprintf("%s/%s/render", baseRoute, existingTemplate.ID), `{"service":"sms"}`, "test@test.com")
+				Expect(status).To(Equal(http.StatusUnprocessableEntity))
+				var response map[string]interface{}
+				err := json.Unmarshal([]byte(body), &response)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(response["reason"]).To(Equal("invalid service"))
+			})
+
+			It("should return 422 if template id is not UUID", func() {
+				status, _ := Post(app, fmt.Sprintf("%s/not-uuid/render", baseRoute), "{}", "test@test.com")
+				Expect(status).To(Equal(http.StatusUnprocessableEntity))
+			})
+		})
+	})
+
+	Describe("Get /apps/:id/templates/:tid/versions", func() {
+		Describe("Sucesfully", func() {
+			It("should return 200 and a version for each change of the template", func() {
+				existingTemplate := CreateTestTemplate(app.DB, existingApp.ID)
+				payload := GetTemplatePayload(map[string]interface{}{
+					"name":   existingTemplate.Name,
+					"locale": existingTemplate.Locale,
+				})
+				pl, _ := json.Marshal(payload)
+				status, body := Put(app, fmt.Sprintf("%s/%s", baseRoute, existingTemplate.ID), string(pl), "success@test.com")
+				Expect(status).To(Equal(http.StatusOK))
+				var template map[string]interface{}
+				err := json.Unmarshal([]byte(body), &template)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(template["version"]).To(BeEquivalentTo(2))
+
+				status, body = Get(app, fmt.Sprintf("%s/%s/versions", baseRoute, existingTemplate.ID), "success@test.com")
+				Expect(status).To(Equal(http.StatusOK))
+				var versions []map[string]interface{}
+				err = json.Unmarshal([]byte(body), &versions)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(versions).To(HaveLen(2))
+				Expect(versions[0]["version"]).To(BeEquivalentTo(2))
+				Expect(versions[0]["templateId"]).To(Equal(existingTemplate.ID.String()))
+				Expect(versions[0]["body"]).To(Equal(payload["body"]))
+				Expect(versions[0]["createdBy"]).To(Equal("success@test.com"))
+				Expect(versions[1]["version"]).To(BeEquivalentTo(1))
+				Expect(versions[1]["body"]).To(Equal(existingTemplate.Body))
+				Expect(versions[1]["defaults"]).To(Equal(existingTemplate.Defaults))
+			})
+
+			It("should not create a version if the template did not change", func() {
+				existingTemplate := CreateTestTemplate(app.DB, existingApp.ID)
+				pl, _ := json.Marshal(map[string]interface{}{
+					"name":     existingTemplate.Name,
+					"locale":   existingTemplate.Locale,
+					"defaults": existingTemplate.Defaults,
+					"body":     existingTemplate.Body,
+				})
+				status, _ := Put(app, fmt.Sprintf("%s/%s", baseRoute, existingTemplate.ID), string(pl), "success@test.com")
+				Expect(status).To(Equal(http.StatusOK))
+
+				status, body := Get(app, fmt.Sprintf("%s/%s/versions", baseRoute, existingTemplate.ID), "success@test.com")
+				Expect(status).To(Equal(http.StatusOK))
+				var versions []map[string]interface{}
+				err := json.Unmarshal([]byte(body), &versions)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(versions).To(HaveLen(1))
+			})
+		})
+
+		Describe("Unsucesfully", func() {
+			It("should return 401 if no authenticated user", func() {
+				existingTemplate := CreateTestTemplate(app.DB, existingApp.ID)
+				status, _ := Get(app, fmt.Sprintf("%s/%s/versions", baseRoute, existingTemplate.ID), "")
+				Expect(status).To(Equal(http.StatusUnauthorized))
+			})
+
+			It("should return 404 if the template does not exist", func() {
+				status, _ := Get(app, fmt.Sprintf("%s/%s/versions", baseRoute, uuid.NewV4()), "success@test.com")
+				Expect(status).To(Equal(http.StatusNotFound))
+			})
+		})
+	})
+
+	Describe("Post /apps/:id/templates/:tid/versions/:version/rollback", func() {
+		Describe("Sucesfully", func() {
+			It("should return 200 and restore the template version as a new version", func() {
+				existingTemplate := CreateTestTemplate(app.DB, existingApp.ID)
+				payload := GetTemplatePayload(map[string]interface{}{
+					"name":   existingTemplate.Name,
+					"locale": existingTemplate.Locale,
+				})
+				pl, _ := json.Marshal(payload)
+				status, _ := Put(app, fmt.Sprintf("%s/%s", baseRoute, existingTemplate.ID), string(pl), "success@test.com")
+				Expect(status).To(Equal(http.StatusOK))
+
+				status, body := Post(app, fmt.Sprintf("%s/%s/versions/1/rollback", baseRoute, existingTemplate.ID), "", "rollback@test.com")
+				Expect(status).To(Equal(http.StatusOK))
+				var template map[string]interface{}
+				err := json.Unmarshal([]byte(body), &template)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(template["version"]).To(BeEquivalentTo(3))
+				Expect(template["name"]).To(Equal(existingTemplate.Name))
+				Expect(template["body"]).To(Equal(existingTemplate.Body))
+				Expect(template["defaults"]).To(Equal(existingTemplate.Defaults))
+
+				dbTemplate := &model.Template{ID: existingTemplate.ID}
+				err = app.DB.Select(&dbTemplate)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(dbTemplate.Version).To(Equal(3))
+				Expect(dbTemplate.Body).To(Equal(existingTemplate.Body))
+				Expect(dbTemplate.CreatedBy).To(Equal(existingTemplate.CreatedBy))
+				Expect(dbTemplate.UpdatedBy).To(Equal("rollback@test.com"))
+
+				status, body = Get(app, fmt.Sprintf("%s/%s/versions", baseRoute, existingTemplate.ID), "rollback@test.com")
+				Expect(status).To(Equal(http.StatusOK))
+				var versions []map[string]interface{}
+				err = json.Unmarshal([]byte(body), &versions)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(versions[0]["createdBy"]).To(Equal("rollback@test.com"))
+				Expect(versions[2]["createdBy"]).To(Equal(existingTemplate.CreatedBy))
+			})
+		})
+
+		Describe("Unsucesfully", func() {
+			It("should return 404 if the version does not exist", func() {
+				existingTemplate := CreateTestTemplate(app.DB, existingApp.ID)
+				status, _ := Post(app, fmt.Sprintf("%s/%s/versions/2/rollback", baseRoute, existingTemplate.ID), "", "rollback@test.com")
+				Expect(status).To(Equal(http.StatusNotFound))
+			})
+
+			It("should return 422 if the version is not a number", func() {
+				existingTemplate := CreateTestTemplate(app.DB, existingApp.ID)
+				status, _ := Post(app, fmt.Sprintf("%s/%s/versions/last/rollback", baseRoute, existingTemplate.ID), "", "rollback@test.com")
+				Expect(status).To(Equal(http.StatusUnprocessableEntity))
+			})
 		})
 	})
 
@@ -23,6 +23,7 @@
 package extensions
 
 import (
+	"encoding/json"
 	"fmt"
 
 	"github.com/spf13/viper"
@@ -69,3 +70,12 @@ func NewRedis(prefix string, conf *viper.Viper, logger zap.Logger) (*redis.Clien
 	log.I(l, "Connected to redis successfully.")
 	return client, nil
 }
+
+//PublishJSON publishes the JSON representation of value to the redis channel
+func PublishJSON(client *redis.Client, channel string, value interface{}) error {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return client.Publish(channel, string(b)).Err()
+}
@@ -30,10 +30,13 @@ import (
 	"time"
 
 	raven "github.com/getsentry/raven-go"
+	uuid "github.com/satori/go.uuid"
 	"github.com/spf13/viper"
 	"github.com/topfreegames/marathon/extensions"
 	"github.com/topfreegames/marathon/log"
+	"github.com/topfreegames/marathon/model"
 	"github.com/uber-go/zap"
+	redis "gopkg.in/redis.v5"
 )
 
 var feedbackCacheMutex sync.Mutex
@@ -51,6 +54,7 @@ type Handler struct {
 	FeedbackCache     map[string]map[string]int
 	FlushInterval     time.Duration
 	MarathonDB        *extensions.PGClient
+	RedisClient       *redis.Client
 	Logger            zap.Logger
 	run               bool
 }
@@ -76,11 +80,12 @@ func NewHandler(config *viper.Viper, logger zap.Logger, pendingMessagesWG *sync.
 		pendingMessagesWG: pendingMessagesWG,
 		FeedbackCache:     map[string]map[string]int{},
 	}
+	var err error
 	if len(DBOrNil) > 0 {
-		h.configure(DBOrNil[0])
-		return h, nil
+		err = h.configure(DBOrNil[0])
+	} else {
+		err = h.configure()
 	}
-	err := h.configure()
 	if err != nil {
 		return nil, err
 	}
@@ -95,6 +100,11 @@ func (h *Handler) configure(DBOrNil ...*extensions.PGClient) error {
 	h.loadConfigurationDefaults()
 	interval := h.Config.GetInt("feedbackListener.flushInterval")
 	h.FlushInterval = time.Duration(interval) * time.Millisecond
+	redisClient, err := extensions.NewRedis("workers", h.Config, h.Logger)
+	if err != nil {
+		return err
+	}
+	h.RedisClient = redisClient
 	if len(DBOrNil) > 0 {
 		h.MarathonDB = DBOrNil[0]
 		return nil
@@ -194,6 +204,18 @@ func (h *Handler) generatePGIncrJSON(jobID string, values map[string]int) string
 	return fmt.Sprintf("%s%s%s", q, joinedModifiers, endQ)
 }
 
+// publishFeedbacks publishes the feedbacks flushed for the job to the job events channel
+func (h *Handler) publishFeedbacks(jobID string, values map[string]int) {
+	id, err := uuid.FromString(jobID)
+	if err != nil {
+		return
+	}
+	err = extensions.PublishJSON(h.RedisClient, model.JobEventsChannel(jobID), model.NewJobFeedbacksEvent(id, values))
+	if err != nil {
+		h.Logger.Error("error publishing feedbacks event", zap.Error(err))
+	}
+}
+
 func (h *Handler) flushFeedbacks() {
 	ticker := time.NewTicker(h.FlushInterval)
 	for range ticker.C {
@@ -211,6 +233,7 @@ func (h *Handler) flushFeedbacks() {
 				h.Logger.Error("error updating feedbacks table", zap.Error(err))
 			} else {
 				h.Logger.Debug("successfully updated rows", zap.Int("rows affected", results.RowsAffected()))
+				h.publishFeedbacks(k, v)
 			}
 			delete(h.FeedbackCache, k)
 		}
@@ -0,0 +1,45 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+CREATE TABLE "recurring_jobs" (
+  "id" uuid DEFAULT uuid_generate_v4(),
+  "cron_spec" text NOT NULL,
+  "localized" boolean NOT NULL DEFAULT false,
+  "ends_at" bigint,
+  "next_run_at" bigint,
+  "last_run_at" bigint,
+  "context" JSONB NOT NULL DEFAULT '{}'::JSONB,
+  "service" text,
+  "filters" JSONB NOT NULL DEFAULT '{}'::JSONB,
+  "metadata" JSONB NOT NULL DEFAULT '{}'::JSONB,
+  "csv_path" text,
+  "created_by" text,
+  "app_id" uuid NOT NULL,
+  "template_name" text NOT NULL,
+  "past_time_strategy" text,
+  "created_at" bigint,
+  "updated_at" bigint,
+  PRIMARY KEY ("id")
+);
+
+ALTER TABLE "recurring_jobs"
+ADD CONSTRAINT recurring_jobs_app_id_apps_id_foreign
+FOREIGN KEY (app_id)
+REFERENCES apps(id)
+ON DELETE CASCADE
+ON UPDATE CASCADE;
+
+CREATE INDEX recurring_jobs_next_run_at ON "recurring_jobs"(next_run_at);
+
+ALTER TABLE "jobs" ADD COLUMN recurring_job_id uuid;
+
+ALTER TABLE "jobs"
+ADD CONSTRAINT jobs_recurring_job_id_recurring_jobs_id_foreign
+FOREIGN KEY (recurring_job_id)
+REFERENCES recurring_jobs(id)
+ON DELETE SET NULL
+ON UPDATE CASCADE;
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+ALTER TABLE "jobs" DROP COLUMN recurring_job_id;
+DROP TABLE "recurring_jobs";
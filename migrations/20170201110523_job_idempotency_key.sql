@@ -0,0 +1,12 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+ALTER TABLE "jobs" ADD COLUMN idempotency_key text;
+ALTER TABLE "jobs" ADD COLUMN request_hash text;
+
+CREATE UNIQUE INDEX jobs_app_id_idempotency_key ON "jobs"(app_id, idempotency_key);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+DROP INDEX jobs_app_id_idempotency_key;
+ALTER TABLE "jobs" DROP COLUMN request_hash;
+ALTER TABLE "jobs" DROP COLUMN idempotency_key;
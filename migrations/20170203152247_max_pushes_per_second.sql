@@ -0,0 +1,9 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+ALTER TABLE "apps" ADD COLUMN max_pushes_per_second integer;
+ALTER TABLE "jobs" ADD COLUMN max_pushes_per_second integer;
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+ALTER TABLE "jobs" DROP COLUMN max_pushes_per_second;
+ALTER TABLE "apps" DROP COLUMN max_pushes_per_second;
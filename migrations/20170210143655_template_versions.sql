@@ -0,0 +1,61 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+CREATE TABLE "template_versions" (
+  "id" uuid DEFAULT uuid_generate_v4(),
+  "template_id" uuid NOT NULL,
+  "version" integer NOT NULL,
+  "name" text NOT NULL,
+  "locale" text NOT NULL,
+  "defaults" JSONB NOT NULL DEFAULT '{}'::JSONB,
+  "body" JSONB NOT NULL DEFAULT '{}'::JSONB,
+  "created_by" text NOT NULL,
+  "app_id" uuid NOT NULL,
+  "created_at" bigint,
+  PRIMARY KEY ("id")
+);
+
+CREATE UNIQUE INDEX template_versions_template_id_version ON "template_versions"(template_id, version);
+
+-- versions are not removed with their template so that the jobs that pinned them can still be sent
+ALTER TABLE "template_versions"
+ADD CONSTRAINT template_versions_app_id_apps_id_foreign
+FOREIGN KEY (app_id)
+REFERENCES apps(id)
+ON DELETE CASCADE
+ON UPDATE CASCADE;
+
+ALTER TABLE "templates" ADD COLUMN version integer NOT NULL DEFAULT 1;
+
+INSERT INTO "template_versions" (template_id, version, name, locale, defaults, body, created_by, app_id, created_at)
+SELECT id, version, name, locale, defaults, body, created_by, app_id, COALESCE(updated_at, created_at) FROM "templates";
+
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION version_template() RETURNS trigger AS $$
+BEGIN
+  IF TG_OP = 'UPDATE' THEN
+    IF NEW.name = OLD.name AND NEW.locale = OLD.locale AND NEW.defaults = OLD.defaults AND NEW.body = OLD.body THEN
+      NEW.version := OLD.version;
+      RETURN NEW;
+    END IF;
+    NEW.version := OLD.version + 1;
+  ELSE
+    NEW.version := 1;
+  END IF;
+  INSERT INTO "template_versions" (template_id, version, name, locale, defaults, body, created_by, app_id, created_at)
+  VALUES (NEW.id, NEW.version, NEW.name, NEW.locale, NEW.defaults, NEW.body, NEW.created_by, NEW.app_id, COALESCE(NEW.updated_at, NEW.created_at));
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+CREATE TRIGGER templates_version BEFORE INSERT OR UPDATE ON "templates" FOR EACH ROW EXECUTE PROCEDURE version_template();
+
+ALTER TABLE "jobs" ADD COLUMN template_versions JSONB NOT NULL DEFAULT '{}'::JSONB;
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+ALTER TABLE "jobs" DROP COLUMN template_versions;
+DROP TRIGGER templates_version ON "templates";
+DROP FUNCTION version_template();
+ALTER TABLE "templates" DROP COLUMN version;
+DROP TABLE "template_versions";
@@ -0,0 +1,11 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+ALTER TABLE "apps" ADD COLUMN default_locale varchar(10);
+ALTER TABLE "apps" ADD COLUMN locale_fallbacks JSONB;
+ALTER TABLE "jobs" ADD COLUMN fallback_users integer NOT NULL DEFAULT 0;
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+ALTER TABLE "jobs" DROP COLUMN fallback_users;
+ALTER TABLE "apps" DROP COLUMN locale_fallbacks;
+ALTER TABLE "apps" DROP COLUMN default_locale;
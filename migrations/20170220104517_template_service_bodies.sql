@@ -0,0 +1,47 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+ALTER TABLE "templates" ADD COLUMN service_bodies JSONB;
+ALTER TABLE "template_versions" ADD COLUMN service_bodies JSONB;
+
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION version_template() RETURNS trigger AS $$
+BEGIN
+  IF TG_OP = 'UPDATE' THEN
+    IF NEW.name = OLD.name AND NEW.locale = OLD.locale AND NEW.defaults = OLD.defaults AND NEW.body = OLD.body AND NEW.engine IS NOT DISTINCT FROM OLD.engine AND NEW.service_bodies IS NOT DISTINCT FROM OLD.service_bodies THEN
+      NEW.version := OLD.version;
+      RETURN NEW;
+    END IF;
+    NEW.version := OLD.version + 1;
+  ELSE
+    NEW.version := 1;
+  END IF;
+  INSERT INTO "template_versions" (template_id, version, name, locale, defaults, body, service_bodies, engine, created_by, app_id, created_at)
+  VALUES (NEW.id, NEW.version, NEW.name, NEW.locale, NEW.defaults, NEW.body, NEW.service_bodies, NEW.engine, NEW.created_by, NEW.app_id, COALESCE(NEW.updated_at, NEW.created_at));
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION version_template() RETURNS trigger AS $$
+BEGIN
+  IF TG_OP = 'UPDATE' THEN
+    IF NEW.name = OLD.name AND NEW.locale = OLD.locale AND NEW.defaults = OLD.defaults AND NEW.body = OLD.body AND NEW.engine IS NOT DISTINCT FROM OLD.engine THEN
+      NEW.version := OLD.version;
+      RETURN NEW;
+    END IF;
+    NEW.version := OLD.version + 1;
+  ELSE
+    NEW.version := 1;
+  END IF;
+  INSERT INTO "template_versions" (template_id, version, name, locale, defaults, body, engine, created_by, app_id, created_at)
+  VALUES (NEW.id, NEW.version, NEW.name, NEW.locale, NEW.defaults, NEW.body, NEW.engine, NEW.created_by, NEW.app_id, COALESCE(NEW.updated_at, NEW.created_at));
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+ALTER TABLE "template_versions" DROP COLUMN service_bodies;
+ALTER TABLE "templates" DROP COLUMN service_bodies;
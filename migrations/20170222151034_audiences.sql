@@ -0,0 +1,39 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+CREATE TABLE "audiences" (
+  "id" uuid DEFAULT uuid_generate_v4(),
+  "name" text NOT NULL,
+  "service" text NOT NULL,
+  "filters" JSONB NOT NULL DEFAULT '{}'::JSONB,
+  "csv_path" text,
+  "size" integer NOT NULL DEFAULT 0,
+  "size_updated_at" bigint NOT NULL DEFAULT 0,
+  "created_by" text,
+  "app_id" uuid NOT NULL,
+  "created_at" bigint,
+  "updated_at" bigint,
+  PRIMARY KEY ("id")
+);
+
+ALTER TABLE "audiences"
+ADD CONSTRAINT audiences_app_id_apps_id_foreign
+FOREIGN KEY (app_id)
+REFERENCES apps(id)
+ON DELETE CASCADE
+ON UPDATE CASCADE;
+
+CREATE UNIQUE INDEX audiences_app_id_name ON "audiences"(app_id, name);
+
+ALTER TABLE "jobs" ADD COLUMN audience_id uuid;
+
+ALTER TABLE "jobs"
+ADD CONSTRAINT jobs_audience_id_audiences_id_foreign
+FOREIGN KEY (audience_id)
+REFERENCES audiences(id)
+ON DELETE SET NULL
+ON UPDATE CASCADE;
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+ALTER TABLE "jobs" DROP COLUMN audience_id;
+DROP TABLE "audiences";
@@ -0,0 +1,23 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+CREATE TABLE "suppressed_users" (
+  "app_id" uuid NOT NULL,
+  "user_id" text NOT NULL,
+  "created_by" text,
+  "created_at" bigint,
+  PRIMARY KEY ("app_id", "user_id")
+);
+
+ALTER TABLE "suppressed_users"
+ADD CONSTRAINT suppressed_users_app_id_apps_id_foreign
+FOREIGN KEY (app_id)
+REFERENCES apps(id)
+ON DELETE CASCADE
+ON UPDATE CASCADE;
+
+ALTER TABLE "jobs" ADD COLUMN suppressed_users integer NOT NULL DEFAULT 0;
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+ALTER TABLE "jobs" DROP COLUMN suppressed_users;
+DROP TABLE "suppressed_users";
@@ -0,0 +1,11 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+ALTER TABLE "jobs" ADD COLUMN sample_rate double precision;
+ALTER TABLE "jobs" ADD COLUMN sample_from double precision;
+ALTER TABLE "jobs" ADD COLUMN sample_salt text;
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+ALTER TABLE "jobs" DROP COLUMN sample_salt;
+ALTER TABLE "jobs" DROP COLUMN sample_from;
+ALTER TABLE "jobs" DROP COLUMN sample_rate;
@@ -30,12 +30,15 @@ import (
 
 // App is the app model struct
 type App struct {
-	ID        uuid.UUID `sql:",pk" json:"id"`
-	Name      string    `json:"name"`
-	BundleID  string    `json:"bundleId"`
-	CreatedBy string    `json:"createdBy"`
-	CreatedAt int64     `json:"createdAt"`
-	UpdatedAt int64     `json:"updatedAt"`
+	ID                 uuid.UUID           `sql:",pk" json:"id"`
+	Name               string              `json:"name"`
+	BundleID           string              `json:"bundleId"`
+	CreatedBy          string              `json:"createdBy"`
+	MaxPushesPerSecond int                 `json:"maxPushesPerSecond"`
+	DefaultLocale      string              `json:"defaultLocale"`
+	LocaleFallbacks    map[string][]string `json:"localeFallbacks"`
+	CreatedAt          int64               `json:"createdAt"`
+	UpdatedAt          int64               `json:"updatedAt"`
 }
 
 // Validate implementation of the InputValidation interface
@@ -52,5 +55,56 @@ func (a *App) Validate(c echo.Context) error {
 	if !valid {
 		return InvalidField("createdBy")
 	}
+	valid = a.MaxPushesPerSecond >= 0
+	if !valid {
+		return InvalidField("maxPushesPerSecond")
+	}
+	valid = govalidator.StringLength(a.DefaultLocale, "0", "10")
+	if !valid {
+		return InvalidField("defaultLocale")
+	}
+	for locale, fallbacks := range a.LocaleFallbacks {
+		valid = govalidator.StringLength(locale, "1", "10")
+		for _, fallback := range fallbacks {
+			valid = valid && govalidator.StringLength(fallback, "1", "10")
+		}
+		if !valid {
+			return InvalidField("localeFallbacks")
+		}
+	}
 	return nil
 }
+
+// GetDefaultLocale returns the normalized default locale of the app or DefaultLocale if it has none
+func (a *App) GetDefaultLocale() string {
+	if a.DefaultLocale == "" {
+		return DefaultLocale
+	}
+	return NormalizeLocale(a.DefaultLocale)
+}
+
+// LocaleFallbackChain returns the normalized locales used to find the template of a user with the given locale, in
+// order: the locale, the fallbacks configured in the app for it, the same for each of its parent locales and then
+// the app default locale, e.g. pt-BR, pt and en
+func (a *App) LocaleFallbackChain(locale string) []string {
+	fallbacks := map[string][]string{}
+	for key, value := range a.LocaleFallbacks {
+		fallbacks[NormalizeLocale(key)] = value
+	}
+	chain := []string{}
+	added := map[string]bool{}
+	add := func(l string) {
+		if l != "" && !added[l] {
+			added[l] = true
+			chain = append(chain, l)
+		}
+	}
+	for l := NormalizeLocale(locale); l != ""; l = ParentLocale(l) {
+		add(l)
+		for _, fallback := range fallbacks[l] {
+			add(NormalizeLocale(fallback))
+		}
+	}
+	add(a.GetDefaultLocale())
+	return chain
+}
@@ -0,0 +1,79 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package model
+
+import (
+	"fmt"
+
+	"github.com/asaskevich/govalidator"
+	"github.com/labstack/echo"
+	"github.com/satori/go.uuid"
+)
+
+// Audience is the audience model struct, a saved set of users of a service given by filters or a csv that jobs
+// can target by id. Size is the number of users of the audience when it was last counted, at SizeUpdatedAt
+type Audience struct {
+	ID            uuid.UUID              `sql:",pk" json:"id"`
+	Name          string                 `json:"name"`
+	Service       string                 `json:"service"`
+	Filters       map[string]interface{} `json:"filters"`
+	CSVPath       string                 `json:"csvPath"`
+	Size          int                    `json:"size"`
+	SizeUpdatedAt int64                  `json:"sizeUpdatedAt"`
+	CreatedBy     string                 `json:"createdBy"`
+	App           App                    `json:"app"`
+	AppID         uuid.UUID              `json:"appId"`
+	CreatedAt     int64                  `json:"createdAt"`
+	UpdatedAt     int64                  `json:"updatedAt"`
+}
+
+// Validate implementation of the InputValidation interface
+func (a *Audience) Validate(c echo.Context) error {
+	valid := govalidator.StringLength(a.Name, "1", "255")
+	if !valid {
+		return InvalidField("name")
+	}
+
+	valid = govalidator.StringMatches(a.Service, "^(apns|gcm)$")
+	if !valid {
+		return InvalidField("service")
+	}
+
+	valid = govalidator.IsEmail(a.CreatedBy)
+	if !valid {
+		return InvalidField("createdBy")
+	}
+
+	hasFilters := len(a.Filters) != 0
+	hasCSVPath := !govalidator.IsNull(a.CSVPath)
+	valid = hasFilters != hasCSVPath
+	if !valid {
+		return InvalidField("filters or csvPath must exist, not both")
+	}
+
+	if _, err := ParseFilters(a.Filters); err != nil {
+		return fmt.Errorf("invalid filters: %s", err.Error())
+	}
+
+	return nil
+}
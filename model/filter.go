@@ -0,0 +1,342 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Filter operators
+const (
+	FilterEqual          = "="
+	FilterNotEqual       = "!="
+	FilterLess           = "<"
+	FilterLessOrEqual    = "<="
+	FilterGreater        = ">"
+	FilterGreaterOrEqual = ">="
+	FilterIn             = "in"
+	FilterBetween        = "between"
+	FilterLike           = "like"
+	FilterIsNull         = "is null"
+)
+
+// legacyNotPrefix negates a key of the flat filters, e.g. NOTregion
+const legacyNotPrefix = "NOT"
+
+var filterColumn = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+var relativeDate = regexp.MustCompile(`^([+-]?)([0-9]+)(m|h|d|w)$`)
+
+var relativeDateUnits = map[string]string{
+	"m": "minutes",
+	"h": "hours",
+	"d": "days",
+	"w": "weeks",
+}
+
+// Filter is a node of the filter tree of a job: a group of filters joined by and or or, a negated filter or a
+// condition comparing a column of the push db users with a value
+type Filter struct {
+	And    []*Filter   `json:"and,omitempty"`
+	Or     []*Filter   `json:"or,omitempty"`
+	Not    *Filter     `json:"not,omitempty"`
+	Column string      `json:"column,omitempty"`
+	Op     string      `json:"op,omitempty"`
+	Value  interface{} `json:"value,omitempty"`
+}
+
+// isFilterTree returns whether the job filters are a filter tree instead of the flat format
+func isFilterTree(filters map[string]interface{}) bool {
+	for _, key := range []string{"and", "or", "not", "op"} {
+		if _, ok := filters[key]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseFilters returns the validated filter tree of the job filters, or nil if there are no filters. The filters
+// are either a filter tree or the flat format, where each key is a column, prefixed by NOT to negate it, and each
+// value has the comma separated values the column can have, e.g. {"locale": "en,fr", "NOTregion": "US"}
+func ParseFilters(filters map[string]interface{}) (*Filter, error) {
+	if len(filters) == 0 {
+		return nil, nil
+	}
+	if !isFilterTree(filters) {
+		return parseFlatFilters(filters)
+	}
+	b, err := json.Marshal(filters)
+	if err != nil {
+		return nil, err
+	}
+	filter := &Filter{}
+	if err := json.Unmarshal(b, filter); err != nil {
+		return nil, err
+	}
+	if err := filter.Validate(); err != nil {
+		return nil, err
+	}
+	return filter, nil
+}
+
+func parseFlatFilters(filters map[string]interface{}) (*Filter, error) {
+	keys := make([]string, 0, len(filters))
+	for key := range filters {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	filter := &Filter{}
+	for _, key := range keys {
+		value, ok := filters[key].(string)
+		if !ok {
+			return nil, fmt.Errorf("filter %s must be a string", key)
+		}
+		column := key
+		negated := strings.HasPrefix(key, legacyNotPrefix)
+		if negated {
+			column = strings.TrimPrefix(key, legacyNotPrefix)
+		}
+		values := []interface{}{}
+		for _, v := range strings.Split(value, ",") {
+			values = append(values, v)
+		}
+		condition := &Filter{Column: column, Op: FilterEqual, Value: values[0]}
+		if len(values) > 1 {
+			condition = &Filter{Column: column, Op: FilterIn, Value: values}
+		}
+		if negated {
+			condition = &Filter{Not: condition}
+		}
+		filter.And = append(filter.And, condition)
+	}
+	if err := filter.Validate(); err != nil {
+		return nil, err
+	}
+	if len(filter.And) == 1 {
+		return filter.And[0], nil
+	}
+	return filter, nil
+}
+
+// Conditions returns the column conditions of the filter tree
+func (f *Filter) Conditions() []*Filter {
+	if f == nil {
+		return nil
+	}
+	if f.Column != "" {
+		return []*Filter{f}
+	}
+	conditions := f.Not.Conditions()
+	for _, children := range [][]*Filter{f.And, f.Or} {
+		for _, child := range children {
+			conditions = append(conditions, child.Conditions()...)
+		}
+	}
+	return conditions
+}
+
+// mapFilterValue returns value, a condition value or a list of them, with its strings replaced by f(string)
+func mapFilterValue(value interface{}, f func(string) string) interface{} {
+	switch v := value.(type) {
+	case string:
+		return f(v)
+	case []interface{}:
+		mapped := make([]interface{}, len(v))
+		for i, item := range v {
+			mapped[i] = mapFilterValue(item, f)
+		}
+		return mapped
+	}
+	return value
+}
+
+// MapFilterValues returns the job filters, in the same format, with the string values of the conditions of the
+// columns in mappers replaced by the result of the mapper of the column
+func MapFilterValues(filters map[string]interface{}, mappers map[string]func(string) string) (map[string]interface{}, error) {
+	if !isFilterTree(filters) {
+		mapped := make(map[string]interface{}, len(filters))
+		for key, value := range filters {
+			mapped[key] = value
+			f, ok := mappers[strings.TrimPrefix(key, legacyNotPrefix)]
+			if !ok {
+				continue
+			}
+			s, ok := value.(string)
+			if !ok {
+				return nil, fmt.Errorf("filter %s must be a string", key)
+			}
+			mapped[key] = f(s)
+		}
+		return mapped, nil
+	}
+	filter, err := ParseFilters(filters)
+	if err != nil {
+		return nil, err
+	}
+	for _, condition := range filter.Conditions() {
+		if f, ok := mappers[condition.Column]; ok {
+			condition.Value = mapFilterValue(condition.Value, f)
+		}
+	}
+	b, err := json.Marshal(filter)
+	if err != nil {
+		return nil, err
+	}
+	mapped := map[string]interface{}{}
+	if err := json.Unmarshal(b, &mapped); err != nil {
+		return nil, err
+	}
+	return mapped, nil
+}
+
+func isFilterScalar(value interface{}) bool {
+	switch value.(type) {
+	case string, float64, int, int64, bool:
+		return true
+	}
+	return false
+}
+
+// RelativeDateInterval returns the postgres interval of a relative date value, e.g. -7 days for
+// {"relative": "-7d"}, and whether value is a relative date
+func RelativeDateInterval(value interface{}) (string, bool, error) {
+	v, ok := value.(map[string]interface{})
+	if !ok {
+		return "", false, nil
+	}
+	relative, ok := v["relative"].(string)
+	if !ok || len(v) != 1 {
+		return "", true, fmt.Errorf("relative date must be {\"relative\": \"<+|-><number><m|h|d|w>\"}")
+	}
+	match := relativeDate.FindStringSubmatch(relative)
+	if match == nil {
+		return "", true, fmt.Errorf("invalid relative date %s", relative)
+	}
+	n, err := strconv.Atoi(match[2])
+	if err != nil {
+		return "", true, err
+	}
+	if match[1] == "-" {
+		n = -n
+	}
+	return fmt.Sprintf("%d %s", n, relativeDateUnits[match[3]]), true, nil
+}
+
+// validateComparable checks that value is a scalar or a relative date
+func validateComparable(value interface{}) error {
+	if isFilterScalar(value) {
+		return nil
+	}
+	_, isRelative, err := RelativeDateInterval(value)
+	if err != nil {
+		return err
+	}
+	if !isRelative {
+		return fmt.Errorf("value must be a string, number, boolean or relative date")
+	}
+	return nil
+}
+
+// Validate checks that the filter has exactly one group, negation or condition and that the conditions have
+// valid columns, operators and values
+func (f *Filter) Validate() error {
+	kinds := 0
+	if len(f.And) > 0 {
+		kinds++
+	}
+	if len(f.Or) > 0 {
+		kinds++
+	}
+	if f.Not != nil {
+		kinds++
+	}
+	if f.Op != "" || f.Column != "" {
+		kinds++
+	}
+	if kinds != 1 {
+		return fmt.Errorf("filter must have exactly one of and, or, not or a column condition")
+	}
+	for _, children := range [][]*Filter{f.And, f.Or} {
+		for _, child := range children {
+			if child == nil {
+				return fmt.Errorf("filter must be an object")
+			}
+			if err := child.Validate(); err != nil {
+				return err
+			}
+		}
+	}
+	if f.Not != nil {
+		return f.Not.Validate()
+	}
+	if len(f.And) > 0 || len(f.Or) > 0 {
+		return nil
+	}
+
+	if !filterColumn.MatchString(f.Column) {
+		return fmt.Errorf("invalid filter column '%s'", f.Column)
+	}
+	var err error
+	switch f.Op {
+	case FilterEqual, FilterNotEqual, FilterLess, FilterLessOrEqual, FilterGreater, FilterGreaterOrEqual:
+		err = validateComparable(f.Value)
+	case FilterLike:
+		if _, ok := f.Value.(string); !ok {
+			err = fmt.Errorf("value must be a string")
+		}
+	case FilterIn:
+		values, ok := f.Value.([]interface{})
+		if !ok || len(values) == 0 {
+			err = fmt.Errorf("value must be a non empty list")
+		}
+		for _, value := range values {
+			if !isFilterScalar(value) {
+				err = fmt.Errorf("values must be strings, numbers or booleans")
+			}
+		}
+	case FilterBetween:
+		values, ok := f.Value.([]interface{})
+		if !ok || len(values) != 2 {
+			err = fmt.Errorf("value must be a list with the lower and upper bounds")
+		}
+		for _, value := range values {
+			if e := validateComparable(value); e != nil {
+				err = e
+			}
+		}
+	case FilterIsNull:
+		if f.Value != nil {
+			err = fmt.Errorf("value must be empty")
+		}
+	default:
+		return fmt.Errorf("invalid filter operator '%s' of column %s", f.Op, f.Column)
+	}
+	if err != nil {
+		return fmt.Errorf("filter %s %s: %s", f.Column, f.Op, err.Error())
+	}
+	return nil
+}
@@ -23,6 +23,7 @@
 package model
 
 import (
+	"fmt"
 	"time"
 
 	"github.com/asaskevich/govalidator"
@@ -30,32 +31,52 @@ import (
 	"github.com/satori/go.uuid"
 )
 
+// Job priorities, jobs without priority have the normal priority
+const (
+	HighJobPriority   = "high"
+	NormalJobPriority = "normal"
+	LowJobPriority    = "low"
+)
+
 // Job is the job model struct
 type Job struct {
-	ID               uuid.UUID              `sql:",pk" json:"id"`
-	TotalBatches     int                    `json:"totalBatches"`
-	CompletedBatches int                    `json:"completedBatches"`
-	TotalUsers       int                    `json:"totalUsers"`
-	CompletedUsers   int                    `json:"completedUsers"`
-	DBPageSize       int                    `json:"dbPageSize"`
-	Localized        bool                   `json:"localized"`
-	CompletedAt      int64                  `json:"completedAt"`
-	ExpiresAt        int64                  `json:"expiresAt"`
-	StartsAt         int64                  `json:"startsAt"`
-	Context          map[string]interface{} `json:"context"`
-	Service          string                 `json:"service"`
-	Filters          map[string]interface{} `json:"filters"`
-	Metadata         map[string]interface{} `json:"metadata"`
-	CSVPath          string                 `json:"csvPath"`
-	CreatedBy        string                 `json:"createdBy"`
-	App              App                    `json:"app"`
-	AppID            uuid.UUID              `json:"appId"`
-	TemplateName     string                 `json:"templateName"`
-	PastTimeStrategy string                 `json:"pastTimeStrategy"`
-	Status           string                 `json:"status"`
-	Feedbacks        map[string]interface{} `json:"feedbacks"`
-	CreatedAt        int64                  `json:"createdAt"`
-	UpdatedAt        int64                  `json:"updatedAt"`
+	ID                 uuid.UUID              `sql:",pk" json:"id"`
+	TotalBatches       int                    `json:"totalBatches"`
+	CompletedBatches   int                    `json:"completedBatches"`
+	TotalUsers         int                    `json:"totalUsers"`
+	CompletedUsers     int                    `json:"completedUsers"`
+	FallbackUsers      int                    `json:"fallbackUsers"`
+	SuppressedUsers    int                    `json:"suppressedUsers"`
+	DuplicateTokens    int                    `json:"duplicateTokens"`
+	DBPageSize         int                    `json:"dbPageSize"`
+	Localized          bool                   `json:"localized"`
+	CompletedAt        int64                  `json:"completedAt"`
+	ExpiresAt          int64                  `json:"expiresAt"`
+	StartsAt           int64                  `json:"startsAt"`
+	Context            map[string]interface{} `json:"context"`
+	Service            string                 `json:"service"`
+	Filters            map[string]interface{} `json:"filters"`
+	Metadata           map[string]interface{} `json:"metadata"`
+	CSVPath            string                 `json:"csvPath"`
+	CreatedBy          string                 `json:"createdBy"`
+	App                App                    `json:"app"`
+	AppID              uuid.UUID              `json:"appId"`
+	TemplateName       string                 `json:"templateName"`
+	TemplateVersions   map[string]string      `json:"templateVersions"`
+	PastTimeStrategy   string                 `json:"pastTimeStrategy"`
+	MaxPushesPerSecond int                    `json:"maxPushesPerSecond"`
+	SampleRate         float64                `json:"sampleRate"`
+	SampleFrom         float64                `json:"sampleFrom"`
+	SampleSalt         string                 `json:"sampleSalt"`
+	Status             string                 `json:"status"`
+	Priority           string                 `json:"priority"`
+	Feedbacks          map[string]interface{} `json:"feedbacks"`
+	RecurringJobID     *uuid.UUID             `json:"recurringJobId"`
+	AudienceID         *uuid.UUID             `json:"audienceId"`
+	IdempotencyKey     string                 `json:"idempotencyKey"`
+	RequestHash        string                 `json:"-"`
+	CreatedAt          int64                  `json:"createdAt"`
+	UpdatedAt          int64                  `json:"updatedAt"`
 }
 
 // Validate implementation of the InputValidation interface
@@ -85,5 +106,34 @@ func (j *Job) Validate(c echo.Context) error {
 		return InvalidField("filters or csvPath must exist, not both")
 	}
 
+	valid = j.AudienceID == nil || (len(j.Filters) == 0 && govalidator.IsNull(j.CSVPath))
+	if !valid {
+		return InvalidField("audienceId or filters and csvPath must exist, not both")
+	}
+
+	if _, err := ParseFilters(j.Filters); err != nil {
+		return fmt.Errorf("invalid filters: %s", err.Error())
+	}
+
+	valid = j.Priority == "" || govalidator.StringMatches(j.Priority, "^(high|normal|low)$")
+	if !valid {
+		return InvalidField("priority")
+	}
+
+	valid = j.MaxPushesPerSecond >= 0
+	if !valid {
+		return InvalidField("maxPushesPerSecond")
+	}
+
+	valid = j.SampleRate >= 0 && j.SampleRate <= 1
+	if !valid {
+		return InvalidField("sampleRate")
+	}
+
+	valid = j.SampleFrom == 0 || (j.SampleFrom > 0 && j.SampleFrom < j.SampleRate)
+	if !valid {
+		return InvalidField("sampleFrom")
+	}
+
 	return nil
 }
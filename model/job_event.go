@@ -0,0 +1,89 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package model
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/satori/go.uuid"
+)
+
+const (
+	// JobProgressEvent is the type of the events with the job batches and users counters
+	JobProgressEvent = "progress"
+	// JobStatusEvent is the type of the events sent when the job status changes
+	JobStatusEvent = "status"
+	// JobFeedbacksEvent is the type of the events with the feedbacks received since the last one
+	JobFeedbacksEvent = "feedbacks"
+	// CompletedJobStatus is the status sent in status events when the job completes
+	CompletedJobStatus = "completed"
+)
+
+// JobEvent is a job update published to the job events channel
+type JobEvent struct {
+	Type             string         `json:"type"`
+	JobID            uuid.UUID      `json:"jobId"`
+	TotalBatches     int            `json:"totalBatches"`
+	CompletedBatches int            `json:"completedBatches"`
+	TotalUsers       int            `json:"totalUsers"`
+	CompletedUsers   int            `json:"completedUsers"`
+	FallbackUsers    int            `json:"fallbackUsers"`
+	SuppressedUsers  int            `json:"suppressedUsers"`
+	DuplicateTokens  int            `json:"duplicateTokens"`
+	Status           string         `json:"status,omitempty"`
+	Feedbacks        map[string]int `json:"feedbacks,omitempty"`
+	CreatedAt        int64          `json:"createdAt"`
+}
+
+// JobEventsChannel returns the redis channel where the events of the job are published
+func JobEventsChannel(jobID string) string {
+	return fmt.Sprintf("%s-events", jobID)
+}
+
+// NewJobEvent returns an event of the given type with the job counters
+func NewJobEvent(eventType string, job *Job) *JobEvent {
+	return &JobEvent{
+		Type:             eventType,
+		JobID:            job.ID,
+		TotalBatches:     job.TotalBatches,
+		CompletedBatches: job.CompletedBatches,
+		TotalUsers:       job.TotalUsers,
+		CompletedUsers:   job.CompletedUsers,
+		FallbackUsers:    job.FallbackUsers,
+		SuppressedUsers:  job.SuppressedUsers,
+		DuplicateTokens:  job.DuplicateTokens,
+		Status:           job.Status,
+		CreatedAt:        time.Now().UnixNano(),
+	}
+}
+
+// NewJobFeedbacksEvent returns an event with the feedbacks received by the job since the last event
+func NewJobFeedbacksEvent(jobID uuid.UUID, feedbacks map[string]int) *JobEvent {
+	return &JobEvent{
+		Type:      JobFeedbacksEvent,
+		JobID:     jobID,
+		Feedbacks: feedbacks,
+		CreatedAt: time.Now().UnixNano(),
+	}
+}
@@ -0,0 +1,61 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package model
+
+import "strings"
+
+// DefaultLocale is the locale used by apps without a default locale
+const DefaultLocale = "en"
+
+// NormalizeLocale returns the canonical form of locale, with its subtags separated by "-", the language in lower
+// case, the script in title case and the region in upper case, e.g. pt_br, PT-BR and pt_BR.UTF-8 become pt-BR
+func NormalizeLocale(locale string) string {
+	locale = strings.TrimSpace(locale)
+	if i := strings.IndexAny(locale, ".@"); i >= 0 {
+		locale = locale[:i]
+	}
+	subtags := []string{}
+	for _, subtag := range strings.FieldsFunc(locale, func(r rune) bool { return r == '-' || r == '_' }) {
+		switch {
+		case len(subtags) == 0:
+			subtag = strings.ToLower(subtag)
+		case len(subtag) == 2:
+			subtag = strings.ToUpper(subtag)
+		case len(subtag) == 4:
+			subtag = strings.ToUpper(subtag[:1]) + strings.ToLower(subtag[1:])
+		default:
+			subtag = strings.ToLower(subtag)
+		}
+		subtags = append(subtags, subtag)
+	}
+	return strings.Join(subtags, "-")
+}
+
+// ParentLocale returns the normalized locale without its last subtag, e.g. pt-BR becomes pt and pt becomes ""
+func ParentLocale(locale string) string {
+	locale = NormalizeLocale(locale)
+	if i := strings.LastIndex(locale, "-"); i >= 0 {
+		return locale[:i]
+	}
+	return ""
+}
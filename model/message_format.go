@@ -0,0 +1,377 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package model
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Plural categories of the CLDR plural rules
+const (
+	PluralZero  = "zero"
+	PluralOne   = "one"
+	PluralTwo   = "two"
+	PluralFew   = "few"
+	PluralMany  = "many"
+	PluralOther = "other"
+)
+
+// messageArgument matches the start of an ICU MessageFormat plural or select argument, e.g. {count, plural,
+var messageArgument = regexp.MustCompile(`{\s*([A-Za-z_][A-Za-z0-9_]*)\s*,\s*(plural|select)\s*,`)
+
+var pluralKey = regexp.MustCompile(`^(zero|one|two|few|many|other|=[0-9]+)$`)
+var selectKey = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// messageNode is a piece of a message: literal text or a plural or select argument
+type messageNode struct {
+	text     string
+	arg      string
+	kind     string
+	offset   float64
+	branches map[string][]messageNode
+}
+
+// pluralLanguage returns the lower case language of the locale, e.g. pt for pt-BR
+func pluralLanguage(locale string) string {
+	return strings.ToLower(strings.SplitN(NormalizeLocale(locale), "-", 2)[0])
+}
+
+// PluralCategory returns the CLDR plural category of n in the language of the locale. Languages without rules
+// here use the english rules
+func PluralCategory(locale string, n float64) string {
+	n = math.Abs(n)
+	isInteger := n == math.Trunc(n)
+	i := int64(n)
+	switch pluralLanguage(locale) {
+	case "ja", "zh", "ko", "vi", "th", "id", "ms", "km", "lo", "my":
+		return PluralOther
+	case "fr":
+		if i == 0 || i == 1 {
+			return PluralOne
+		}
+		return PluralOther
+	case "pt":
+		if strings.EqualFold(NormalizeLocale(locale), "pt-PT") {
+			break
+		}
+		if i == 0 || i == 1 {
+			return PluralOne
+		}
+		return PluralOther
+	case "ru", "uk", "be":
+		if !isInteger {
+			return PluralOther
+		}
+		if i%10 == 1 && i%100 != 11 {
+			return PluralOne
+		}
+		if i%10 >= 2 && i%10 <= 4 && (i%100 < 12 || i%100 > 14) {
+			return PluralFew
+		}
+		return PluralMany
+	case "pl":
+		if !isInteger {
+			return PluralOther
+		}
+		if i == 1 {
+			return PluralOne
+		}
+		if i%10 >= 2 && i%10 <= 4 && (i%100 < 12 || i%100 > 14) {
+			return PluralFew
+		}
+		return PluralMany
+	case "cs", "sk":
+		if !isInteger {
+			return PluralMany
+		}
+		if i == 1 {
+			return PluralOne
+		}
+		if i >= 2 && i <= 4 {
+			return PluralFew
+		}
+		return PluralOther
+	case "ar":
+		if !isInteger {
+			return PluralOther
+		}
+		switch {
+		case i == 0:
+			return PluralZero
+		case i == 1:
+			return PluralOne
+		case i == 2:
+			return PluralTwo
+		case i%100 >= 3 && i%100 <= 10:
+			return PluralFew
+		case i%100 >= 11 && i%100 <= 99:
+			return PluralMany
+		}
+		return PluralOther
+	case "he":
+		if isInteger && i == 1 {
+			return PluralOne
+		}
+		if isInteger && i == 2 {
+			return PluralTwo
+		}
+		return PluralOther
+	}
+	if isInteger && i == 1 {
+		return PluralOne
+	}
+	return PluralOther
+}
+
+// PluralLocale returns the locale whose plural rules are used to render a template of templateLocale to a user
+// with userLocale: the user locale if it has the language of the template, the template locale otherwise, since
+// the user received the template of a fallback locale
+func PluralLocale(templateLocale, userLocale string) string {
+	if userLocale != "" && pluralLanguage(userLocale) == pluralLanguage(templateLocale) {
+		return userLocale
+	}
+	return templateLocale
+}
+
+// closingBrace returns the index of the brace that closes the one at start
+func closingBrace(text string, start int) int {
+	depth := 0
+	for i := start; i < len(text); i++ {
+		switch text[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// parseMessage parses the plural and select arguments of text, the remaining text, including the placeholders
+// of the template engines, is kept as literal text
+func parseMessage(text string) ([]messageNode, error) {
+	nodes := []messageNode{}
+	for {
+		match := messageArgument.FindStringSubmatchIndex(text)
+		if match == nil {
+			if text != "" {
+				nodes = append(nodes, messageNode{text: text})
+			}
+			return nodes, nil
+		}
+		if match[0] > 0 {
+			nodes = append(nodes, messageNode{text: text[:match[0]]})
+		}
+		end := closingBrace(text, match[0])
+		if end < 0 {
+			return nil, fmt.Errorf("%s argument %s is not closed", text[match[4]:match[5]], text[match[2]:match[3]])
+		}
+		node, err := parseMessageArgument(text[match[2]:match[3]], text[match[4]:match[5]], text[match[1]:end])
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+		text = text[end+1:]
+	}
+}
+
+// parseMessageArgument parses the options of a plural or select argument, e.g. one {# gift} other {# gifts}
+func parseMessageArgument(arg, kind, options string) (messageNode, error) {
+	node := messageNode{arg: arg, kind: kind, branches: map[string][]messageNode{}}
+	options = strings.TrimSpace(options)
+	if kind == "plural" && strings.HasPrefix(options, "offset:") {
+		options = strings.TrimLeft(options[len("offset:"):], " ")
+		end := strings.IndexAny(options, " \t\n{")
+		if end < 0 {
+			end = len(options)
+		}
+		offset, err := strconv.ParseFloat(options[:end], 64)
+		if err != nil {
+			return node, fmt.Errorf("%s argument %s has an invalid offset", kind, arg)
+		}
+		node.offset = offset
+		options = strings.TrimSpace(options[end:])
+	}
+	keys := pluralKey
+	if kind == "select" {
+		keys = selectKey
+	}
+	for options != "" {
+		start := strings.Index(options, "{")
+		if start < 0 {
+			return node, fmt.Errorf("%s argument %s has an option without message", kind, arg)
+		}
+		key := strings.TrimSpace(options[:start])
+		if !keys.MatchString(key) {
+			return node, fmt.Errorf("%s argument %s has an invalid option '%s'", kind, arg, key)
+		}
+		if _, ok := node.branches[key]; ok {
+			return node, fmt.Errorf("%s argument %s has the option %s twice", kind, arg, key)
+		}
+		end := closingBrace(options, start)
+		if end < 0 {
+			return node, fmt.Errorf("%s argument %s option %s is not closed", kind, arg, key)
+		}
+		branch, err := parseMessage(options[start+1 : end])
+		if err != nil {
+			return node, err
+		}
+		node.branches[key] = branch
+		options = strings.TrimSpace(options[end+1:])
+	}
+	if _, ok := node.branches[PluralOther]; !ok {
+		return node, fmt.Errorf("%s argument %s has no other option", kind, arg)
+	}
+	return node, nil
+}
+
+// formatMessageNodes writes the nodes rendered with data to buf, # is replaced by hash in the text of plural
+// options
+func formatMessageNodes(buf *bytes.Buffer, nodes []messageNode, locale string, data map[string]interface{}, hash string) error {
+	for _, node := range nodes {
+		if node.arg == "" {
+			if hash != "" {
+				buf.WriteString(strings.Replace(node.text, "#", hash, -1))
+			} else {
+				buf.WriteString(node.text)
+			}
+			continue
+		}
+		value := data[node.arg]
+		branchHash := hash
+		var branch []messageNode
+		if node.kind == "plural" {
+			n, err := toFloat(value)
+			if err != nil {
+				return fmt.Errorf("plural argument %s: %s", node.arg, err.Error())
+			}
+			branch = node.branches[fmt.Sprintf("=%s", strconv.FormatFloat(n, 'f', -1, 64))]
+			if branch == nil {
+				branch = node.branches[PluralCategory(locale, n-node.offset)]
+			}
+			branchHash = strconv.FormatFloat(n-node.offset, 'f', -1, 64)
+		} else if value != nil {
+			branch = node.branches[fmt.Sprint(value)]
+		}
+		if branch == nil {
+			branch = node.branches[PluralOther]
+		}
+		if err := formatMessageNodes(buf, branch, locale, data, branchHash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// expandMessageNodes writes the nodes to buf with the options of each argument one after the other, so that the
+// placeholders of every option can be checked
+func expandMessageNodes(buf *bytes.Buffer, nodes []messageNode, args map[string]bool) {
+	for _, node := range nodes {
+		if node.arg == "" {
+			buf.WriteString(node.text)
+			continue
+		}
+		args[node.arg] = true
+		for _, branch := range node.branches {
+			expandMessageNodes(buf, branch, args)
+		}
+	}
+}
+
+// hasMessageArguments returns whether text has plural or select arguments
+func hasMessageArguments(text string) bool {
+	return strings.Contains(text, "plural") || strings.Contains(text, "select")
+}
+
+// FormatMessage replaces the ICU MessageFormat plural and select arguments of text, e.g.
+// {count, plural, one {# gift} other {# gifts}} or {gender, select, female {her} male {his} other {their}}, with
+// the option chosen by the value in data. Plural options are chosen with the plural rules of the locale
+func FormatMessage(text, locale string, data map[string]interface{}) (string, error) {
+	if !hasMessageArguments(text) {
+		return text, nil
+	}
+	nodes, err := parseMessage(text)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := formatMessageNodes(&buf, nodes, locale, data, ""); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// expandMessage returns text with the options of its plural and select arguments one after the other, and the
+// names of the arguments
+func expandMessage(text string) (string, []string, error) {
+	if !hasMessageArguments(text) {
+		return text, nil, nil
+	}
+	nodes, err := parseMessage(text)
+	if err != nil {
+		return "", nil, err
+	}
+	var buf bytes.Buffer
+	args := map[string]bool{}
+	expandMessageNodes(&buf, nodes, args)
+	names := make([]string, 0, len(args))
+	for arg := range args {
+		names = append(names, arg)
+	}
+	return buf.String(), names, nil
+}
+
+// FormatMessageBody returns the body with the plural and select arguments of every string formatted with data
+// and the plural rules of the locale
+func FormatMessageBody(body map[string]interface{}, locale string, data map[string]interface{}) (map[string]interface{}, error) {
+	formatted, err := walkTemplateBody("body", body, func(path, text string) (interface{}, error) {
+		message, err := FormatMessage(text, locale, data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err.Error())
+		}
+		return message, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return formatted.(map[string]interface{}), nil
+}
+
+// ParseMessageBody checks the plural and select arguments of every string of the body
+func ParseMessageBody(body map[string]interface{}) error {
+	_, err := walkTemplateBody("body", body, func(path, text string) (interface{}, error) {
+		if _, _, err := expandMessage(text); err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err.Error())
+		}
+		return text, nil
+	})
+	return err
+}
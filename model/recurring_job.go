@@ -0,0 +1,127 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package model
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/asaskevich/govalidator"
+	"github.com/labstack/echo"
+	"github.com/satori/go.uuid"
+)
+
+// RecurringJob is the recurring job model struct, a job that is created again on every occurrence of its cron spec
+type RecurringJob struct {
+	ID               uuid.UUID              `sql:",pk" json:"id"`
+	CronSpec         string                 `json:"cronSpec"`
+	Localized        bool                   `json:"localized"`
+	EndsAt           int64                  `json:"endsAt"`
+	NextRunAt        int64                  `json:"nextRunAt"`
+	LastRunAt        int64                  `json:"lastRunAt"`
+	Context          map[string]interface{} `json:"context"`
+	Service          string                 `json:"service"`
+	Filters          map[string]interface{} `json:"filters"`
+	Metadata         map[string]interface{} `json:"metadata"`
+	CSVPath          string                 `json:"csvPath"`
+	CreatedBy        string                 `json:"createdBy"`
+	App              App                    `json:"app"`
+	AppID            uuid.UUID              `json:"appId"`
+	TemplateName     string                 `json:"templateName"`
+	PastTimeStrategy string                 `json:"pastTimeStrategy"`
+	CreatedAt        int64                  `json:"createdAt"`
+	UpdatedAt        int64                  `json:"updatedAt"`
+}
+
+// Validate implementation of the InputValidation interface
+func (r *RecurringJob) Validate(c echo.Context) error {
+	if _, err := ParseCronSpec(r.CronSpec); err != nil {
+		return InvalidField("cronSpec")
+	}
+
+	valid := govalidator.StringMatches(r.Service, "^(apns|gcm)$")
+	if !valid {
+		return InvalidField("service")
+	}
+
+	valid = r.EndsAt == 0 || time.Now().UnixNano() < r.EndsAt
+	if !valid {
+		return InvalidField("endsAt")
+	}
+
+	valid = govalidator.IsEmail(r.CreatedBy)
+	if !valid {
+		return InvalidField("createdBy")
+	}
+
+	valid = !(len(r.Filters) != 0 && !govalidator.IsNull(r.CSVPath))
+	if !valid {
+		return InvalidField("filters or csvPath must exist, not both")
+	}
+
+	if _, err := ParseFilters(r.Filters); err != nil {
+		return fmt.Errorf("invalid filters: %s", err.Error())
+	}
+
+	return nil
+}
+
+// ScheduleNextRun sets NextRunAt to the first occurrence of the cron spec after the given time
+// NextRunAt is set to 0 if there are no more occurrences before EndsAt
+func (r *RecurringJob) ScheduleNextRun(after int64) error {
+	schedule, err := ParseCronSpec(r.CronSpec)
+	if err != nil {
+		return err
+	}
+	next := schedule.Next(time.Unix(0, after))
+	if next.IsZero() || (r.EndsAt != 0 && next.UnixNano() > r.EndsAt) {
+		r.NextRunAt = 0
+		return nil
+	}
+	r.NextRunAt = next.UnixNano()
+	return nil
+}
+
+// NewJob returns the job of the occurrence of the recurring job that starts at startsAt
+func (r *RecurringJob) NewJob(startsAt int64) *Job {
+	now := time.Now().UnixNano()
+	recurringJobID := r.ID
+	return &Job{
+		ID:               uuid.NewV4(),
+		Localized:        r.Localized,
+		StartsAt:         startsAt,
+		Context:          r.Context,
+		Service:          r.Service,
+		Filters:          r.Filters,
+		Metadata:         r.Metadata,
+		CSVPath:          r.CSVPath,
+		CreatedBy:        r.CreatedBy,
+		App:              r.App,
+		AppID:            r.AppID,
+		TemplateName:     r.TemplateName,
+		PastTimeStrategy: r.PastTimeStrategy,
+		RecurringJobID:   &recurringJobID,
+		CreatedAt:        now,
+		UpdatedAt:        now,
+	}
+}
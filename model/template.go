@@ -23,6 +23,9 @@
 package model
 
 import (
+	"fmt"
+	"sort"
+
 	"github.com/asaskevich/govalidator"
 	"github.com/labstack/echo"
 	"github.com/satori/go.uuid"
@@ -30,16 +33,20 @@ import (
 
 // Template is the template model struct
 type Template struct {
-	ID        uuid.UUID              `sql:",pk" json:"id"`
-	Name      string                 `json:"name"`
-	Locale    string                 `json:"locale"`
-	Defaults  map[string]interface{} `json:"defaults"`
-	Body      map[string]interface{} `json:"body"`
-	CreatedBy string                 `json:"createdBy"`
-	App       App                    `json:"app"`
-	AppID     uuid.UUID              `json:"appId"`
-	CreatedAt int64                  `json:"createdAt"`
-	UpdatedAt int64                  `json:"updatedAt"`
+	ID            uuid.UUID                         `sql:",pk" json:"id"`
+	Name          string                            `json:"name"`
+	Locale        string                            `json:"locale"`
+	Defaults      map[string]interface{}            `json:"defaults"`
+	Body          map[string]interface{}            `json:"body"`
+	ServiceBodies map[string]map[string]interface{} `json:"serviceBodies"`
+	Engine        string                            `json:"engine"`
+	Version       int                               `json:"version"`
+	CreatedBy     string                            `json:"createdBy"`
+	UpdatedBy     string                            `json:"updatedBy"`
+	App           App                               `json:"app"`
+	AppID         uuid.UUID                         `json:"appId"`
+	CreatedAt     int64                             `json:"createdAt"`
+	UpdatedAt     int64                             `json:"updatedAt"`
 }
 
 // Validate implementation of the InputValidation interface
@@ -60,9 +67,51 @@ func (t *Template) Validate(c echo.Context) error {
 	if !valid {
 		return InvalidField("body")
 	}
+	for _, service := range t.Services() {
+		valid = govalidator.StringMatches(service, "^[a-z0-9]+$") && len(t.ServiceBodies[service]) > 0
+		if !valid {
+			return InvalidField(fmt.Sprintf("serviceBodies.%s", service))
+		}
+	}
+	valid = t.Engine == "" || govalidator.StringMatches(t.Engine, "^(simple|gotemplate)$")
+	if !valid {
+		return InvalidField("engine")
+	}
+	parseBody := ParseMessageBody
+	if t.Engine == GoTemplateEngine {
+		parseBody = ParseGoTemplateBody
+	}
+	if err := parseBody(t.Body); err != nil {
+		return fmt.Errorf("invalid body: %s", err.Error())
+	}
+	for _, service := range t.Services() {
+		if err := parseBody(t.ServiceBodies[service]); err != nil {
+			return fmt.Errorf("invalid serviceBodies.%s: %s", service, err.Error())
+		}
+	}
 	valid = govalidator.IsEmail(t.CreatedBy)
 	if !valid {
 		return InvalidField("createdBy")
 	}
 	return nil
 }
+
+// Services returns the sorted names of the services that have a body of their own
+func (t *Template) Services() []string {
+	services := make([]string, 0, len(t.ServiceBodies))
+	for service := range t.ServiceBodies {
+		services = append(services, service)
+	}
+	sort.Strings(services)
+	return services
+}
+
+// ServiceTemplate returns the template used in the messages of the service: a copy of the template with the body
+// of the service, if it has one, as Body
+func (t *Template) ServiceTemplate(service string) Template {
+	template := *t
+	if body := t.ServiceBodies[service]; len(body) > 0 {
+		template.Body = body
+	}
+	return template
+}
@@ -0,0 +1,576 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package model
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"time"
+)
+
+// Template engines, templates without engine use the simple engine
+const (
+	// SimpleTemplateEngine replaces {{key}} placeholders in the template body
+	SimpleTemplateEngine = "simple"
+	// GoTemplateEngine renders every string of the template body as a go text/template
+	GoTemplateEngine = "gotemplate"
+)
+
+// simplePlaceholder matches the {{key}} placeholders of the simple engine
+var simplePlaceholder = regexp.MustCompile(`{{(.*?)}}`)
+
+// goTemplateMaxWidth is the largest width, precision or number of decimals a go template can format a value with
+const goTemplateMaxWidth = 100
+
+// goTemplateMaxSize is the largest size in bytes of the strings of a body rendered as go templates, the size limit
+// of the push payloads
+const goTemplateMaxSize = 4096
+
+// goTemplateMaxRangeLength is the largest number of items of the lists in the data of go templates with range
+// actions, so a range action with an empty body can't loop for long without reaching goTemplateMaxSize
+const goTemplateMaxRangeLength = 10000
+
+// printfVerb matches the verbs of printf formats up to the verb letter
+var printfVerb = regexp.MustCompile(`%[^a-zA-Z%]*`)
+
+// printfNumber matches the widths, precisions and argument indexes of a printf verb
+var printfNumber = regexp.MustCompile(`[0-9]+`)
+
+// goTemplateFuncs are the functions available to go templates besides the text/template builtins. The builtins
+// that could use unbounded memory or call functions are replaced
+var goTemplateFuncs = template.FuncMap{
+	"default":      defaultValue,
+	"upper":        strings.ToUpper,
+	"lower":        strings.ToLower,
+	"title":        strings.Title,
+	"trim":         strings.TrimSpace,
+	"plural":       plural,
+	"formatNumber": formatNumber,
+	"formatDate":   formatDate,
+	"printf":       printf,
+	"call":         call,
+}
+
+func isEmptyValue(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return true
+	case string:
+		return v == ""
+	case bool:
+		return !v
+	case float64:
+		return v == 0
+	case int:
+		return v == 0
+	case []interface{}:
+		return len(v) == 0
+	case map[string]interface{}:
+		return len(v) == 0
+	}
+	return false
+}
+
+// defaultValue returns value or def if value is empty, e.g. {{.name | default "friend"}}
+func defaultValue(def, value interface{}) interface{} {
+	if isEmptyValue(value) {
+		return def
+	}
+	return value
+}
+
+func toFloat(value interface{}) (float64, error) {
+	switch v := value.(type) {
+	case float64:
+		return v, nil
+	case int:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case string:
+		return strconv.ParseFloat(v, 64)
+	}
+	return 0, fmt.Errorf("%v is not a number", value)
+}
+
+// plural returns singular if count is 1 and plural otherwise, e.g. {{plural .lives "life" "lives"}}
+func plural(count interface{}, singular, plural string) (string, error) {
+	n, err := toFloat(count)
+	if err != nil {
+		return "", err
+	}
+	if n == 1 {
+		return singular, nil
+	}
+	return plural, nil
+}
+
+// printf is fmt.Sprintf with the widths and precisions of the verbs limited to goTemplateMaxWidth, so a template
+// can't allocate a huge string, e.g. {{printf "%05d" .level}}
+func printf(format string, args ...interface{}) (string, error) {
+	for _, verb := range printfVerb.FindAllString(format, -1) {
+		if strings.Contains(verb, "*") {
+			return "", fmt.Errorf("printf: * widths are not allowed")
+		}
+		for _, number := range printfNumber.FindAllString(verb, -1) {
+			if n, err := strconv.Atoi(number); err != nil || n > goTemplateMaxWidth {
+				return "", fmt.Errorf("printf: widths and precisions must be at most %d", goTemplateMaxWidth)
+			}
+		}
+	}
+	return fmt.Sprintf(format, args...), nil
+}
+
+// call replaces the call builtin, the data of the templates never has functions to call
+func call(fn interface{}, args ...interface{}) (interface{}, error) {
+	return nil, fmt.Errorf("call is not allowed")
+}
+
+// formatNumber formats value with the given decimals and thousands separated by commas, e.g.
+// {{.coins | formatNumber 0}}
+func formatNumber(decimals int, value interface{}) (string, error) {
+	if decimals < 0 || decimals > goTemplateMaxWidth {
+		return "", fmt.Errorf("formatNumber: decimals must be between 0 and %d", goTemplateMaxWidth)
+	}
+	n, err := toFloat(value)
+	if err != nil {
+		return "", err
+	}
+	formatted := strconv.FormatFloat(math.Abs(n), 'f', decimals, 64)
+	integer := formatted
+	fraction := ""
+	if i := strings.Index(formatted, "."); i >= 0 {
+		integer = formatted[:i]
+		fraction = formatted[i:]
+	}
+	var buf bytes.Buffer
+	if n < 0 {
+		buf.WriteString("-")
+	}
+	for i, digit := range integer {
+		if i > 0 && (len(integer)-i)%3 == 0 {
+			buf.WriteString(",")
+		}
+		buf.WriteRune(digit)
+	}
+	buf.WriteString(fraction)
+	return buf.String(), nil
+}
+
+// formatDate formats value, a unix timestamp in seconds or a RFC3339 date, with the given go time layout in UTC,
+// e.g. {{.endsAt | formatDate "Jan 2"}}
+func formatDate(layout string, value interface{}) (string, error) {
+	if s, ok := value.(string); ok {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return "", err
+		}
+		return t.UTC().Format(layout), nil
+	}
+	n, err := toFloat(value)
+	if err != nil {
+		return "", err
+	}
+	return time.Unix(int64(n), 0).UTC().Format(layout), nil
+}
+
+// checkGoTemplateNode rejects the actions that execute other templates, which could recurse forever, range
+// actions inside range actions, which could loop over the data a quadratic number of times, and range actions
+// over anything but the fields of the data, since a number such as {{range 2000000000}} loops that many times
+func checkGoTemplateNode(node parse.Node, inRange bool) error {
+	switch n := node.(type) {
+	case *parse.TemplateNode:
+		return fmt.Errorf("template actions are not allowed")
+	case *parse.ListNode:
+		if n == nil {
+			return nil
+		}
+		for _, child := range n.Nodes {
+			if err := checkGoTemplateNode(child, inRange); err != nil {
+				return err
+			}
+		}
+	case *parse.IfNode:
+		return checkGoTemplateBranch(&n.BranchNode, inRange)
+	case *parse.RangeNode:
+		if inRange {
+			return fmt.Errorf("nested range actions are not allowed")
+		}
+		if !isGoTemplateField(n.Pipe) {
+			return fmt.Errorf("range actions are only allowed over fields of the data")
+		}
+		return checkGoTemplateBranch(&n.BranchNode, true)
+	case *parse.WithNode:
+		return checkGoTemplateBranch(&n.BranchNode, inRange)
+	}
+	return nil
+}
+
+// isGoTemplateField returns whether pipe is a single field of the data, such as .items or $.items
+func isGoTemplateField(pipe *parse.PipeNode) bool {
+	if len(pipe.Cmds) != 1 || len(pipe.Cmds[0].Args) != 1 {
+		return false
+	}
+	switch arg := pipe.Cmds[0].Args[0].(type) {
+	case *parse.FieldNode:
+		return true
+	case *parse.VariableNode:
+		return len(arg.Ident) > 1 && arg.Ident[0] == "$"
+	}
+	return false
+}
+
+// hasGoTemplateRange returns whether node has range actions
+func hasGoTemplateRange(node parse.Node) bool {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return false
+		}
+		for _, child := range n.Nodes {
+			if hasGoTemplateRange(child) {
+				return true
+			}
+		}
+	case *parse.RangeNode:
+		return true
+	case *parse.IfNode:
+		return hasGoTemplateRange(n.List) || hasGoTemplateRange(n.ElseList)
+	case *parse.WithNode:
+		return hasGoTemplateRange(n.List) || hasGoTemplateRange(n.ElseList)
+	}
+	return false
+}
+
+// checkGoTemplateRangeData returns an error if a list in value has more than goTemplateMaxRangeLength items
+func checkGoTemplateRangeData(value interface{}) error {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for _, child := range v {
+			if err := checkGoTemplateRangeData(child); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		if len(v) > goTemplateMaxRangeLength {
+			return fmt.Errorf("lists ranged over by templates can have at most %d items", goTemplateMaxRangeLength)
+		}
+		for _, child := range v {
+			if err := checkGoTemplateRangeData(child); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func checkGoTemplateBranch(branch *parse.BranchNode, inRange bool) error {
+	if err := checkGoTemplateNode(branch.List, inRange); err != nil {
+		return err
+	}
+	return checkGoTemplateNode(branch.ElseList, inRange)
+}
+
+// NewGoTemplate parses text as a go template with the template functions, missing keys are errors
+func NewGoTemplate(name, text string) (*template.Template, error) {
+	t, err := template.New(name).Funcs(goTemplateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	if len(t.Templates()) > 1 {
+		return nil, fmt.Errorf("%s: define and block actions are not allowed", name)
+	}
+	if t.Tree == nil {
+		return t, nil
+	}
+	if err := checkGoTemplateNode(t.Tree.Root, false); err != nil {
+		return nil, fmt.Errorf("%s: %s", name, err.Error())
+	}
+	return t, nil
+}
+
+// walkTemplateBody calls f with the path and value of every string in value and replaces them with the result
+func walkTemplateBody(path string, value interface{}, f func(path, text string) (interface{}, error)) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		return f(path, v)
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for key, child := range v {
+			rendered, err := walkTemplateBody(fmt.Sprintf("%s.%s", path, key), child, f)
+			if err != nil {
+				return nil, err
+			}
+			result[key] = rendered
+		}
+		return result, nil
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, child := range v {
+			rendered, err := walkTemplateBody(fmt.Sprintf("%s[%d]", path, i), child, f)
+			if err != nil {
+				return nil, err
+			}
+			result[i] = rendered
+		}
+		return result, nil
+	}
+	return value, nil
+}
+
+// ParseGoTemplateBody checks that every string of the body is a valid go template with every option of its plural
+// and select arguments
+func ParseGoTemplateBody(body map[string]interface{}) error {
+	_, err := walkTemplateBody("body", body, func(path, text string) (interface{}, error) {
+		expanded, _, err := expandMessage(text)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err.Error())
+		}
+		_, err = NewGoTemplate(path, expanded)
+		return text, err
+	})
+	return err
+}
+
+// GoTemplateCache keeps the parsed go templates of the strings of template bodies by path and text, so the
+// messages built from the same template version parse each of its strings once. It is not safe for concurrent use
+type GoTemplateCache map[string]*template.Template
+
+// Get returns the go template of the string of a body at path, parsing it if it is not in the cache. A nil cache
+// parses the string every time
+func (c GoTemplateCache) Get(path, text string) (*template.Template, error) {
+	key := fmt.Sprintf("%s\x00%s", path, text)
+	if t, ok := c[key]; ok {
+		return t, nil
+	}
+	t, err := NewGoTemplate(path, text)
+	if err != nil {
+		return nil, err
+	}
+	if c != nil {
+		c[key] = t
+	}
+	return t, nil
+}
+
+// limitedBuffer is a buffer that fails the writes after remaining bytes
+type limitedBuffer struct {
+	bytes.Buffer
+	remaining int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if len(p) > b.remaining {
+		return 0, fmt.Errorf("rendered body is larger than %d bytes", goTemplateMaxSize)
+	}
+	b.remaining -= len(p)
+	return b.Buffer.Write(p)
+}
+
+// RenderGoTemplateBody returns the body with every string rendered as a go template with data, taken from the
+// cache. The rendered strings can have at most goTemplateMaxSize bytes in total and the lists of the data of the
+// strings with range actions at most goTemplateMaxRangeLength items
+func RenderGoTemplateBody(body map[string]interface{}, data map[string]interface{}, cache GoTemplateCache) (map[string]interface{}, error) {
+	buf := &limitedBuffer{remaining: goTemplateMaxSize}
+	rangeDataChecked := false
+	rendered, err := walkTemplateBody("body", body, func(path, text string) (interface{}, error) {
+		t, err := cache.Get(path, text)
+		if err != nil {
+			return "", err
+		}
+		if !rangeDataChecked && t.Tree != nil && hasGoTemplateRange(t.Tree.Root) {
+			if err := checkGoTemplateRangeData(data); err != nil {
+				return "", fmt.Errorf("%s: %s", path, err.Error())
+			}
+			rangeDataChecked = true
+		}
+		buf.Reset()
+		if err := t.Execute(buf, data); err != nil {
+			return "", err
+		}
+		return buf.String(), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rendered.(map[string]interface{}), nil
+}
+
+// placeholderString returns the text that replaces a placeholder in the middle of a string, values that are not
+// strings or numbers are written as json
+func placeholderString(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	}
+	b, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// renderSimpleString replaces the placeholders of text with the values in data, missing keys are replaced by
+// an empty string. If text is a single placeholder it is replaced by the value itself, keeping numbers, booleans
+// and objects in the message
+func renderSimpleString(path, text string, data map[string]interface{}) (interface{}, error) {
+	if match := simplePlaceholder.FindStringSubmatchIndex(text); match != nil && match[0] == 0 && match[1] == len(text) {
+		key := text[match[2]:match[3]]
+		if value, ok := data[key]; ok {
+			if _, err := json.Marshal(value); err != nil {
+				return nil, fmt.Errorf("%s: placeholder {{%s}}: %s", path, key, err.Error())
+			}
+			return value, nil
+		}
+	}
+	var renderErr error
+	rendered := simplePlaceholder.ReplaceAllStringFunc(text, func(placeholder string) string {
+		key := placeholder[2 : len(placeholder)-2]
+		value, err := placeholderString(data[key])
+		if err != nil && renderErr == nil {
+			renderErr = fmt.Errorf("%s: placeholder {{%s}}: %s", path, key, err.Error())
+		}
+		return value
+	})
+	if renderErr != nil {
+		return nil, renderErr
+	}
+	return rendered, nil
+}
+
+// RenderSimpleTemplateBody returns the body with the {{key}} placeholders of every string replaced by the values
+// in data. The rendered values are json values, so they can have quotes, backslashes or new lines
+func RenderSimpleTemplateBody(body map[string]interface{}, data map[string]interface{}) (map[string]interface{}, error) {
+	rendered, err := walkTemplateBody("body", body, func(path, text string) (interface{}, error) {
+		return renderSimpleString(path, text, data)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rendered.(map[string]interface{}), nil
+}
+
+// goTemplatePlaceholders adds to keys the fields of the data used by node. The fields used inside range and with
+// actions are not added, since the dot is not the data there
+func goTemplatePlaceholders(node parse.Node, keys map[string]bool) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			goTemplatePlaceholders(child, keys)
+		}
+	case *parse.ActionNode:
+		goTemplatePlaceholders(n.Pipe, keys)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, cmd := range n.Cmds {
+			goTemplatePlaceholders(cmd, keys)
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			goTemplatePlaceholders(arg, keys)
+		}
+	case *parse.ChainNode:
+		goTemplatePlaceholders(n.Node, keys)
+	case *parse.FieldNode:
+		keys[n.Ident[0]] = true
+	case *parse.VariableNode:
+		if len(n.Ident) > 1 && n.Ident[0] == "$" {
+			keys[n.Ident[1]] = true
+		}
+	case *parse.IfNode:
+		goTemplatePlaceholders(n.Pipe, keys)
+		goTemplatePlaceholders(n.List, keys)
+		goTemplatePlaceholders(n.ElseList, keys)
+	case *parse.RangeNode:
+		goTemplatePlaceholders(n.Pipe, keys)
+		goTemplatePlaceholders(n.ElseList, keys)
+	case *parse.WithNode:
+		goTemplatePlaceholders(n.Pipe, keys)
+		goTemplatePlaceholders(n.ElseList, keys)
+	}
+}
+
+// TemplatePlaceholders returns the sorted keys of the data used by the strings of the template body and service
+// bodies, including the plural and select arguments
+func TemplatePlaceholders(t *Template) ([]string, error) {
+	keys := map[string]bool{}
+	addPlaceholders := func(path, text string) (interface{}, error) {
+		text, args, err := expandMessage(text)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err.Error())
+		}
+		for _, arg := range args {
+			keys[arg] = true
+		}
+		if t.Engine != GoTemplateEngine {
+			for _, match := range simplePlaceholder.FindAllStringSubmatch(text, -1) {
+				keys[match[1]] = true
+			}
+			return text, nil
+		}
+		goTemplate, err := NewGoTemplate(path, text)
+		if err != nil {
+			return nil, err
+		}
+		if goTemplate.Tree != nil {
+			goTemplatePlaceholders(goTemplate.Tree.Root, keys)
+		}
+		return text, nil
+	}
+	if _, err := walkTemplateBody("body", t.Body, addPlaceholders); err != nil {
+		return nil, err
+	}
+	for _, service := range t.Services() {
+		path := fmt.Sprintf("serviceBodies.%s", service)
+		if _, err := walkTemplateBody(path, t.ServiceBodies[service], addPlaceholders); err != nil {
+			return nil, err
+		}
+	}
+	placeholders := make([]string, 0, len(keys))
+	for key := range keys {
+		placeholders = append(placeholders, key)
+	}
+	sort.Strings(placeholders)
+	return placeholders, nil
+}
@@ -0,0 +1,62 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package model
+
+import (
+	"github.com/satori/go.uuid"
+)
+
+// TemplateVersion is the template version model struct, a snapshot of the template created by the database every
+// time the template is created or updated
+type TemplateVersion struct {
+	ID            uuid.UUID                         `sql:",pk" json:"id"`
+	TemplateID    uuid.UUID                         `json:"templateId"`
+	Version       int                               `json:"version"`
+	Name          string                            `json:"name"`
+	Locale        string                            `json:"locale"`
+	Defaults      map[string]interface{}            `json:"defaults"`
+	Body          map[string]interface{}            `json:"body"`
+	ServiceBodies map[string]map[string]interface{} `json:"serviceBodies"`
+	Engine        string                            `json:"engine"`
+	CreatedBy     string                            `json:"createdBy"`
+	AppID         uuid.UUID                         `json:"appId"`
+	CreatedAt     int64                             `json:"createdAt"`
+}
+
+// Template returns the template as it was in the version
+func (v *TemplateVersion) Template() Template {
+	return Template{
+		ID:            v.TemplateID,
+		Name:          v.Name,
+		Locale:        v.Locale,
+		Defaults:      v.Defaults,
+		Body:          v.Body,
+		ServiceBodies: v.ServiceBodies,
+		Engine:        v.Engine,
+		Version:       v.Version,
+		CreatedBy:     v.CreatedBy,
+		AppID:         v.AppID,
+		CreatedAt:     v.CreatedAt,
+		UpdatedAt:     v.CreatedAt,
+	}
+}
@@ -54,6 +54,8 @@ func CreateTestApp(db interfaces.DB, options ...map[string]interface{}) *model.A
 	app.Name = getOpt(opts, "name", "testapp").(string)
 	app.BundleID = getOpt(opts, "bundleId", fmt.Sprintf("com.app.%s", strings.Split(uuid.NewV4().String(), "-")[0])).(string)
 	app.CreatedBy = getOpt(opts, "createdBy", fmt.Sprintf("%s@test.com", strings.Split(uuid.NewV4().String(), "-")[0])).(string)
+	app.DefaultLocale = getOpt(opts, "defaultLocale", "").(string)
+	app.LocaleFallbacks = getOpt(opts, "localeFallbacks", map[string][]string{}).(map[string][]string)
 
 	err := db.Insert(&app)
 	gomega.Expect(err).NotTo(gomega.HaveOccurred())
@@ -107,6 +109,10 @@ func CreateTestTemplate(db interfaces.DB, appID uuid.UUID, options ...map[string
 	template.Name = getOpt(opts, "name", uuid.NewV4().String()).(string)
 	template.Locale = getOpt(opts, "locale", strings.Split(uuid.NewV4().String(), "-")[0]).(string)
 	template.CreatedBy = getOpt(opts, "createdBy", fmt.Sprintf("%s@test.com", strings.Split(uuid.NewV4().String(), "-")[0])).(string)
+	template.Version = 1
+	if serviceBodies, ok := opts["serviceBodies"]; ok {
+		template.ServiceBodies = serviceBodies.(map[string]map[string]interface{})
+	}
 
 	err := db.Insert(&template)
 	gomega.Expect(err).NotTo(gomega.HaveOccurred())
@@ -173,6 +179,13 @@ func CreateTestJob(db interfaces.DB, appID uuid.UUID, templateName string, optio
 	job.ExpiresAt = getOpt(opts, "expiresAt", time.Now().Add(time.Hour).UnixNano()).(int64)
 	job.CreatedBy = getOpt(opts, "createdBy", fmt.Sprintf("%s@test.com", strings.Split(uuid.NewV4().String(), "-")[0])).(string)
 	job.StartsAt = getOpt(opts, "startsAt", time.Now().Add(time.Hour).UnixNano()).(int64)
+	job.Status = getOpt(opts, "status", "").(string)
+	job.Priority = getOpt(opts, "priority", "").(string)
+	job.SampleRate = getOpt(opts, "sampleRate", 0.0).(float64)
+	job.SampleFrom = getOpt(opts, "sampleFrom", 0.0).(float64)
+	job.SampleSalt = getOpt(opts, "sampleSalt", "").(string)
+	job.CreatedAt = getOpt(opts, "createdAt", time.Now().UnixNano()).(int64)
+	job.UpdatedAt = job.CreatedAt
 
 	err := db.Insert(&job)
 	gomega.Expect(err).NotTo(gomega.HaveOccurred())
@@ -219,3 +232,123 @@ func GetJobPayload(options ...map[string]interface{}) map[string]interface{} {
 	}
 	return job
 }
+
+//CreateTestRecurringJob with specified optional values
+func CreateTestRecurringJob(db interfaces.DB, appID uuid.UUID, templateName string, options ...map[string]interface{}) *model.RecurringJob {
+	opts := map[string]interface{}{}
+	if len(options) == 1 {
+		opts = options[0]
+	}
+
+	filters := getOpt(opts, "filters", map[string]interface{}{"locale": strings.Split(uuid.NewV4().String(), "-")[0]}).(map[string]interface{})
+	context := getOpt(opts, "context", map[string]interface{}{"value": uuid.NewV4().String()}).(map[string]interface{})
+	metadata := getOpt(opts, "metadata", map[string]interface{}{"meta": uuid.NewV4().String()}).(map[string]interface{})
+
+	recurringJob := &model.RecurringJob{}
+	recurringJob.AppID = appID
+	recurringJob.TemplateName = templateName
+	recurringJob.Filters = filters
+	recurringJob.Metadata = metadata
+	recurringJob.Context = context
+	recurringJob.ID = getOpt(opts, "id", uuid.NewV4()).(uuid.UUID)
+	recurringJob.CronSpec = getOpt(opts, "cronSpec", "0 10 * * 1").(string)
+	recurringJob.Localized = getOpt(opts, "localized", false).(bool)
+	recurringJob.Service = getOpt(opts, "service", "apns").(string)
+	recurringJob.CSVPath = getOpt(opts, "csvPath", "").(string)
+	recurringJob.PastTimeStrategy = getOpt(opts, "pastTimeStrategy", "").(string)
+	recurringJob.EndsAt = getOpt(opts, "endsAt", int64(0)).(int64)
+	recurringJob.NextRunAt = getOpt(opts, "nextRunAt", time.Now().Add(time.Hour).UnixNano()).(int64)
+	recurringJob.LastRunAt = getOpt(opts, "lastRunAt", int64(0)).(int64)
+	recurringJob.CreatedBy = getOpt(opts, "createdBy", fmt.Sprintf("%s@test.com", strings.Split(uuid.NewV4().String(), "-")[0])).(string)
+
+	err := db.Insert(&recurringJob)
+	gomega.Expect(err).NotTo(gomega.HaveOccurred())
+	return recurringJob
+}
+
+//GetRecurringJobPayload with specified optional values
+func GetRecurringJobPayload(options ...map[string]interface{}) map[string]interface{} {
+	opts := map[string]interface{}{}
+	if len(options) == 1 {
+		opts = options[0]
+	}
+
+	filters := getOpt(opts, "filters", map[string]interface{}{"locale": strings.Split(uuid.NewV4().String(), "-")[0]}).(map[string]interface{})
+	context := getOpt(opts, "context", map[string]interface{}{"value": uuid.NewV4().String()}).(map[string]interface{})
+	metadata := getOpt(opts, "metadata", map[string]interface{}{"meta": uuid.NewV4().String()}).(map[string]interface{})
+
+	recurringJob := map[string]interface{}{
+		"cronSpec":     getOpt(opts, "cronSpec", "0 10 * * 1").(string),
+		"templateName": getOpt(opts, "templateName", "").(string),
+		"filters":      filters,
+		"context":      context,
+		"metadata":     metadata,
+		"service":      getOpt(opts, "service", "apns").(string),
+		"csvPath":      getOpt(opts, "csvPath", "").(string),
+		"localized":    getOpt(opts, "localized", false).(bool),
+		"endsAt":       getOpt(opts, "endsAt", int64(0)).(int64),
+	}
+	return recurringJob
+}
+
+//CreateTestAudience with specified optional values
+func CreateTestAudience(db interfaces.DB, appID uuid.UUID, options ...map[string]interface{}) *model.Audience {
+	opts := map[string]interface{}{}
+	if len(options) == 1 {
+		opts = options[0]
+	}
+
+	csvPath := getOpt(opts, "csvPath", "").(string)
+	filters := map[string]interface{}{}
+	if csvPath == "" {
+		filters = getOpt(opts, "filters", map[string]interface{}{"locale": strings.Split(uuid.NewV4().String(), "-")[0]}).(map[string]interface{})
+	}
+
+	audience := &model.Audience{}
+	audience.AppID = appID
+	audience.ID = getOpt(opts, "id", uuid.NewV4()).(uuid.UUID)
+	audience.Name = getOpt(opts, "name", uuid.NewV4().String()).(string)
+	audience.Service = getOpt(opts, "service", "apns").(string)
+	audience.Filters = filters
+	audience.CSVPath = csvPath
+	audience.Size = getOpt(opts, "size", 0).(int)
+	audience.CreatedBy = getOpt(opts, "createdBy", fmt.Sprintf("%s@test.com", strings.Split(uuid.NewV4().String(), "-")[0])).(string)
+	audience.CreatedAt = getOpt(opts, "createdAt", time.Now().UnixNano()).(int64)
+	audience.UpdatedAt = audience.CreatedAt
+
+	err := db.Insert(&audience)
+	gomega.Expect(err).NotTo(gomega.HaveOccurred())
+	return audience
+}
+
+//GetAudiencePayload with specified optional values
+func GetAudiencePayload(options ...map[string]interface{}) map[string]interface{} {
+	opts := map[string]interface{}{}
+	if len(options) == 1 {
+		opts = options[0]
+	}
+
+	audience := map[string]interface{}{
+		"name":    getOpt(opts, "name", uuid.NewV4().String()).(string),
+		"service": getOpt(opts, "service", "apns").(string),
+	}
+	if csvPath := getOpt(opts, "csvPath", "").(string); csvPath != "" {
+		audience["csvPath"] = csvPath
+	} else {
+		audience["filters"] = getOpt(opts, "filters", map[string]interface{}{"locale": strings.Split(uuid.NewV4().String(), "-")[0]}).(map[string]interface{})
+	}
+	return audience
+}
+
+//CreateTestSuppressedUser adds the user to the suppression list of the app
+func CreateTestSuppressedUser(db interfaces.DB, appID uuid.UUID, userID string) *model.SuppressedUser {
+	suppressedUser := &model.SuppressedUser{
+		AppID:     appID,
+		UserID:    userID,
+		CreatedBy: "test@test.com",
+		CreatedAt: time.Now().UnixNano(),
+	}
+	err := db.Insert(&suppressedUser)
+	gomega.Expect(err).NotTo(gomega.HaveOccurred())
+	return suppressedUser
+}
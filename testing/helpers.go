@@ -212,6 +212,14 @@ func (m *PGMock) Delete(params interface{}) error {
 	return nil
 }
 
+//Begin mock for testing, the mock does not support transactions
+func (m *PGMock) Begin() (*pg.Tx, error) {
+	if m.Error != nil {
+		return nil, m.Error
+	}
+	return nil, errors.New("transactions are not supported by PGMock")
+}
+
 // FakeS3 for usage in tests
 type FakeS3 struct {
 	s3iface.S3API
@@ -369,7 +377,18 @@ func Delete(app *api.Application, url, auth string) (int, string) {
 	return doRequest(app, "DELETE", url, "", auth)
 }
 
+//GetWithHeaders from server returning the response headers
+func GetWithHeaders(app *api.Application, url, auth string) (int, string, http.Header) {
+	return DoRequest(app, "GET", url, "", auth, nil)
+}
+
 func doRequest(app *api.Application, method, url, body, auth string) (int, string) {
+	status, resBody, _ := DoRequest(app, method, url, body, auth, nil)
+	return status, resBody
+}
+
+//DoRequest to server with the given request headers returning the response headers
+func DoRequest(app *api.Application, method, url, body, auth string, headers map[string]string) (int, string, http.Header) {
 	ts := httptest.NewServer(app.API)
 	defer ts.Close()
 
@@ -382,6 +401,9 @@ func doRequest(app *api.Application, method, url, body, auth string) (int, strin
 	if auth != "" {
 		req.Header.Add("x-forwarded-email", auth)
 	}
+	for key, value := range headers {
+		req.Header.Add(key, value)
+	}
 
 	client := &http.Client{}
 	res, err := client.Do(req)
@@ -394,7 +416,7 @@ func doRequest(app *api.Application, method, url, body, auth string) (int, strin
 	b, err := ioutil.ReadAll(res.Body)
 	gomega.Expect(err).NotTo(gomega.HaveOccurred())
 
-	return res.StatusCode, string(b)
+	return res.StatusCode, string(b), res.Header
 }
 
 //ResetStdout back to os.Stdout
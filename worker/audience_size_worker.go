@@ -0,0 +1,136 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package worker
+
+import (
+	"time"
+
+	"gopkg.in/pg.v5"
+
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/jrallison/go-workers"
+	"github.com/satori/go.uuid"
+	"github.com/spf13/viper"
+	"github.com/topfreegames/marathon/extensions"
+	"github.com/topfreegames/marathon/log"
+	"github.com/topfreegames/marathon/model"
+	"github.com/uber-go/zap"
+)
+
+// AudienceSizeWorker is the AudienceSizeWorker struct, it counts the users of an audience in the push db
+type AudienceSizeWorker struct {
+	Logger     zap.Logger
+	MarathonDB *extensions.PGClient
+	PushDB     *extensions.PGClient
+	Config     *viper.Viper
+	S3Client   s3iface.S3API
+}
+
+// NewAudienceSizeWorker gets a new AudienceSizeWorker
+func NewAudienceSizeWorker(config *viper.Viper, logger zap.Logger) *AudienceSizeWorker {
+	b := &AudienceSizeWorker{
+		Config: config,
+		Logger: logger.With(zap.String("worker", "AudienceSizeWorker")),
+	}
+	b.configure()
+	log.D(logger, "Configured AudienceSizeWorker successfully.")
+	return b
+}
+
+func (b *AudienceSizeWorker) configurePushDatabase() {
+	var err error
+	b.PushDB, err = extensions.NewPGClient("push.db", b.Config, b.Logger)
+	checkErr(b.Logger, err)
+}
+
+func (b *AudienceSizeWorker) configureMarathonDatabase() {
+	var err error
+	b.MarathonDB, err = extensions.NewPGClient("db", b.Config, b.Logger)
+	checkErr(b.Logger, err)
+}
+
+func (b *AudienceSizeWorker) configureS3Client() {
+	s3Client, err := extensions.NewS3(b.Config, b.Logger)
+	checkErr(b.Logger, err)
+	b.S3Client = s3Client
+}
+
+func (b *AudienceSizeWorker) configure() {
+	b.configureMarathonDatabase()
+	b.configurePushDatabase()
+	b.configureS3Client()
+}
+
+// countUsers returns the number of push db users matching the filters or in the csv of the audience
+func (b *AudienceSizeWorker) countUsers(audience *model.Audience) (int, error) {
+	if len(audience.CSVPath) == 0 {
+		return CountUsersFromFilters(b.PushDB.DB, audience.App.Name, audience.Service, audience.Filters)
+	}
+	csvFile, err := extensions.S3GetObject(b.S3Client, audience.CSVPath)
+	if err != nil {
+		return 0, err
+	}
+	userIds, err := ReadUserIDsFromCSV(streamToByte(*csvFile))
+	if err != nil {
+		return 0, err
+	}
+	return CountUsersFromUserIDs(b.PushDB.DB, audience.App.Name, audience.Service, userIds)
+}
+
+// Process processes the messages sent to worker queue
+func (b *AudienceSizeWorker) Process(message *workers.Msg) {
+	arr, err := message.Args().Array()
+	checkErr(b.Logger, err)
+	id, err := uuid.FromString(arr[0].(string))
+	checkErr(b.Logger, err)
+	l := b.Logger.With(
+		zap.String("audienceID", id.String()),
+	)
+	log.I(l, "starting audience_size_worker")
+
+	audience := &model.Audience{}
+	err = b.MarathonDB.DB.Model(audience).Column("audience.*", "App").Where("audience.id = ?", id).Select()
+	if err == pg.ErrNoRows {
+		log.I(l, "audience was deleted, nothing to count")
+		return
+	}
+	checkErr(l, err)
+
+	size, err := b.countUsers(audience)
+	checkErr(l, err)
+
+	// an audience updated while it was counted has another message enqueued, so its size is not overwritten
+	res, err := b.MarathonDB.DB.Model(&model.Audience{}).
+		Set("size = ?, size_updated_at = ?", size, time.Now().UnixNano()).
+		Where("id = ?", audience.ID).
+		Where("updated_at = ?", audience.UpdatedAt).
+		Update()
+	checkErr(l, err)
+	if res.RowsAffected() == 0 {
+		log.I(l, "audience changed while it was counted, size not updated")
+		return
+	}
+	log.I(l, "finished audience_size_worker", func(cm log.CM) {
+		cm.Write(zap.Int("size", size))
+	})
+}
@@ -0,0 +1,115 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permifsion is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package worker_test
+
+import (
+	"encoding/json"
+	"time"
+
+	workers "github.com/jrallison/go-workers"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/topfreegames/marathon/extensions"
+	"github.com/topfreegames/marathon/model"
+	. "github.com/topfreegames/marathon/testing"
+	"github.com/topfreegames/marathon/worker"
+	"github.com/uber-go/zap"
+)
+
+var _ = Describe("AudienceSize Worker", func() {
+	var app *model.App
+	logger := zap.New(
+		zap.NewJSONEncoder(zap.NoTime()), // drop timestamps in tests
+		zap.FatalLevel,
+	)
+	config := GetConf()
+	audienceSizeWorker := worker.NewAudienceSizeWorker(config, logger)
+
+	newMessage := func(audience *model.Audience) *workers.Msg {
+		msgB, err := json.Marshal(map[string][]interface{}{
+			"args": []interface{}{audience.ID.String()},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		message, err := workers.NewMsg(string(msgB))
+		Expect(err).NotTo(HaveOccurred())
+		return message
+	}
+
+	BeforeEach(func() {
+		audienceSizeWorker.S3Client = NewFakeS3()
+		csv := []byte(`userids
+9e558649-9c23-469d-a11c-59b05813e3d5
+57be9009-e616-42c6-9cfe-505508ede2d0
+a8e8d2d5-f178-4d90-9b31-683ad3aae920
+00000000-0000-0000-0000-000000000000`)
+		extensions.S3PutObject(audienceSizeWorker.Config, audienceSizeWorker.S3Client, "test/audiences/obj1.csv", &csv)
+		app = CreateTestApp(audienceSizeWorker.MarathonDB.DB)
+	})
+
+	Describe("Process", func() {
+		It("should cache the number of users matching the audience filters", func() {
+			audience := CreateTestAudience(audienceSizeWorker.MarathonDB.DB, app.ID, map[string]interface{}{
+				"filters": map[string]interface{}{"locale": "pt"},
+			})
+			var expected int
+			_, err := audienceSizeWorker.PushDB.DB.Query(&expected, "SELECT count(1) FROM testapp_apns WHERE locale = 'pt';")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(expected).To(BeNumerically(">", 0))
+
+			before := time.Now().UnixNano()
+			audienceSizeWorker.Process(newMessage(audience))
+
+			dbAudience := &model.Audience{ID: audience.ID}
+			err = audienceSizeWorker.MarathonDB.DB.Select(&dbAudience)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(dbAudience.Size).To(Equal(expected))
+			Expect(dbAudience.SizeUpdatedAt).To(BeNumerically(">=", before))
+		})
+
+		It("should cache the number of push db users in the audience csv", func() {
+			audience := CreateTestAudience(audienceSizeWorker.MarathonDB.DB, app.ID, map[string]interface{}{
+				"csvPath": "tfg-push-notifications/test/audiences/obj1.csv",
+			})
+			audienceSizeWorker.Process(newMessage(audience))
+
+			dbAudience := &model.Audience{ID: audience.ID}
+			err := audienceSizeWorker.MarathonDB.DB.Select(&dbAudience)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(dbAudience.Size).To(Equal(3))
+		})
+
+		It("should not panic if the audience was deleted", func() {
+			audience := CreateTestAudience(audienceSizeWorker.MarathonDB.DB, app.ID)
+			_, err := audienceSizeWorker.MarathonDB.DB.Model(&model.Audience{}).Where("id = ?", audience.ID).Delete()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(func() { audienceSizeWorker.Process(newMessage(audience)) }).ShouldNot(Panic())
+		})
+
+		It("should panic if the csvPath is invalid", func() {
+			audience := CreateTestAudience(audienceSizeWorker.MarathonDB.DB, app.ID, map[string]interface{}{
+				"csvPath": "algum",
+			})
+			Expect(func() { audienceSizeWorker.Process(newMessage(audience)) }).Should(Panic())
+		})
+	})
+})
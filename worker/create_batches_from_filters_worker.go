@@ -28,6 +28,7 @@ import (
 	"io"
 	"math"
 	"sync"
+	"time"
 
 	"gopkg.in/redis.v5"
 
@@ -51,6 +52,7 @@ type CreateBatchesFromFiltersWorker struct {
 	DBPageSize                int
 	S3Client                  s3iface.S3API
 	PageProcessingConcurrency int
+	SuppressionCacheTTL       time.Duration
 	RedisClient               *redis.Client
 }
 
@@ -81,11 +83,13 @@ func (b *CreateBatchesFromFiltersWorker) configureMarathonDatabase() {
 func (b *CreateBatchesFromFiltersWorker) loadConfigurationDefaults() {
 	b.Config.SetDefault("workers.createBatchesFromFilters.dbPageSize", 1000)
 	b.Config.SetDefault("workers.createBatchesFromFilters.pageProcessingConcurrency", 1)
+	b.Config.SetDefault("workers.suppressions.cacheTTL", "10m")
 }
 
 func (b *CreateBatchesFromFiltersWorker) loadConfiguration() {
 	b.DBPageSize = b.Config.GetInt("workers.createBatchesFromFilters.dbPageSize")
 	b.PageProcessingConcurrency = b.Config.GetInt("workers.createBatchesFromFilters.pageProcessingConcurrency")
+	b.SuppressionCacheTTL = b.Config.GetDuration("workers.suppressions.cacheTTL")
 }
 
 func (b *CreateBatchesFromFiltersWorker) configureDatabases() {
@@ -115,7 +119,8 @@ func (b *CreateBatchesFromFiltersWorker) configure() {
 
 func (b *CreateBatchesFromFiltersWorker) getPageFromDBWithFilters(job *model.Job, page DBPage) *[]User {
 	filters := job.Filters
-	whereClause := GetWhereClauseFromFilters(filters)
+	whereClause, params, err := GetWhereClauseFromFilters(filters)
+	checkErr(b.Logger, err)
 	limit := b.DBPageSize
 	var query string
 	if (whereClause) != "" {
@@ -124,22 +129,17 @@ func (b *CreateBatchesFromFiltersWorker) getPageFromDBWithFilters(job *model.Job
 		query = fmt.Sprintf("SELECT user_id FROM %s WHERE seq_id > %d ORDER BY seq_id ASC LIMIT %d;", GetPushDBTableName(job.App.Name, job.Service), page.Offset, limit)
 	}
 	var users []User
-	_, err := b.PushDB.DB.Query(&users, query)
+	_, err = b.PushDB.DB.Query(&users, query, params...)
 	checkErr(b.Logger, err)
 	return &users
 }
 
 func (b *CreateBatchesFromFiltersWorker) preprocessPages(job *model.Job) ([]DBPage, int, int) {
 	filters := job.Filters
-	var count int
-	whereClause := GetWhereClauseFromFilters(filters)
+	whereClause, params, err := GetWhereClauseFromFilters(filters)
+	checkErr(b.Logger, err)
 	var query string
-	if (whereClause) != "" {
-		query = fmt.Sprintf("SELECT count(1) FROM %s WHERE %s;", GetPushDBTableName(job.App.Name, job.Service), whereClause)
-	} else {
-		query = fmt.Sprintf("SELECT count(1) FROM %s;", GetPushDBTableName(job.App.Name, job.Service))
-	}
-	_, err := b.PushDB.DB.Query(&count, query)
+	count, err := CountUsersFromFilters(b.PushDB.DB, job.App.Name, job.Service, filters)
 	if count == 0 {
 		checkErr(b.Logger, fmt.Errorf("no users matching the filters"))
 	}
@@ -158,7 +158,7 @@ func (b *CreateBatchesFromFiltersWorker) preprocessPages(job *model.Job) ([]DBPa
 			query = fmt.Sprintf("SELECT max(q.seq_id) FROM (SELECT seq_id FROM %s WHERE seq_id > %d ORDER BY seq_id ASC LIMIT %d) AS q;", GetPushDBTableName(job.App.Name, job.Service), nextPageOffset, b.DBPageSize)
 		}
 		b.Logger.Info("Querying database", zap.String("query", query))
-		_, err := b.PushDB.DB.Query(&nextPageOffset, query)
+		_, err := b.PushDB.DB.Query(&nextPageOffset, query, params...)
 		checkErr(b.Logger, err)
 	}
 	return pages, pageCount, count
@@ -174,10 +174,15 @@ func (b *CreateBatchesFromFiltersWorker) processPages(c <-chan DBPage, writeToCS
 	}
 }
 
-func (b *CreateBatchesFromFiltersWorker) writeUserPageIntoCSV(c <-chan *[]User, bFilter *bloom.BloomFilter, csvWriter *io.Writer, wgCSV *sync.WaitGroup) {
+func (b *CreateBatchesFromFiltersWorker) writeUserPageIntoCSV(c <-chan *[]User, job *model.Job, bFilter *bloom.BloomFilter, suppressions *SuppressionList, suppressedUsers map[string]bool, csvWriter *io.Writer, wgCSV *sync.WaitGroup) {
 	(*csvWriter).Write([]byte("userIds\n"))
-	for users := range c {
-		for _, user := range *users {
+	for page := range c {
+		users, suppressedUserIds, err := suppressions.Filter(*page)
+		checkErr(b.Logger, err)
+		for _, userID := range suppressedUserIds {
+			suppressedUsers[userID] = true
+		}
+		for _, user := range users {
 			if IsUserIDValid(user.UserID) && !bFilter.TestString(user.UserID) {
 				(*csvWriter).Write([]byte(fmt.Sprintf("%s\n", user.UserID)))
 				bFilter.AddString(user.UserID)
@@ -188,6 +193,10 @@ func (b *CreateBatchesFromFiltersWorker) writeUserPageIntoCSV(c <-chan *[]User,
 }
 
 func (b *CreateBatchesFromFiltersWorker) createBatchesFromFilters(job *model.Job, csvWriter *io.Writer) error {
+	suppressions, err := LoadSuppressionList(b.MarathonDB.DB, b.RedisClient, job.AppID, b.SuppressionCacheTTL)
+	if err != nil {
+		return err
+	}
 	pages, pageCount, usersCount := b.preprocessPages(job)
 	var wg sync.WaitGroup
 	var wgCSV sync.WaitGroup
@@ -200,7 +209,9 @@ func (b *CreateBatchesFromFiltersWorker) createBatchesFromFilters(job *model.Job
 	}
 	rate := 1E-8
 	bFilter := bloom.NewWithEstimates(uint(usersCount), rate)
-	go b.writeUserPageIntoCSV(csvWriterCH, bFilter, csvWriter, &wgCSV)
+	// a user can be in several pages, so the suppressed users are counted by id once all pages are written
+	suppressedUsers := map[string]bool{}
+	go b.writeUserPageIntoCSV(csvWriterCH, job, bFilter, suppressions, suppressedUsers, csvWriter, &wgCSV)
 	for i := 0; i < pageCount; i++ {
 		pageCH <- DBPage{
 			Page:   pages[i].Page,
@@ -211,7 +222,7 @@ func (b *CreateBatchesFromFiltersWorker) createBatchesFromFilters(job *model.Job
 	wgCSV.Wait()
 	close(pageCH)
 	close(csvWriterCH)
-	return nil
+	return SetSuppressedUsers(b.MarathonDB.DB, job, len(suppressedUsers))
 }
 
 func (b *CreateBatchesFromFiltersWorker) updateJobCSVPath(job *model.Job, csvPath string) {
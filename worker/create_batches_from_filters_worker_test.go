@@ -390,6 +390,44 @@ var _ = Describe("CreateBatchesFromFilters Worker", func() {
 			Expect(lines).To(ContainElement("3f8732a1-8642-4f22-8d77-a9688dd6a5ae"))
 		})
 
+		It("should generate a csv with the right number of users if using a filter tree", func() {
+			a := CreateTestApp(createBatchesFromFiltersWorker.MarathonDB.DB, map[string]interface{}{"name": "testapp"})
+			j := CreateTestJob(createBatchesFromFiltersWorker.MarathonDB.DB, a.ID, template.Name, map[string]interface{}{
+				"filters": map[string]interface{}{
+					"and": []interface{}{
+						map[string]interface{}{"column": "locale", "op": "in", "value": []interface{}{"pt"}},
+						map[string]interface{}{"not": map[string]interface{}{"column": "tz", "op": "!=", "value": "-0300"}},
+						map[string]interface{}{"column": "created_at", "op": "<", "value": map[string]interface{}{"relative": "+1d"}},
+					},
+				},
+			})
+			fakeS3 := NewFakeS3()
+			createBatchesFromFiltersWorker.S3Client = fakeS3
+			m := map[string]interface{}{
+				"jid":  6,
+				"args": []string{j.ID.String()},
+			}
+			smsg, err := json.Marshal(m)
+			Expect(err).NotTo(HaveOccurred())
+			msg, err := workers.NewMsg(string(smsg))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(func() { createBatchesFromFiltersWorker.Process(msg) }).ShouldNot(Panic())
+			bucket := createBatchesFromFiltersWorker.Config.GetString("s3.bucket")
+			key := fmt.Sprintf("%s/job-%s.csv", createBatchesFromFiltersWorker.Config.GetString("s3.folder"), j.ID)
+			generatedCSV, err := fakeS3.GetObject(&s3.GetObjectInput{
+				Bucket: &bucket,
+				Key:    &key,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			lines := ReadLinesFromIOReader(generatedCSV.Body)
+			Expect(len(lines)).To(Equal(5))
+			Expect(lines).To(ContainElement("userIds"))
+			Expect(lines).To(ContainElement("9e558649-9c23-469d-a11c-59b05813e3d5"))
+			Expect(lines).To(ContainElement("a8e8d2d5-f178-4d90-9b31-683ad3aae920"))
+			Expect(lines).To(ContainElement("4223171e-c665-4612-9edd-485f229240bf"))
+			Expect(lines).To(ContainElement("3f8732a1-8642-4f22-8d77-a9688dd6a5ae"))
+		})
+
 		It("should generate a csv with the right number of users if using 2 filters", func() {
 			a := CreateTestApp(createBatchesFromFiltersWorker.MarathonDB.DB, map[string]interface{}{"name": "testapp"})
 			j := CreateTestJob(createBatchesFromFiltersWorker.MarathonDB.DB, a.ID, template.Name, map[string]interface{}{
@@ -23,7 +23,6 @@ package worker
 
 import (
 	"bytes"
-	"encoding/csv"
 	"fmt"
 	"io"
 	"math"
@@ -54,6 +53,8 @@ type CreateBatchesWorker struct {
 	DBPageSize                int
 	S3Client                  s3iface.S3API
 	PageProcessingConcurrency int
+	SuppressionCacheTTL       time.Duration
+	TokensTTL                 time.Duration
 	RedisClient               *redis.Client
 }
 
@@ -93,12 +94,16 @@ func (b *CreateBatchesWorker) loadConfigurationDefaults() {
 	b.Config.SetDefault("workers.createBatches.batchSize", 1000)
 	b.Config.SetDefault("workers.createBatches.dbPageSize", 1000)
 	b.Config.SetDefault("workers.createBatches.pageProcessingConcurrency", 1)
+	b.Config.SetDefault("workers.createBatches.tokensTTL", "168h")
+	b.Config.SetDefault("workers.suppressions.cacheTTL", "10m")
 }
 
 func (b *CreateBatchesWorker) loadConfiguration() {
 	b.BatchSize = b.Config.GetInt("workers.createBatches.batchSize")
 	b.DBPageSize = b.Config.GetInt("workers.createBatches.dbPageSize")
 	b.PageProcessingConcurrency = b.Config.GetInt("workers.createBatches.pageProcessingConcurrency")
+	b.TokensTTL = b.Config.GetDuration("workers.createBatches.tokensTTL")
+	b.SuppressionCacheTTL = b.Config.GetDuration("workers.suppressions.cacheTTL")
 }
 
 func (b *CreateBatchesWorker) configurePushDatabase() {
@@ -124,26 +129,14 @@ func streamToByte(stream io.Reader) []byte {
 	return buf.Bytes()
 }
 
-func (b *CreateBatchesWorker) ReadCSVFromS3(csvPath string) *[]string {
+// ReadCSVFromS3 reads the user ids of the csv and the template variables of each user id
+func (b *CreateBatchesWorker) ReadCSVFromS3(csvPath string) (*[]string, map[string]map[string]interface{}) {
 	csvFile, err := extensions.S3GetObject(b.S3Client, csvPath)
 	checkErr(b.Logger, err)
 	bs := streamToByte(*csvFile)
-	for i, b := range bs {
-		if b == 0x0D {
-			bs[i] = 0x0A
-		}
-	}
-	r := csv.NewReader(bytes.NewReader(bs))
-	lines, err := r.ReadAll()
+	res, userVars, err := ReadUsersFromCSV(bs)
 	checkErr(b.Logger, err)
-	res := []string{}
-	for i, line := range lines {
-		if i == 0 {
-			continue
-		}
-		res = append(res, line[0])
-	}
-	return &res
+	return &res, userVars
 }
 
 func (b *CreateBatchesWorker) updateTotalBatches(totalBatches int, job *model.Job) {
@@ -155,9 +148,11 @@ func (b *CreateBatchesWorker) updateTotalBatches(totalBatches int, job *model.Jo
 
 func (b *CreateBatchesWorker) updateTotalUsers(totalUsers int, job *model.Job) {
 	job.TotalUsers = totalUsers
+	updated := model.Job{}
 	// coalesce is necessary since total_users can be null
-	_, err := b.MarathonDB.DB.Model(job).Set("total_users = coalesce(total_users, 0) + ?", totalUsers).Where("id = ?", job.ID).Update()
+	_, err := b.MarathonDB.DB.Model(&updated).Set("total_users = coalesce(total_users, 0) + ?", totalUsers).Where("id = ?", job.ID).Returning("*").Update()
 	checkErr(b.Logger, err)
+	PublishJobEvent(b.RedisClient, b.Logger, model.NewJobEvent(model.JobProgressEvent, &updated))
 }
 
 func (b *CreateBatchesWorker) computeTotalUsersAndBatchesSent(c <-chan *SentBatches, job *model.Job, wg *sync.WaitGroup) {
@@ -170,16 +165,28 @@ func (b *CreateBatchesWorker) computeTotalUsersAndBatchesSent(c <-chan *SentBatc
 
 func (b *CreateBatchesWorker) getCSVUserBatchFromPG(userIds *[]string, appName, service string) *[]User {
 	var users []User
+	if len(*userIds) == 0 {
+		return &users
+	}
 	_, err := b.PushDB.DB.Query(&users, fmt.Sprintf("SELECT user_id, token, locale, tz FROM %s WHERE user_id IN (?)", GetPushDBTableName(appName, service)), pg.In(*userIds))
 	checkErr(b.Logger, err)
 	return &users
 }
 
-func (b *CreateBatchesWorker) processBatch(c <-chan *Batch, batchesSentCH chan<- *SentBatches, job *model.Job, wg *sync.WaitGroup, wgBatchesSent *sync.WaitGroup) {
+func (b *CreateBatchesWorker) processBatch(c <-chan *Batch, batchesSentCH chan<- *SentBatches, job *model.Job, suppressions *SuppressionList, wg *sync.WaitGroup, wgBatchesSent *sync.WaitGroup) {
 	l := b.Logger
 	for batch := range c {
-		usersFromBatch := b.getCSVUserBatchFromPG((*batch).UserIds, job.App.Name, job.Service)
+		userIds := SampleUserIDs(job, *(*batch).UserIds)
+		usersFromBatch := b.getCSVUserBatchFromPG(&userIds, job.App.Name, job.Service)
+		users, suppressedUsers, err := suppressions.Filter(*usersFromBatch)
+		checkErr(l, err)
+		users, duplicateTokens, err := DedupeTokens(b.RedisClient, job.ID.String(), (*batch).PageID, users, b.TokensTTL)
+		checkErr(l, err)
+		usersFromBatch = &users
 		numUsersFromBatch := len(*usersFromBatch)
+		for i, user := range *usersFromBatch {
+			(*usersFromBatch)[i].Vars = (*batch).UserVars[user.UserID]
+		}
 		log.I(l, "got users from db", func(cm log.CM) {
 			cm.Write(zap.Int("usersInBatch", numUsersFromBatch))
 		})
@@ -189,7 +196,13 @@ func (b *CreateBatchesWorker) processBatch(c <-chan *Batch, batchesSentCH chan<-
 				cm.Write(zap.Int("numUsers", len(*users)), zap.String("tz", tz))
 			})
 		}
-		markProcessedPage((*batch).PageID, job.ID, b.RedisClient)
+		// the users of the page are counted only the first time it is marked as processed
+		if markProcessedPage((*batch).PageID, job.ID, b.RedisClient) {
+			err = UpdateSuppressedUsers(b.MarathonDB.DB, job, len(suppressedUsers))
+			checkErr(l, err)
+			err = UpdateDuplicateTokens(b.MarathonDB.DB, job, duplicateTokens)
+			checkErr(l, err)
+		}
 		if job.Localized {
 			b.sendLocalizedBatches(bucketsByTZ, job)
 		} else {
@@ -224,7 +237,7 @@ func (b *CreateBatchesWorker) sendLocalizedBatches(batches map[string]*[]User, j
 				localizedTime = localizedTime.Add(time.Duration(24) * time.Hour)
 			}
 		}
-		_, err = b.Workers.ScheduleProcessBatchJob(job.ID.String(), job.App.Name, users, localizedTime.UnixNano())
+		_, err = b.Workers.ScheduleProcessBatchJob(job.ID.String(), job.App.Name, users, job.Priority, localizedTime.UnixNano())
 		checkErr(l, err)
 	}
 }
@@ -235,14 +248,18 @@ func (b *CreateBatchesWorker) sendBatches(batches map[string]*[]User, job *model
 		log.I(l, "sending batch of users to process batches worker", func(cm log.CM) {
 			cm.Write(zap.Int("numUsers", len(*users)), zap.String("tz", tz))
 		})
-		_, err := b.Workers.CreateProcessBatchJob(job.ID.String(), job.App.Name, users)
+		_, err := b.Workers.CreateProcessBatchJob(job.ID.String(), job.App.Name, users, job.Priority)
 		checkErr(l, err)
 	}
 }
 
 func (b *CreateBatchesWorker) createBatchesUsingCSV(job *model.Job, isReexecution bool, dbPageSize int) error {
 	l := b.Logger
-	userIds := b.ReadCSVFromS3(job.CSVPath)
+	suppressions, err := LoadSuppressionList(b.MarathonDB.DB, b.RedisClient, job.AppID, b.SuppressionCacheTTL)
+	if err != nil {
+		return err
+	}
+	userIds, userVars := b.ReadCSVFromS3(job.CSVPath)
 	numPushes := len(*userIds)
 	log.D(l, "finished reading csv from s3", func(cm log.CM) {
 		cm.Write(zap.Int("numPushes", numPushes),
@@ -260,7 +277,7 @@ func (b *CreateBatchesWorker) createBatchesUsingCSV(job *model.Job, isReexecutio
 	batchesSentCH := make(chan *SentBatches)
 	wg.Add(pages)
 	for i := 0; i < b.PageProcessingConcurrency; i++ {
-		go b.processBatch(pgCH, batchesSentCH, job, &wg, &wgBatchesSent)
+		go b.processBatch(pgCH, batchesSentCH, job, suppressions, &wg, &wgBatchesSent)
 	}
 	go b.computeTotalUsersAndBatchesSent(batchesSentCH, job, &wgBatchesSent)
 	for i := 0; i < pages; i++ {
@@ -273,8 +290,9 @@ func (b *CreateBatchesWorker) createBatchesUsingCSV(job *model.Job, isReexecutio
 		}
 		userBatch := b.getPage(i, dbPageSize, userIds)
 		pgCH <- &Batch{
-			UserIds: &userBatch,
-			PageID:  i,
+			UserIds:  &userBatch,
+			UserVars: userVars,
+			PageID:   i,
 		}
 	}
 	wg.Wait()
@@ -69,7 +69,11 @@ ee4455fe-8ff6-4878-8d7c-aec096bd68b4`)
 		extensions.S3PutObject(createBatchesWorker.Config, createBatchesWorker.S3Client, "test/jobs/obj1.csv", &fakeData1)
 		extensions.S3PutObject(createBatchesWorker.Config, createBatchesWorker.S3Client, "test/jobs/obj2.csv", &fakeData2)
 		extensions.S3PutObject(createBatchesWorker.Config, createBatchesWorker.S3Client, "test/jobs/obj3.csv", &fakeData3)
+		fakeData5 := []byte(`userids,first_name,reward
+9e558649-9c23-469d-a11c-59b05813e3d5,Camila,100
+57be9009-e616-42c6-9cfe-505508ede2d0,,50`)
 		extensions.S3PutObject(createBatchesWorker.Config, createBatchesWorker.S3Client, "test/jobs/obj4.csv", &fakeData4)
+		extensions.S3PutObject(createBatchesWorker.Config, createBatchesWorker.S3Client, "test/jobs/obj5.csv", &fakeData5)
 		app = CreateTestApp(createBatchesWorker.MarathonDB.DB)
 		defaults := map[string]interface{}{
 			"user_name":   "Someone",
@@ -190,6 +194,36 @@ ee4455fe-8ff6-4878-8d7c-aec096bd68b4`)
 			Expect(res).To(BeEquivalentTo(0))
 		})
 
+		It("should send batches to the process_batch_worker queue of the job priority", func() {
+			a := CreateTestApp(createBatchesWorker.MarathonDB.DB, map[string]interface{}{"name": "testapp"})
+			j := CreateTestJob(createBatchesWorker.MarathonDB.DB, a.ID, template.Name, map[string]interface{}{
+				"context":  context,
+				"filters":  map[string]interface{}{},
+				"csvPath":  "tfg-push-notifications/test/jobs/obj1.csv",
+				"priority": "high",
+			})
+			m := map[string]interface{}{
+				"jid":  2,
+				"args": []string{j.ID.String()},
+			}
+			smsg, err := json.Marshal(m)
+			Expect(err).NotTo(HaveOccurred())
+			msg, err := workers.NewMsg(string(smsg))
+			Expect(func() { createBatchesWorker.Process(msg) }).ShouldNot(Panic())
+			res, err := createBatchesWorker.RedisClient.LLen("queue:process_batch_worker").Result()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(res).To(BeEquivalentTo(0))
+			res, err = createBatchesWorker.RedisClient.LLen("queue:process_batch_worker_high").Result()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(res).To(BeEquivalentTo(2))
+			job1, err := createBatchesWorker.RedisClient.LPop("queue:process_batch_worker_high").Result()
+			Expect(err).NotTo(HaveOccurred())
+			j1 := map[string]interface{}{}
+			err = json.Unmarshal([]byte(job1), &j1)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(j1["queue"].(string)).To(Equal("process_batch_worker_high"))
+		})
+
 		It("should create batches with the right tokens and tz and send to process_batches_worker", func() {
 			a := CreateTestApp(createBatchesWorker.MarathonDB.DB, map[string]interface{}{"name": "testapp"})
 			j := CreateTestJob(createBatchesWorker.MarathonDB.DB, a.ID, template.Name, map[string]interface{}{
@@ -223,6 +257,88 @@ ee4455fe-8ff6-4878-8d7c-aec096bd68b4`)
 			Expect(len((j1["args"].([]interface{}))[2].([]interface{})) + len((j2["args"].([]interface{}))[2].([]interface{}))).To(BeEquivalentTo(10))
 		})
 
+		It("should drop the users of the app suppression list and record them in the job", func() {
+			a := CreateTestApp(createBatchesWorker.MarathonDB.DB, map[string]interface{}{"name": "testapp"})
+			suppressedUserIds := []string{"9e558649-9c23-469d-a11c-59b05813e3d5", "57be9009-e616-42c6-9cfe-505508ede2d0"}
+			for _, userID := range suppressedUserIds {
+				CreateTestSuppressedUser(createBatchesWorker.MarathonDB.DB, a.ID, userID)
+			}
+			j := CreateTestJob(createBatchesWorker.MarathonDB.DB, a.ID, template.Name, map[string]interface{}{
+				"context": context,
+				"filters": map[string]interface{}{},
+				"csvPath": "tfg-push-notifications/test/jobs/obj1.csv",
+			})
+			m := map[string]interface{}{
+				"jid":  2,
+				"args": []string{j.ID.String()},
+			}
+			smsg, err := json.Marshal(m)
+			Expect(err).NotTo(HaveOccurred())
+			msg, err := workers.NewMsg(string(smsg))
+			Expect(func() { createBatchesWorker.Process(msg) }).ShouldNot(Panic())
+			sentUsers := 0
+			for {
+				batch, err := createBatchesWorker.RedisClient.LPop("queue:process_batch_worker").Result()
+				if err != nil {
+					break
+				}
+				b := map[string]interface{}{}
+				err = json.Unmarshal([]byte(batch), &b)
+				Expect(err).NotTo(HaveOccurred())
+				for _, user := range b["args"].([]interface{})[2].([]interface{}) {
+					Expect(suppressedUserIds).NotTo(ContainElement(user.(map[string]interface{})["user_id"]))
+					sentUsers++
+				}
+			}
+			Expect(sentUsers).To(Equal(8))
+			dbJob := &model.Job{ID: j.ID}
+			err = createBatchesWorker.MarathonDB.DB.Select(&dbJob)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(dbJob.SuppressedUsers).To(Equal(2))
+
+			Expect(func() { createBatchesWorker.Process(msg) }).ShouldNot(Panic())
+			err = createBatchesWorker.MarathonDB.DB.Select(&dbJob)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(dbJob.SuppressedUsers).To(Equal(2))
+		})
+
+		It("should send the extra csv columns as the vars of each user to process_batches_worker", func() {
+			a := CreateTestApp(createBatchesWorker.MarathonDB.DB, map[string]interface{}{"name": "testapp"})
+			j := CreateTestJob(createBatchesWorker.MarathonDB.DB, a.ID, template.Name, map[string]interface{}{
+				"context": context,
+				"filters": map[string]interface{}{},
+				"csvPath": "tfg-push-notifications/test/jobs/obj5.csv",
+			})
+			m := map[string]interface{}{
+				"jid":  2,
+				"args": []string{j.ID.String()},
+			}
+			smsg, err := json.Marshal(m)
+			Expect(err).NotTo(HaveOccurred())
+			msg, err := workers.NewMsg(string(smsg))
+			Expect(func() { createBatchesWorker.Process(msg) }).ShouldNot(Panic())
+			jobs, err := createBatchesWorker.RedisClient.LRange("queue:process_batch_worker", 0, -1).Result()
+			Expect(err).NotTo(HaveOccurred())
+			varsByUser := map[string]interface{}{}
+			for _, job := range jobs {
+				parsed := map[string]interface{}{}
+				err = json.Unmarshal([]byte(job), &parsed)
+				Expect(err).NotTo(HaveOccurred())
+				for _, user := range (parsed["args"].([]interface{}))[2].([]interface{}) {
+					u := user.(map[string]interface{})
+					varsByUser[u["user_id"].(string)] = u["vars"]
+				}
+			}
+			Expect(varsByUser).To(HaveLen(2))
+			Expect(varsByUser["9e558649-9c23-469d-a11c-59b05813e3d5"]).To(Equal(map[string]interface{}{
+				"first_name": "Camila",
+				"reward":     "100",
+			}))
+			Expect(varsByUser["57be9009-e616-42c6-9cfe-505508ede2d0"]).To(Equal(map[string]interface{}{
+				"reward": "50",
+			}))
+		})
+
 		It("should create batches with the right tokens and tz and send to process_batches_worker if numPushes < dbPageSize", func() {
 			createBatchesWorker.DBPageSize = 500
 			a := CreateTestApp(createBatchesWorker.MarathonDB.DB, map[string]interface{}{"name": "testapp"})
@@ -572,18 +688,185 @@ ee4455fe-8ff6-4878-8d7c-aec096bd68b4`)
 		job := &model.Job{}
 		err = createBatchesWorker.MarathonDB.DB.Model(job).Where("id = ?", j.ID).Select()
 		Expect(err).NotTo(HaveOccurred())
-		Expect(job.TotalUsers).To(BeEquivalentTo(8))
+		Expect(job.TotalUsers).To(BeEquivalentTo(6))
+	})
+
+	It("should drop the users whose token was already sent to a batch of the job", func() {
+		a := CreateTestApp(createBatchesWorker.MarathonDB.DB, map[string]interface{}{"name": "testapp"})
+		j := CreateTestJob(createBatchesWorker.MarathonDB.DB, a.ID, template.Name, map[string]interface{}{
+			"context": context,
+			"filters": map[string]interface{}{},
+			"csvPath": "tfg-push-notifications/test/jobs/obj3.csv",
+		})
+		m := map[string]interface{}{
+			"jid":  3,
+			"args": []string{j.ID.String()},
+		}
+		smsg, err := json.Marshal(m)
+		Expect(err).NotTo(HaveOccurred())
+		msg, err := workers.NewMsg(string(smsg))
+		Expect(func() { createBatchesWorker.Process(msg) }).ShouldNot(Panic())
+		tokens := []string{}
+		for {
+			batch, err := createBatchesWorker.RedisClient.LPop("queue:process_batch_worker").Result()
+			if err != nil {
+				break
+			}
+			b := map[string]interface{}{}
+			err = json.Unmarshal([]byte(batch), &b)
+			Expect(err).NotTo(HaveOccurred())
+			for _, user := range b["args"].([]interface{})[2].([]interface{}) {
+				tokens = append(tokens, user.(map[string]interface{})["token"].(string))
+			}
+		}
+		Expect(tokens).To(ConsistOf("1247", "1248"))
+		job := &model.Job{}
+		err = createBatchesWorker.MarathonDB.DB.Model(job).Where("id = ?", j.ID).Select()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(job.TotalUsers).To(BeEquivalentTo(2))
+		Expect(job.DuplicateTokens).To(BeEquivalentTo(2))
+
+		Expect(func() { createBatchesWorker.Process(msg) }).ShouldNot(Panic())
+		err = createBatchesWorker.MarathonDB.DB.Model(job).Where("id = ?", j.ID).Select()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(job.DuplicateTokens).To(BeEquivalentTo(2))
+	})
+
+	It("should send each user to only one of the jobs that split the sample", func() {
+		a := CreateTestApp(createBatchesWorker.MarathonDB.DB, map[string]interface{}{"name": "testapp"})
+		sentUserIds := []string{}
+		for _, sample := range [][]float64{{0, 0.5}, {0.5, 1}} {
+			j := CreateTestJob(createBatchesWorker.MarathonDB.DB, a.ID, template.Name, map[string]interface{}{
+				"context":    context,
+				"filters":    map[string]interface{}{},
+				"csvPath":    "tfg-push-notifications/test/jobs/obj1.csv",
+				"sampleFrom": sample[0],
+				"sampleRate": sample[1],
+				"sampleSalt": "campaign",
+			})
+			m := map[string]interface{}{
+				"jid":  2,
+				"args": []string{j.ID.String()},
+			}
+			smsg, err := json.Marshal(m)
+			Expect(err).NotTo(HaveOccurred())
+			msg, err := workers.NewMsg(string(smsg))
+			Expect(func() { createBatchesWorker.Process(msg) }).ShouldNot(Panic())
+			for {
+				batch, err := createBatchesWorker.RedisClient.LPop("queue:process_batch_worker").Result()
+				if err != nil {
+					break
+				}
+				b := map[string]interface{}{}
+				err = json.Unmarshal([]byte(batch), &b)
+				Expect(err).NotTo(HaveOccurred())
+				for _, user := range b["args"].([]interface{})[2].([]interface{}) {
+					userID := user.(map[string]interface{})["user_id"].(string)
+					Expect(worker.SamplePosition("campaign", userID)).To(And(BeNumerically(">=", sample[0]), BeNumerically("<", sample[1])))
+					sentUserIds = append(sentUserIds, userID)
+				}
+			}
+		}
+		userIds, _ := createBatchesWorker.ReadCSVFromS3("tfg-push-notifications/test/jobs/obj1.csv")
+		Expect(sentUserIds).To(ConsistOf(*userIds))
+	})
+
+	Describe("Sample users", func() {
+		It("should put each user in the same position for the same salt", func() {
+			userID := uuid.NewV4().String()
+			position := worker.SamplePosition("campaign", userID)
+			Expect(position).To(BeNumerically(">=", 0))
+			Expect(position).To(BeNumerically("<", 1))
+			Expect(worker.SamplePosition("campaign", userID)).To(Equal(position))
+			Expect(worker.SamplePosition("other", userID)).NotTo(Equal(position))
+		})
+
+		It("should keep about the sample rate of the users", func() {
+			userIds := make([]string, 10000)
+			for i := range userIds {
+				userIds[i] = uuid.NewV4().String()
+			}
+			job := &model.Job{SampleRate: 0.1, SampleSalt: "campaign"}
+			Expect(len(worker.SampleUserIDs(job, userIds))).To(BeNumerically("~", 1000, 150))
+			Expect(worker.SampleUserIDs(&model.Job{}, userIds)).To(HaveLen(10000))
+		})
+	})
+
+	Describe("Suppression list", func() {
+		It("should not use the cached bloom filter of a previous version of the list", func() {
+			db := createBatchesWorker.MarathonDB.DB
+			a := CreateTestApp(db)
+			CreateTestSuppressedUser(db, a.ID, "user-a")
+			users := []worker.User{{UserID: "user-a", Token: "1"}, {UserID: "user-b", Token: "2"}}
+
+			suppressions, err := worker.LoadSuppressionList(db, createBatchesWorker.RedisClient, a.ID, time.Minute)
+			Expect(err).NotTo(HaveOccurred())
+			kept, suppressed, err := suppressions.Filter(users)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(kept).To(HaveLen(1))
+			Expect(suppressed).To(ConsistOf("user-a"))
+
+			tx, err := db.Begin()
+			Expect(err).NotTo(HaveOccurred())
+			err = worker.IncrementSuppressionsVersion(tx, a.ID)
+			Expect(err).NotTo(HaveOccurred())
+			err = tx.Insert(&model.SuppressedUser{AppID: a.ID, UserID: "user-b", CreatedBy: "test@test.com", CreatedAt: time.Now().UnixNano()})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tx.Commit()).To(Succeed())
+
+			suppressions, err = worker.LoadSuppressionList(db, createBatchesWorker.RedisClient, a.ID, time.Minute)
+			Expect(err).NotTo(HaveOccurred())
+			kept, suppressed, err = suppressions.Filter(users)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(kept).To(BeEmpty())
+			Expect(suppressed).To(ConsistOf("user-a", "user-b"))
+		})
+	})
+
+	Describe("Dedupe tokens", func() {
+		It("should keep the tokens of a page that is processed again", func() {
+			jobID := uuid.NewV4().String()
+			users := []worker.User{{UserID: "a", Token: "1"}, {UserID: "b", Token: "2"}}
+			kept, duplicates, err := worker.DedupeTokens(createBatchesWorker.RedisClient, jobID, 0, users, time.Minute)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(kept).To(HaveLen(2))
+			Expect(duplicates).To(Equal(0))
+
+			kept, duplicates, err = worker.DedupeTokens(createBatchesWorker.RedisClient, jobID, 0, users, time.Minute)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(kept).To(HaveLen(2))
+			Expect(duplicates).To(Equal(0))
+
+			kept, duplicates, err = worker.DedupeTokens(createBatchesWorker.RedisClient, jobID, 1, []worker.User{{UserID: "c", Token: "2"}, {UserID: "d", Token: "3"}}, time.Minute)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(kept).To(Equal([]worker.User{{UserID: "d", Token: "3"}}))
+			Expect(duplicates).To(Equal(1))
+
+			ttl, err := createBatchesWorker.RedisClient.TTL(worker.JobTokensKey(jobID)).Result()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ttl).To(BeNumerically(">", 0))
+		})
 	})
 
 	Describe("Read CSV from S3", func() {
 		It("should return correct array from Unix csv data", func() {
-			res := createBatchesWorker.ReadCSVFromS3("tfg-push-notifications/test/jobs/obj3.csv")
+			res, _ := createBatchesWorker.ReadCSVFromS3("tfg-push-notifications/test/jobs/obj3.csv")
 			Expect(*res).To(HaveLen(2))
 		})
 
 		It("should return correct array from DOS csv data", func() {
-			res := createBatchesWorker.ReadCSVFromS3("tfg-push-notifications/test/jobs/obj4.csv")
+			res, _ := createBatchesWorker.ReadCSVFromS3("tfg-push-notifications/test/jobs/obj4.csv")
+			Expect(*res).To(HaveLen(2))
+		})
+
+		It("should return the vars of each user from the extra columns", func() {
+			res, userVars := createBatchesWorker.ReadCSVFromS3("tfg-push-notifications/test/jobs/obj5.csv")
 			Expect(*res).To(HaveLen(2))
+			Expect(userVars).To(HaveLen(2))
+			Expect(userVars["9e558649-9c23-469d-a11c-59b05813e3d5"]).To(Equal(map[string]interface{}{
+				"first_name": "Camila",
+				"reward":     "100",
+			}))
 		})
 	})
 })
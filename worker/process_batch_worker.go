@@ -25,9 +25,9 @@ package worker
 import (
 	"encoding/json"
 	"fmt"
-	"strings"
 	"time"
 
+	pg "gopkg.in/pg.v5"
 	redis "gopkg.in/redis.v5"
 
 	workers "github.com/jrallison/go-workers"
@@ -103,6 +103,9 @@ func (batchWorker *ProcessBatchWorker) incrFailedBatches(jobID uuid.UUID, totalB
 		checkErr(batchWorker.Logger, err)
 		changedStatus, err := batchWorker.RedisClient.SetNX(fmt.Sprintf("%s-circuitbreak", jobID.String()), 1, 1*time.Minute).Result()
 		checkErr(batchWorker.Logger, err)
+		if changedStatus {
+			PublishJobEvent(batchWorker.RedisClient, batchWorker.Logger, model.NewJobEvent(model.JobStatusEvent, &job))
+		}
 		if changedStatus && batchWorker.SendgridClient != nil {
 			var expireAt int64
 			if ttl > 0 {
@@ -135,31 +138,63 @@ func (batchWorker *ProcessBatchWorker) sendToKafka(service, topic string, msg, m
 }
 
 func (batchWorker *ProcessBatchWorker) getJob(jobID uuid.UUID) (*model.Job, error) {
-	job := model.Job{
-		ID: jobID,
-	}
-	err := batchWorker.MarathonDB.DB.Select(&job)
+	job := model.Job{}
+	err := batchWorker.MarathonDB.DB.Model(&job).Column("job.*", "App").Where("job.id = ?", jobID).Select()
 	return &job, err
 }
 
-func (batchWorker *ProcessBatchWorker) getJobTemplatesByLocale(appID uuid.UUID, templateName string) (map[string]model.Template, error) {
+// getJobTemplatesByLocale returns the template versions pinned by the job by normalized locale, jobs created before
+// template versioning have no pinned versions and are sent with the current templates
+func (batchWorker *ProcessBatchWorker) getJobTemplatesByLocale(job *model.Job) (map[string]model.Template, error) {
 	templateByLocale := make(map[string]model.Template)
+	if len(job.TemplateVersions) > 0 {
+		ids := []string{}
+		for _, id := range job.TemplateVersions {
+			ids = append(ids, id)
+		}
+		var versions []model.TemplateVersion
+		err := batchWorker.MarathonDB.DB.Model(&versions).Where("app_id = ? AND id IN (?)", job.AppID, pg.In(ids)).Select()
+		if err != nil {
+			return nil, err
+		}
+		for _, version := range versions {
+			templateByLocale[model.NormalizeLocale(version.Locale)] = version.Template()
+		}
+		return templateByLocale, nil
+	}
+
 	var templates []model.Template
-	err := batchWorker.MarathonDB.DB.Model(&templates).Where("app_id = ? AND name = ?", appID, templateName).Select()
+	err := batchWorker.MarathonDB.DB.Model(&templates).Where("app_id = ? AND name = ?", job.AppID, job.TemplateName).Select()
 	if err != nil {
 		return nil, err
 	}
 	for _, tpl := range templates {
-		templateByLocale[tpl.Locale] = tpl
+		templateByLocale[model.NormalizeLocale(tpl.Locale)] = tpl
 	}
 
 	return templateByLocale, nil
 }
 
-func (batchWorker *ProcessBatchWorker) updateJobUsersInfo(jobID uuid.UUID, numUsers int) error {
+// getUserTemplate returns the template of the first locale of the user locale fallback chain that has one and
+// whether it is not the template of the user locale
+func getUserTemplate(templatesByLocale map[string]model.Template, app *model.App, user User) (model.Template, bool, bool) {
+	chain := app.LocaleFallbackChain(user.Locale)
+	for i, locale := range chain {
+		if template, ok := templatesByLocale[locale]; ok {
+			return template, i > 0 || model.NormalizeLocale(user.Locale) != locale, true
+		}
+	}
+	return model.Template{}, false, false
+}
+
+func (batchWorker *ProcessBatchWorker) updateJobUsersInfo(jobID uuid.UUID, numUsers, numFallbackUsers int) error {
 	job := model.Job{}
-	_, err := batchWorker.MarathonDB.DB.Model(&job).Set("completed_users = completed_users + ?", numUsers).Where("id = ?", jobID).Returning("*").Update()
-	return err
+	_, err := batchWorker.MarathonDB.DB.Model(&job).Set("completed_users = completed_users + ?, fallback_users = fallback_users + ?", numUsers, numFallbackUsers).Where("id = ?", jobID).Returning("*").Update()
+	if err != nil {
+		return err
+	}
+	PublishJobEvent(batchWorker.RedisClient, batchWorker.Logger, model.NewJobEvent(model.JobProgressEvent, &job))
+	return nil
 }
 
 func (batchWorker *ProcessBatchWorker) updateJobBatchesInfo(jobID uuid.UUID) error {
@@ -171,6 +206,12 @@ func (batchWorker *ProcessBatchWorker) updateJobBatchesInfo(jobID uuid.UUID) err
 	if job.TotalBatches != 0 && job.CompletedBatches >= job.TotalBatches && job.CompletedAt == 0 {
 		job.CompletedAt = time.Now().UnixNano()
 		_, err = batchWorker.MarathonDB.DB.Model(&job).Column("completed_at").Update()
+		if err != nil {
+			return err
+		}
+		event := model.NewJobEvent(model.JobStatusEvent, &job)
+		event.Status = model.CompletedJobStatus
+		PublishJobEvent(batchWorker.RedisClient, batchWorker.Logger, event)
 	}
 	return err
 }
@@ -188,6 +229,7 @@ func (batchWorker *ProcessBatchWorker) moveJobToPausedQueue(jobID uuid.UUID, mes
 // Process processes the messages sent to batch worker queue and send them to kafka
 func (batchWorker *ProcessBatchWorker) Process(message *workers.Msg) {
 	batchErrorCounter := 0
+	fallbackCounter := 0
 	l := batchWorker.Logger.With(
 		zap.String("source", "processBatchWorker"),
 		zap.String("operation", "process"),
@@ -224,7 +266,7 @@ func (batchWorker *ProcessBatchWorker) Process(message *workers.Msg) {
 		log.D(l, "valid process_batch_worker")
 	}
 
-	templatesByLocale, err := batchWorker.getJobTemplatesByLocale(job.AppID, job.TemplateName)
+	templatesByLocale, err := batchWorker.getJobTemplatesByLocale(job)
 	if err != nil {
 		batchWorker.incrFailedBatches(job.ID, job.TotalBatches, parsed.AppName)
 	}
@@ -238,18 +280,33 @@ func (batchWorker *ProcessBatchWorker) Process(message *workers.Msg) {
 	log.D(l, "Built topic name successfully.", func(cm log.CM) {
 		cm.Write(zap.String("topic", topic))
 	})
-	for _, user := range parsed.Users {
-		var template model.Template
-		if val, ok := templatesByLocale[strings.ToLower(user.Locale)]; ok {
-			template = val
-		} else if val, ok := templatesByLocale["en"]; ok {
-			template = val
-		} else {
+	maxPushesPerSecond := job.MaxPushesPerSecond
+	if maxPushesPerSecond == 0 {
+		maxPushesPerSecond = job.App.MaxPushesPerSecond
+	}
+	availableTokens := 0
+	goTemplates := model.GoTemplateCache{}
+	for i, user := range parsed.Users {
+		if maxPushesPerSecond > 0 && availableTokens == 0 {
+			availableTokens = len(parsed.Users) - i
+			if availableTokens > maxPushesPerSecond {
+				availableTokens = maxPushesPerSecond
+			}
+			err = WaitForPushTokens(batchWorker.RedisClient, job.ID.String(), maxPushesPerSecond, availableTokens)
+			checkErr(l, err)
+		}
+		availableTokens = availableTokens - 1
+
+		template, isFallback, ok := getUserTemplate(templatesByLocale, &job.App, user)
+		if !ok {
 			batchWorker.incrFailedBatches(job.ID, job.TotalBatches, parsed.AppName)
-			checkErr(l, fmt.Errorf("there is no template for the given locale or 'en'"))
+			checkErr(l, fmt.Errorf("there is no template for the locale %s or its fallbacks", user.Locale))
+		}
+		if isFallback {
+			fallbackCounter = fallbackCounter + 1
 		}
 
-		msgStr, msgErr := BuildMessageFromTemplate(template, job.Context)
+		msgStr, msgErr := BuildMessageFromCachedTemplate(template.ServiceTemplate(job.Service), job.Context, user.Vars, user.Locale, goTemplates)
 		if msgErr != nil {
 			batchWorker.incrFailedBatches(job.ID, job.TotalBatches, parsed.AppName)
 		}
@@ -260,15 +317,7 @@ func (batchWorker *ProcessBatchWorker) Process(message *workers.Msg) {
 			batchWorker.incrFailedBatches(job.ID, job.TotalBatches, parsed.AppName)
 		}
 		checkErr(l, err)
-		pushMetadata := map[string]interface{}{
-			"userId":       user.UserID,
-			"templateName": job.TemplateName,
-			"jobId":        job.ID.String(),
-			"pushType":     "massive",
-		}
-		if user.CreatedAt.Unix() > 0 {
-			pushMetadata["tokenCreatedAt"] = user.CreatedAt.Unix()
-		}
+		pushMetadata := BuildPushMetadata(job, user)
 		err = batchWorker.sendToKafka(job.Service, topic, msg, job.Metadata, pushMetadata, user.Token, job.ExpiresAt)
 		if err != nil {
 			batchErrorCounter = batchErrorCounter + 1
@@ -289,7 +338,7 @@ func (batchWorker *ProcessBatchWorker) Process(message *workers.Msg) {
 	err = batchWorker.updateJobBatchesInfo(parsed.JobID)
 	checkErr(l, err)
 	log.D(l, "Updated job batches info successfully.")
-	err = batchWorker.updateJobUsersInfo(parsed.JobID, len(parsed.Users)-batchErrorCounter)
+	err = batchWorker.updateJobUsersInfo(parsed.JobID, len(parsed.Users)-batchErrorCounter, fallbackCounter)
 	checkErr(l, err)
 	log.D(l, "Updated job users info successfully.")
 	if float64(batchErrorCounter)/float64(len(parsed.Users)) > batchWorker.Config.GetFloat64("workers.processBatch.maxUserFailureInBatch") {
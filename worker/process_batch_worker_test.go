@@ -135,6 +135,82 @@ var _ = Describe("ProcessBatch Worker", func() {
 		}
 	})
 
+	Describe("Push rate limit", func() {
+		It("should take tokens while the job bucket has them", func() {
+			jobID := uuid.NewV4().String()
+			wait, err := worker.TakePushTokens(processBatchWorker.RedisClient, jobID, 10, 10)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(wait).To(BeEquivalentTo(0))
+
+			wait, err = worker.TakePushTokens(processBatchWorker.RedisClient, jobID, 10, 5)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(wait).To(BeNumerically(">", 0))
+			Expect(wait).To(BeNumerically("<=", 500*time.Millisecond))
+
+			ttl, err := processBatchWorker.RedisClient.TTL(worker.JobRateLimitKey(jobID)).Result()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ttl).To(BeNumerically(">", 0))
+		})
+
+		It("should not share the bucket between jobs", func() {
+			wait, err := worker.TakePushTokens(processBatchWorker.RedisClient, uuid.NewV4().String(), 10, 10)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(wait).To(BeEquivalentTo(0))
+			wait, err = worker.TakePushTokens(processBatchWorker.RedisClient, uuid.NewV4().String(), 10, 10)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(wait).To(BeEquivalentTo(0))
+		})
+
+		It("should wait for the bucket to be refilled", func() {
+			jobID := uuid.NewV4().String()
+			start := time.Now()
+			err := worker.WaitForPushTokens(processBatchWorker.RedisClient, jobID, 2, 4)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(time.Now().Sub(start)).To(BeNumerically(">=", 900*time.Millisecond))
+		})
+
+		It("should limit the job pushes to the app maxPushesPerSecond", func() {
+			_, err := processBatchWorker.MarathonDB.DB.Model(&model.App{}).Set("max_pushes_per_second = 1").Where("id = ?", app.ID).Update()
+			Expect(err).NotTo(HaveOccurred())
+			appName := strings.Split(app.BundleID, ".")[2]
+			msgB, err := json.Marshal(map[string][]interface{}{
+				"args": []interface{}{job.ID, appName, users},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			message, err := workers.NewMsg(string(msgB))
+			Expect(err).NotTo(HaveOccurred())
+
+			start := time.Now()
+			processBatchWorker.Process(message)
+			Expect(time.Now().Sub(start)).To(BeNumerically(">=", 900*time.Millisecond))
+
+			dbJob := model.Job{
+				ID: job.ID,
+			}
+			err = processBatchWorker.MarathonDB.DB.Select(&dbJob)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(dbJob.CompletedUsers).To(Equal(len(users)))
+		})
+
+		It("should prefer the job maxPushesPerSecond over the app one", func() {
+			_, err := processBatchWorker.MarathonDB.DB.Model(&model.App{}).Set("max_pushes_per_second = 1").Where("id = ?", app.ID).Update()
+			Expect(err).NotTo(HaveOccurred())
+			_, err = processBatchWorker.MarathonDB.DB.Model(&model.Job{}).Set("max_pushes_per_second = 100").Where("id = ?", job.ID).Update()
+			Expect(err).NotTo(HaveOccurred())
+			appName := strings.Split(app.BundleID, ".")[2]
+			msgB, err := json.Marshal(map[string][]interface{}{
+				"args": []interface{}{job.ID, appName, users},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			message, err := workers.NewMsg(string(msgB))
+			Expect(err).NotTo(HaveOccurred())
+
+			start := time.Now()
+			processBatchWorker.Process(message)
+			Expect(time.Now().Sub(start)).To(BeNumerically("<", 900*time.Millisecond))
+		})
+	})
+
 	Describe("Process", func() {
 		It("should process when service is gcm and increment job completed batches", func() {
 			appName := strings.Split(app.BundleID, ".")[2]
@@ -313,6 +389,33 @@ var _ = Describe("ProcessBatch Worker", func() {
 			Expect(dbJob.CompletedUsers).To(Equal(len(users)))
 		})
 
+		It("should publish a progress event to the job events channel", func() {
+			_, err := processBatchWorker.MarathonDB.DB.Model(&model.Job{}).Set("service = gcm").Where("id = ?", job.ID).Update()
+			Expect(err).NotTo(HaveOccurred())
+			appName := strings.Split(app.BundleID, ".")[2]
+			pubsub, err := processBatchWorker.RedisClient.Subscribe(model.JobEventsChannel(job.ID.String()))
+			Expect(err).NotTo(HaveOccurred())
+			defer pubsub.Close()
+
+			msgB, err := json.Marshal(map[string][]interface{}{
+				"args": []interface{}{job.ID, appName, users},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			message, err := workers.NewMsg(string(msgB))
+			Expect(err).NotTo(HaveOccurred())
+
+			processBatchWorker.Process(message)
+
+			msg, err := pubsub.ReceiveMessage()
+			Expect(err).NotTo(HaveOccurred())
+			event := model.JobEvent{}
+			err = json.Unmarshal([]byte(msg.Payload), &event)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(event.Type).To(Equal(model.JobProgressEvent))
+			Expect(event.JobID).To(Equal(job.ID))
+			Expect(event.CompletedUsers).To(Equal(len(users)))
+		})
+
 		It("should not process batch if job is expired", func() {
 			_, err := processBatchWorker.MarathonDB.DB.Model(&model.Job{}).Set("completed_batches = 0").Set("expires_at = ?", time.Now().UnixNano()-50000).Where("id = ?", job.ID).Update()
 			appName := strings.Split(app.BundleID, ".")[2]
@@ -410,6 +513,202 @@ var _ = Describe("ProcessBatch Worker", func() {
 			}
 		})
 
+		It("should process the message using the template of the normalized locale and count the fallback users", func() {
+			locales := []string{"pt_BR", "PT", "fr-ca", "de"}
+			users = make([]worker.User, len(locales))
+			for index, locale := range locales {
+				users[index] = worker.User{
+					UserID: uuid.NewV4().String(),
+					Token:  strings.Replace(uuid.NewV4().String(), "-", "", -1),
+					Locale: locale,
+				}
+			}
+			appName := strings.Split(app.BundleID, ".")[2]
+			msgB, err := json.Marshal(map[string][]interface{}{
+				"args": []interface{}{job.ID, appName, users},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			message, err := workers.NewMsg(string(msgB))
+			Expect(err).NotTo(HaveOccurred())
+
+			processBatchWorker.Process(message)
+
+			alerts := []interface{}{}
+			for idx := range users {
+				var apnsMessage messages.APNSMessage
+				err = json.Unmarshal([]byte(mockKafkaProducer.APNSMessages[idx]), &apnsMessage)
+				Expect(err).NotTo(HaveOccurred())
+				alerts = append(alerts, apnsMessage.Payload.Aps["alert"])
+			}
+			Expect(alerts).To(Equal([]interface{}{
+				"Everyone curtiram sua vila!",
+				"Everyone curtiram sua vila!",
+				"Everyone a aimé ta ville!",
+				"Everyone just liked your village!",
+			}))
+
+			dbJob := model.Job{ID: job.ID}
+			err = processBatchWorker.MarathonDB.DB.Select(&dbJob)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(dbJob.CompletedUsers).To(Equal(4))
+			Expect(dbJob.FallbackUsers).To(Equal(3))
+		})
+
+		It("should process the message using the locale fallbacks and default locale of the app", func() {
+			_, err := processBatchWorker.MarathonDB.DB.Model(&model.App{}).
+				Set("default_locale = ?", "fr").
+				Set("locale_fallbacks = ?", map[string][]string{"es": []string{"pt"}}).
+				Where("id = ?", app.ID).Update()
+			Expect(err).NotTo(HaveOccurred())
+			locales := []string{"es-MX", "de", ""}
+			users = make([]worker.User, len(locales))
+			for index, locale := range locales {
+				users[index] = worker.User{
+					UserID: uuid.NewV4().String(),
+					Token:  strings.Replace(uuid.NewV4().String(), "-", "", -1),
+					Locale: locale,
+				}
+			}
+			appName := strings.Split(app.BundleID, ".")[2]
+			msgB, err := json.Marshal(map[string][]interface{}{
+				"args": []interface{}{job.ID, appName, users},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			message, err := workers.NewMsg(string(msgB))
+			Expect(err).NotTo(HaveOccurred())
+
+			processBatchWorker.Process(message)
+
+			alerts := []interface{}{}
+			for idx := range users {
+				var apnsMessage messages.APNSMessage
+				err = json.Unmarshal([]byte(mockKafkaProducer.APNSMessages[idx]), &apnsMessage)
+				Expect(err).NotTo(HaveOccurred())
+				alerts = append(alerts, apnsMessage.Payload.Aps["alert"])
+			}
+			Expect(alerts).To(Equal([]interface{}{
+				"Everyone curtiram sua vila!",
+				"Everyone a aimé ta ville!",
+				"Everyone a aimé ta ville!",
+			}))
+
+			dbJob := model.Job{ID: job.ID}
+			err = processBatchWorker.MarathonDB.DB.Select(&dbJob)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(dbJob.FallbackUsers).To(Equal(3))
+		})
+
+		It("should process the message using the template versions pinned by the job", func() {
+			templateVersions, err := worker.GetTemplateVersions(processBatchWorker.MarathonDB.DB, app.ID, template.Name)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(templateVersions).To(HaveLen(3))
+			_, err = processBatchWorker.MarathonDB.DB.Model(&model.Job{}).Set("template_versions = ?", templateVersions).Where("id = ?", job.ID).Update()
+			Expect(err).NotTo(HaveOccurred())
+			_, err = processBatchWorker.MarathonDB.DB.Model(&model.Template{}).Set("body = ?", map[string]interface{}{
+				"alert": "{{user_name}} changed the template!",
+			}).Where("id = ?", template.ID).Update()
+			Expect(err).NotTo(HaveOccurred())
+
+			appName := strings.Split(app.BundleID, ".")[2]
+			msgB, err := json.Marshal(map[string][]interface{}{
+				"args": []interface{}{job.ID, appName, users},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			message, err := workers.NewMsg(string(msgB))
+			Expect(err).NotTo(HaveOccurred())
+
+			processBatchWorker.Process(message)
+
+			for idx := range users {
+				var apnsMessage messages.APNSMessage
+				err = json.Unmarshal([]byte(mockKafkaProducer.APNSMessages[idx]), &apnsMessage)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(apnsMessage.Payload.Aps["alert"]).To(Equal("Everyone just liked your village!"))
+			}
+		})
+
+		It("should process the message using the vars of each user", func() {
+			users[0].Vars = map[string]interface{}{"user_name": "Camila"}
+			appName := strings.Split(app.BundleID, ".")[2]
+			msgB, err := json.Marshal(map[string][]interface{}{
+				"args": []interface{}{job.ID, appName, users},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			message, err := workers.NewMsg(string(msgB))
+			Expect(err).NotTo(HaveOccurred())
+
+			processBatchWorker.Process(message)
+
+			alerts := map[string]interface{}{}
+			for idx := range users {
+				var apnsMessage messages.APNSMessage
+				err = json.Unmarshal([]byte(mockKafkaProducer.APNSMessages[idx]), &apnsMessage)
+				Expect(err).NotTo(HaveOccurred())
+				alerts[apnsMessage.DeviceToken] = apnsMessage.Payload.Aps["alert"]
+			}
+			Expect(alerts[users[0].Token]).To(Equal("Camila just liked your village!"))
+			Expect(alerts[users[1].Token]).To(Equal("Everyone just liked your village!"))
+		})
+
+		It("should process the message using the plural rules of each user locale", func() {
+			_, err := processBatchWorker.MarathonDB.DB.Model(&model.Template{}).Set("body = ?", map[string]interface{}{
+				"alert": "{count, plural, one {# vila curtida} other {# vilas curtidas}}",
+			}).Where("app_id = ? AND locale = ?", app.ID, "pt").Update()
+			Expect(err).NotTo(HaveOccurred())
+			_, err = processBatchWorker.MarathonDB.DB.Model(&model.Job{}).Set("context = ?", map[string]interface{}{
+				"count": 0,
+			}).Where("id = ?", job.ID).Update()
+			Expect(err).NotTo(HaveOccurred())
+			users[0].Locale = "pt_BR"
+			users[1].Locale = "pt_PT"
+
+			appName := strings.Split(app.BundleID, ".")[2]
+			msgB, err := json.Marshal(map[string][]interface{}{
+				"args": []interface{}{job.ID, appName, users},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			message, err := workers.NewMsg(string(msgB))
+			Expect(err).NotTo(HaveOccurred())
+
+			processBatchWorker.Process(message)
+
+			alerts := map[string]interface{}{}
+			for idx := range users {
+				var apnsMessage messages.APNSMessage
+				err = json.Unmarshal([]byte(mockKafkaProducer.APNSMessages[idx]), &apnsMessage)
+				Expect(err).NotTo(HaveOccurred())
+				alerts[apnsMessage.DeviceToken] = apnsMessage.Payload.Aps["alert"]
+			}
+			Expect(alerts[users[0].Token]).To(Equal("0 vila curtida"))
+			Expect(alerts[users[1].Token]).To(Equal("0 vilas curtidas"))
+		})
+
+		It("should process the message using the template body of the job service", func() {
+			_, err := processBatchWorker.MarathonDB.DB.Model(&model.Template{}).Set("service_bodies = ?", map[string]map[string]interface{}{
+				"apns": map[string]interface{}{"alert": "{{user_name}} sent you an apns push!", "badge": 1},
+				"gcm":  map[string]interface{}{"alert": "{{user_name}} sent you a gcm push!"},
+			}).Where("id = ?", template.ID).Update()
+			Expect(err).NotTo(HaveOccurred())
+
+			appName := strings.Split(app.BundleID, ".")[2]
+			msgB, err := json.Marshal(map[string][]interface{}{
+				"args": []interface{}{job.ID, appName, users},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			message, err := workers.NewMsg(string(msgB))
+			Expect(err).NotTo(HaveOccurred())
+
+			processBatchWorker.Process(message)
+
+			for idx := range users {
+				var apnsMessage messages.APNSMessage
+				err = json.Unmarshal([]byte(mockKafkaProducer.APNSMessages[idx]), &apnsMessage)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(apnsMessage.Payload.Aps["alert"]).To(Equal("Everyone sent you an apns push!"))
+				Expect(apnsMessage.Payload.Aps["badge"]).To(BeEquivalentTo(1))
+			}
+		})
+
 		It("should process the message and put the right pushMetadata on it if apns push", func() {
 			userID := uuid.NewV4().String()
 			token := strings.Replace(uuid.NewV4().String(), "-", "", -1)
@@ -0,0 +1,100 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package worker
+
+import (
+	"fmt"
+	"time"
+
+	redis "gopkg.in/redis.v5"
+)
+
+// tokenBucketScript takes ARGV[2] tokens from the bucket in KEYS[1], which is refilled at ARGV[1]
+// tokens per second up to ARGV[1] tokens, at the time ARGV[3] in milliseconds. It returns 0 if the
+// tokens were taken or how many milliseconds to wait before they are available otherwise
+var tokenBucketScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local requested = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local bucket = redis.call("HMGET", KEYS[1], "tokens", "ts")
+local tokens = tonumber(bucket[1])
+local ts = tonumber(bucket[2])
+if tokens == nil or ts == nil then
+	tokens = rate
+	ts = now
+end
+if now > ts then
+	tokens = math.min(rate, tokens + (now - ts) * rate / 1000)
+	ts = now
+end
+local wait = 0
+if tokens >= requested then
+	tokens = tokens - requested
+else
+	wait = math.ceil((requested - tokens) * 1000 / rate)
+end
+redis.call("HMSET", KEYS[1], "tokens", tostring(tokens), "ts", tostring(ts))
+redis.call("PEXPIRE", KEYS[1], 60000)
+return wait
+`)
+
+// JobRateLimitKey returns the key of the job delivery token bucket
+func JobRateLimitKey(jobID string) string {
+	return fmt.Sprintf("%s-ratelimit", jobID)
+}
+
+// TakePushTokens takes n tokens from the job token bucket, which is shared by all the workers and
+// refilled at maxPushesPerSecond, returning how long to wait before retrying if they are not available
+func TakePushTokens(client *redis.Client, jobID string, maxPushesPerSecond, n int) (time.Duration, error) {
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	res, err := tokenBucketScript.Run(client, []string{JobRateLimitKey(jobID)}, maxPushesPerSecond, n, now).Result()
+	if err != nil {
+		return 0, err
+	}
+	wait, ok := res.(int64)
+	if !ok {
+		return 0, fmt.Errorf("unexpected token bucket result %v", res)
+	}
+	return time.Duration(wait) * time.Millisecond, nil
+}
+
+// WaitForPushTokens blocks until n tokens are taken from the job token bucket, so that the job
+// throughput across all workers stays under maxPushesPerSecond
+func WaitForPushTokens(client *redis.Client, jobID string, maxPushesPerSecond, n int) error {
+	for n > 0 {
+		chunk := n
+		if chunk > maxPushesPerSecond {
+			chunk = maxPushesPerSecond
+		}
+		wait, err := TakePushTokens(client, jobID, maxPushesPerSecond, chunk)
+		if err != nil {
+			return err
+		}
+		if wait > 0 {
+			time.Sleep(wait)
+			continue
+		}
+		n = n - chunk
+	}
+	return nil
+}
@@ -0,0 +1,219 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package worker
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/topfreegames/marathon/extensions"
+	"github.com/topfreegames/marathon/log"
+	"github.com/topfreegames/marathon/model"
+	"github.com/uber-go/zap"
+)
+
+// RecurringJobsScheduler creates a job for each occurrence of the recurring jobs
+type RecurringJobsScheduler struct {
+	Logger     zap.Logger
+	MarathonDB *extensions.PGClient
+	Workers    *Worker
+	Config     *viper.Viper
+	Interval   time.Duration
+	Lookahead  time.Duration
+	// EnqueueJob sends the created jobs to the create batches workers
+	EnqueueJob func(job *model.Job) (string, error)
+}
+
+// NewRecurringJobsScheduler gets a new RecurringJobsScheduler
+func NewRecurringJobsScheduler(config *viper.Viper, logger zap.Logger, workers *Worker) *RecurringJobsScheduler {
+	s := &RecurringJobsScheduler{
+		Config:  config,
+		Logger:  logger.With(zap.String("worker", "RecurringJobsScheduler")),
+		Workers: workers,
+	}
+	s.EnqueueJob = workers.EnqueueJob
+	s.configure()
+	log.D(logger, "Configured RecurringJobsScheduler successfully.")
+	return s
+}
+
+func (s *RecurringJobsScheduler) loadConfigurationDefaults() {
+	s.Config.SetDefault("workers.recurringJobs.interval", "1m")
+	s.Config.SetDefault("workers.recurringJobs.lookahead", "24h")
+}
+
+func (s *RecurringJobsScheduler) loadConfiguration() {
+	s.Interval = s.Config.GetDuration("workers.recurringJobs.interval")
+	s.Lookahead = s.Config.GetDuration("workers.recurringJobs.lookahead")
+}
+
+func (s *RecurringJobsScheduler) configureMarathonDatabase() {
+	var err error
+	s.MarathonDB, err = extensions.NewPGClient("db", s.Config, s.Logger)
+	checkErr(s.Logger, err)
+}
+
+func (s *RecurringJobsScheduler) configure() {
+	s.loadConfigurationDefaults()
+	s.loadConfiguration()
+	s.configureMarathonDatabase()
+}
+
+// ScheduleDueJobs creates the jobs of the recurring jobs whose next occurrence is before now plus the lookahead
+// Localized jobs are created ahead of their occurrence so that users in every timezone can receive them
+// It returns the number of created jobs
+func (s *RecurringJobsScheduler) ScheduleDueJobs(now time.Time) (int, error) {
+	recurringJobs := []model.RecurringJob{}
+	err := s.MarathonDB.DB.Model(&recurringJobs).Column("recurring_job.*", "App").
+		Where("recurring_job.next_run_at > 0").
+		Where("recurring_job.next_run_at <= ?", now.Add(s.Lookahead).UnixNano()).
+		Select()
+	if err != nil {
+		return 0, err
+	}
+	created := 0
+	for i := range recurringJobs {
+		ok, err := s.scheduleOccurrence(&recurringJobs[i])
+		if err != nil {
+			return created, err
+		}
+		if ok {
+			created++
+		}
+	}
+	return created, nil
+}
+
+// scheduleOccurrence creates and enqueues the job of the next occurrence of the recurring job
+// It returns false if the occurrence was already taken by another scheduler
+func (s *RecurringJobsScheduler) scheduleOccurrence(recurringJob *model.RecurringJob) (bool, error) {
+	l := s.Logger.With(
+		zap.String("recurringJobId", recurringJob.ID.String()),
+		zap.Int64("occurrence", recurringJob.NextRunAt),
+	)
+	startsAt := recurringJob.NextRunAt
+	lastRunAt := recurringJob.LastRunAt
+	job := recurringJob.NewJob(startsAt)
+	var err error
+	job.TemplateVersions, err = GetTemplateVersions(s.MarathonDB.DB, job.AppID, job.TemplateName)
+	if err != nil {
+		return false, err
+	}
+	err = recurringJob.ScheduleNextRun(startsAt)
+	if err != nil {
+		return false, err
+	}
+	recurringJob.LastRunAt = startsAt
+	recurringJob.UpdatedAt = time.Now().UnixNano()
+
+	tx, err := s.MarathonDB.DB.Begin()
+	if err != nil {
+		return false, err
+	}
+	// the occurrence is claimed by moving next_run_at forward, so concurrent schedulers never create it twice
+	// the claim is committed with the job, so an occurrence is never claimed without its job
+	res, err := tx.Model(&model.RecurringJob{}).
+		Set("next_run_at = ?, last_run_at = ?, updated_at = ?", recurringJob.NextRunAt, recurringJob.LastRunAt, recurringJob.UpdatedAt).
+		Where("id = ?", recurringJob.ID).
+		Where("next_run_at = ?", startsAt).
+		Update()
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	if res.RowsAffected() == 0 {
+		tx.Rollback()
+		log.D(l, "Occurrence already scheduled.")
+		return false, nil
+	}
+	err = tx.Insert(job)
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return false, err
+	}
+
+	wJobID, err := s.EnqueueJob(job)
+	if err != nil {
+		if releaseErr := s.releaseOccurrence(recurringJob, job, startsAt, lastRunAt); releaseErr != nil {
+			return false, fmt.Errorf("%s; failed to release occurrence: %s", err.Error(), releaseErr.Error())
+		}
+		return false, err
+	}
+	log.I(l, "Created job for recurring job occurrence.", func(cm log.CM) {
+		cm.Write(
+			zap.String("jobId", job.ID.String()),
+			zap.String("workerJobId", wJobID),
+			zap.Int64("nextRunAt", recurringJob.NextRunAt),
+		)
+	})
+	return true, nil
+}
+
+// releaseOccurrence deletes the job of an occurrence that could not be enqueued and restores the next run of
+// the recurring job to the occurrence, so that the next check creates it again
+func (s *RecurringJobsScheduler) releaseOccurrence(recurringJob *model.RecurringJob, job *model.Job, startsAt, lastRunAt int64) error {
+	tx, err := s.MarathonDB.DB.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Model(&model.Job{}).Where("id = ?", job.ID).Delete()
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, err = tx.Model(&model.RecurringJob{}).
+		Set("next_run_at = ?, last_run_at = ?, updated_at = ?", startsAt, lastRunAt, time.Now().UnixNano()).
+		Where("id = ?", recurringJob.ID).
+		Where("next_run_at = ?", recurringJob.NextRunAt).
+		Update()
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Start checks the recurring jobs every interval
+func (s *RecurringJobsScheduler) Start() {
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		created, err := s.ScheduleDueJobs(now)
+		if err != nil {
+			log.E(s.Logger, "Failed to schedule recurring jobs.", func(cm log.CM) {
+				cm.Write(zap.Error(err))
+			})
+			continue
+		}
+		if created > 0 {
+			log.I(s.Logger, "Scheduled recurring jobs.", func(cm log.CM) {
+				cm.Write(zap.Int("createdJobs", created))
+			})
+		}
+	}
+}
@@ -0,0 +1,209 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package worker_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/topfreegames/marathon/model"
+	. "github.com/topfreegames/marathon/testing"
+	"github.com/topfreegames/marathon/worker"
+	"github.com/uber-go/zap"
+)
+
+var _ = Describe("RecurringJobs Scheduler", func() {
+	var scheduler *worker.RecurringJobsScheduler
+	var w *worker.Worker
+	var app *model.App
+	var template *model.Template
+	BeforeEach(func() {
+		logger := zap.New(
+			zap.NewJSONEncoder(zap.NoTime()), // drop timestamps in tests
+			zap.FatalLevel,
+		)
+		config := GetConf()
+		w = worker.NewWorker(false, logger, GetConfPath())
+		scheduler = worker.NewRecurringJobsScheduler(config, logger, w)
+		w.RedisClient.FlushAll()
+		scheduler.MarathonDB.DB.Exec("DELETE FROM recurring_jobs;")
+
+		app = CreateTestApp(scheduler.MarathonDB.DB)
+		template = CreateTestTemplate(scheduler.MarathonDB.DB, app.ID)
+	})
+
+	Describe("Schedule due jobs", func() {
+		It("should create a job for the next occurrence and schedule the following one", func() {
+			nextRunAt := time.Date(2030, time.January, 7, 10, 0, 0, 0, time.UTC).UnixNano()
+			recurringJob := CreateTestRecurringJob(scheduler.MarathonDB.DB, app.ID, template.Name, map[string]interface{}{
+				"nextRunAt": nextRunAt,
+			})
+
+			created, err := scheduler.ScheduleDueJobs(time.Unix(0, nextRunAt).Add(-time.Hour))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(created).To(Equal(1))
+
+			jobs := []model.Job{}
+			err = scheduler.MarathonDB.DB.Model(&jobs).Where("recurring_job_id = ?", recurringJob.ID).Select()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(jobs).To(HaveLen(1))
+			Expect(jobs[0].StartsAt).To(Equal(nextRunAt))
+			Expect(jobs[0].TemplateName).To(Equal(template.Name))
+			Expect(jobs[0].Filters).To(Equal(recurringJob.Filters))
+			Expect(jobs[0].Context).To(Equal(recurringJob.Context))
+			Expect(jobs[0].CreatedBy).To(Equal(recurringJob.CreatedBy))
+
+			scheduled, err := w.RedisClient.ZRange("schedule", 0, -1).Result()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(scheduled).To(HaveLen(1))
+			msg := map[string]interface{}{}
+			err = json.Unmarshal([]byte(scheduled[0]), &msg)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(msg["queue"]).To(Equal("create_batches_from_filters_worker"))
+			Expect(msg["args"]).To(Equal([]interface{}{jobs[0].ID.String()}))
+
+			dbRecurringJob := &model.RecurringJob{ID: recurringJob.ID}
+			err = scheduler.MarathonDB.DB.Select(&dbRecurringJob)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(dbRecurringJob.LastRunAt).To(Equal(nextRunAt))
+			Expect(dbRecurringJob.NextRunAt).To(Equal(time.Date(2030, time.January, 14, 10, 0, 0, 0, time.UTC).UnixNano()))
+		})
+
+		It("should enqueue localized jobs right away", func() {
+			nextRunAt := time.Date(2030, time.January, 7, 10, 0, 0, 0, time.UTC).UnixNano()
+			CreateTestRecurringJob(scheduler.MarathonDB.DB, app.ID, template.Name, map[string]interface{}{
+				"nextRunAt": nextRunAt,
+				"localized": true,
+			})
+
+			created, err := scheduler.ScheduleDueJobs(time.Unix(0, nextRunAt).Add(-time.Hour))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(created).To(Equal(1))
+			Expect(w.RedisClient.LLen("queue:create_batches_from_filters_worker").Val()).To(BeEquivalentTo(1))
+		})
+
+		It("should not create jobs for occurrences after the lookahead", func() {
+			nextRunAt := time.Date(2030, time.January, 7, 10, 0, 0, 0, time.UTC).UnixNano()
+			CreateTestRecurringJob(scheduler.MarathonDB.DB, app.ID, template.Name, map[string]interface{}{
+				"nextRunAt": nextRunAt,
+			})
+
+			created, err := scheduler.ScheduleDueJobs(time.Unix(0, nextRunAt).Add(-scheduler.Lookahead - time.Minute))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(created).To(Equal(0))
+		})
+
+		It("should release the occurrence if the job can't be enqueued", func() {
+			nextRunAt := time.Date(2030, time.January, 7, 10, 0, 0, 0, time.UTC).UnixNano()
+			recurringJob := CreateTestRecurringJob(scheduler.MarathonDB.DB, app.ID, template.Name, map[string]interface{}{
+				"nextRunAt": nextRunAt,
+			})
+			scheduler.EnqueueJob = func(job *model.Job) (string, error) {
+				return "", fmt.Errorf("redis is down")
+			}
+
+			created, err := scheduler.ScheduleDueJobs(time.Unix(0, nextRunAt).Add(-time.Hour))
+			Expect(err).To(HaveOccurred())
+			Expect(created).To(Equal(0))
+
+			count, err := scheduler.MarathonDB.DB.Model(&model.Job{}).Where("recurring_job_id = ?", recurringJob.ID).Count()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(count).To(Equal(0))
+			dbRecurringJob := &model.RecurringJob{ID: recurringJob.ID}
+			err = scheduler.MarathonDB.DB.Select(&dbRecurringJob)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(dbRecurringJob.NextRunAt).To(Equal(nextRunAt))
+
+			scheduler.EnqueueJob = w.EnqueueJob
+			created, err = scheduler.ScheduleDueJobs(time.Unix(0, nextRunAt).Add(-time.Hour))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(created).To(Equal(1))
+		})
+
+		It("should create each occurrence only once", func() {
+			nextRunAt := time.Date(2030, time.January, 7, 10, 0, 0, 0, time.UTC).UnixNano()
+			CreateTestRecurringJob(scheduler.MarathonDB.DB, app.ID, template.Name, map[string]interface{}{
+				"nextRunAt": nextRunAt,
+			})
+
+			now := time.Unix(0, nextRunAt).Add(-time.Hour)
+			created, err := scheduler.ScheduleDueJobs(now)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(created).To(Equal(1))
+			created, err = scheduler.ScheduleDueJobs(now)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(created).To(Equal(0))
+		})
+
+		It("should stop scheduling after the end date", func() {
+			nextRunAt := time.Date(2030, time.January, 7, 10, 0, 0, 0, time.UTC).UnixNano()
+			recurringJob := CreateTestRecurringJob(scheduler.MarathonDB.DB, app.ID, template.Name, map[string]interface{}{
+				"nextRunAt": nextRunAt,
+				"endsAt":    time.Date(2030, time.January, 10, 0, 0, 0, 0, time.UTC).UnixNano(),
+			})
+
+			created, err := scheduler.ScheduleDueJobs(time.Unix(0, nextRunAt).Add(-time.Hour))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(created).To(Equal(1))
+
+			dbRecurringJob := &model.RecurringJob{ID: recurringJob.ID}
+			err = scheduler.MarathonDB.DB.Select(&dbRecurringJob)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(dbRecurringJob.NextRunAt).To(BeEquivalentTo(0))
+		})
+	})
+
+	Describe("Cron spec", func() {
+		It("should find the next occurrence of a cron spec", func() {
+			schedule, err := model.ParseCronSpec("*/15 9-17 * * 1-5")
+			Expect(err).NotTo(HaveOccurred())
+			// friday 17:50 UTC
+			next := schedule.Next(time.Date(2017, time.January, 27, 17, 50, 0, 0, time.UTC))
+			Expect(next).To(Equal(time.Date(2017, time.January, 30, 9, 0, 0, 0, time.UTC)))
+		})
+
+		It("should match either day field when both are restricted", func() {
+			schedule, err := model.ParseCronSpec("0 0 1 * 0")
+			Expect(err).NotTo(HaveOccurred())
+			next := schedule.Next(time.Date(2017, time.January, 2, 0, 0, 0, 0, time.UTC))
+			Expect(next).To(Equal(time.Date(2017, time.January, 8, 0, 0, 0, 0, time.UTC)))
+		})
+
+		It("should accept 7 as sunday", func() {
+			schedule, err := model.ParseCronSpec("0 0 * * 7")
+			Expect(err).NotTo(HaveOccurred())
+			next := schedule.Next(time.Date(2017, time.January, 2, 0, 0, 0, 0, time.UTC))
+			Expect(next).To(Equal(time.Date(2017, time.January, 8, 0, 0, 0, 0, time.UTC)))
+		})
+
+		It("should fail for invalid cron specs", func() {
+			for _, spec := range []string{"", "* * * *", "60 * * * *", "* * 0 * *", "5-1 * * * *", "*/0 * * * *", "a * * * *"} {
+				_, err := model.ParseCronSpec(spec)
+				Expect(err).To(HaveOccurred())
+			}
+		})
+	})
+})
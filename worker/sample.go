@@ -0,0 +1,54 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package worker
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+
+	"github.com/topfreegames/marathon/model"
+)
+
+// SamplePosition returns the position of the user in [0, 1) for the salt. It depends only on the salt and the
+// user id, so every job with the same salt puts the user in the same position
+func SamplePosition(salt, userID string) float64 {
+	sum := sha1.Sum([]byte(salt + ":" + userID))
+	// the 53 most significant bits fill the mantissa of a float64
+	return float64(binary.BigEndian.Uint64(sum[:8])>>11) / (1 << 53)
+}
+
+// SampleUserIDs returns the user ids whose position is in the sample [sampleFrom, sampleRate) of the job
+// Jobs without a sample rate are sent to all the users
+func SampleUserIDs(job *model.Job, userIds []string) []string {
+	if job.SampleRate == 0 {
+		return userIds
+	}
+	sampled := make([]string, 0, len(userIds))
+	for _, userID := range userIds {
+		position := SamplePosition(job.SampleSalt, userID)
+		if position >= job.SampleFrom && position < job.SampleRate {
+			sampled = append(sampled, userID)
+		}
+	}
+	return sampled
+}
@@ -0,0 +1,156 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package worker
+
+import (
+	"fmt"
+	"time"
+
+	"gopkg.in/pg.v5"
+	"gopkg.in/redis.v5"
+
+	"github.com/satori/go.uuid"
+	"github.com/topfreegames/marathon/interfaces"
+	"github.com/topfreegames/marathon/model"
+	"github.com/willf/bloom"
+)
+
+// suppressionFalsePositiveRate is the false positive rate of the suppression list bloom filter. The users the
+// filter matches are checked in the database, so a false positive never drops a user
+const suppressionFalsePositiveRate = 1e-4
+
+// SuppressionCacheKey returns the redis key of the cached bloom filter of the version of the suppression list of
+// the app. The version is incremented with every change of the list, so a cached filter never gets outdated
+func SuppressionCacheKey(appID uuid.UUID, version int) string {
+	return fmt.Sprintf("%s-suppressions-%d", appID.String(), version)
+}
+
+// IncrementSuppressionsVersion increments the version of the suppression list of the app, it must run in the
+// transaction that changes the list
+func IncrementSuppressionsVersion(tx *pg.Tx, appID uuid.UUID) error {
+	_, err := tx.Exec("UPDATE apps SET suppressions_version = suppressions_version + 1 WHERE id = ?", appID)
+	return err
+}
+
+// SuppressionList is the suppression list of an app, with a bloom filter of its user ids
+type SuppressionList struct {
+	AppID  uuid.UUID
+	db     interfaces.DB
+	filter *bloom.BloomFilter
+}
+
+type suppressionRow struct {
+	Version int
+	UserID  string
+}
+
+// LoadSuppressionList returns the suppression list of the app. Its bloom filter is read from the redis key of the
+// current version of the list or built from the database and cached in the key of the version it was read at
+func LoadSuppressionList(db interfaces.DB, redisClient *redis.Client, appID uuid.UUID, ttl time.Duration) (*SuppressionList, error) {
+	s := &SuppressionList{AppID: appID, db: db, filter: &bloom.BloomFilter{}}
+	var version int
+	_, err := db.Query(pg.Scan(&version), "SELECT suppressions_version FROM apps WHERE id = ?", appID)
+	if err != nil && err != pg.ErrNoRows {
+		return nil, err
+	}
+	cached, err := redisClient.Get(SuppressionCacheKey(appID, version)).Bytes()
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+	if err == nil && s.filter.GobDecode(cached) == nil {
+		return s, nil
+	}
+
+	// the version and the users are read by the same statement, so the users are the ones of the version
+	var rows []suppressionRow
+	_, err = db.Query(&rows, `SELECT a.suppressions_version AS version, s.user_id
+		FROM apps AS a LEFT JOIN suppressed_users AS s ON s.app_id = a.id
+		WHERE a.id = ?`, appID)
+	if err != nil {
+		return nil, err
+	}
+	s.filter = bloom.NewWithEstimates(uint(len(rows)+1), suppressionFalsePositiveRate)
+	for _, row := range rows {
+		version = row.Version
+		if row.UserID != "" {
+			s.filter.AddString(row.UserID)
+		}
+	}
+	encoded, err := s.filter.GobEncode()
+	if err != nil {
+		return nil, err
+	}
+	err = redisClient.Set(SuppressionCacheKey(appID, version), encoded, ttl).Err()
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Filter returns the users that are not in the suppression list and the ids of the suppressed users it dropped
+func (s *SuppressionList) Filter(users []User) ([]User, []string, error) {
+	candidates := []string{}
+	for _, user := range users {
+		if s.filter.TestString(user.UserID) {
+			candidates = append(candidates, user.UserID)
+		}
+	}
+	if len(candidates) == 0 {
+		return users, nil, nil
+	}
+
+	var suppressedUsers []model.SuppressedUser
+	err := s.db.Model(&suppressedUsers).Column("user_id").Where("app_id = ?", s.AppID).Where("user_id IN (?)", pg.In(candidates)).Select()
+	if err != nil {
+		return nil, nil, err
+	}
+	suppressed := map[string]bool{}
+	userIds := make([]string, 0, len(suppressedUsers))
+	for _, suppressedUser := range suppressedUsers {
+		suppressed[suppressedUser.UserID] = true
+		userIds = append(userIds, suppressedUser.UserID)
+	}
+	kept := make([]User, 0, len(users))
+	for _, user := range users {
+		if !suppressed[user.UserID] {
+			kept = append(kept, user)
+		}
+	}
+	return kept, userIds, nil
+}
+
+// UpdateSuppressedUsers adds the users dropped by the suppression list to the suppressed users of the job, it
+// must be called once per page, so the users of a page processed again are not counted twice
+func UpdateSuppressedUsers(db interfaces.DB, job *model.Job, suppressedUsers int) error {
+	if suppressedUsers == 0 {
+		return nil
+	}
+	_, err := db.Model(&model.Job{}).Set("suppressed_users = suppressed_users + ?", suppressedUsers).Where("id = ?", job.ID).Update()
+	return err
+}
+
+// SetSuppressedUsers sets the suppressed users of the job, so a job processed again counts its users only once
+func SetSuppressedUsers(db interfaces.DB, job *model.Job, suppressedUsers int) error {
+	_, err := db.Model(&model.Job{}).Set("suppressed_users = ?", suppressedUsers).Where("id = ?", job.ID).Update()
+	return err
+}
@@ -0,0 +1,171 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package worker
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/topfreegames/marathon/interfaces"
+	"github.com/topfreegames/marathon/messages"
+	"github.com/topfreegames/marathon/model"
+	pg "gopkg.in/pg.v5"
+)
+
+// Payload size limits of the push services in bytes
+const (
+	APNSMaxPayloadSize = 4096
+	GCMMaxPayloadSize  = 4096
+)
+
+// PushServices are the services marathon sends pushes to
+var PushServices = []string{"apns", "gcm"}
+
+// TemplateLintFinding is a problem found in a template
+type TemplateLintFinding struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// GetAppServices returns the services that have a users table in the push db for the app
+func GetAppServices(db interfaces.DB, appName string) ([]string, error) {
+	tableNames := []string{}
+	for _, service := range PushServices {
+		tableNames = append(tableNames, GetPushDBTableName(appName, service))
+	}
+	var tables []struct {
+		TableName string `sql:"table_name"`
+	}
+	_, err := db.Query(&tables, "SELECT table_name FROM information_schema.tables WHERE table_name IN (?)", pg.In(tableNames))
+	if err != nil {
+		return nil, err
+	}
+	services := []string{}
+	for _, service := range PushServices {
+		for _, table := range tables {
+			if table.TableName == GetPushDBTableName(appName, service) {
+				services = append(services, service)
+			}
+		}
+	}
+	return services, nil
+}
+
+// PushPayloadSize returns the size in bytes of the payload the service sends to the devices for msg and the message
+// metadata, and the limit of the service
+func PushPayloadSize(service string, msg, messageMetadata map[string]interface{}) (int, int, error) {
+	data := make(map[string]interface{}, len(msg))
+	for key, value := range msg {
+		data[key] = value
+	}
+	var payload []byte
+	var err error
+	switch service {
+	case "apns":
+		payload, err = json.Marshal(messages.NewAPNSMessage("", 0, data, messageMetadata, nil).Payload)
+		return len(payload), APNSMaxPayloadSize, err
+	case "gcm":
+		payload, err = json.Marshal(messages.NewGCMMessage("", data, messageMetadata, nil, 0).Data)
+		return len(payload), GCMMaxPayloadSize, err
+	}
+	return 0, 0, fmt.Errorf("service should be in ['apns', 'gcm']")
+}
+
+// renderLintBody renders the template with the defaults, returning a finding of field if it is not a json object
+func renderLintBody(template model.Template, defaults map[string]interface{}, field string) (map[string]interface{}, *TemplateLintFinding) {
+	msgStr, err := BuildMessageFromTemplate(template, defaults, nil, "")
+	if err != nil {
+		return nil, &TemplateLintFinding{Field: field, Message: err.Error()}
+	}
+	var msg map[string]interface{}
+	err = json.Unmarshal([]byte(msgStr), &msg)
+	if err != nil {
+		return nil, &TemplateLintFinding{
+			Field:   field,
+			Message: fmt.Sprintf("rendered %s is not a json object: %s", field, err.Error()),
+		}
+	}
+	return msg, nil
+}
+
+// LintTemplate returns the problems of the template: placeholders without a default, bodies or service bodies that
+// do not render to a json object with the defaults and rendered messages bigger than the payload limit of the
+// services. All services are checked if services is empty
+func LintTemplate(template model.Template, services []string) []TemplateLintFinding {
+	findings := []TemplateLintFinding{}
+	placeholders, err := model.TemplatePlaceholders(&template)
+	if err != nil {
+		return append(findings, TemplateLintFinding{Field: "body", Message: err.Error()})
+	}
+	defaults := map[string]interface{}{}
+	for _, placeholder := range placeholders {
+		if _, ok := template.Defaults[placeholder]; !ok {
+			findings = append(findings, TemplateLintFinding{
+				Field:   "defaults",
+				Message: fmt.Sprintf("placeholder %s has no default", placeholder),
+			})
+			// zero also renders as the value of plural arguments
+			defaults[placeholder] = 0
+		}
+	}
+
+	msg, finding := renderLintBody(template, defaults, "body")
+	if finding != nil {
+		return append(findings, *finding)
+	}
+	serviceMsgs := map[string]map[string]interface{}{}
+	for _, service := range template.Services() {
+		field := fmt.Sprintf("serviceBodies.%s", service)
+		serviceMsg, finding := renderLintBody(template.ServiceTemplate(service), defaults, field)
+		if finding != nil {
+			findings = append(findings, *finding)
+			continue
+		}
+		serviceMsgs[service] = serviceMsg
+	}
+	if len(serviceMsgs) < len(template.ServiceBodies) {
+		return findings
+	}
+
+	if len(services) == 0 {
+		services = PushServices
+	}
+	for _, service := range services {
+		serviceMsg, field := msg, "body"
+		if _, ok := serviceMsgs[service]; ok {
+			serviceMsg, field = serviceMsgs[service], fmt.Sprintf("serviceBodies.%s", service)
+		}
+		size, limit, err := PushPayloadSize(service, serviceMsg, nil)
+		if err != nil {
+			findings = append(findings, TemplateLintFinding{Field: field, Message: err.Error()})
+			continue
+		}
+		if size > limit {
+			findings = append(findings, TemplateLintFinding{
+				Field:   field,
+				Message: fmt.Sprintf("%s payload has %d bytes, more than the limit of %d bytes", service, size, limit),
+			})
+		}
+	}
+	return findings
+}
@@ -0,0 +1,138 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permifsion is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package worker_test
+
+import (
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/topfreegames/marathon/extensions"
+	"github.com/topfreegames/marathon/model"
+	. "github.com/topfreegames/marathon/testing"
+	"github.com/topfreegames/marathon/worker"
+	"github.com/uber-go/zap"
+)
+
+var _ = Describe("Template Lint", func() {
+	logger := zap.New(
+		zap.NewJSONEncoder(zap.NoTime()),
+		zap.FatalLevel,
+	)
+	var template model.Template
+	BeforeEach(func() {
+		template = model.Template{
+			Body: map[string]interface{}{
+				"alert": "{{user_name}} just liked your {{object_name}}!",
+			},
+			Defaults: map[string]interface{}{
+				"user_name":   "Someone",
+				"object_name": "village",
+			},
+		}
+	})
+
+	Describe("Lint template", func() {
+		It("should return no findings for a valid template", func() {
+			findings := worker.LintTemplate(template, nil)
+			Expect(findings).To(BeEmpty())
+		})
+
+		It("should return the placeholders without default", func() {
+			template.Body["title"] = "{{title}} {{subtitle}}"
+			findings := worker.LintTemplate(template, nil)
+			Expect(findings).To(Equal([]worker.TemplateLintFinding{
+				{Field: "defaults", Message: "placeholder subtitle has no default"},
+				{Field: "defaults", Message: "placeholder title has no default"},
+			}))
+		})
+
+		It("should return the placeholders without default of go templates", func() {
+			template.Engine = model.GoTemplateEngine
+			template.Body = map[string]interface{}{
+				"alert": "{{if .vip}}Dear {{.user_name}}{{end}}{{range .items}}{{.name}}{{end}}",
+			}
+			findings := worker.LintTemplate(template, nil)
+			Expect(findings).To(Equal([]worker.TemplateLintFinding{
+				{Field: "defaults", Message: "placeholder items has no default"},
+				{Field: "defaults", Message: "placeholder vip has no default"},
+			}))
+		})
+
+		It("should return the plural and select arguments without default", func() {
+			template.Body["title"] = "{count, plural, one {# gift} other {# gifts}} {gender, select, female {her} other {their}}"
+			findings := worker.LintTemplate(template, nil)
+			Expect(findings).To(Equal([]worker.TemplateLintFinding{
+				{Field: "defaults", Message: "placeholder count has no default"},
+				{Field: "defaults", Message: "placeholder gender has no default"},
+			}))
+		})
+
+		It("should return the services whose payload limit is exceeded", func() {
+			template.Defaults["user_name"] = strings.Repeat("a", worker.APNSMaxPayloadSize)
+			findings := worker.LintTemplate(template, []string{"apns"})
+			Expect(findings).To(HaveLen(1))
+			Expect(findings[0].Field).To(Equal("body"))
+			Expect(findings[0].Message).To(HavePrefix("apns payload has"))
+
+			findings = worker.LintTemplate(template, nil)
+			Expect(findings).To(HaveLen(2))
+			Expect(findings[1].Message).To(HavePrefix("gcm payload has"))
+		})
+
+		It("should lint the service bodies with the payload limit of their service", func() {
+			template.ServiceBodies = map[string]map[string]interface{}{
+				"gcm": map[string]interface{}{"alert": "{{user_name}} {{title}}", "long": strings.Repeat("a", worker.GCMMaxPayloadSize)},
+			}
+			findings := worker.LintTemplate(template, nil)
+			Expect(findings).To(HaveLen(2))
+			Expect(findings[0]).To(Equal(worker.TemplateLintFinding{Field: "defaults", Message: "placeholder title has no default"}))
+			Expect(findings[1].Field).To(Equal("serviceBodies.gcm"))
+			Expect(findings[1].Message).To(HavePrefix("gcm payload has"))
+		})
+
+		It("should return the rendering errors", func() {
+			template.Engine = model.GoTemplateEngine
+			template.Body = map[string]interface{}{
+				"alert": "{{.user_name | formatNumber 0}}",
+			}
+			findings := worker.LintTemplate(template, nil)
+			Expect(findings).To(HaveLen(1))
+			Expect(findings[0].Field).To(Equal("body"))
+		})
+	})
+
+	Describe("Get app services", func() {
+		It("should return the services with a users table in the push db", func() {
+			pushDB, err := extensions.NewPGClient("push.db", GetConf(), logger)
+			Expect(err).NotTo(HaveOccurred())
+			services, err := worker.GetAppServices(pushDB.DB, "testapp")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(services).To(Equal([]string{"apns", "gcm"}))
+
+			services, err = worker.GetAppServices(pushDB.DB, "notanapp")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(services).To(BeEmpty())
+		})
+	})
+})
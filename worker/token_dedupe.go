@@ -0,0 +1,105 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package worker
+
+import (
+	"fmt"
+	"time"
+
+	redis "gopkg.in/redis.v5"
+
+	"github.com/topfreegames/marathon/interfaces"
+	"github.com/topfreegames/marathon/model"
+)
+
+// claimTokensScript claims the tokens in ARGV[3..] for the page ARGV[1] in the hash KEYS[1], which
+// expires in ARGV[2] milliseconds. It returns the indexes (starting at 1) of the tokens that were
+// already claimed by another page, so that a page processed again in a reexecution keeps its tokens
+var claimTokensScript = redis.NewScript(`
+local duplicates = {}
+for i = 3, #ARGV do
+	if redis.call("HSETNX", KEYS[1], ARGV[i], ARGV[1]) == 0 and redis.call("HGET", KEYS[1], ARGV[i]) ~= ARGV[1] then
+		table.insert(duplicates, i - 2)
+	end
+end
+redis.call("PEXPIRE", KEYS[1], ARGV[2])
+return duplicates
+`)
+
+// JobTokensKey returns the key of the hash with the tokens already sent to batches of the job
+func JobTokensKey(jobID string) string {
+	return fmt.Sprintf("%s-tokens", jobID)
+}
+
+// DedupeTokens drops the users whose token was already sent to a batch of the job, either by another
+// page or by a previous user of the same page, and returns the kept users and how many were dropped
+func DedupeTokens(client *redis.Client, jobID string, pageID int, users []User, ttl time.Duration) ([]User, int, error) {
+	unique := make([]User, 0, len(users))
+	seen := map[string]bool{}
+	args := []interface{}{pageID, int64(ttl / time.Millisecond)}
+	for _, user := range users {
+		if seen[user.Token] {
+			continue
+		}
+		seen[user.Token] = true
+		unique = append(unique, user)
+		args = append(args, user.Token)
+	}
+	if len(unique) == 0 {
+		return unique, len(users), nil
+	}
+
+	res, err := claimTokensScript.Run(client, []string{JobTokensKey(jobID)}, args...).Result()
+	if err != nil {
+		return nil, 0, err
+	}
+	duplicates, ok := res.([]interface{})
+	if !ok {
+		return nil, 0, fmt.Errorf("unexpected claim tokens result %v", res)
+	}
+	claimedByOtherPage := map[int64]bool{}
+	for _, duplicate := range duplicates {
+		index, ok := duplicate.(int64)
+		if !ok {
+			return nil, 0, fmt.Errorf("unexpected claim tokens result %v", res)
+		}
+		claimedByOtherPage[index-1] = true
+	}
+	kept := make([]User, 0, len(unique))
+	for i, user := range unique {
+		if !claimedByOtherPage[int64(i)] {
+			kept = append(kept, user)
+		}
+	}
+	return kept, len(users) - len(kept), nil
+}
+
+// UpdateDuplicateTokens adds the users dropped because their token was already sent to the duplicate tokens of the
+// job, it must be called once per page, so the users of a page processed again are not counted twice
+func UpdateDuplicateTokens(db interfaces.DB, job *model.Job, duplicateTokens int) error {
+	if duplicateTokens == 0 {
+		return nil
+	}
+	_, err := db.Model(&model.Job{}).Set("duplicate_tokens = duplicate_tokens + ?", duplicateTokens).Where("id = ?", job.ID).Update()
+	return err
+}
@@ -22,9 +22,12 @@
 package worker
 
 import (
+	"bytes"
+	"encoding/csv"
 	"encoding/json"
 	"fmt"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -35,14 +38,18 @@ import (
 	raven "github.com/getsentry/raven-go"
 	uuid "github.com/satori/go.uuid"
 	"github.com/topfreegames/marathon/extensions"
+	"github.com/topfreegames/marathon/interfaces"
 	"github.com/topfreegames/marathon/log"
+	"github.com/topfreegames/marathon/messages"
 	"github.com/topfreegames/marathon/model"
 	"github.com/uber-go/zap"
-	"github.com/valyala/fasttemplate"
 )
 
 const stoppedJobStatus = "stopped"
 
+// defaultTz is the tz used for users that have no tz in the push db
+const defaultTz = "-0500"
+
 // User is the struct that will keep users before sending them to send batches worker
 type User struct {
 	CreatedAt pg.NullTime `json:"created_at" sql:"created_at"`
@@ -51,12 +58,15 @@ type User struct {
 	Locale    string      `json:"locale" sql:"locale"`
 	Region    string      `json:"region" sql:"region"`
 	Tz        string      `json:"tz" sql:"tz"`
+	// Vars are the template variables of the user read from the extra columns of the job csv
+	Vars map[string]interface{} `json:"vars,omitempty" sql:"-"`
 }
 
 // Batch is a struct that helps tracking processes pages
 type Batch struct {
-	UserIds *[]string
-	PageID  int
+	UserIds  *[]string
+	UserVars map[string]map[string]interface{}
+	PageID   int
 }
 
 // DBPage is a struct that helps create batches from filters jobs
@@ -65,6 +75,39 @@ type DBPage struct {
 	Offset int
 }
 
+// UsersBucket is a struct that helps counting users by locale and tz
+type UsersBucket struct {
+	Locale string `json:"locale" sql:"locale"`
+	Tz     string `json:"tz" sql:"tz"`
+	Users  int    `json:"users" sql:"users"`
+}
+
+type usersBuckets []UsersBucket
+
+func (b usersBuckets) Len() int      { return len(b) }
+func (b usersBuckets) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b usersBuckets) Less(i, j int) bool {
+	if b[i].Locale != b[j].Locale {
+		return b[i].Locale < b[j].Locale
+	}
+	return b[i].Tz < b[j].Tz
+}
+
+// MergeUsersBuckets sums the users of buckets with the same locale and tz
+func MergeUsersBuckets(buckets []UsersBucket) []UsersBucket {
+	usersByBucket := map[UsersBucket]int{}
+	for _, bucket := range buckets {
+		usersByBucket[UsersBucket{Locale: bucket.Locale, Tz: bucket.Tz}] += bucket.Users
+	}
+	merged := usersBuckets{}
+	for bucket, users := range usersByBucket {
+		bucket.Users = users
+		merged = append(merged, bucket)
+	}
+	sort.Sort(merged)
+	return merged
+}
+
 // SentBatches is a struct that helps tracking sent batches
 type SentBatches struct {
 	NumBatches int
@@ -116,8 +159,10 @@ func checkIsReexecution(jobID uuid.UUID, redisClient *redis.Client, l zap.Logger
 	return res
 }
 
-func markProcessedPage(page int, jobID uuid.UUID, redisClient *redis.Client) {
-	redisClient.SAdd(fmt.Sprintf("%s-processedpages", jobID.String()), page)
+// markProcessedPage returns whether the page was not marked as processed yet
+func markProcessedPage(page int, jobID uuid.UUID, redisClient *redis.Client) bool {
+	added, _ := redisClient.SAdd(fmt.Sprintf("%s-processedpages", jobID.String()), page).Result()
+	return added > 0
 }
 
 // SplitUsersInBucketsByTZ splits users in buckets by tz
@@ -126,7 +171,7 @@ func SplitUsersInBucketsByTZ(users *[]User) map[string]*[]User {
 	for _, user := range *users {
 		userTz := user.Tz
 		if len(userTz) == 0 {
-			userTz = "-0500"
+			userTz = defaultTz
 		}
 		if res, ok := bucketsByTZ[userTz]; ok {
 			users := append(*res, user)
@@ -147,34 +192,171 @@ func checkErr(l zap.Logger, err error) {
 	}
 }
 
-// GetWhereClauseFromFilters returns a string cointaining the where clause to use in the query
-func GetWhereClauseFromFilters(filters map[string]interface{}) string {
-	if len(filters) == 0 {
-		return ""
+// PublishJobEvent publishes the event to the job events channel
+// Events are best effort so failures are only logged
+func PublishJobEvent(client *redis.Client, l zap.Logger, event *model.JobEvent) {
+	err := extensions.PublishJSON(client, model.JobEventsChannel(event.JobID.String()), event)
+	if err != nil {
+		log.E(l, "Failed to publish job event.", func(cm log.CM) {
+			cm.Write(zap.String("eventType", event.Type), zap.Error(err))
+		})
+	}
+}
+
+// filterValueClause returns the placeholder of a filter value and its params, relative dates are compared with
+// the current time
+func filterValueClause(value interface{}) (string, []interface{}) {
+	if interval, isRelative, _ := model.RelativeDateInterval(value); isRelative {
+		return "now() + ?::interval", []interface{}{interval}
 	}
+	return "?", []interface{}{value}
+}
 
-	queryFilters := []string{}
-	for key, val := range filters {
-		operator := "="
-		connector := " OR "
-		if strings.Contains(key, "NOT") {
-			key = strings.Trim(key, "NOT")
-			operator = "!="
-			connector = " AND "
+// filterWhereClause compiles the filter into a where clause with ? placeholders and the params that replace them
+func filterWhereClause(filter *model.Filter) (string, []interface{}) {
+	params := []interface{}{}
+	if len(filter.And) > 0 || len(filter.Or) > 0 {
+		children, connector := filter.And, " AND "
+		if len(filter.Or) > 0 {
+			children, connector = filter.Or, " OR "
+		}
+		clauses := make([]string, len(children))
+		for i, child := range children {
+			var childParams []interface{}
+			clauses[i], childParams = filterWhereClause(child)
+			params = append(params, childParams...)
 		}
-		strVal := val.(string)
-		if strings.Contains(strVal, ",") {
-			filterArray := []string{}
-			vals := strings.Split(strVal, ",")
-			for _, fVal := range vals {
-				filterArray = append(filterArray, fmt.Sprintf("\"%s\"%s'%s'", key, operator, fVal))
+		return fmt.Sprintf("(%s)", strings.Join(clauses, connector)), params
+	}
+	if filter.Not != nil {
+		clause, params := filterWhereClause(filter.Not)
+		return fmt.Sprintf("NOT (%s)", clause), params
+	}
+
+	column := fmt.Sprintf("\"%s\"", filter.Column)
+	switch filter.Op {
+	case model.FilterIsNull:
+		return fmt.Sprintf("%s IS NULL", column), params
+	case model.FilterIn:
+		return fmt.Sprintf("%s IN (?)", column), []interface{}{pg.In(filter.Value)}
+	case model.FilterBetween:
+		bounds := filter.Value.([]interface{})
+		lower, lowerParams := filterValueClause(bounds[0])
+		upper, upperParams := filterValueClause(bounds[1])
+		return fmt.Sprintf("%s BETWEEN %s AND %s", column, lower, upper), append(lowerParams, upperParams...)
+	case model.FilterLike:
+		return fmt.Sprintf("%s LIKE ?", column), []interface{}{filter.Value}
+	}
+	value, params := filterValueClause(filter.Value)
+	return fmt.Sprintf("%s %s %s", column, filter.Op, value), params
+}
+
+// GetWhereClauseFromFilters returns the where clause of the job filters, with ? placeholders for the filter values,
+// and the params that replace them in the query. The where clause is empty if there are no filters
+func GetWhereClauseFromFilters(filters map[string]interface{}) (string, []interface{}, error) {
+	filter, err := model.ParseFilters(filters)
+	if err != nil || filter == nil {
+		return "", nil, err
+	}
+	whereClause, params := filterWhereClause(filter)
+	return whereClause, params, nil
+}
+
+// CountUsersFromFilters returns the number of users in the push db matching the filters
+func CountUsersFromFilters(db interfaces.DB, appName, service string, filters map[string]interface{}) (int, error) {
+	var count int
+	whereClause, params, err := GetWhereClauseFromFilters(filters)
+	if err != nil {
+		return 0, err
+	}
+	var query string
+	if (whereClause) != "" {
+		query = fmt.Sprintf("SELECT count(1) FROM %s WHERE %s;", GetPushDBTableName(appName, service), whereClause)
+	} else {
+		query = fmt.Sprintf("SELECT count(1) FROM %s;", GetPushDBTableName(appName, service))
+	}
+	_, err = db.Query(&count, query, params...)
+	return count, err
+}
+
+func usersBucketsQuery(appName, service, whereClause string) string {
+	query := fmt.Sprintf("SELECT coalesce(lower(locale), '') AS locale, coalesce(nullif(tz, ''), '%s') AS tz, count(1) AS users FROM %s", defaultTz, GetPushDBTableName(appName, service))
+	if whereClause != "" {
+		query = fmt.Sprintf("%s WHERE %s", query, whereClause)
+	}
+	return fmt.Sprintf("%s GROUP BY 1, 2 ORDER BY 1, 2;", query)
+}
+
+// GetUsersBucketsFromFilters returns the users matching the filters counted by locale and tz
+func GetUsersBucketsFromFilters(db interfaces.DB, appName, service string, filters map[string]interface{}) ([]UsersBucket, error) {
+	var buckets []UsersBucket
+	whereClause, params, err := GetWhereClauseFromFilters(filters)
+	if err != nil {
+		return nil, err
+	}
+	_, err = db.Query(&buckets, usersBucketsQuery(appName, service, whereClause), params...)
+	return buckets, err
+}
+
+// GetUsersBucketsFromUserIDs returns the users with the given ids counted by locale and tz
+func GetUsersBucketsFromUserIDs(db interfaces.DB, appName, service string, userIds []string) ([]UsersBucket, error) {
+	var buckets []UsersBucket
+	_, err := db.Query(&buckets, usersBucketsQuery(appName, service, "user_id IN (?)"), pg.In(userIds))
+	return buckets, err
+}
+
+// CountUsersFromUserIDs returns the number of users in the push db with the given ids
+func CountUsersFromUserIDs(db interfaces.DB, appName, service string, userIds []string) (int, error) {
+	var count int
+	if len(userIds) == 0 {
+		return 0, nil
+	}
+	query := fmt.Sprintf("SELECT count(1) FROM %s WHERE user_id IN (?);", GetPushDBTableName(appName, service))
+	_, err := db.Query(&count, query, pg.In(userIds))
+	return count, err
+}
+
+// ReadUserIDsFromCSV returns the user ids in the first column of a csv, skipping its header
+func ReadUserIDsFromCSV(csvBytes []byte) ([]string, error) {
+	userIds, _, err := ReadUsersFromCSV(csvBytes)
+	return userIds, err
+}
+
+// ReadUsersFromCSV returns the user ids in the first column of a csv and the template variables of each
+// user id, named by the header of the other columns. Empty cells are skipped so that the job context and
+// the template defaults are used instead
+func ReadUsersFromCSV(csvBytes []byte) ([]string, map[string]map[string]interface{}, error) {
+	for i, b := range csvBytes {
+		if b == 0x0D {
+			csvBytes[i] = 0x0A
+		}
+	}
+	r := csv.NewReader(bytes.NewReader(csvBytes))
+	lines, err := r.ReadAll()
+	if err != nil {
+		return nil, nil, err
+	}
+	res := []string{}
+	userVars := map[string]map[string]interface{}{}
+	var header []string
+	for i, line := range lines {
+		if i == 0 {
+			header = line
+			continue
+		}
+		res = append(res, line[0])
+		for j := 1; j < len(line) && j < len(header); j++ {
+			name := strings.TrimSpace(header[j])
+			if name == "" || line[j] == "" {
+				continue
 			}
-			queryFilters = append(queryFilters, fmt.Sprintf("(%s)", strings.Join(filterArray, connector)))
-		} else {
-			queryFilters = append(queryFilters, fmt.Sprintf("\"%s\"%s'%s'", key, operator, val))
+			if _, ok := userVars[line[0]]; !ok {
+				userVars[line[0]] = map[string]interface{}{}
+			}
+			userVars[line[0]][name] = line[j]
 		}
 	}
-	return strings.Join(queryFilters, " AND ")
+	return res, userVars, nil
 }
 
 // GetPushDBTableName get the table name using appName and service
@@ -236,15 +418,37 @@ func ParseProcessBatchWorkerMessageArray(arr []interface{}) (*BatchWorkerMessage
 	return message, nil
 }
 
-// BuildMessageFromTemplate build a message using a template and the context
-func BuildMessageFromTemplate(template model.Template, context map[string]interface{}) (string, error) {
-	body, err := json.Marshal(template.Body)
+// GetTemplateVersions returns the ids of the current versions of each locale of the template, which are pinned by
+// the jobs so that they are sent with the template as it was when they were created
+func GetTemplateVersions(db interfaces.DB, appID uuid.UUID, templateName string) (map[string]string, error) {
+	var versions []model.TemplateVersion
+	err := db.Model(&versions).
+		Column("template_version.*").
+		Join("JOIN templates AS t ON t.id = template_version.template_id AND t.version = template_version.version").
+		Where("t.app_id = ?", appID).
+		Where("t.name = ?", templateName).
+		Select()
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	templateVersions := map[string]string{}
+	for _, version := range versions {
+		templateVersions[version.Locale] = version.ID.String()
 	}
-	bodyString := string(body)
-	t := fasttemplate.New(bodyString, "{{", "}}")
+	return templateVersions, nil
+}
+
+// BuildMessageFromTemplate build a message using a template, the context and the vars of the user,
+// the vars of the user take precedence over the context and the context over the template defaults. The plural
+// arguments of the template use the rules of the user locale, or of the template locale if it is empty or has
+// another language
+func BuildMessageFromTemplate(template model.Template, context, userVars map[string]interface{}, locale string) (string, error) {
+	return BuildMessageFromCachedTemplate(template, context, userVars, locale, nil)
+}
 
+// BuildMessageFromCachedTemplate builds a message like BuildMessageFromTemplate, taking the parsed go templates of
+// the strings of the template from goTemplates, so the messages of a batch parse each string once
+func BuildMessageFromCachedTemplate(template model.Template, context, userVars map[string]interface{}, locale string, goTemplates model.GoTemplateCache) (string, error) {
 	substitutions := make(map[string]interface{})
 	for k, v := range template.Defaults {
 		substitutions[k] = v
@@ -252,8 +456,52 @@ func BuildMessageFromTemplate(template model.Template, context map[string]interf
 	for k, v := range context {
 		substitutions[k] = v
 	}
-	message := t.ExecuteString(substitutions)
-	return message, nil
+	for k, v := range userVars {
+		substitutions[k] = v
+	}
+
+	body, err := model.FormatMessageBody(template.Body, model.PluralLocale(template.Locale, locale), substitutions)
+	if err != nil {
+		return "", err
+	}
+	var rendered map[string]interface{}
+	if template.Engine == model.GoTemplateEngine {
+		rendered, err = model.RenderGoTemplateBody(body, substitutions, goTemplates)
+	} else {
+		rendered, err = model.RenderSimpleTemplateBody(body, substitutions)
+	}
+	if err != nil {
+		return "", err
+	}
+	message, err := json.Marshal(rendered)
+	return string(message), err
+}
+
+// BuildPushMetadata builds the metadata sent with the push of a job to a user
+func BuildPushMetadata(job *model.Job, user User) map[string]interface{} {
+	pushMetadata := map[string]interface{}{
+		"userId":       user.UserID,
+		"templateName": job.TemplateName,
+		"jobId":        job.ID.String(),
+		"pushType":     "massive",
+	}
+	if user.CreatedAt.Unix() > 0 {
+		pushMetadata["tokenCreatedAt"] = user.CreatedAt.Unix()
+	}
+	return pushMetadata
+}
+
+// BuildPushMessage builds the message that is sent to kafka for the given service
+func BuildPushMessage(service, deviceToken string, msg, messageMetadata, pushMetadata map[string]interface{}, expiresAt int64) (string, error) {
+	pushExpiry := expiresAt / 1000000000 // convert from nanoseconds to seconds
+	switch service {
+	case "apns":
+		return messages.NewAPNSMessage(deviceToken, pushExpiry, msg, messageMetadata, pushMetadata).ToJSON()
+	case "gcm":
+		return messages.NewGCMMessage(deviceToken, msg, messageMetadata, pushMetadata, pushExpiry).ToJSON()
+	default:
+		return "", fmt.Errorf("service should be in ['apns', 'gcm']")
+	}
 }
 
 //SendCircuitBreakJobEmail builds a circuit break job email message and sends it with sendgrid
@@ -24,6 +24,7 @@ package worker_test
 
 import (
 	"encoding/json"
+	"math"
 	"strings"
 
 	workers "github.com/jrallison/go-workers"
@@ -94,7 +95,7 @@ var _ = Describe("Worker Util", func() {
 	Describe("Build message from template", func() {
 		It("should make correct substitutions using defaults", func() {
 			context := map[string]interface{}{}
-			msgString, err := worker.BuildMessageFromTemplate(template, context)
+			msgString, err := worker.BuildMessageFromTemplate(template, context, nil, "")
 			Expect(err).NotTo(HaveOccurred())
 			var msg map[string]interface{}
 			err = json.Unmarshal([]byte(msgString), &msg)
@@ -110,7 +111,7 @@ var _ = Describe("Worker Util", func() {
 				"user_name":   "Camila",
 				"object_name": "building",
 			}
-			msgString, err := worker.BuildMessageFromTemplate(template, context)
+			msgString, err := worker.BuildMessageFromTemplate(template, context, nil, "")
 			Expect(err).NotTo(HaveOccurred())
 			var msg map[string]interface{}
 			err = json.Unmarshal([]byte(msgString), &msg)
@@ -125,7 +126,7 @@ var _ = Describe("Worker Util", func() {
 			context := map[string]interface{}{
 				"user_name": "Camila",
 			}
-			msgString, err := worker.BuildMessageFromTemplate(template, context)
+			msgString, err := worker.BuildMessageFromTemplate(template, context, nil, "")
 			Expect(err).NotTo(HaveOccurred())
 			var msg map[string]interface{}
 			err = json.Unmarshal([]byte(msgString), &msg)
@@ -135,6 +136,297 @@ var _ = Describe("Worker Util", func() {
 			Expect(msg["alert"]).NotTo(ContainSubstring("{{user_name}}"))
 			Expect(msg["alert"]).NotTo(ContainSubstring("{{object_name}}"))
 		})
+
+		It("should make correct substitutions using the user vars over context and defaults", func() {
+			context := map[string]interface{}{
+				"user_name":   "Camila",
+				"object_name": "building",
+			}
+			userVars := map[string]interface{}{
+				"user_name": "Joana",
+			}
+			msgString, err := worker.BuildMessageFromTemplate(template, context, userVars, "")
+			Expect(err).NotTo(HaveOccurred())
+			var msg map[string]interface{}
+			err = json.Unmarshal([]byte(msgString), &msg)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(msg["alert"]).To(Equal("Joana just liked your building!"))
+		})
+
+		It("should escape quotes, backslashes and new lines of the values", func() {
+			context := map[string]interface{}{
+				"user_name": "Camila \"the great\"\\o/\nfrom Brazil",
+			}
+			msgString, err := worker.BuildMessageFromTemplate(template, context, nil, "")
+			Expect(err).NotTo(HaveOccurred())
+			var msg map[string]interface{}
+			err = json.Unmarshal([]byte(msgString), &msg)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(msg["alert"]).To(Equal("Camila \"the great\"\\o/\nfrom Brazil just liked your village!"))
+		})
+
+		It("should keep the type of the values of placeholders that are the whole value", func() {
+			template.Body = map[string]interface{}{
+				"alert": "You won {{coins}} coins and {{reward}}",
+				"badge": "{{badge}}",
+				"data": map[string]interface{}{
+					"vip":    "{{vip}}",
+					"reward": "{{reward}}",
+					"items":  []interface{}{"{{badge}}", "{{missing}}"},
+				},
+			}
+			context := map[string]interface{}{
+				"coins":  1500.0,
+				"badge":  3,
+				"vip":    true,
+				"reward": map[string]interface{}{"type": "gem"},
+			}
+			msgString, err := worker.BuildMessageFromTemplate(template, context, nil, "")
+			Expect(err).NotTo(HaveOccurred())
+			var msg map[string]interface{}
+			err = json.Unmarshal([]byte(msgString), &msg)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(msg["alert"]).To(Equal(`You won 1500 coins and {"type":"gem"}`))
+			Expect(msg["badge"]).To(BeEquivalentTo(3))
+			data := msg["data"].(map[string]interface{})
+			Expect(data["vip"]).To(BeTrue())
+			Expect(data["reward"]).To(Equal(map[string]interface{}{"type": "gem"}))
+			Expect(data["items"]).To(Equal([]interface{}{float64(3), ""}))
+		})
+
+		It("should return an error with the placeholder that could not be rendered", func() {
+			template.Body = map[string]interface{}{
+				"alert": "{{user_name}} just liked your {{object_name}}!",
+				"data": map[string]interface{}{
+					"score": "{{score}}",
+				},
+			}
+			context := map[string]interface{}{
+				"score": math.NaN(),
+			}
+			_, err := worker.BuildMessageFromTemplate(template, context, nil, "")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("body.data.score: placeholder {{score}}"))
+		})
+	})
+
+	Describe("Build message from go template", func() {
+		var goTemplate model.Template
+		BeforeEach(func() {
+			goTemplate = model.Template{
+				Engine: model.GoTemplateEngine,
+				Body: map[string]interface{}{
+					"alert": "{{if .vip}}Dear {{.user_name | title}}{{else}}Hi {{.user_name}}{{end}}, you won {{.coins | formatNumber 0}} {{plural .coins \"coin\" \"coins\"}}!",
+					"data": map[string]interface{}{
+						"until": "{{.ends_at | formatDate \"Jan 2\"}}",
+						"count": 2,
+					},
+				},
+				Defaults: map[string]interface{}{
+					"user_name": "someone",
+					"vip":       false,
+					"coins":     1,
+					"ends_at":   0,
+				},
+			}
+		})
+
+		It("should render the conditionals and functions using defaults", func() {
+			msgString, err := worker.BuildMessageFromTemplate(goTemplate, map[string]interface{}{}, nil, "")
+			Expect(err).NotTo(HaveOccurred())
+			var msg map[string]interface{}
+			err = json.Unmarshal([]byte(msgString), &msg)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(msg["alert"]).To(Equal("Hi someone, you won 1 coin!"))
+			Expect(msg["data"].(map[string]interface{})["until"]).To(Equal("Jan 1"))
+			Expect(msg["data"].(map[string]interface{})["count"]).To(BeEquivalentTo(2))
+		})
+
+		It("should render the conditionals and functions using context", func() {
+			context := map[string]interface{}{
+				"user_name": "camila \"the great\"",
+				"vip":       true,
+				"coins":     12345,
+				"ends_at":   1487030400,
+			}
+			msgString, err := worker.BuildMessageFromTemplate(goTemplate, context, nil, "")
+			Expect(err).NotTo(HaveOccurred())
+			var msg map[string]interface{}
+			err = json.Unmarshal([]byte(msgString), &msg)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(msg["alert"]).To(Equal("Dear Camila \"The Great\", you won 12,345 coins!"))
+			Expect(msg["data"].(map[string]interface{})["until"]).To(Equal("Feb 14"))
+		})
+
+		It("should return an error if a key is missing", func() {
+			goTemplate.Body = map[string]interface{}{
+				"alert": "{{.unknown}}",
+			}
+			_, err := worker.BuildMessageFromTemplate(goTemplate, map[string]interface{}{}, nil, "")
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should use the default function for empty values", func() {
+			goTemplate.Body = map[string]interface{}{
+				"alert": "Hi {{.user_name | default \"friend\"}}",
+			}
+			msgString, err := worker.BuildMessageFromTemplate(goTemplate, map[string]interface{}{"user_name": ""}, nil, "")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(msgString).To(Equal(`{"alert":"Hi friend"}`))
+		})
+
+		It("should parse each string of the template once with a cache", func() {
+			goTemplates := model.GoTemplateCache{}
+			for _, userName := range []string{"camila", "joana"} {
+				_, err := worker.BuildMessageFromCachedTemplate(goTemplate, map[string]interface{}{}, map[string]interface{}{"user_name": userName}, "", goTemplates)
+				Expect(err).NotTo(HaveOccurred())
+			}
+			Expect(goTemplates).To(HaveLen(2))
+		})
+
+		It("should return an error if a printf width is too large", func() {
+			goTemplate.Body = map[string]interface{}{
+				"alert": "{{printf \"%1000000000d\" .coins}}",
+			}
+			_, err := worker.BuildMessageFromTemplate(goTemplate, map[string]interface{}{}, nil, "")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("widths and precisions must be at most 100"))
+		})
+
+		It("should return an error if the rendered body is too large", func() {
+			goTemplate.Body = map[string]interface{}{
+				"alert": "{{range .items}}{{.}}{{end}}",
+			}
+			items := make([]interface{}, 2000)
+			for i := range items {
+				items[i] = "gift"
+			}
+			_, err := worker.BuildMessageFromTemplate(goTemplate, map[string]interface{}{"items": items}, nil, "")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("rendered body is larger than 4096 bytes"))
+		})
+
+		It("should not accept nested range actions", func() {
+			err := model.ParseGoTemplateBody(map[string]interface{}{
+				"alert": "{{range .items}}{{range $.items}}{{.}}{{end}}{{end}}",
+			})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("nested range actions are not allowed"))
+		})
+
+		It("should not accept range actions over anything but fields of the data", func() {
+			for _, alert := range []string{
+				"{{range 2000000000}}{{end}}",
+				"{{$n := 2000000000}}{{range $n}}{{end}}",
+				"{{with 2000000000}}{{range .}}{{end}}{{end}}",
+				`{{range (printf "%d" 1)}}{{end}}`,
+			} {
+				err := model.ParseGoTemplateBody(map[string]interface{}{"alert": alert})
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("range actions are only allowed over fields of the data"))
+			}
+			err := model.ParseGoTemplateBody(map[string]interface{}{
+				"alert": "{{range $i, $item := $.items}}{{$i}}{{$item}}{{end}}",
+			})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should return an error if a list ranged over is too long", func() {
+			goTemplate.Body = map[string]interface{}{
+				"alert": "{{range .items}}{{end}}",
+			}
+			items := make([]interface{}, 10001)
+			_, err := worker.BuildMessageFromTemplate(goTemplate, map[string]interface{}{"items": items}, nil, "")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("lists ranged over by templates can have at most 10000 items"))
+		})
+	})
+
+	Describe("Build message from template with plural and select arguments", func() {
+		var template model.Template
+		BeforeEach(func() {
+			template = model.Template{
+				Name:   "gifts",
+				Locale: "ru",
+				Body: map[string]interface{}{
+					"alert": "{{user_name}}: {count, plural, one {# подарок} few {# подарка} many {# подарков} other {# подарка}}",
+				},
+				Defaults: map[string]interface{}{
+					"user_name": "someone",
+					"count":     1,
+				},
+			}
+		})
+
+		It("should choose the plural option with the rules of the user locale", func() {
+			alerts := []string{}
+			for _, count := range []int{1, 3, 5, 21, 112} {
+				msgString, err := worker.BuildMessageFromTemplate(template, map[string]interface{}{"count": count}, nil, "ru_RU")
+				Expect(err).NotTo(HaveOccurred())
+				var msg map[string]interface{}
+				err = json.Unmarshal([]byte(msgString), &msg)
+				Expect(err).NotTo(HaveOccurred())
+				alerts = append(alerts, msg["alert"].(string))
+			}
+			Expect(alerts).To(Equal([]string{
+				"someone: 1 подарок",
+				"someone: 3 подарка",
+				"someone: 5 подарков",
+				"someone: 21 подарок",
+				"someone: 112 подарков",
+			}))
+		})
+
+		It("should use the rules of the template locale if the user locale has another language", func() {
+			template.Locale = "en"
+			template.Body = map[string]interface{}{
+				"alert": "{count, plural, =0 {no gifts} one {# gift} other {# gifts}}",
+			}
+			msgString, err := worker.BuildMessageFromTemplate(template, map[string]interface{}{"count": 21}, nil, "ru")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(msgString).To(Equal(`{"alert":"21 gifts"}`))
+
+			msgString, err = worker.BuildMessageFromTemplate(template, map[string]interface{}{"count": 0}, nil, "ru")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(msgString).To(Equal(`{"alert":"no gifts"}`))
+		})
+
+		It("should choose the select option with the user vars", func() {
+			template.Locale = "en"
+			template.Body = map[string]interface{}{
+				"alert": "{gender, select, female {{{user_name}} sent her gift} male {{{user_name}} sent his gift} other {{{user_name}} sent a gift}}",
+			}
+			template.Defaults["gender"] = "other"
+			msgString, err := worker.BuildMessageFromTemplate(template, nil, map[string]interface{}{"gender": "female", "user_name": "Camila"}, "en")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(msgString).To(Equal(`{"alert":"Camila sent her gift"}`))
+
+			msgString, err = worker.BuildMessageFromTemplate(template, nil, map[string]interface{}{"gender": "unknown"}, "en")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(msgString).To(Equal(`{"alert":"someone sent a gift"}`))
+		})
+
+		It("should format the plural arguments of go templates", func() {
+			template.Engine = model.GoTemplateEngine
+			template.Locale = "pl"
+			template.Body = map[string]interface{}{
+				"alert": "{{.user_name | title}}: {count, plural, one {# prezent} few {# prezenty} many {# prezentów} other {# prezentu}}",
+			}
+			msgString, err := worker.BuildMessageFromTemplate(template, map[string]interface{}{"count": 22}, nil, "pl-PL")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(msgString).To(Equal(`{"alert":"Someone: 22 prezenty"}`))
+		})
+
+		It("should return an error if the plural value is not a number", func() {
+			_, err := worker.BuildMessageFromTemplate(template, map[string]interface{}{"count": "many"}, nil, "ru")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(HavePrefix("body.alert: plural argument count"))
+		})
 	})
 
 	Describe("Parse ProcessBatchWorker message array", func() {
@@ -203,40 +495,49 @@ var _ = Describe("Worker Util", func() {
 	Describe("Get Clause From Filters", func() {
 		It("should return empty string if filters is empty", func() {
 			filters := map[string]interface{}{}
-			where := worker.GetWhereClauseFromFilters(filters)
+			where, params, err := worker.GetWhereClauseFromFilters(filters)
+			Expect(err).NotTo(HaveOccurred())
 			Expect(where).To(Equal(""))
+			Expect(params).To(BeEmpty())
 		})
 
 		It("should succeed with one simple filter", func() {
 			filters := map[string]interface{}{
 				"region": "US",
 			}
-			where := worker.GetWhereClauseFromFilters(filters)
-			Expect(where).To(Equal("\"region\"='US'"))
+			where, params, err := worker.GetWhereClauseFromFilters(filters)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(where).To(Equal("\"region\" = ?"))
+			Expect(params).To(Equal([]interface{}{"US"}))
 		})
 
 		It("should succeedd with one comma separated filter", func() {
 			filters := map[string]interface{}{
 				"region": "US,CA",
 			}
-			where := worker.GetWhereClauseFromFilters(filters)
-			Expect(where).To(Equal("(\"region\"='US' OR \"region\"='CA')"))
+			where, params, err := worker.GetWhereClauseFromFilters(filters)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(where).To(Equal("\"region\" IN (?)"))
+			Expect(params).To(HaveLen(1))
 		})
 
 		It("should succeed with one negative simple filter", func() {
 			filters := map[string]interface{}{
 				"NOTregion": "US",
 			}
-			where := worker.GetWhereClauseFromFilters(filters)
-			Expect(where).To(Equal("\"region\"!='US'"))
+			where, params, err := worker.GetWhereClauseFromFilters(filters)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(where).To(Equal("NOT (\"region\" = ?)"))
+			Expect(params).To(Equal([]interface{}{"US"}))
 		})
 
 		It("should succeed with one negative comma separated filter", func() {
 			filters := map[string]interface{}{
 				"NOTregion": "US,CA",
 			}
-			where := worker.GetWhereClauseFromFilters(filters)
-			Expect(where).To(Equal("(\"region\"!='US' AND \"region\"!='CA')"))
+			where, _, err := worker.GetWhereClauseFromFilters(filters)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(where).To(Equal("NOT (\"region\" IN (?))"))
 		})
 
 		It("should succeed with multiple filters", func() {
@@ -244,10 +545,103 @@ var _ = Describe("Worker Util", func() {
 				"NOTregion": "US,CA",
 				"locale":    "en,fr",
 			}
-			where := worker.GetWhereClauseFromFilters(filters)
-			Expect(where).To(ContainSubstring("(\"locale\"='en' OR \"locale\"='fr')"))
-			Expect(where).To(ContainSubstring("(\"region\"!='US' AND \"region\"!='CA')"))
-			Expect(where).To(ContainSubstring(") AND ("))
+			where, params, err := worker.GetWhereClauseFromFilters(filters)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(where).To(Equal("(NOT (\"region\" IN (?)) AND \"locale\" IN (?))"))
+			Expect(params).To(HaveLen(2))
+		})
+
+		It("should not put the filter values in the where clause", func() {
+			filters := map[string]interface{}{
+				"region": "US' OR '1'='1",
+			}
+			where, params, err := worker.GetWhereClauseFromFilters(filters)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(where).To(Equal("\"region\" = ?"))
+			Expect(params).To(Equal([]interface{}{"US' OR '1'='1"}))
+		})
+
+		It("should succeed with a filter tree", func() {
+			filters := map[string]interface{}{
+				"and": []interface{}{
+					map[string]interface{}{"column": "locale", "op": "in", "value": []interface{}{"en", "fr"}},
+					map[string]interface{}{"or": []interface{}{
+						map[string]interface{}{"column": "region", "op": "is null"},
+						map[string]interface{}{"column": "region", "op": "like", "value": "U%"},
+					}},
+					map[string]interface{}{"not": map[string]interface{}{"column": "tz", "op": "!=", "value": "-0300"}},
+					map[string]interface{}{"column": "level", "op": "between", "value": []interface{}{10, 20}},
+					map[string]interface{}{"column": "created_at", "op": ">", "value": map[string]interface{}{"relative": "-7d"}},
+				},
+			}
+			where, params, err := worker.GetWhereClauseFromFilters(filters)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(where).To(Equal("(\"locale\" IN (?) AND (\"region\" IS NULL OR \"region\" LIKE ?) AND NOT (\"tz\" != ?) AND \"level\" BETWEEN ? AND ? AND \"created_at\" > now() + ?::interval)"))
+			Expect(params).To(HaveLen(6))
+			Expect(params[1:]).To(Equal([]interface{}{"U%", "-0300", float64(10), float64(20), "-7 days"}))
+		})
+
+		It("should return an error if the filter tree is invalid", func() {
+			invalidFilters := []map[string]interface{}{
+				{"column": "region\"; DROP TABLE users; --", "op": "=", "value": "US"},
+				{"column": "region", "op": "~", "value": "US"},
+				{"column": "region", "op": "in", "value": "US"},
+				{"column": "level", "op": "between", "value": []interface{}{10}},
+				{"column": "created_at", "op": ">", "value": map[string]interface{}{"relative": "7 days"}},
+				{"and": []interface{}{map[string]interface{}{"column": "tz", "op": "is null"}}, "column": "region", "op": "is null"},
+				{"or": []interface{}{map[string]interface{}{"column": "region"}}},
+				{"region": 1},
+			}
+			for _, filters := range invalidFilters {
+				_, _, err := worker.GetWhereClauseFromFilters(filters)
+				Expect(err).To(HaveOccurred())
+			}
+		})
+	})
+
+	Describe("Merge users buckets", func() {
+		It("should sum users with the same locale and tz and sort the buckets", func() {
+			buckets := worker.MergeUsersBuckets([]worker.UsersBucket{
+				{Locale: "pt", Tz: "-0300", Users: 2},
+				{Locale: "en", Tz: "-0500", Users: 1},
+				{Locale: "pt", Tz: "-0300", Users: 3},
+			})
+			Expect(buckets).To(Equal([]worker.UsersBucket{
+				{Locale: "en", Tz: "-0500", Users: 1},
+				{Locale: "pt", Tz: "-0300", Users: 5},
+			}))
+		})
+	})
+
+	Describe("Build push message", func() {
+		It("should build an apns message", func() {
+			msg := map[string]interface{}{"alert": "hello"}
+			pushMetadata := map[string]interface{}{"jobId": jobID}
+			res, err := worker.BuildPushMessage("apns", "token", msg, nil, pushMetadata, 2000000000)
+			Expect(err).NotTo(HaveOccurred())
+			var apnsMsg map[string]interface{}
+			err = json.Unmarshal([]byte(res), &apnsMsg)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(apnsMsg["DeviceToken"]).To(Equal("token"))
+			Expect(apnsMsg["push_expiry"]).To(BeEquivalentTo(2))
+			Expect(apnsMsg["Payload"].(map[string]interface{})["aps"]).To(Equal(msg))
+			Expect(apnsMsg["metadata"]).To(Equal(pushMetadata))
+		})
+
+		It("should build a gcm message", func() {
+			msg := map[string]interface{}{"alert": "hello"}
+			res, err := worker.BuildPushMessage("gcm", "token", msg, nil, nil, 0)
+			Expect(err).NotTo(HaveOccurred())
+			var gcmMsg map[string]interface{}
+			err = json.Unmarshal([]byte(res), &gcmMsg)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(gcmMsg["to"]).To(Equal("token"))
+			Expect(gcmMsg["data"]).To(Equal(msg))
+		})
+
+		It("should fail for an unknown service", func() {
+			_, err := worker.BuildPushMessage("blabla", "token", nil, nil, nil, 0)
+			Expect(err).To(HaveOccurred())
 		})
 	})
 })
@@ -23,22 +23,54 @@
 package worker
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
+	"time"
+
+	redis "gopkg.in/redis.v5"
 
 	raven "github.com/getsentry/raven-go"
 	"github.com/jrallison/go-workers"
 	"github.com/spf13/viper"
+	"github.com/topfreegames/marathon/extensions"
+	"github.com/topfreegames/marathon/model"
 	"github.com/uber-go/zap"
 )
 
+// ProcessBatchQueues maps the job priorities to the process_batch_worker queues, each one with its own concurrency
+var ProcessBatchQueues = map[string]string{
+	model.HighJobPriority:   "process_batch_worker_high",
+	model.NormalJobPriority: "process_batch_worker",
+	model.LowJobPriority:    "process_batch_worker_low",
+}
+
+// JobQueues are the go-workers queues that receive messages with a marathon job id as their first argument
+var JobQueues = []string{
+	"create_batches_worker",
+	"create_batches_from_filters_worker",
+	"process_batch_worker_high",
+	"process_batch_worker",
+	"process_batch_worker_low",
+}
+
+// GetProcessBatchQueue returns the process_batch_worker queue of the priority
+func GetProcessBatchQueue(priority string) string {
+	if queue, ok := ProcessBatchQueues[priority]; ok {
+		return queue
+	}
+	return ProcessBatchQueues[model.NormalJobPriority]
+}
+
 // Worker is the struct that will configure workers
 type Worker struct {
-	Debug      bool
-	Logger     zap.Logger
-	ConfigPath string
-	Config     *viper.Viper
+	Debug       bool
+	Logger      zap.Logger
+	ConfigPath  string
+	Config      *viper.Viper
+	RedisClient *redis.Client
 }
 
 // NewWorker returns a configured worker
@@ -78,6 +110,10 @@ func (w *Worker) loadConfigurationDefaults() {
 	w.Config.SetDefault("workers.redis.poolSize", "10")
 	w.Config.SetDefault("workers.statsPort", 8081)
 	w.Config.SetDefault("workers.concurrency", 10)
+	w.Config.SetDefault("workers.processBatch.highPriorityConcurrency", 10)
+	w.Config.SetDefault("workers.processBatch.lowPriorityConcurrency", 2)
+	w.Config.SetDefault("workers.audienceSize.concurrency", 5)
+	w.Config.SetDefault("workers.audienceSize.maxRetries", 5)
 	w.Config.SetDefault("database.url", "postgres://localhost:5432/marathon?sslmode=disable")
 }
 
@@ -111,6 +147,10 @@ func (w *Worker) configureRedis() {
 		"password": redisPassword,
 	})
 
+	w.RedisClient, err = extensions.NewRedis("workers", w.Config, w.Logger)
+	if err != nil {
+		panic(err)
+	}
 }
 
 func (w *Worker) configureWorkers() {
@@ -118,14 +158,21 @@ func (w *Worker) configureWorkers() {
 	c := NewCreateBatchesWorker(w.Config, w.Logger, w)
 	f := NewCreateBatchesFromFiltersWorker(w.Config, w.Logger, w)
 	r := NewResumeJobWorker(w.Config, w.Logger, w)
+	s := NewAudienceSizeWorker(w.Config, w.Logger)
 	createBatchesWorkerConcurrency := w.Config.GetInt("workers.createBatches.concurrency")
 	createBatchesFromFiltersWorkerConcurrency := w.Config.GetInt("workers.createBatchesFromFilters.concurrency")
 	processBatchWorkerConcurrency := w.Config.GetInt("workers.processBatch.concurrency")
+	highPriorityProcessBatchWorkerConcurrency := w.Config.GetInt("workers.processBatch.highPriorityConcurrency")
+	lowPriorityProcessBatchWorkerConcurrency := w.Config.GetInt("workers.processBatch.lowPriorityConcurrency")
 	resumeJobWorkerConcurrency := w.Config.GetInt("workers.resume.concurrency")
+	audienceSizeWorkerConcurrency := w.Config.GetInt("workers.audienceSize.concurrency")
 	workers.Process("create_batches_worker", c.Process, createBatchesWorkerConcurrency)
-	workers.Process("process_batch_worker", p.Process, processBatchWorkerConcurrency)
+	workers.Process(ProcessBatchQueues[model.HighJobPriority], p.Process, highPriorityProcessBatchWorkerConcurrency)
+	workers.Process(ProcessBatchQueues[model.NormalJobPriority], p.Process, processBatchWorkerConcurrency)
+	workers.Process(ProcessBatchQueues[model.LowJobPriority], p.Process, lowPriorityProcessBatchWorkerConcurrency)
 	workers.Process("create_batches_from_filters_worker", f.Process, createBatchesFromFiltersWorkerConcurrency)
 	workers.Process("resume_job_worker", r.Process, resumeJobWorkerConcurrency)
+	workers.Process("audience_size_worker", s.Process, audienceSizeWorkerConcurrency)
 }
 
 func (w *Worker) configureSentry() {
@@ -156,9 +203,9 @@ func (w *Worker) CreateBatchesFromFiltersJob(jobID *[]string) (string, error) {
 	})
 }
 
-// CreateProcessBatchJob creates a new ProcessBatchWorker job
-func (w *Worker) CreateProcessBatchJob(jobID string, appName string, users *[]User) (string, error) {
-	return workers.Enqueue("process_batch_worker", "Add", []interface{}{jobID, appName, *users})
+// CreateProcessBatchJob creates a new ProcessBatchWorker job in the queue of the job priority
+func (w *Worker) CreateProcessBatchJob(jobID string, appName string, users *[]User, priority string) (string, error) {
+	return workers.Enqueue(GetProcessBatchQueue(priority), "Add", []interface{}{jobID, appName, *users})
 }
 
 // CreateResumeJob creates a new ResumeJobWorker job
@@ -170,6 +217,15 @@ func (w *Worker) CreateResumeJob(jobID *[]string) (string, error) {
 	})
 }
 
+// CreateAudienceSizeJob creates a new AudienceSizeWorker job that counts the users of the audience
+func (w *Worker) CreateAudienceSizeJob(audienceID string) (string, error) {
+	maxRetries := w.Config.GetInt("workers.audienceSize.maxRetries")
+	return workers.EnqueueWithOptions("audience_size_worker", "Add", []string{audienceID}, workers.EnqueueOptions{
+		Retry:      true,
+		RetryCount: maxRetries,
+	})
+}
+
 // ScheduleCreateBatchesJob schedules a new CreateBatchesWorker job
 func (w *Worker) ScheduleCreateBatchesJob(jobID *[]string, at int64) (string, error) {
 	return workers.EnqueueWithOptions(
@@ -194,10 +250,26 @@ func (w *Worker) ScheduleCreateBatchesFromFiltersJob(jobID *[]string, at int64)
 		})
 }
 
-// ScheduleProcessBatchJob schedules a new ProcessBatchWorker job
-func (w *Worker) ScheduleProcessBatchJob(jobID string, appName string, users *[]User, at int64) (string, error) {
+// EnqueueJob sends a marathon job to create_batches_worker or create_batches_from_filters_worker, scheduling it
+// to its startsAt if the job is not localized
+func (w *Worker) EnqueueJob(job *model.Job) (string, error) {
+	jobID := &[]string{job.ID.String()}
+	if job.StartsAt != 0 && !job.Localized {
+		if len(job.CSVPath) > 0 {
+			return w.ScheduleCreateBatchesJob(jobID, job.StartsAt)
+		}
+		return w.ScheduleCreateBatchesFromFiltersJob(jobID, job.StartsAt)
+	}
+	if len(job.CSVPath) > 0 {
+		return w.CreateBatchesJob(jobID)
+	}
+	return w.CreateBatchesFromFiltersJob(jobID)
+}
+
+// ScheduleProcessBatchJob schedules a new ProcessBatchWorker job in the queue of the job priority
+func (w *Worker) ScheduleProcessBatchJob(jobID string, appName string, users *[]User, priority string, at int64) (string, error) {
 	return workers.EnqueueWithOptions(
-		"process_batch_worker",
+		GetProcessBatchQueue(priority),
 		"Add",
 		[]interface{}{jobID, appName, *users},
 		workers.EnqueueOptions{
@@ -205,9 +277,196 @@ func (w *Worker) ScheduleProcessBatchJob(jobID string, appName string, users *[]
 		})
 }
 
+type queuedMessage struct {
+	Queue string        `json:"queue"`
+	Args  []interface{} `json:"args"`
+}
+
+func isJobMessage(rawMessage, jobID string, queues []string) bool {
+	if !strings.Contains(rawMessage, jobID) {
+		return false
+	}
+	msg := queuedMessage{}
+	if err := json.Unmarshal([]byte(rawMessage), &msg); err != nil {
+		return false
+	}
+	if len(msg.Args) == 0 || msg.Args[0] != jobID {
+		return false
+	}
+	for _, queue := range queues {
+		if msg.Queue == queue {
+			return true
+		}
+	}
+	return false
+}
+
+// jobMessagesScanSize is the number of members of the go-workers sets and queues read at a time when looking for
+// the messages of a job, so whole shared queues are never loaded at once
+const jobMessagesScanSize = 1000
+
+// findJobMessages returns the messages of the job in the given queues that are in a go-workers sorted set. If dueOnly
+// only the messages whose time, the score in the set, already passed are returned
+func (w *Worker) findJobMessages(set, jobID string, queues []string, dueOnly bool) ([]string, error) {
+	now := float64(time.Now().UnixNano()) / float64(time.Second)
+	found := []string{}
+	var cursor uint64
+	for {
+		members, nextCursor, err := w.RedisClient.ZScan(set, cursor, fmt.Sprintf("*%s*", jobID), jobMessagesScanSize).Result()
+		if err != nil {
+			return nil, err
+		}
+		// zscan returns member, score pairs
+		for i := 0; i+1 < len(members); i += 2 {
+			if !isJobMessage(members[i], jobID, queues) {
+				continue
+			}
+			if dueOnly {
+				at, err := strconv.ParseFloat(members[i+1], 64)
+				if err != nil {
+					return nil, err
+				}
+				if at > now {
+					continue
+				}
+			}
+			found = append(found, members[i])
+		}
+		cursor = nextCursor
+		if cursor == 0 {
+			return found, nil
+		}
+	}
+}
+
+// removeJobMessages removes the messages of the job from the go-workers schedule and retry sets and from the queues.
+// If dueOnly the messages scheduled to a time that did not pass yet are kept in the schedule set
+func (w *Worker) removeJobMessages(jobID string, queues []string, dueOnly bool, f func(rawMessage string) error) (int, error) {
+	removed := 0
+	for _, set := range []string{"schedule", "retry"} {
+		msgs, err := w.findJobMessages(set, jobID, queues, dueOnly && set == "schedule")
+		if err != nil {
+			return removed, err
+		}
+		for _, msg := range msgs {
+			res, err := w.RedisClient.ZRem(set, msg).Result()
+			if err != nil {
+				return removed, err
+			}
+			if res == 0 {
+				// the message was already moved to its queue by go-workers
+				continue
+			}
+			if err = f(msg); err != nil {
+				return removed, err
+			}
+			removed++
+		}
+	}
+	for _, queue := range queues {
+		n, err := w.removeQueueJobMessages(fmt.Sprintf("queue:%s", queue), jobID, queues, f)
+		removed += n
+		if err != nil {
+			return removed, err
+		}
+	}
+	return removed, nil
+}
+
+// removeQueueJobMessages removes the messages of the job from a go-workers queue, reading it in chunks of
+// jobMessagesScanSize. The workers push to the head and fetch from the tail of the queue, so a chunk can only
+// have messages of the previous one again, which are skipped, and no message is missed
+func (w *Worker) removeQueueJobMessages(key, jobID string, queues []string, f func(rawMessage string) error) (int, error) {
+	removed := 0
+	var start int64
+	for {
+		msgs, err := w.RedisClient.LRange(key, start, start+jobMessagesScanSize-1).Result()
+		if err != nil {
+			return removed, err
+		}
+		removedInChunk := 0
+		for _, msg := range msgs {
+			if !isJobMessage(msg, jobID, queues) {
+				continue
+			}
+			res, err := w.RedisClient.LRem(key, 1, msg).Result()
+			if err != nil {
+				return removed, err
+			}
+			if res == 0 {
+				// the message was already fetched by a worker
+				continue
+			}
+			removedInChunk++
+			if err = f(msg); err != nil {
+				return removed + removedInChunk, err
+			}
+		}
+		removed += removedInChunk
+		if len(msgs) < jobMessagesScanSize {
+			return removed, nil
+		}
+		// the removed messages shifted the rest of the queue towards the head
+		start += int64(len(msgs) - removedInChunk)
+	}
+}
+
+// RemoveJobMessages removes every enqueued, scheduled or retrying message of the job and its paused batches
+// It returns the number of removed messages
+func (w *Worker) RemoveJobMessages(jobID string) (int, error) {
+	removed, err := w.removeJobMessages(jobID, JobQueues, false, func(string) error { return nil })
+	if err != nil {
+		return removed, err
+	}
+	pausedJobs, err := w.RedisClient.LLen(fmt.Sprintf("%s-pausedjobs", jobID)).Result()
+	if err != nil {
+		return removed, err
+	}
+	err = w.RedisClient.Del(fmt.Sprintf("%s-pausedjobs", jobID)).Err()
+	return removed + int(pausedJobs), err
+}
+
+// RemoveCreateBatchesMessages removes the enqueued, scheduled or retrying create_batches_worker and
+// create_batches_from_filters_worker messages of the job, if none is removed the job already started creating batches
+// It returns the number of removed messages
+func (w *Worker) RemoveCreateBatchesMessages(jobID string) (int, error) {
+	return w.removeJobMessages(jobID, []string{
+		"create_batches_worker",
+		"create_batches_from_filters_worker",
+	}, false, func(string) error { return nil })
+}
+
+// MoveJobMessagesToPausedQueue moves every enqueued or due process_batch_worker message of the job to the paused jobs
+// list, from where they are sent again when the job is resumed. The messages scheduled to the future, like the
+// batches of the later timezones of localized jobs, are kept in the schedule set, the process batch worker moves them
+// to the paused jobs list if the job is still paused when they are due
+// It returns the number of moved messages
+func (w *Worker) MoveJobMessagesToPausedQueue(jobID string) (int, error) {
+	key := fmt.Sprintf("%s-pausedjobs", jobID)
+	queues := []string{}
+	for _, queue := range ProcessBatchQueues {
+		queues = append(queues, queue)
+	}
+	moved, err := w.removeJobMessages(jobID, queues, true, func(rawMessage string) error {
+		return w.RedisClient.RPush(key, rawMessage).Err()
+	})
+	if err != nil || moved == 0 {
+		return moved, err
+	}
+	ttl, err := w.RedisClient.TTL(key).Result()
+	if err != nil {
+		return moved, err
+	}
+	if ttl < 0 {
+		err = w.RedisClient.Expire(key, 7*24*time.Hour).Err()
+	}
+	return moved, err
+}
+
 // Start starts the worker
 func (w *Worker) Start() {
 	jobsStatsPort := w.Config.GetInt("workers.statsPort")
 	go workers.StatsServer(jobsStatsPort)
+	go NewRecurringJobsScheduler(w.Config, w.Logger, w).Start()
 	workers.Run()
 }